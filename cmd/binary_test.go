@@ -1,6 +1,7 @@
 package cmd_test
 
 import (
+	"encoding/json"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -60,3 +61,60 @@ func TestBuiltBinaryAcceptsPairCommand(t *testing.T) {
 		t.Errorf("binary panicked:\n%s", output)
 	}
 }
+
+// TestBuiltBinaryServesMCPOverStdio runs "serve -mcp stdio" the way an MCP
+// client does: requests on stdin, nothing but responses on stdout, and an
+// exit once stdin closes.
+func TestBuiltBinaryServesMCPOverStdio(t *testing.T) {
+	tmpDir := t.TempDir()
+	binary := filepath.Join(tmpDir, "openmessage")
+	build := exec.Command("go", "build", "-o", binary, "..")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+
+	cmd := exec.Command(binary, "serve", "-mcp", "stdio")
+	cmd.Env = append(os.Environ(),
+		"OPENMESSAGES_DATA_DIR="+filepath.Join(tmpDir, "data"),
+		"OPENMESSAGES_DEMO=1",
+		"OPENMESSAGES_PORT=0",
+	)
+	cmd.Stdin = strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}
+{"jsonrpc":"2.0","method":"notifications/initialized"}
+{"jsonrpc":"2.0","id":2,"method":"tools/list"}
+`)
+	stdout, stderr := &strings.Builder{}, &strings.Builder{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start binary: %v", err)
+	}
+	timer := time.AfterFunc(10*time.Second, func() {
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("serve didn't exit cleanly when stdin closed: %v\n%s", err, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("stdout has %d lines, want 2 responses:\n%s", len(lines), stdout)
+	}
+	for i, line := range lines {
+		var resp struct {
+			ID     int            `json:"id"`
+			Result map[string]any `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil || resp.ID != i+1 || resp.Result == nil {
+			t.Errorf("stdout line %d isn't a response to request %d: %s", i+1, i+1, line)
+		}
+	}
+	if !strings.Contains(lines[1], `"send_message"`) {
+		t.Errorf("tools/list is missing send_message: %s", lines[1])
+	}
+	if !strings.Contains(stderr.String(), "MCP available on stdio") {
+		t.Errorf("logs didn't go to stderr:\n%s", stderr)
+	}
+}
@@ -20,7 +20,7 @@ func RunDebugMedia(logger zerolog.Logger, convID string) error {
 		return err
 	}
 
-	resp, err := a.Client.GM.FetchMessages(convID, 10, nil)
+	resp, err := a.Client().GM.FetchMessages(convID, 10, nil)
 	if err != nil {
 		return fmt.Errorf("fetch: %w", err)
 	}
@@ -3,9 +3,7 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/google/uuid"
 	"github.com/rs/zerolog"
-	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
 	"github.com/maxghenis/openmessage/internal/app"
 )
@@ -21,38 +19,14 @@ func RunSend(logger zerolog.Logger, conversationID, message string) error {
 		return fmt.Errorf("connect: %w", err)
 	}
 
-	// Look up conversation to get outgoing participant ID
-	conv, err := a.Store.GetConversation(conversationID)
-	if err != nil {
-		return fmt.Errorf("get conversation: %w", err)
-	}
-	if conv == nil {
-		return fmt.Errorf("conversation %s not found", conversationID)
-	}
-
-	tmpID := uuid.NewString()
-	_, err = a.Client.GM.SendMessage(&gmproto.SendMessageRequest{
-		ConversationID: conversationID,
-		TmpID:          tmpID,
-		MessagePayload: &gmproto.MessagePayload{
-			TmpID:          tmpID,
-			TmpID2:         tmpID,
-			ConversationID: conversationID,
-			MessageInfo: []*gmproto.MessageInfo{
-				{
-					Data: &gmproto.MessageInfo_MessageContent{
-						MessageContent: &gmproto.MessageContent{
-							Content: message,
-						},
-					},
-				},
-			},
-		},
-	})
+	res, err := a.Sender.Send(app.SendRequest{ConversationID: conversationID, Body: message})
 	if err != nil {
 		return fmt.Errorf("send: %w", err)
 	}
+	if !res.Success {
+		return fmt.Errorf("send: Google Messages did not accept the message (status %s)", res.Status)
+	}
 
-	logger.Info().Str("conversation", conversationID).Msg("Message sent")
+	logger.Info().Str("conversation", conversationID).Str("message_id", res.MessageID).Msg("Message sent")
 	return nil
 }
@@ -1,22 +1,61 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 
 	mcpserver "github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/auth"
 	"github.com/maxghenis/openmessage/internal/tools"
 	"github.com/maxghenis/openmessage/internal/web"
 )
 
-func RunServe(logger zerolog.Logger) error {
+// MCP transports, chosen with serve's -mcp flag.
+const (
+	// MCPSSE serves MCP over server-sent events at /mcp/sse.
+	MCPSSE = "sse"
+	// MCPHTTP serves MCP over streamable HTTP at /mcp.
+	MCPHTTP = "http"
+	// MCPStdio serves MCP on stdin and stdout, for clients that start the
+	// server themselves. The web UI is still served, but not MCP over HTTP.
+	MCPStdio = "stdio"
+)
+
+func RunServe(logger zerolog.Logger, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	transport := flags.String("mcp", MCPSSE, "MCP transport: sse, http or stdio")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	switch *transport {
+	case MCPSSE, MCPHTTP, MCPStdio:
+	default:
+		return fmt.Errorf("unknown MCP transport %q; want %s, %s or %s", *transport, MCPSSE, MCPHTTP, MCPStdio)
+	}
+
+	// Over stdio, stdout carries the protocol and nothing else: anything
+	// that prints to it goes to stderr, with the logs.
+	protocolOut := os.Stdout
+	if *transport == MCPStdio {
+		os.Stdout = os.Stderr
+	}
+
 	a, err := app.New(logger)
 	if err != nil {
 		return fmt.Errorf("init app: %w", err)
@@ -25,61 +64,154 @@ func RunServe(logger zerolog.Logger) error {
 
 	// Connect to Google Messages (skip in demo mode)
 	if os.Getenv("OPENMESSAGES_DEMO") == "" {
-		if err := a.LoadAndConnect(); err != nil {
+		if err := a.LoadAndConnect(); errors.Is(err, fs.ErrNotExist) {
+			// Keep serving so the phone can be paired from the web UI.
+			logger.Warn().Msg("Not paired yet — open the web UI to pair a phone")
+		} else if err != nil {
 			return fmt.Errorf("connect: %w", err)
+		} else {
+			// Backfill existing conversations and messages
+			a.CatchUp()
 		}
-
-		// Backfill existing conversations and messages
-		go func() {
-			if err := a.Backfill(); err != nil {
-				logger.Warn().Err(err).Msg("Backfill failed")
-			}
-		}()
 	} else {
 		logger.Info().Msg("Demo mode — skipping phone connection")
 	}
 
+	// Send whatever was queued or came due while we were offline, and keep
+	// sending. Post incoming messages to webhooks likewise.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go a.Outbox.Run(ctx)
+	go a.Scheduler.Run(ctx)
+	go a.Webhooks.Run(ctx)
+
 	// Start web server
 	port := os.Getenv("OPENMESSAGES_PORT")
 	if port == "" {
 		port = "7007"
 	}
+	// Anyone who can reach the server can read and send texts, so only
+	// this machine can unless told otherwise.
+	host := os.Getenv("OPENMESSAGES_HOST")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	// Requests must name a host the server knows it's reached by, so a web
+	// page can't point a DNS name of its own at it.
+	var hosts []string
+	if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+		hosts = append(hosts, host)
+	}
+	for _, h := range strings.Split(os.Getenv("OPENMESSAGES_ALLOWED_HOSTS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		logger.Warn().Str("host", host).Msg("Only requests to localhost are accepted; set OPENMESSAGES_ALLOWED_HOSTS to the names other machines reach this server by")
+	}
+
+	var authn *auth.Auth
+	if os.Getenv("OPENMESSAGES_AUTH") == "off" {
+		logger.Warn().Msg("Authentication is off: anyone who can reach the server can read and send messages")
+	} else {
+		tokensPath := filepath.Join(a.DataDir, "tokens.json")
+		var created bool
+		if authn, created, err = auth.Load(tokensPath); err != nil {
+			return fmt.Errorf("load API tokens: %w", err)
+		}
+		if created {
+			logger.Info().Str("path", tokensPath).Msg("Generated API tokens; log in to the web UI with one of them")
+		} else {
+			logger.Info().Str("path", tokensPath).Msg("API tokens loaded")
+		}
+	}
+
+	logger.Info().Str("mode", a.Approvals.Policy().Mode).Msg("Send policy for agents")
 
 	// Create MCP server
+	resources := tools.NewResources(a)
 	mcpSrv := mcpserver.NewMCPServer(
 		"openmessage",
 		"0.1.0",
-		mcpserver.WithToolCapabilities(true),
+		append([]mcpserver.ServerOption{
+			mcpserver.WithToolCapabilities(true),
+			// Tools' structured output must match the schemas they declare.
+			mcpserver.WithOutputSchemaValidation(),
+		}, resources.ServerOptions()...)...,
 	)
 	tools.Register(mcpSrv, a)
+	resources.Register(mcpSrv)
+	go resources.Run(ctx)
 
-	// Create SSE transport for MCP, mounted at /mcp/
-	sseSrv := mcpserver.NewSSEServer(mcpSrv,
-		mcpserver.WithBaseURL(fmt.Sprintf("http://localhost:%s", port)),
-		mcpserver.WithStaticBasePath("/mcp"),
-	)
+	var mcpHandler http.Handler
+	var mcpURL string
+	switch *transport {
+	case MCPSSE:
+		mcpHandler = mcpserver.NewSSEServer(mcpSrv,
+			mcpserver.WithBaseURL(fmt.Sprintf("http://localhost:%s", port)),
+			mcpserver.WithStaticBasePath("/mcp"),
+		)
+		mcpURL = "http://localhost:" + port + "/mcp/sse"
+	case MCPHTTP:
+		mcpHandler = mcpserver.NewStreamableHTTPServer(mcpSrv)
+		mcpURL = "http://localhost:" + port + "/mcp"
+	}
 
-	httpHandler := web.APIHandlerFull(a.Store, a.Client, logger, sseSrv,
-		func() bool { return a.Connected.Load() },
-		a.Unpair,
-		a.DeepBackfill,
-	)
-	ln, err := net.Listen("tcp", ":"+port)
+	// Pairing from the browser would replace the demo data with a real
+	// account, so it's only offered outside demo mode.
+	pairer := a.Pairer
+	if os.Getenv("OPENMESSAGES_DEMO") != "" {
+		pairer = nil
+	}
+	httpHandler := web.APIHandlerFull(web.Config{
+		Store:       a.Store,
+		Clients:     a.Clients,
+		Logger:      logger,
+		MCPHandler:  mcpHandler,
+		IsConnected: func() bool { return a.Connected.Load() },
+		Connection:  a.ConnectionStatus,
+		Unpair:      a.Unpair,
+		Media:       a.Media,
+		Pairer:      pairer,
+		Backfills:   a.Backfills,
+		Sender:      a.Sender,
+		Outbox:      a.Outbox,
+		Scheduler:   a.Scheduler,
+		Events:      a.Events,
+		Webhooks:    a.Webhooks,
+		Approvals:   a.Approvals,
+		Auth:        authn,
+		Hosts:       hosts,
+	})
+	addr := net.JoinHostPort(host, port)
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		return fmt.Errorf("listen on port %s: %w", port, err)
+		return fmt.Errorf("listen on %s: %w", addr, err)
 	}
 	go func() {
-		logger.Info().Str("port", port).Msg("Web UI available at http://localhost:" + port)
-		logger.Info().Str("port", port).Msg("MCP SSE available at http://localhost:" + port + "/mcp/sse")
+		logger.Info().Str("addr", addr).Msg("Web UI available at http://localhost:" + port)
+		if mcpURL != "" {
+			logger.Info().Str("addr", addr).Str("transport", *transport).Msg("MCP available at " + mcpURL)
+		}
 		if err := http.Serve(ln, httpHandler); err != nil {
 			logger.Error().Err(err).Msg("HTTP server error")
 		}
 	}()
 
-	// Block until signal
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	sigCtx, stopSignals := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	if *transport == MCPStdio {
+		// The client ends the session by closing stdin.
+		logger.Info().Msg("MCP available on stdio")
+		stdio := mcpserver.NewStdioServer(mcpSrv)
+		stdio.SetErrorLogger(log.New(os.Stderr, "mcp: ", 0))
+		if err := stdio.Listen(sigCtx, os.Stdin, protocolOut); err != nil && !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("MCP over stdio: %w", err)
+		}
+	} else {
+		<-sigCtx.Done()
+	}
 	logger.Info().Msg("Shutting down")
 	return nil
 }
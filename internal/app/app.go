@@ -1,25 +1,71 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"sync/atomic"
 
 	"github.com/rs/zerolog"
 
+	"github.com/maxghenis/openmessage/internal/bus"
 	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/db"
+	"github.com/maxghenis/openmessage/internal/media"
 )
 
+// eventHistory is how many recent events are kept for web clients that
+// reconnect to the event stream.
+const eventHistory = 1000
+
 type App struct {
-	Client       *client.Client
-	Store        *db.Store
-	EventHandler *client.EventHandler
-	Logger       zerolog.Logger
-	DataDir      string
-	SessionPath  string
-	Connected    atomic.Bool
+	// Clients holds the current Google Messages client; see Client.
+	Clients     *client.Provider
+	Store       *db.Store
+	Logger      zerolog.Logger
+	DataDir     string
+	SessionPath string
+	Connected   atomic.Bool
+
+	// Media caches downloaded attachments under DataDir/media. When
+	// PrefetchMedia is set, backfill downloads attachments as it goes.
+	Media         *media.Cache
+	PrefetchMedia bool
+
+	// connMu guards what LoadAndConnect replaces on every connect, which
+	// the pairing goroutine does while handlers read them. Read them with
+	// EventHandler and Supervisor.
+	connMu         sync.Mutex
+	eventHandler   *client.EventHandler
+	supervisor     *Supervisor
+	stopSupervisor context.CancelFunc
+
+	// Pairer pairs a phone from the web UI while serving.
+	Pairer *Pairer
+	// Backfills runs backfills in the background, one at a time.
+	Backfills *BackfillJobs
+	// Sender sends every outgoing message.
+	Sender *Sender
+	// Outbox queues messages that can't be sent yet. Its worker runs only
+	// while serving.
+	Outbox *Outbox
+	// Scheduler sends scheduled messages when they come due. Like the
+	// outbox, it runs only while serving.
+	Scheduler *Scheduler
+	// Webhooks posts incoming messages to webhooks. It too runs only while
+	// serving.
+	Webhooks *Webhooks
+	// Approvals applies the send policy in DataDir/policy.json to messages
+	// agents send, through the MCP tools or with an API token.
+	Approvals *Approvals
+	// Events publishes new messages, status changes and connection changes
+	// as they happen. New sets it and subscribes storage to it; an App built
+	// without it stores nothing the phone sends.
+	Events      *bus.Bus
+	stopStorage func()
 }
 
 func DefaultDataDir() string {
@@ -38,12 +84,14 @@ func New(logger zerolog.Logger) (*App, error) {
 
 	// In demo mode, use a temp DB so we never touch real data
 	dbPath := filepath.Join(dataDir, "messages.db")
+	mediaDir := filepath.Join(dataDir, "media")
 	if os.Getenv("OPENMESSAGES_DEMO") != "" {
 		tmpDir, err := os.MkdirTemp("", "openmessage-demo-*")
 		if err != nil {
 			return nil, fmt.Errorf("create temp dir: %w", err)
 		}
 		dbPath = filepath.Join(tmpDir, "demo.db")
+		mediaDir = filepath.Join(tmpDir, "media")
 	}
 
 	store, err := db.New(dbPath)
@@ -60,14 +108,51 @@ func New(logger zerolog.Logger) (*App, error) {
 		logger.Info().Str("db", dbPath).Msg("Demo mode — seeded fake data")
 	}
 
+	var maxMediaBytes int64
+	if mb := os.Getenv("OPENMESSAGES_MEDIA_CACHE_MB"); mb != "" {
+		n, err := strconv.ParseInt(mb, 10, 64)
+		if err != nil || n <= 0 {
+			store.Close()
+			return nil, fmt.Errorf("invalid OPENMESSAGES_MEDIA_CACHE_MB %q", mb)
+		}
+		maxMediaBytes = n << 20
+	}
+	mediaCache, err := media.New(store, mediaDir, maxMediaBytes, logger)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("open media cache: %w", err)
+	}
+
 	sessionPath := filepath.Join(dataDir, "session.json")
 
+	policy, err := LoadPolicy(filepath.Join(dataDir, "policy.json"))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
 	app := &App{
-		Store:       store,
-		Logger:      logger,
-		DataDir:     dataDir,
-		SessionPath: sessionPath,
+		Clients:       client.NewProvider(nil),
+		Store:         store,
+		Logger:        logger,
+		DataDir:       dataDir,
+		SessionPath:   sessionPath,
+		Media:         mediaCache,
+		PrefetchMedia: os.Getenv("OPENMESSAGES_PREFETCH_MEDIA") != "",
+		Events:        bus.New(eventHistory),
 	}
+	app.Pairer = NewPairer(app)
+	app.Backfills = NewBackfillJobs(app)
+	app.Sender = NewSender(app)
+	app.Outbox = NewOutbox(app)
+	app.Scheduler = NewScheduler(app)
+	app.Webhooks = NewWebhooks(app)
+	app.Approvals = NewApprovals(app, policy)
+
+	// If storage falls too far behind to catch up from the bus, fetch what
+	// it missed from the phone instead.
+	storage := &client.Storage{Store: store, Events: app.Events, Logger: logger}
+	app.stopStorage = app.Events.Consume(storage.Handle, app.CatchUp)
 	return app, nil
 }
 
@@ -81,34 +166,144 @@ func (a *App) LoadAndConnect() error {
 	if err != nil {
 		return fmt.Errorf("create client: %w", err)
 	}
-	a.Client = cli
+	if old := a.Clients.Set(cli); old != nil {
+		old.GM.Disconnect()
+	}
 
-	a.EventHandler = &client.EventHandler{
-		Store:       a.Store,
+	handler := &client.EventHandler{
 		Logger:      a.Logger,
 		SessionPath: a.SessionPath,
-		Client:      cli,
-		OnDisconnect: func() {
-			a.Connected.Store(false)
-			a.Logger.Warn().Msg("Disconnected from Google Messages")
+		Clients:     a.Clients,
+		Events:      a.Events,
+		OnDisconnect: func(err error) {
+			a.setConnected(false)
+			a.Logger.Warn().Err(err).Msg("Disconnected from Google Messages")
+			if s := a.Supervisor(); s != nil {
+				s.Disconnected(err)
+			}
 		},
 	}
-	cli.GM.SetEventHandler(a.EventHandler.Handle)
+	a.connMu.Lock()
+	a.eventHandler = handler
+	a.connMu.Unlock()
+	cli.GM.SetEventHandler(handler.Handle)
+
+	// After pairing from the web UI this replaces an earlier supervisor
+	// that gave up.
+	a.SetSupervisor(NewSupervisor(a.reconnect, a.onReconnected, a.Logger))
 
 	if err := cli.GM.Connect(); err != nil {
 		return fmt.Errorf("connect: %w", err)
 	}
-	a.Connected.Store(true)
+	a.setConnected(true)
 	a.Logger.Info().Msg("Connected to Google Messages")
+	if a.Outbox != nil {
+		a.Outbox.Wake()
+	}
 	return nil
 }
 
+// EventHandler returns the handler of the current client's events, or nil
+// before LoadAndConnect has run.
+func (a *App) EventHandler() *client.EventHandler {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	return a.eventHandler
+}
+
+// Supervisor returns the supervisor that reconnects after the connection
+// drops, or nil before LoadAndConnect has run.
+func (a *App) Supervisor() *Supervisor {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	return a.supervisor
+}
+
+// SetSupervisor stops the current supervisor, if any, and runs s in its
+// place until it is replaced or the app is closed.
+func (a *App) SetSupervisor(s *Supervisor) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.connMu.Lock()
+	if a.stopSupervisor != nil {
+		a.stopSupervisor()
+	}
+	a.supervisor, a.stopSupervisor = s, cancel
+	a.connMu.Unlock()
+	go s.Run(ctx)
+}
+
+// setConnected records whether we're connected and tells listeners.
+func (a *App) setConnected(connected bool) {
+	a.Connected.Store(connected)
+	a.Events.Publish(bus.ConnectionChanged{Connected: connected})
+}
+
+// Client returns the current Google Messages client, or nil when not paired.
+// Resolve it once per operation rather than storing it: pairing and
+// unpairing replace it.
+func (a *App) Client() *client.Client {
+	return a.Clients.Get()
+}
+
+func (a *App) reconnect() error {
+	cli := a.Client()
+	if cli == nil {
+		return ErrUnpaired
+	}
+	return cli.GM.Reconnect()
+}
+
+// onReconnected runs after the supervisor restores the connection. Messages
+// that arrived while we were away aren't replayed by Google, so catch up
+// with a regular backfill.
+func (a *App) onReconnected() {
+	a.setConnected(true)
+	if a.Outbox != nil {
+		a.Outbox.Wake()
+	}
+	a.CatchUp()
+}
+
+// CatchUp fetches, in the background, the messages that arrived since the
+// last sync. If another backfill is running it is left to finish instead.
+func (a *App) CatchUp() {
+	if a.Backfills == nil {
+		go func() {
+			if err := a.Backfill(); err != nil {
+				a.Logger.Warn().Err(err).Msg("Catch-up backfill failed")
+			}
+		}()
+		return
+	}
+	if _, err := a.Backfills.StartRecent(); err != nil {
+		a.Logger.Info().Err(err).Msg("Catch-up backfill not started")
+	}
+}
+
+// ConnectionStatus reports the supervisor's state, or StateDisconnected
+// before LoadAndConnect has run.
+func (a *App) ConnectionStatus() ConnectionStatus {
+	s := a.Supervisor()
+	if s == nil {
+		return ConnectionStatus{State: StateDisconnected, History: []ReconnectEvent{}}
+	}
+	return s.Status()
+}
+
 // Unpair deletes the session file so the app can re-pair.
 func (a *App) Unpair() error {
-	a.Connected.Store(false)
-	if a.Client != nil {
-		a.Client.GM.Disconnect()
-		a.Client = nil
+	a.setConnected(false)
+	a.connMu.Lock()
+	if a.stopSupervisor != nil {
+		a.stopSupervisor()
+	}
+	s := a.supervisor
+	a.connMu.Unlock()
+	if s != nil {
+		s.GiveUp(ErrUnpaired)
+	}
+	if cli := a.Clients.Set(nil); cli != nil {
+		cli.GM.Disconnect()
 	}
 	if err := os.Remove(a.SessionPath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("remove session: %w", err)
@@ -118,8 +313,19 @@ func (a *App) Unpair() error {
 }
 
 func (a *App) Close() {
-	if a.Client != nil {
-		a.Client.GM.Disconnect()
+	a.connMu.Lock()
+	if a.stopSupervisor != nil {
+		a.stopSupervisor()
+	}
+	a.connMu.Unlock()
+	if a.Backfills != nil {
+		a.Backfills.Cancel()
+	}
+	if cli := a.Client(); cli != nil {
+		cli.GM.Disconnect()
+	}
+	if a.stopStorage != nil {
+		a.stopStorage()
 	}
 	if a.Store != nil {
 		a.Store.Close()
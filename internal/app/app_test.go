@@ -0,0 +1,61 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+func TestUnpairClearsClientForEveryone(t *testing.T) {
+	sessionPath := filepath.Join(t.TempDir(), "session.json")
+	if err := os.WriteFile(sessionPath, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		Clients:     client.NewProvider(client.NewForPairing(zerolog.Nop())),
+		Logger:      zerolog.Nop(),
+		SessionPath: sessionPath,
+	}
+	// Anyone holding the provider, like the web handlers, sees the change.
+	shared := a.Clients
+
+	if err := a.Unpair(); err != nil {
+		t.Fatalf("unpair: %v", err)
+	}
+	if a.Client() != nil || shared.Get() != nil {
+		t.Error("client should be cleared after unpair")
+	}
+	if _, err := os.Stat(sessionPath); !os.IsNotExist(err) {
+		t.Error("session file should be deleted")
+	}
+	if err := a.reconnect(); err != ErrUnpaired {
+		t.Errorf("reconnect after unpair: got %v, want ErrUnpaired", err)
+	}
+}
+
+func TestSupervisorSwapWhileReadingStatus(t *testing.T) {
+	a := &App{Logger: zerolog.Nop()}
+	defer a.Close()
+	// Pairing from the web UI replaces the supervisor while handlers read
+	// the connection status; run with -race to check.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			a.SetSupervisor(NewSupervisor(nil, nil, zerolog.Nop()))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		a.ConnectionStatus()
+	}
+	wg.Wait()
+	if st := a.ConnectionStatus(); st.State != StateConnected {
+		t.Errorf("state %q, want %q", st.State, StateConnected)
+	}
+}
@@ -0,0 +1,342 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+var (
+	// ErrNotAllowlisted is returned for an agent send to a recipient that
+	// isn't on the allowlist, in allowlist mode.
+	ErrNotAllowlisted = errors.New("recipient is not on the send allowlist")
+	// ErrRateLimited is wrapped by errors for agent sends over one of the
+	// policy's rate limits.
+	ErrRateLimited = errors.New("send rate limit reached")
+	// ErrApprovalDecided is returned when approving or rejecting an
+	// approval that was already approved or rejected.
+	ErrApprovalDecided = errors.New("approval was already decided")
+)
+
+// Send policy modes.
+const (
+	// PolicyAlways sends what agents ask to send straight away.
+	PolicyAlways = "always"
+	// PolicyApprove holds everything agents ask to send until the user
+	// approves it.
+	PolicyApprove = "approve"
+	// PolicyAllowlist sends to allowlisted contacts and conversations
+	// straight away and refuses to send anywhere else.
+	PolicyAllowlist = "allowlist"
+)
+
+// Policy decides what happens to messages agents ask to send, through the
+// MCP tools or the HTTP API with an API token. Messages sent from the web
+// UI or the command line aren't subject to it.
+type Policy struct {
+	Mode string `json:"mode"`
+	// Contacts (phone numbers) and Conversations (IDs) are the allowlist.
+	// A conversation is also allowlisted when it isn't a group and its
+	// other participant is an allowlisted contact.
+	Contacts      []string `json:"contacts,omitempty"`
+	Conversations []string `json:"conversations,omitempty"`
+	// RateLimit caps agent sends in any mode, counting those held for
+	// approval.
+	RateLimit RateLimit `json:"rate_limit,omitzero"`
+}
+
+// RateLimit is the most sends allowed in any minute, hour and day. Zero
+// means no limit.
+type RateLimit struct {
+	PerMinute int `json:"per_minute,omitempty"`
+	PerHour   int `json:"per_hour,omitempty"`
+	PerDay    int `json:"per_day,omitempty"`
+}
+
+// DefaultPolicy is used without a policy file: every agent send waits for
+// approval.
+var DefaultPolicy = Policy{Mode: PolicyApprove}
+
+// LoadPolicy reads the policy file at path, or returns DefaultPolicy if
+// there is none.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultPolicy, nil
+	}
+	if err != nil {
+		return Policy{}, fmt.Errorf("read send policy: %w", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Policy{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	switch p.Mode {
+	case PolicyAlways, PolicyApprove, PolicyAllowlist:
+	default:
+		return Policy{}, fmt.Errorf("%s: mode is %q; want %q, %q or %q", path, p.Mode, PolicyAlways, PolicyApprove, PolicyAllowlist)
+	}
+	if r := p.RateLimit; r.PerMinute < 0 || r.PerHour < 0 || r.PerDay < 0 {
+		return Policy{}, fmt.Errorf("%s: rate limits can't be negative", path)
+	}
+	return p, nil
+}
+
+// AgentSend is a message an agent asked to send: now, or at SendAt if it
+// isn't zero. As for ScheduleRequest, a DraftID without a Body sends the
+// draft's text.
+type AgentSend struct {
+	ConversationID string
+	PhoneNumber    string
+	Body           string
+	DraftID        string
+	SendAt         time.Time
+}
+
+// Approvals applies the send policy to messages agents ask to send, and
+// hands on the ones held for approval once the user approves them.
+type Approvals struct {
+	app    *App
+	policy Policy
+
+	mu     sync.Mutex
+	recent []time.Time // agent sends in the last day, oldest first
+
+	// Swapped out in tests.
+	now func() time.Time
+}
+
+// NewApprovals returns Approvals applying p.
+func NewApprovals(a *App, p Policy) *Approvals {
+	return &Approvals{app: a, policy: p, now: time.Now}
+}
+
+// Policy returns the policy in force.
+func (ap *Approvals) Policy() Policy {
+	return ap.policy
+}
+
+// Check applies the policy to req. It returns nil, nil if req may be sent
+// straight away, and the pending approval if it has to wait for the user.
+// A send that may not happen at all returns an error wrapping
+// ErrNotAllowlisted or ErrRateLimited.
+func (ap *Approvals) Check(req AgentSend) (*db.Approval, error) {
+	var reason string
+	switch ap.policy.Mode {
+	case PolicyAlways:
+	case PolicyAllowlist:
+		if !ap.allowlisted(req) {
+			return nil, ErrNotAllowlisted
+		}
+	default:
+		reason = "the send policy requires approval"
+	}
+	if err := ap.take(); err != nil {
+		return nil, err
+	}
+	if reason == "" {
+		return nil, nil
+	}
+
+	now := ap.now().UnixMilli()
+	a := &db.Approval{
+		ConversationID: req.ConversationID,
+		PhoneNumber:    req.PhoneNumber,
+		Body:           req.Body,
+		DraftID:        req.DraftID,
+		Reason:         reason,
+		State:          db.ApprovalPending,
+		CreatedAt:      now,
+	}
+	if !req.SendAt.IsZero() {
+		a.SendAt = req.SendAt.UnixMilli()
+	}
+	if err := ap.app.Store.AddApproval(a); err != nil {
+		return nil, fmt.Errorf("save approval: %w", err)
+	}
+	ap.app.Events.Publish(bus.ApprovalUpdated{Approval: a})
+	return a, nil
+}
+
+// take counts a send against the rate limits, or returns an error wrapping
+// ErrRateLimited if it would go over one.
+func (ap *Approvals) take() error {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	now := ap.now()
+	for len(ap.recent) > 0 && now.Sub(ap.recent[0]) >= 24*time.Hour {
+		ap.recent = ap.recent[1:]
+	}
+	for _, limit := range []struct {
+		n      int
+		window time.Duration
+		name   string
+	}{
+		{ap.policy.RateLimit.PerMinute, time.Minute, "minute"},
+		{ap.policy.RateLimit.PerHour, time.Hour, "hour"},
+		{ap.policy.RateLimit.PerDay, 24 * time.Hour, "day"},
+	} {
+		if limit.n == 0 {
+			continue
+		}
+		count := 0
+		for _, t := range ap.recent {
+			if now.Sub(t) < limit.window {
+				count++
+			}
+		}
+		if count >= limit.n {
+			return fmt.Errorf("%w: at most %d per %s", ErrRateLimited, limit.n, limit.name)
+		}
+	}
+	ap.recent = append(ap.recent, now)
+	return nil
+}
+
+// allowlisted reports whether req goes to an allowlisted conversation or
+// contact.
+func (ap *Approvals) allowlisted(req AgentSend) bool {
+	if req.ConversationID != "" {
+		if slices.Contains(ap.policy.Conversations, req.ConversationID) {
+			return true
+		}
+		// Conversations we don't know of aren't allowlisted.
+		conv, err := ap.app.Store.GetConversation(req.ConversationID)
+		if err != nil || conv.IsGroup {
+			return false
+		}
+		var participants []struct {
+			Number string `json:"number"`
+			IsMe   bool   `json:"is_me"`
+		}
+		json.Unmarshal([]byte(conv.Participants), &participants)
+		others := 0
+		for _, p := range participants {
+			if p.IsMe {
+				continue
+			}
+			if !ap.contact(p.Number) {
+				return false
+			}
+			others++
+		}
+		return others > 0
+	}
+	return ap.contact(req.PhoneNumber)
+}
+
+func (ap *Approvals) contact(number string) bool {
+	for _, c := range ap.policy.Contacts {
+		if db.SamePhone(c, number) {
+			return true
+		}
+	}
+	return false
+}
+
+// Approve hands a pending approval on: to the outbox to send now, or to
+// the scheduler if its send time is still to come. It returns nil, nil if
+// there is no such approval and ErrApprovalDecided if it isn't pending. An
+// approval that can't be handed on is marked failed rather than returning
+// an error.
+func (ap *Approvals) Approve(id int64) (*db.Approval, error) {
+	a, err := ap.decide(id, db.ApprovalApproved)
+	if a == nil || err != nil {
+		return a, err
+	}
+
+	if a.SendAt <= ap.now().UnixMilli() {
+		err = ap.sendNow(a)
+	} else if ap.app.Scheduler == nil {
+		err = errors.New("scheduling is not available")
+	} else {
+		var m *db.ScheduledMessage
+		m, err = ap.app.Scheduler.Schedule(ScheduleRequest{
+			ConversationID: a.ConversationID,
+			Body:           a.Body,
+			DraftID:        a.DraftID,
+			SendAt:         time.UnixMilli(a.SendAt),
+		})
+		if err == nil {
+			a.ScheduledID = m.ID
+		}
+	}
+	if err != nil {
+		a.State = db.ApprovalFailed
+		a.LastError = err.Error()
+	}
+	if err := ap.app.Store.UpdateApproval(a); err != nil {
+		return nil, fmt.Errorf("save approval: %w", err)
+	}
+	ap.app.Events.Publish(bus.ApprovalUpdated{Approval: a})
+	return a, nil
+}
+
+// sendNow sends an approved message straight away, through the outbox if
+// there is one. A draft it was sent from is deleted once it's handed on,
+// as when the user sends a draft.
+func (ap *Approvals) sendNow(a *db.Approval) error {
+	body := a.Body
+	if body == "" && a.DraftID != "" {
+		d, err := ap.app.Store.GetDraft(a.DraftID)
+		if err != nil {
+			return fmt.Errorf("get draft: %w", err)
+		}
+		if d == nil {
+			return errors.New("the draft was deleted before it could be sent")
+		}
+		body = d.Body
+	}
+
+	req := SendRequest{ConversationID: a.ConversationID, PhoneNumber: a.PhoneNumber, Body: body}
+	if ap.app.Outbox != nil {
+		it, err := ap.app.Outbox.Enqueue(req)
+		if err != nil {
+			return err
+		}
+		a.OutboxID = it.ID
+	} else if _, err := ap.app.Sender.Send(req); err != nil {
+		return err
+	}
+	if a.DraftID != "" {
+		if err := ap.app.Store.DeleteDraft(a.DraftID); err != nil {
+			ap.app.Logger.Warn().Err(err).Str("draft_id", a.DraftID).Msg("Failed to delete sent draft")
+		}
+	}
+	return nil
+}
+
+// Reject drops a pending approval without sending it. Like Approve, it
+// returns nil, nil if there is no such approval and ErrApprovalDecided if
+// it isn't pending.
+func (ap *Approvals) Reject(id int64) (*db.Approval, error) {
+	a, err := ap.decide(id, db.ApprovalRejected)
+	if a == nil || err != nil {
+		return a, err
+	}
+	ap.app.Events.Publish(bus.ApprovalUpdated{Approval: a})
+	return a, nil
+}
+
+// decide moves a pending approval to state and returns it as it now is.
+func (ap *Approvals) decide(id int64, state string) (*db.Approval, error) {
+	ok, err := ap.app.Store.DecideApproval(id, state, ap.now().UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("save approval: %w", err)
+	}
+	a, err := ap.app.Store.GetApproval(id)
+	if err != nil {
+		return nil, fmt.Errorf("get approval: %w", err)
+	}
+	if a != nil && !ok {
+		return nil, ErrApprovalDecided
+	}
+	return a, nil
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func testApprovals(t *testing.T, p Policy) (*Approvals, *time.Time) {
+	t.Helper()
+	a := newBackfillApp(t)
+	a.Outbox = NewOutbox(a)
+	a.Scheduler = NewScheduler(a)
+	ap := NewApprovals(a, p)
+	now := time.UnixMilli(1_000_000)
+	ap.now = func() time.Time { return now }
+	a.Scheduler.now = ap.now
+	return ap, &now
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.json")
+	if p, err := LoadPolicy(path); err != nil || p.Mode != PolicyApprove {
+		t.Errorf("no file: %+v, %v; want approval for everything", p, err)
+	}
+
+	os.WriteFile(path, []byte(`{"mode": "allowlist", "contacts": ["+15551234567"], "rate_limit": {"per_hour": 10}}`), 0600)
+	p, err := LoadPolicy(path)
+	if err != nil || p.Mode != PolicyAllowlist || len(p.Contacts) != 1 || p.RateLimit.PerHour != 10 {
+		t.Errorf("allowlist: %+v, %v", p, err)
+	}
+
+	for _, bad := range []string{`{"mode": "yolo"}`, `{}`, `{"mode": "always", "rate_limit": {"per_day": -1}}`, `not json`} {
+		os.WriteFile(path, []byte(bad), 0600)
+		if _, err := LoadPolicy(path); err == nil {
+			t.Errorf("%s: loaded", bad)
+		}
+	}
+}
+
+func TestApprovalsHoldUntilApproved(t *testing.T) {
+	ap, now := testApprovals(t, Policy{Mode: PolicyApprove})
+
+	pending, err := ap.Check(AgentSend{PhoneNumber: "+15551234567", Body: "hello"})
+	if err != nil || pending == nil || pending.State != db.ApprovalPending {
+		t.Fatalf("check: %+v, %v", pending, err)
+	}
+	if items, _ := ap.app.Store.ListOutbox("", 10); len(items) != 0 {
+		t.Fatal("sent before approval")
+	}
+
+	a, err := ap.Approve(pending.ID)
+	if err != nil || a.State != db.ApprovalApproved || a.OutboxID == 0 {
+		t.Fatalf("approve: %+v, %v", a, err)
+	}
+	if it, _ := ap.app.Store.GetOutboxItem(a.OutboxID); it == nil || it.Body != "hello" || it.PhoneNumber != "+15551234567" {
+		t.Errorf("queued: %+v", it)
+	}
+	if _, err := ap.Reject(pending.ID); !errors.Is(err, ErrApprovalDecided) {
+		t.Errorf("reject after approving: %v", err)
+	}
+	if a, err := ap.Approve(99); a != nil || err != nil {
+		t.Errorf("approve a missing approval: %+v, %v", a, err)
+	}
+
+	// A scheduled send is scheduled once approved, and rejecting one drops it.
+	scheduled, _ := ap.Check(AgentSend{ConversationID: "c1", Body: "later", SendAt: now.Add(time.Hour)})
+	rejected, _ := ap.Check(AgentSend{ConversationID: "c1", Body: "never", SendAt: now.Add(time.Hour)})
+	if a, err := ap.Approve(scheduled.ID); err != nil || a.ScheduledID == 0 {
+		t.Errorf("approve scheduled: %+v, %v", a, err)
+	}
+	if a, err := ap.Reject(rejected.ID); err != nil || a.State != db.ApprovalRejected {
+		t.Errorf("reject: %+v, %v", a, err)
+	}
+	if msgs, _ := ap.app.Store.ListScheduledMessages("", "", 10); len(msgs) != 1 || msgs[0].Body != "later" {
+		t.Errorf("scheduled: %+v", msgs)
+	}
+
+	// Approved after its send time: it's sent now instead.
+	late, _ := ap.Check(AgentSend{ConversationID: "c1", Body: "running late", SendAt: now.Add(time.Minute)})
+	*now = now.Add(time.Hour)
+	if a, err := ap.Approve(late.ID); err != nil || a.State != db.ApprovalApproved || a.OutboxID == 0 || a.ScheduledID != 0 {
+		t.Errorf("approve late: %+v, %v", a, err)
+	}
+}
+
+func TestApprovalsSendDraft(t *testing.T) {
+	ap, _ := testApprovals(t, Policy{Mode: PolicyApprove})
+	store := ap.app.Store
+	store.UpsertDraft(&db.Draft{DraftID: "d1", ConversationID: "c1", Body: "draft text"})
+	store.UpsertDraft(&db.Draft{DraftID: "d2", ConversationID: "c1", Body: "gone soon"})
+
+	pending, _ := ap.Check(AgentSend{ConversationID: "c1", DraftID: "d1"})
+	a, err := ap.Approve(pending.ID)
+	if err != nil || a.State != db.ApprovalApproved {
+		t.Fatalf("approve: %+v, %v", a, err)
+	}
+	if it, _ := store.GetOutboxItem(a.OutboxID); it == nil || it.Body != "draft text" {
+		t.Errorf("queued: %+v", it)
+	}
+	if d, _ := store.GetDraft("d1"); d != nil {
+		t.Errorf("sent draft wasn't deleted: %+v", d)
+	}
+
+	// A draft deleted while its send waited for approval fails it.
+	pending, _ = ap.Check(AgentSend{ConversationID: "c1", DraftID: "d2"})
+	store.DeleteDraft("d2")
+	if a, err := ap.Approve(pending.ID); err != nil || a.State != db.ApprovalFailed || a.OutboxID != 0 {
+		t.Errorf("approve deleted draft: %+v, %v", a, err)
+	}
+}
+
+func TestApprovalsAllowlist(t *testing.T) {
+	ap, _ := testApprovals(t, Policy{
+		Mode:          PolicyAllowlist,
+		Contacts:      []string{"+1 (555) 123-4567"},
+		Conversations: []string{"group"},
+	})
+	store := ap.app.Store
+	store.UpsertConversation(&db.Conversation{ConversationID: "alice", Participants: `[{"name":"Alice","number":"+15551234567"},{"name":"Me","number":"+15550000000","is_me":true}]`})
+	store.UpsertConversation(&db.Conversation{ConversationID: "bob", Participants: `[{"name":"Bob","number":"+15559876543"}]`})
+	store.UpsertConversation(&db.Conversation{ConversationID: "group", IsGroup: true})
+	store.UpsertConversation(&db.Conversation{ConversationID: "other-group", IsGroup: true, Participants: `[{"name":"Alice","number":"+15551234567"}]`})
+
+	for _, c := range []struct {
+		name string
+		req  AgentSend
+		ok   bool
+	}{
+		{"contact", AgentSend{PhoneNumber: "+15551234567"}, true},
+		{"contact, written differently", AgentSend{PhoneNumber: "555-123-4567"}, true},
+		{"conversation with a contact", AgentSend{ConversationID: "alice"}, true},
+		{"listed conversation", AgentSend{ConversationID: "group"}, true},
+		{"stranger", AgentSend{PhoneNumber: "+15559876543"}, false},
+		{"conversation with a stranger", AgentSend{ConversationID: "bob"}, false},
+		{"group with a contact", AgentSend{ConversationID: "other-group"}, false},
+		{"unknown conversation", AgentSend{ConversationID: "nope"}, false},
+	} {
+		c.req.Body = "hi"
+		pending, err := ap.Check(c.req)
+		if pending != nil {
+			t.Errorf("%s: held for approval", c.name)
+		}
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.ok && !errors.Is(err, ErrNotAllowlisted) {
+			t.Errorf("%s: got %v, want ErrNotAllowlisted", c.name, err)
+		}
+	}
+}
+
+func TestApprovalsRateLimit(t *testing.T) {
+	ap, now := testApprovals(t, Policy{Mode: PolicyApprove, RateLimit: RateLimit{PerMinute: 2, PerHour: 3}})
+	send := func() error {
+		_, err := ap.Check(AgentSend{PhoneNumber: "+15551234567", Body: "hi"})
+		return err
+	}
+
+	for i := range 2 {
+		if err := send(); err != nil {
+			t.Fatalf("send %d: %v", i+1, err)
+		}
+	}
+	if err := send(); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("third send in a minute: %v", err)
+	}
+	*now = now.Add(time.Minute)
+	if err := send(); err != nil {
+		t.Fatalf("next minute: %v", err)
+	}
+	*now = now.Add(time.Minute)
+	if err := send(); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("fourth send in an hour: %v", err)
+	}
+	*now = now.Add(time.Hour)
+	if err := send(); err != nil {
+		t.Fatalf("next hour: %v", err)
+	}
+	// Refused sends don't count.
+	if pending, _ := ap.app.Store.ListApprovals(db.ApprovalPending, 10); len(pending) != 4 {
+		t.Errorf("got %d pending approvals, want 4", len(pending))
+	}
+}
@@ -1,9 +1,11 @@
 package app
 
 import (
+	"cmp"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
@@ -11,170 +13,377 @@ import (
 	"github.com/maxghenis/openmessage/internal/db"
 )
 
-// Backfill fetches existing conversations and recent messages from
-// Google Messages and stores them in the local database.
+// Page sizes for backfill. A conversation seen for the first time gets one
+// page of recent messages; older history is fetched on request.
+const (
+	recentPageSize  = 20
+	historyPageSize = 50
+
+	conversationPageSize = 100
+	// maxConversations bounds listAllConversations' window per folder.
+	maxConversations = 12800
+)
+
+// messageSource is the part of the Google Messages client that backfill
+// reads from. *libgm.Client implements it.
+type messageSource interface {
+	ListConversations(count int, folder gmproto.ListConversationsRequest_Folder) (*gmproto.ListConversationsResponse, error)
+	FetchMessages(conversationID string, count int64, cursor *gmproto.Cursor) (*gmproto.ListMessagesResponse, error)
+}
+
+// Backfill fetches existing conversations and the messages that arrived
+// since the last sync, and stores them in the local database. Conversations
+// never synced before get a page of recent messages.
 func (a *App) Backfill() error {
-	if a.Client == nil {
-		return fmt.Errorf("client not connected")
+	cli := a.Client()
+	if cli == nil {
+		return ErrNotConnected
 	}
+	return a.backfill(nil, cli.GM)
+}
 
+func (a *App) backfill(run *backfillRun, src messageSource) error {
 	a.Logger.Info().Msg("Starting backfill of conversations and messages")
 
-	resp, err := a.Client.GM.ListConversations(100, gmproto.ListConversationsRequest_INBOX)
+	resp, err := src.ListConversations(conversationPageSize, gmproto.ListConversationsRequest_INBOX)
 	if err != nil {
 		return fmt.Errorf("list conversations: %w", err)
 	}
 
 	convos := resp.GetConversations()
 	a.Logger.Info().Int("count", len(convos)).Msg("Fetched conversations")
+	run.update(func(p *BackfillProgress) { p.ConversationsTotal = len(convos) })
 
+	total := 0
 	for _, conv := range convos {
-		if err := a.storeConversation(conv); err != nil {
-			a.Logger.Error().Err(err).Str("conv_id", conv.GetConversationID()).Msg("Failed to store conversation")
-			continue
+		if err := run.cancelled(); err != nil {
+			return err
 		}
-
-		// Fetch recent messages for each conversation
-		msgResp, err := a.Client.GM.FetchMessages(conv.GetConversationID(), 20, nil)
-		if err != nil {
-			a.Logger.Warn().Err(err).Str("conv_id", conv.GetConversationID()).Msg("Failed to fetch messages")
+		convID := conv.GetConversationID()
+		run.update(func(p *BackfillProgress) { p.Current = convID })
+		if err := a.storeConversation(conv, db.FolderInbox); err != nil {
+			a.Logger.Error().Err(err).Str("conv_id", convID).Msg("Failed to store conversation")
+			run.fail(convID, err)
 			continue
 		}
 
-		for _, msg := range msgResp.GetMessages() {
-			a.storeMessage(msg)
+		_, n, err := a.syncRecent(run, src, convID, conv.GetLastMessageTimestamp()/1000)
+		total += n
+		if err != nil {
+			a.Logger.Warn().Err(err).Str("conv_id", convID).Msg("Failed to fetch messages")
+			run.fail(convID, err)
 		}
+		run.update(func(p *BackfillProgress) { p.ConversationsDone++ })
 	}
 
-	a.Logger.Info().Int("conversations", len(convos)).Msg("Backfill complete")
+	a.Logger.Info().Int("conversations", len(convos)).Int("messages", total).Msg("Backfill complete")
 	return nil
 }
 
-// DeepBackfill fetches ALL conversations and ALL messages with pagination.
-// Runs in the background and logs progress.
+// DeepBackfill fetches ALL conversations in every folder and ALL their
+// messages with pagination. It resumes from each conversation's
+// checkpoint, so history fetched by an earlier run (even one that crashed)
+// isn't fetched again. Use Backfills to run it in the background with
+// progress and cancellation.
 func (a *App) DeepBackfill() {
-	if a.Client == nil {
+	cli := a.Client()
+	if cli == nil {
 		a.Logger.Error().Msg("Deep backfill: client not connected")
 		return
 	}
+	a.deepBackfill(nil, cli.GM)
+}
 
-	a.Logger.Info().Msg("Starting deep backfill of all messages")
+// backfillFolders are the folders DeepBackfill walks, with the name each
+// is stored under.
+var backfillFolders = []struct {
+	folder gmproto.ListConversationsRequest_Folder
+	name   string
+}{
+	{gmproto.ListConversationsRequest_INBOX, db.FolderInbox},
+	{gmproto.ListConversationsRequest_ARCHIVE, db.FolderArchive},
+	{gmproto.ListConversationsRequest_SPAM_BLOCKED, db.FolderSpamBlocked},
+}
 
-	totalConvos := 0
-	totalMsgs := 0
+func (a *App) deepBackfill(run *backfillRun, src messageSource) error {
+	a.Logger.Info().Msg("Starting deep backfill of all messages")
 
-	// Paginate through all conversations
-	var cursor *gmproto.Cursor
-	for {
-		resp, err := a.Client.GM.ListConversations(100, gmproto.ListConversationsRequest_INBOX)
-		if err != nil {
-			a.Logger.Error().Err(err).Msg("Deep backfill: list conversations failed")
-			break
+	// List every folder first so progress has a total to count towards.
+	type listed struct {
+		conv   *gmproto.Conversation
+		folder string
+	}
+	var all []listed
+	for _, f := range backfillFolders {
+		if err := run.cancelled(); err != nil {
+			return err
 		}
-
-		convos := resp.GetConversations()
-		if len(convos) == 0 {
-			break
+		convos, err := a.listAllConversations(src, f.folder)
+		if err != nil {
+			a.Logger.Error().Err(err).Str("folder", f.name).Msg("Deep backfill: list conversations failed")
+			run.fail("", fmt.Errorf("list %s: %w", f.name, err))
+			continue
 		}
-
+		a.Logger.Info().Str("folder", f.name).Int("count", len(convos)).Msg("Deep backfill: fetched conversations")
 		for _, conv := range convos {
-			if err := a.storeConversation(conv); err != nil {
-				a.Logger.Error().Err(err).Str("conv_id", conv.GetConversationID()).Msg("Deep backfill: store conversation failed")
-				continue
-			}
-			totalConvos++
+			all = append(all, listed{conv, f.name})
+		}
+		run.update(func(p *BackfillProgress) { p.ConversationsTotal = len(all) })
+	}
 
-			// Paginate through all messages in this conversation
-			n := a.deepBackfillConversation(conv.GetConversationID())
-			totalMsgs += n
+	totalConvos := 0
+	totalMsgs := 0
+	for _, l := range all {
+		if err := run.cancelled(); err != nil {
+			return err
 		}
+		convID := l.conv.GetConversationID()
+		run.update(func(p *BackfillProgress) { p.Current = convID })
+		if err := a.storeConversation(l.conv, l.folder); err != nil {
+			a.Logger.Error().Err(err).Str("conv_id", convID).Msg("Deep backfill: store conversation failed")
+			run.fail(convID, err)
+			continue
+		}
+		totalConvos++
 
-		cursor = resp.GetCursor()
-		if cursor == nil {
-			break
+		n, err := a.backfillConversation(run, src, convID, 0)
+		totalMsgs += n
+		if err != nil && run.cancelled() == nil {
+			a.Logger.Warn().Err(err).Str("conv_id", convID).Msg("Deep backfill: fetch messages failed")
+			run.fail(convID, err)
 		}
-		// ListConversations doesn't take a cursor param in the current API,
-		// so we only get the first page. Break after first batch.
-		break
+		run.update(func(p *BackfillProgress) { p.ConversationsDone++ })
 	}
 
 	a.Logger.Info().
 		Int("conversations", totalConvos).
 		Int("messages", totalMsgs).
 		Msg("Deep backfill complete")
+	return nil
 }
 
-// deepBackfillConversation fetches all messages in a conversation using cursor pagination.
-func (a *App) deepBackfillConversation(convID string) int {
-	total := 0
-	var cursor *gmproto.Cursor
-
-	for {
-		resp, err := a.Client.GM.FetchMessages(convID, 50, cursor)
+// listAllConversations returns every conversation in a folder, up to
+// maxConversations. The request has a cursor, but libgm's ListConversations
+// doesn't let us set it, so instead of paging this asks for a window twice
+// as large each time until the phone returns fewer conversations than
+// asked for, or nothing new.
+func (a *App) listAllConversations(src messageSource, folder gmproto.ListConversationsRequest_Folder) ([]*gmproto.Conversation, error) {
+	seen := make(map[string]bool)
+	var all []*gmproto.Conversation
+	for count := conversationPageSize; ; count *= 2 {
+		resp, err := src.ListConversations(count, folder)
 		if err != nil {
-			a.Logger.Warn().Err(err).Str("conv_id", convID).Msg("Deep backfill: fetch messages failed")
-			break
+			if len(all) > 0 {
+				// Keep what the smaller windows returned.
+				a.Logger.Warn().Err(err).Int("count", count).Msg("Deep backfill: listing more conversations failed")
+				return all, nil
+			}
+			return nil, err
+		}
+		convos := resp.GetConversations()
+		added := 0
+		for _, conv := range convos {
+			if id := conv.GetConversationID(); !seen[id] {
+				seen[id] = true
+				all = append(all, conv)
+				added++
+			}
+		}
+		if len(convos) < count || added == 0 {
+			return all, nil
 		}
+		if count >= maxConversations {
+			a.Logger.Warn().Stringer("folder", folder).Int("count", len(all)).Msg("Deep backfill: conversation limit reached, older conversations not listed")
+			return all, nil
+		}
+	}
+}
+
+// BackfillConversation fetches a conversation's new messages and then its
+// history back to until, or all of it if until is zero. It returns the
+// number of messages fetched.
+func (a *App) BackfillConversation(convID string, until time.Time) (int, error) {
+	cli := a.Client()
+	if cli == nil {
+		return 0, ErrNotConnected
+	}
+	return a.backfillConversation(nil, cli.GM, convID, untilMS(until))
+}
+
+func untilMS(until time.Time) int64 {
+	if until.IsZero() {
+		return 0
+	}
+	return until.UnixMilli()
+}
+
+func (a *App) backfillConversation(run *backfillRun, src messageSource, convID string, untilMS int64) (int, error) {
+	st, total, err := a.syncRecent(run, src, convID, 0)
+	if err != nil {
+		return total, err
+	}
+	n, err := a.syncHistory(run, src, st, untilMS)
+	total += n
+	if total > 0 {
+		a.Logger.Info().
+			Str("conv_id", convID).
+			Int("messages", total).
+			Bool("complete", st.Complete).
+			Msg("Deep backfill: conversation complete")
+	}
+	return total, err
+}
 
+// syncRecent fetches the messages newer than the conversation's checkpoint,
+// paging back until it reaches messages already synced. lastMessageMS, if
+// known, lets it skip conversations with nothing new. The first sync of a
+// conversation fetches a single page and records where older history
+// continues.
+func (a *App) syncRecent(run *backfillRun, src messageSource, convID string, lastMessageMS int64) (*db.SyncState, int, error) {
+	st, err := a.Store.GetSyncState(convID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("load sync state: %w", err)
+	}
+
+	if st == nil || st.NewestTS == 0 {
+		st = &db.SyncState{ConversationID: convID}
+		resp, err := src.FetchMessages(convID, recentPageSize, nil)
+		if err != nil {
+			return st, 0, err
+		}
 		msgs := resp.GetMessages()
-		if len(msgs) == 0 {
-			break
+		if err := a.storePage(st, msgs); err != nil {
+			return st, 0, err
 		}
+		run.fetched(len(msgs))
+		advanceCursor(st, resp.GetCursor(), len(msgs))
+		return st, len(msgs), a.saveSyncState(st)
+	}
+
+	if lastMessageMS > 0 && lastMessageMS <= st.NewestTS {
+		return st, 0, nil
+	}
 
+	known := st.NewestTS
+	total := 0
+	var cursor *gmproto.Cursor
+	for {
+		if err := run.cancelled(); err != nil {
+			return st, total, err
+		}
+		resp, err := src.FetchMessages(convID, recentPageSize, cursor)
+		if err != nil {
+			return st, total, err
+		}
+		msgs := resp.GetMessages()
+		// Older messages on the page are already stored, and storing them
+		// again would move OldestTS away from the history cursor.
+		var fresh []*gmproto.Message
 		for _, msg := range msgs {
-			a.storeMessage(msg)
-			total++
+			if msg.GetTimestamp()/1000 > known {
+				fresh = append(fresh, msg)
+			}
+		}
+		reachedKnown := len(fresh) < len(msgs)
+		if err := a.storePage(st, fresh); err != nil {
+			return st, total, err
 		}
+		run.fetched(len(fresh))
+		total += len(fresh)
 
 		cursor = resp.GetCursor()
-		if cursor == nil {
+		if reachedKnown || len(msgs) == 0 || cursor == nil {
 			break
 		}
-
 		a.Logger.Debug().
 			Str("conv_id", convID).
-			Int("batch", len(msgs)).
 			Int("total_so_far", total).
-			Msg("Deep backfill: fetched message batch")
+			Msg("Backfill: fetched gap batch")
 	}
+	// NewestTS only moves once the whole gap is stored, so a crash part way
+	// through fetches the gap again rather than leaving a hole.
+	return st, total, a.saveSyncState(st)
+}
 
-	if total > 0 {
-		a.Logger.Info().
-			Str("conv_id", convID).
-			Int("messages", total).
-			Msg("Deep backfill: conversation complete")
-	}
+// syncHistory pages back from the conversation's cursor until it reaches
+// untilMS (0 for the start of the conversation), checkpointing after every
+// page so an interrupted run resumes where it stopped.
+func (a *App) syncHistory(run *backfillRun, src messageSource, st *db.SyncState, untilMS int64) (int, error) {
+	total := 0
+	for !st.Complete && (untilMS == 0 || st.OldestTS == 0 || st.OldestTS > untilMS) {
+		if err := run.cancelled(); err != nil {
+			return total, err
+		}
+		var cursor *gmproto.Cursor
+		if st.CursorItemID != "" || st.CursorTS != 0 {
+			cursor = &gmproto.Cursor{LastItemID: st.CursorItemID, LastItemTimestamp: st.CursorTS}
+		}
+		resp, err := src.FetchMessages(st.ConversationID, historyPageSize, cursor)
+		if err != nil {
+			return total, err
+		}
+		msgs := resp.GetMessages()
+		if err := a.storePage(st, msgs); err != nil {
+			return total, err
+		}
+		advanceCursor(st, resp.GetCursor(), len(msgs))
+		if err := a.saveSyncState(st); err != nil {
+			return total, err
+		}
+		run.fetched(len(msgs))
+		total += len(msgs)
 
-	return total
+		a.Logger.Debug().
+			Str("conv_id", st.ConversationID).
+			Int("batch", len(msgs)).
+			Int("total_so_far", total).
+			Msg("Deep backfill: fetched message batch")
+	}
+	return total, nil
 }
 
-func (a *App) storeConversation(conv *gmproto.Conversation) error {
-	participantsJSON := "[]"
-	if ps := conv.GetParticipants(); len(ps) > 0 {
-		type pInfo struct {
-			Name   string `json:"name"`
-			Number string `json:"number"`
-			IsMe   bool   `json:"is_me,omitempty"`
+// storePage stores a page of messages and widens the synced range to
+// cover them. If a message can't be stored it returns the error, and the
+// caller must not checkpoint st, so the page is fetched again next time.
+func (a *App) storePage(st *db.SyncState, msgs []*gmproto.Message) error {
+	for _, msg := range msgs {
+		if err := a.storeMessage(msg); err != nil {
+			return fmt.Errorf("store message %s: %w", msg.GetMessageID(), err)
 		}
-		var infos []pInfo
-		for _, p := range ps {
-			info := pInfo{
-				Name: p.GetFullName(),
-				IsMe: p.GetIsMe(),
-			}
-			if id := p.GetID(); id != nil {
-				info.Number = id.GetNumber()
-			}
-			if info.Number == "" {
-				info.Number = p.GetFormattedNumber()
-			}
-			infos = append(infos, info)
+		ts := msg.GetTimestamp() / 1000
+		if ts > st.NewestTS {
+			st.NewestTS = ts
 		}
-		if b, err := json.Marshal(infos); err == nil {
-			participantsJSON = string(b)
+		if st.OldestTS == 0 || ts < st.OldestTS {
+			st.OldestTS = ts
 		}
 	}
+	return nil
+}
+
+// advanceCursor points st at the page after one of n messages whose
+// response carried next.
+func advanceCursor(st *db.SyncState, next *gmproto.Cursor, n int) {
+	if next == nil || n == 0 {
+		st.CursorItemID, st.CursorTS = "", 0
+		st.Complete = true
+		return
+	}
+	st.CursorItemID = next.GetLastItemID()
+	st.CursorTS = next.GetLastItemTimestamp()
+}
+
+func (a *App) saveSyncState(st *db.SyncState) error {
+	st.UpdatedAt = time.Now().UnixMilli()
+	if err := a.Store.PutSyncState(st); err != nil {
+		return fmt.Errorf("save sync state: %w", err)
+	}
+	return nil
+}
 
+// storeConversation stores a conversation listed in folder. The
+// conversation's own status wins if it names a folder.
+func (a *App) storeConversation(conv *gmproto.Conversation, folder string) error {
 	unread := 0
 	if conv.GetUnread() {
 		unread = 1
@@ -184,20 +393,18 @@ func (a *App) storeConversation(conv *gmproto.Conversation) error {
 		ConversationID: conv.GetConversationID(),
 		Name:           conv.GetName(),
 		IsGroup:        conv.GetIsGroupChat(),
-		Participants:   participantsJSON,
+		Participants:   client.ExtractParticipantsJSON(conv),
 		LastMessageTS:  conv.GetLastMessageTimestamp() / 1000,
 		UnreadCount:    unread,
+		Folder:         cmp.Or(client.ExtractFolder(conv), folder),
 	})
 }
 
-func (a *App) storeMessage(msg *gmproto.Message) {
+func (a *App) storeMessage(msg *gmproto.Message) error {
 	body := client.ExtractMessageBody(msg)
 	senderName, senderNumber := client.ExtractSenderInfo(msg)
 
-	status := "unknown"
-	if ms := msg.GetMessageStatus(); ms != nil {
-		status = ms.GetStatus().String()
-	}
+	status, statusErr := client.ExtractStatus(msg)
 
 	dbMsg := &db.Message{
 		MessageID:      msg.GetMessageID(),
@@ -207,6 +414,7 @@ func (a *App) storeMessage(msg *gmproto.Message) {
 		Body:           body,
 		TimestampMS:    msg.GetTimestamp() / 1000,
 		Status:         status,
+		StatusError:    statusErr,
 		IsFromMe:       msg.GetSenderParticipant() != nil && msg.GetSenderParticipant().GetIsMe(),
 	}
 
@@ -222,8 +430,18 @@ func (a *App) storeMessage(msg *gmproto.Message) {
 		}
 	}
 	dbMsg.ReplyToID = client.ExtractReplyToID(msg)
+	if dbMsg.IsFromMe {
+		dbMsg.TmpID = msg.GetTmpID()
+	}
 
-	if err := a.Store.UpsertMessage(dbMsg); err != nil {
-		a.Logger.Error().Err(err).Str("msg_id", dbMsg.MessageID).Msg("Failed to store backfill message")
+	if _, err := a.Store.ReconcileMessage(dbMsg); err != nil {
+		return err
 	}
+
+	if cli := a.Client(); a.PrefetchMedia && a.Media != nil && cli != nil && dbMsg.MediaID != "" {
+		if err := a.Media.Prefetch(dbMsg, cli.GM.DownloadMedia); err != nil {
+			a.Logger.Warn().Err(err).Str("msg_id", dbMsg.MessageID).Msg("Failed to prefetch media")
+		}
+	}
+	return nil
 }
@@ -1,9 +1,16 @@
 package app
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
 	"github.com/maxghenis/openmessage/internal/db"
 )
@@ -73,3 +80,326 @@ func TestBackfillPopulatesDB(t *testing.T) {
 		t.Fatalf("got body %q", msgs[0].Body)
 	}
 }
+
+// fakeSource serves conversations whose messages are held newest first and
+// paged like Google's cursors: each cursor names the last message returned.
+type fakeSource struct {
+	// convs are in the inbox; folders holds the other folders.
+	convs   []*gmproto.Conversation
+	folders map[gmproto.ListConversationsRequest_Folder][]*gmproto.Conversation
+	msgs    map[string][]*gmproto.Message
+	// listCounts records the count of every ListConversations call.
+	listCounts []int
+	// cursors records the cursor item ID of every fetch ("" for the newest page).
+	cursors []string
+	// failAt makes the nth fetch (1-based) fail.
+	failAt int
+}
+
+func (f *fakeSource) ListConversations(count int, folder gmproto.ListConversationsRequest_Folder) (*gmproto.ListConversationsResponse, error) {
+	f.listCounts = append(f.listCounts, count)
+	convs := f.convs
+	if folder != gmproto.ListConversationsRequest_INBOX {
+		convs = f.folders[folder]
+	}
+	return &gmproto.ListConversationsResponse{Conversations: convs[:min(count, len(convs))]}, nil
+}
+
+func (f *fakeSource) FetchMessages(convID string, count int64, cursor *gmproto.Cursor) (*gmproto.ListMessagesResponse, error) {
+	f.cursors = append(f.cursors, cursor.GetLastItemID())
+	if len(f.cursors) == f.failAt {
+		return nil, errors.New("connection reset")
+	}
+	all := f.msgs[convID]
+	start := 0
+	if cursor != nil {
+		for i, m := range all {
+			if m.GetMessageID() == cursor.GetLastItemID() {
+				start = i + 1
+			}
+		}
+	}
+	end := min(start+int(count), len(all))
+	page := all[start:end]
+	resp := &gmproto.ListMessagesResponse{Messages: page}
+	if end < len(all) {
+		last := page[len(page)-1]
+		resp.Cursor = &gmproto.Cursor{LastItemID: last.GetMessageID(), LastItemTimestamp: last.GetTimestamp()}
+	}
+	return resp, nil
+}
+
+// addMessages gives convID n more messages, one a minute, newer than any
+// it already has, and bumps the conversation's last message time.
+func (f *fakeSource) addMessages(convID string, n int) {
+	if f.msgs == nil {
+		f.msgs = make(map[string][]*gmproto.Message)
+	}
+	next := len(f.msgs[convID])
+	var conv *gmproto.Conversation
+	for _, c := range f.convs {
+		if c.GetConversationID() == convID {
+			conv = c
+		}
+	}
+	if conv == nil {
+		conv = &gmproto.Conversation{ConversationID: convID}
+		f.convs = append(f.convs, conv)
+	}
+	for i := 0; i < n; i++ {
+		ts := fakeMessageTime(next + i)
+		msg := &gmproto.Message{
+			MessageID:      fmt.Sprintf("%s-%03d", convID, next+i),
+			ConversationID: convID,
+			Timestamp:      ts.UnixMicro(),
+		}
+		f.msgs[convID] = append([]*gmproto.Message{msg}, f.msgs[convID]...)
+		conv.LastMessageTimestamp = msg.Timestamp
+	}
+}
+
+func fakeMessageTime(i int) time.Time {
+	return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Minute)
+}
+
+func newBackfillApp(t *testing.T) *App {
+	t.Helper()
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return &App{Store: store, Logger: zerolog.Nop()}
+}
+
+func countMessages(t *testing.T, a *App, convID string) int {
+	t.Helper()
+	msgs, err := a.Store.GetMessagesByConversation(convID, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(msgs)
+}
+
+func TestBackfillFetchesOnlyTheGap(t *testing.T) {
+	a := newBackfillApp(t)
+	src := &fakeSource{}
+	src.addMessages("c1", 100)
+	src.addMessages("c2", 5)
+
+	if err := a.backfill(nil, src); err != nil {
+		t.Fatal(err)
+	}
+	if n := countMessages(t, a, "c1"); n != recentPageSize {
+		t.Errorf("first backfill stored %d messages, want %d", n, recentPageSize)
+	}
+	st, _ := a.Store.GetSyncState("c1")
+	if st == nil || st.Complete || st.CursorItemID != "c1-080" {
+		t.Fatalf("checkpoint after first backfill: %+v", st)
+	}
+	if st.NewestTS != fakeMessageTime(99).UnixMilli() || st.OldestTS != fakeMessageTime(80).UnixMilli() {
+		t.Errorf("synced range: %+v", st)
+	}
+	if st2, _ := a.Store.GetSyncState("c2"); st2 == nil || !st2.Complete {
+		t.Errorf("short conversation should be complete: %+v", st2)
+	}
+
+	// Three new messages in c1, nothing new in c2.
+	src.addMessages("c1", 3)
+	src.cursors = nil
+	if err := a.backfill(nil, src); err != nil {
+		t.Fatal(err)
+	}
+	if len(src.cursors) != 1 {
+		t.Errorf("second backfill made %d fetches, want 1 (c2 unchanged)", len(src.cursors))
+	}
+	if n := countMessages(t, a, "c1"); n != recentPageSize+3 {
+		t.Errorf("after gap fetch: %d messages, want %d", n, recentPageSize+3)
+	}
+	st, _ = a.Store.GetSyncState("c1")
+	if st.NewestTS != fakeMessageTime(102).UnixMilli() || st.CursorItemID != "c1-080" {
+		t.Errorf("gap fetch should move only the newest edge: %+v", st)
+	}
+}
+
+func TestBackfillPagesThroughLongGap(t *testing.T) {
+	a := newBackfillApp(t)
+	src := &fakeSource{}
+	src.addMessages("c1", 10)
+	a.backfill(nil, src)
+
+	src.addMessages("c1", 120)
+	if err := a.backfill(nil, src); err != nil {
+		t.Fatal(err)
+	}
+	if n := countMessages(t, a, "c1"); n != 130 {
+		t.Errorf("got %d messages, want 130", n)
+	}
+}
+
+func TestBackfillConversationStopsAtDate(t *testing.T) {
+	a := newBackfillApp(t)
+	src := &fakeSource{}
+	src.addMessages("c1", 300)
+
+	until := fakeMessageTime(200)
+	if _, err := a.backfillConversation(nil, src, "c1", until.UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := a.Store.GetSyncState("c1")
+	if st.Complete {
+		t.Error("should not have fetched the whole history")
+	}
+	if st.OldestTS > until.UnixMilli() {
+		t.Errorf("oldest synced %v, want at or before %v", time.UnixMilli(st.OldestTS).UTC(), until)
+	}
+	// One recent page, then history pages until the date is covered.
+	if n := countMessages(t, a, "c1"); n != recentPageSize+2*historyPageSize {
+		t.Errorf("got %d messages, want %d", n, recentPageSize+2*historyPageSize)
+	}
+}
+
+func TestDeepBackfillResumesAfterFailure(t *testing.T) {
+	a := newBackfillApp(t)
+	src := &fakeSource{failAt: 3}
+	src.addMessages("c1", 200)
+
+	// Recent page, one history page, then the connection drops.
+	if _, err := a.backfillConversation(nil, src, "c1", 0); err == nil {
+		t.Fatal("expected the injected failure")
+	}
+	st, _ := a.Store.GetSyncState("c1")
+	if st.CursorItemID != "c1-130" {
+		t.Fatalf("checkpoint after failure: %+v", st)
+	}
+
+	src.failAt = 0
+	src.cursors = nil
+	a.deepBackfill(nil, src)
+	if src.cursors[0] != "" || src.cursors[1] != "c1-130" {
+		t.Errorf("resume should check for new messages, then continue from the checkpoint: %v", src.cursors)
+	}
+	if n := countMessages(t, a, "c1"); n != 200 {
+		t.Errorf("got %d messages, want 200", n)
+	}
+	if st, _ := a.Store.GetSyncState("c1"); !st.Complete {
+		t.Error("history should be complete")
+	}
+
+	// Nothing left to fetch but the newest page.
+	src.cursors = nil
+	a.deepBackfill(nil, src)
+	if len(src.cursors) != 1 {
+		t.Errorf("complete conversation made %d fetches, want 1", len(src.cursors))
+	}
+}
+
+func TestBackfillDoesNotCheckpointPastStoreFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := db.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{Store: store, Logger: zerolog.Nop()}
+	src := &fakeSource{}
+	src.addMessages("c1", 200)
+
+	// A second connection makes storing one message of the first history
+	// page fail, as a transient database error would.
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Exec(`CREATE TRIGGER fail_store BEFORE INSERT ON messages WHEN NEW.message_id = 'c1-150'
+		BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.backfillConversation(nil, src, "c1", 0); err == nil {
+		t.Fatal("expected the store failure")
+	}
+	st, _ := a.Store.GetSyncState("c1")
+	if st.CursorItemID != "c1-180" || st.OldestTS != fakeMessageTime(180).UnixMilli() {
+		t.Fatalf("checkpoint moved past the failed page: %+v", st)
+	}
+
+	if _, err := raw.Exec(`DROP TRIGGER fail_store`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.backfillConversation(nil, src, "c1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := countMessages(t, a, "c1"); n != 200 {
+		t.Errorf("got %d messages, want 200", n)
+	}
+}
+
+func TestDeepBackfillWalksEveryPageAndFolder(t *testing.T) {
+	a := newBackfillApp(t)
+	src := &fakeSource{}
+	for i := 0; i < 250; i++ {
+		src.convs = append(src.convs, &gmproto.Conversation{ConversationID: fmt.Sprintf("inbox-%03d", i)})
+	}
+	src.folders = map[gmproto.ListConversationsRequest_Folder][]*gmproto.Conversation{
+		gmproto.ListConversationsRequest_ARCHIVE: {{ConversationID: "old-thread"}},
+		gmproto.ListConversationsRequest_SPAM_BLOCKED: {
+			{ConversationID: "spam", Status: gmproto.ConversationStatus_SPAM_FOLDER},
+			// The conversation's own status wins over the folder it was listed in.
+			{ConversationID: "blocked", Status: gmproto.ConversationStatus_BLOCKED_FOLDER},
+		},
+	}
+
+	a.deepBackfill(nil, src)
+
+	convs, err := a.Store.ListConversations(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(convs) != 253 {
+		t.Fatalf("got %d conversations, want 253", len(convs))
+	}
+	// Inbox needed 100, 200 and 400; the other folders one call each.
+	if fmt.Sprint(src.listCounts) != "[100 200 400 100 100]" {
+		t.Errorf("list calls: %v", src.listCounts)
+	}
+	for id, want := range map[string]string{
+		"inbox-249":  db.FolderInbox,
+		"old-thread": db.FolderArchive,
+		"spam":       db.FolderSpamBlocked,
+		"blocked":    db.FolderSpamBlocked,
+	} {
+		c, err := a.Store.GetConversation(id)
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if c.Folder != want {
+			t.Errorf("%s: folder %q, want %q", id, c.Folder, want)
+		}
+	}
+}
+
+func TestListAllConversationsStopsAtLimit(t *testing.T) {
+	var logs strings.Builder
+	a := newBackfillApp(t)
+	a.Logger = zerolog.New(&logs)
+	src := &fakeSource{}
+	for i := 0; i < maxConversations+50; i++ {
+		src.convs = append(src.convs, &gmproto.Conversation{ConversationID: fmt.Sprintf("c%05d", i)})
+	}
+
+	convs, err := a.listAllConversations(src, gmproto.ListConversationsRequest_INBOX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(convs) != maxConversations {
+		t.Errorf("got %d conversations, want %d", len(convs), maxConversations)
+	}
+	if last := src.listCounts[len(src.listCounts)-1]; last != maxConversations {
+		t.Errorf("last list call asked for %d, want %d", last, maxConversations)
+	}
+	if !strings.Contains(logs.String(), "conversation limit reached") {
+		t.Errorf("hitting the limit wasn't logged: %s", logs.String())
+	}
+}
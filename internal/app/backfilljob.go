@@ -0,0 +1,249 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+)
+
+var (
+	// ErrNotConnected is returned when an operation needs a Google
+	// Messages client and there is none.
+	ErrNotConnected = errors.New("client not connected")
+	// ErrBackfillRunning is returned when a backfill is started while
+	// another is still running.
+	ErrBackfillRunning = errors.New("a backfill is already running")
+)
+
+// BackfillState is where a backfill job is.
+type BackfillState string
+
+const (
+	BackfillIdle      BackfillState = "idle"
+	BackfillRunning   BackfillState = "running"
+	BackfillDone      BackfillState = "done"
+	BackfillCancelled BackfillState = "cancelled"
+	BackfillFailed    BackfillState = "failed"
+)
+
+// Kinds of backfill job.
+const (
+	// BackfillRecent fetches what arrived since the last sync.
+	BackfillRecent = "recent"
+	// BackfillDeep fetches all history in every folder.
+	BackfillDeep = "deep"
+	// BackfillConversationKind fetches one conversation back to a date.
+	BackfillConversationKind = "conversation"
+)
+
+// maxBackfillErrors is how many error messages a job keeps; ErrorCount
+// still counts the rest.
+const maxBackfillErrors = 20
+
+// BackfillProgress is a snapshot of the current or last backfill job.
+type BackfillProgress struct {
+	State              BackfillState `json:"state"`
+	Kind               string        `json:"kind,omitempty"`
+	ConversationID     string        `json:"conversation_id,omitempty"`
+	Until              *time.Time    `json:"until,omitempty"`
+	ConversationsDone  int           `json:"conversations_done"`
+	ConversationsTotal int           `json:"conversations_total"`
+	MessagesFetched    int           `json:"messages_fetched"`
+	Current            string        `json:"current_conversation,omitempty"`
+	ErrorCount         int           `json:"error_count"`
+	Errors             []string      `json:"errors,omitempty"`
+	StartedAt          *time.Time    `json:"started_at,omitempty"`
+	FinishedAt         *time.Time    `json:"finished_at,omitempty"`
+}
+
+// BackfillJobs runs backfills in the background, one at a time, and
+// reports their progress. Starting a job while one runs fails with
+// ErrBackfillRunning rather than running both: they would race on the same
+// sync checkpoints.
+type BackfillJobs struct {
+	app *App
+
+	mu       sync.Mutex
+	progress BackfillProgress
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	// source is swapped out in tests.
+	source func() messageSource
+}
+
+// NewBackfillJobs returns an idle job runner for a.
+func NewBackfillJobs(a *App) *BackfillJobs {
+	j := &BackfillJobs{
+		app:      a,
+		progress: BackfillProgress{State: BackfillIdle},
+	}
+	j.source = func() messageSource {
+		if cli := a.Client(); cli != nil {
+			return cli.GM
+		}
+		return nil
+	}
+	return j
+}
+
+// StartRecent starts fetching what arrived since the last sync.
+func (j *BackfillJobs) StartRecent() (BackfillProgress, error) {
+	return j.start(BackfillProgress{Kind: BackfillRecent}, func(run *backfillRun, src messageSource) error {
+		return j.app.backfill(run, src)
+	})
+}
+
+// StartDeep starts fetching all history in every folder.
+func (j *BackfillJobs) StartDeep() (BackfillProgress, error) {
+	return j.start(BackfillProgress{Kind: BackfillDeep}, func(run *backfillRun, src messageSource) error {
+		return j.app.deepBackfill(run, src)
+	})
+}
+
+// StartConversation starts fetching one conversation's history back to
+// until, or all of it if until is zero.
+func (j *BackfillJobs) StartConversation(convID string, until time.Time) (BackfillProgress, error) {
+	p := BackfillProgress{Kind: BackfillConversationKind, ConversationID: convID, ConversationsTotal: 1}
+	if !until.IsZero() {
+		p.Until = &until
+	}
+	return j.start(p, func(run *backfillRun, src messageSource) error {
+		run.update(func(p *BackfillProgress) { p.Current = convID })
+		_, err := j.app.backfillConversation(run, src, convID, untilMS(until))
+		if err == nil {
+			run.update(func(p *BackfillProgress) { p.ConversationsDone = 1 })
+		}
+		return err
+	})
+}
+
+func (j *BackfillJobs) start(p BackfillProgress, work func(*backfillRun, messageSource) error) (BackfillProgress, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.progress.State == BackfillRunning {
+		return j.progress, ErrBackfillRunning
+	}
+	src := j.source()
+	if src == nil {
+		return j.progress, ErrNotConnected
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	p.State = BackfillRunning
+	p.StartedAt = &now
+	j.progress = p
+	j.cancel = cancel
+	j.done = make(chan struct{})
+
+	run := &backfillRun{ctx: ctx, jobs: j}
+	go j.run(run, src, work, j.done)
+	return j.progress, nil
+}
+
+func (j *BackfillJobs) run(run *backfillRun, src messageSource, work func(*backfillRun, messageSource) error, done chan struct{}) {
+	defer close(done)
+	err := work(run, src)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.progress.FinishedAt = &now
+	j.progress.Current = ""
+	switch {
+	case run.ctx.Err() != nil:
+		j.progress.State = BackfillCancelled
+	case err != nil:
+		j.progress.State = BackfillFailed
+		j.recordLocked("", err)
+	default:
+		j.progress.State = BackfillDone
+	}
+	j.cancel()
+	if j.progress.MessagesFetched > 0 {
+		j.app.Events.Publish(bus.Resync{})
+	}
+	j.app.Logger.Info().
+		Str("kind", j.progress.Kind).
+		Str("state", string(j.progress.State)).
+		Int("messages", j.progress.MessagesFetched).
+		Msg("Backfill job finished")
+}
+
+// Cancel stops the running job and waits for it to wind down. It reports
+// whether there was a job to cancel.
+func (j *BackfillJobs) Cancel() bool {
+	j.mu.Lock()
+	if j.progress.State != BackfillRunning {
+		j.mu.Unlock()
+		return false
+	}
+	j.cancel()
+	done := j.done
+	j.mu.Unlock()
+	<-done
+	return true
+}
+
+// Progress returns a snapshot of the current or last job.
+func (j *BackfillJobs) Progress() BackfillProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p := j.progress
+	p.Errors = append([]string(nil), j.progress.Errors...)
+	return p
+}
+
+func (j *BackfillJobs) recordLocked(convID string, err error) {
+	j.progress.ErrorCount++
+	if len(j.progress.Errors) >= maxBackfillErrors {
+		return
+	}
+	msg := err.Error()
+	if convID != "" {
+		msg = fmt.Sprintf("%s: %s", convID, msg)
+	}
+	j.progress.Errors = append(j.progress.Errors, msg)
+}
+
+// backfillRun carries a job's cancellation and progress reporting through
+// the backfill functions. A nil run never cancels and reports nothing.
+type backfillRun struct {
+	ctx  context.Context
+	jobs *BackfillJobs
+}
+
+func (r *backfillRun) cancelled() error {
+	if r == nil {
+		return nil
+	}
+	return r.ctx.Err()
+}
+
+func (r *backfillRun) update(f func(*BackfillProgress)) {
+	if r == nil {
+		return
+	}
+	r.jobs.mu.Lock()
+	f(&r.jobs.progress)
+	r.jobs.mu.Unlock()
+}
+
+func (r *backfillRun) fetched(n int) {
+	r.update(func(p *BackfillProgress) { p.MessagesFetched += n })
+}
+
+// fail records a per-conversation error that didn't stop the job.
+func (r *backfillRun) fail(convID string, err error) {
+	if r == nil {
+		return
+	}
+	r.jobs.mu.Lock()
+	r.jobs.recordLocked(convID, err)
+	r.jobs.mu.Unlock()
+}
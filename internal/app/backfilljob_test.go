@@ -0,0 +1,152 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+)
+
+// gatedSource holds every fetch until the test lets it through.
+type gatedSource struct {
+	*fakeSource
+	fetching chan struct{}
+	release  chan struct{}
+}
+
+func newGatedSource(src *fakeSource) *gatedSource {
+	return &gatedSource{fakeSource: src, fetching: make(chan struct{}, 1000), release: make(chan struct{})}
+}
+
+func (g *gatedSource) FetchMessages(convID string, count int64, cursor *gmproto.Cursor) (*gmproto.ListMessagesResponse, error) {
+	g.fetching <- struct{}{}
+	<-g.release
+	return g.fakeSource.FetchMessages(convID, count, cursor)
+}
+
+func testJobs(t *testing.T, src messageSource) *BackfillJobs {
+	t.Helper()
+	j := NewBackfillJobs(newBackfillApp(t))
+	j.source = func() messageSource { return src }
+	return j
+}
+
+func waitForJob(t *testing.T, j *BackfillJobs) BackfillProgress {
+	t.Helper()
+	waitFor(t, "backfill to finish", func() bool { return j.Progress().State != BackfillRunning })
+	return j.Progress()
+}
+
+func TestBackfillJobReportsProgress(t *testing.T) {
+	src := &fakeSource{}
+	src.addMessages("c1", 120)
+	src.addMessages("c2", 10)
+	j := testJobs(t, src)
+
+	p, err := j.StartDeep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.State != BackfillRunning || p.Kind != BackfillDeep || p.StartedAt == nil {
+		t.Errorf("start: %+v", p)
+	}
+
+	p = waitForJob(t, j)
+	if p.State != BackfillDone {
+		t.Fatalf("state: got %s, want done (errors %v)", p.State, p.Errors)
+	}
+	if p.ConversationsDone != 2 || p.ConversationsTotal != 2 {
+		t.Errorf("conversations: %d of %d, want 2 of 2", p.ConversationsDone, p.ConversationsTotal)
+	}
+	if p.MessagesFetched != 130 {
+		t.Errorf("messages fetched: got %d, want 130", p.MessagesFetched)
+	}
+	if p.Current != "" || p.FinishedAt == nil {
+		t.Errorf("finished job: %+v", p)
+	}
+}
+
+func TestBackfillJobIsSingleFlight(t *testing.T) {
+	src := newGatedSource(&fakeSource{})
+	src.addMessages("c1", 10)
+	j := testJobs(t, src)
+
+	if _, err := j.StartDeep(); err != nil {
+		t.Fatal(err)
+	}
+	<-src.fetching
+	if _, err := j.StartConversation("c1", time.Time{}); !errors.Is(err, ErrBackfillRunning) {
+		t.Errorf("second start: got %v, want ErrBackfillRunning", err)
+	}
+	if got := j.Progress().Current; got != "c1" {
+		t.Errorf("current conversation: got %q", got)
+	}
+
+	close(src.release)
+	if p := waitForJob(t, j); p.State != BackfillDone {
+		t.Fatalf("state: got %s", p.State)
+	}
+	// Once finished, another job may start.
+	if _, err := j.StartRecent(); err != nil {
+		t.Errorf("start after finish: %v", err)
+	}
+	waitForJob(t, j)
+}
+
+func TestBackfillJobCancel(t *testing.T) {
+	src := newGatedSource(&fakeSource{})
+	src.addMessages("c1", 500)
+	j := testJobs(t, src)
+
+	if _, err := j.StartConversation("c1", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// Let the first two pages through, then cancel during the third.
+	for i := 0; i < 2; i++ {
+		<-src.fetching
+		src.release <- struct{}{}
+	}
+	<-src.fetching
+	cancelled := make(chan bool)
+	go func() { cancelled <- j.Cancel() }()
+	// Keep fetches moving until the job notices the cancel.
+	var ok bool
+	for wait := true; wait; {
+		select {
+		case src.release <- struct{}{}:
+		case ok = <-cancelled:
+			wait = false
+		}
+	}
+
+	if !ok {
+		t.Fatal("Cancel reported nothing running")
+	}
+	p := j.Progress()
+	if p.State != BackfillCancelled {
+		t.Fatalf("state: got %s, want cancelled", p.State)
+	}
+	if p.MessagesFetched < recentPageSize+2*historyPageSize || p.MessagesFetched >= 500 {
+		t.Errorf("messages fetched: got %d", p.MessagesFetched)
+	}
+
+	// The checkpoint survives, so the next run resumes rather than restarts.
+	st, _ := j.app.Store.GetSyncState("c1")
+	if st == nil || st.Complete || st.CursorItemID == "" {
+		t.Errorf("checkpoint after cancel: %+v", st)
+	}
+	if j.Cancel() {
+		t.Error("second cancel should report nothing running")
+	}
+}
+
+func TestBackfillJobNeedsClient(t *testing.T) {
+	j := NewBackfillJobs(newBackfillApp(t))
+	if _, err := j.StartDeep(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("got %v, want ErrNotConnected", err)
+	}
+	if p := j.Progress(); p.State != BackfillIdle {
+		t.Errorf("state: got %s, want idle", p.State)
+	}
+}
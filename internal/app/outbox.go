@@ -0,0 +1,302 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// ErrOutboxItemBusy is returned when retrying or cancelling an outbox item
+// that is sending or already sent.
+var ErrOutboxItemBusy = errors.New("outbox item is sending or already sent")
+
+// Outbox keeps text messages that couldn't be sent straight away and sends
+// them, in order, once it can. A send that fails before reaching Google is
+// retried with exponential backoff until MaxAttempts; one that fails only
+// because there is no client waits for the connection to come back without
+// using up an attempt. A send that may have gone out (ErrMaybeSent) is
+// never retried automatically: it is marked failed for the user to check.
+type Outbox struct {
+	app *App
+
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+
+	wake chan struct{}
+
+	// Swapped out in tests.
+	send  func(SendRequest) (*SendResult, error)
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+// NewOutbox returns an outbox that sends through a.Sender. Nothing is sent
+// until Run is called.
+func NewOutbox(a *App) *Outbox {
+	return &Outbox{
+		app:         a,
+		MinDelay:    5 * time.Second,
+		MaxDelay:    10 * time.Minute,
+		MaxAttempts: 8,
+		wake:        make(chan struct{}, 1),
+		send:        func(req SendRequest) (*SendResult, error) { return a.Sender.Send(req) },
+		now:         time.Now,
+		after:       time.After,
+	}
+}
+
+// Submit sends req now if it can, and queues it otherwise. On success
+// exactly one of the result and the queued item is set. Messages with media
+// are never queued.
+//
+// A message is queued without trying when older ones to the same recipient
+// are still queued, so it can't overtake them. A send that may have gone
+// out isn't queued: it is recorded as a failed item and its error
+// returned.
+func (o *Outbox) Submit(req SendRequest) (*SendResult, *db.OutboxItem, error) {
+	if err := req.validate(); err != nil {
+		return nil, nil, err
+	}
+	if req.Media != nil {
+		res, err := o.send(req)
+		return res, nil, err
+	}
+
+	waiting, err := o.app.Store.HasPendingOutbox(req.ConversationID, req.PhoneNumber)
+	if err != nil {
+		return nil, nil, fmt.Errorf("check outbox: %w", err)
+	}
+	var lastErr string
+	if !waiting {
+		res, err := o.send(req)
+		if err == nil {
+			return res, nil, nil
+		}
+		if errors.Is(err, ErrMaybeSent) {
+			it := newOutboxItem(req, o.now())
+			it.State = db.OutboxFailed
+			it.LastError = err.Error()
+			if aerr := o.app.Store.AddOutboxItem(it); aerr != nil {
+				o.app.Logger.Error().Err(aerr).Msg("Failed to record failed send in the outbox")
+			}
+			return nil, nil, err
+		}
+		lastErr = err.Error()
+		o.app.Logger.Warn().Err(err).Msg("Send failed; queueing it in the outbox")
+	}
+
+	it, err := o.Enqueue(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lastErr != "" {
+		it.LastError = lastErr
+		o.app.Store.UpdateOutboxItem(it)
+	}
+	return nil, it, nil
+}
+
+// Enqueue queues req to be sent as soon as possible.
+func (o *Outbox) Enqueue(req SendRequest) (*db.OutboxItem, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	if req.Media != nil {
+		return nil, errors.New("messages with media can't be queued")
+	}
+	it := newOutboxItem(req, o.now())
+	if err := o.app.Store.AddOutboxItem(it); err != nil {
+		return nil, fmt.Errorf("queue message: %w", err)
+	}
+	o.Wake()
+	return it, nil
+}
+
+// newOutboxItem returns a queued item for req, due at now.
+func newOutboxItem(req SendRequest, now time.Time) *db.OutboxItem {
+	ms := now.UnixMilli()
+	return &db.OutboxItem{
+		ConversationID: req.ConversationID,
+		PhoneNumber:    req.PhoneNumber,
+		Body:           req.Body,
+		ReplyToID:      req.ReplyToID,
+		State:          db.OutboxQueued,
+		NextAttemptAt:  ms,
+		CreatedAt:      ms,
+		UpdatedAt:      ms,
+	}
+}
+
+// Retry queues a failed item to be sent again with a fresh set of
+// attempts.
+func (o *Outbox) Retry(id int64) (*db.OutboxItem, error) {
+	it, err := o.app.Store.GetOutboxItem(id)
+	if err != nil || it == nil {
+		return nil, err
+	}
+	if it.State != db.OutboxFailed {
+		return nil, ErrOutboxItemBusy
+	}
+	now := o.now().UnixMilli()
+	it.State = db.OutboxQueued
+	it.Attempts = 0
+	it.NextAttemptAt = now
+	it.UpdatedAt = now
+	if err := o.app.Store.UpdateOutboxItem(it); err != nil {
+		return nil, err
+	}
+	o.Wake()
+	return it, nil
+}
+
+// Cancel removes a queued or failed item. It returns nil, nil if there is
+// no such item.
+func (o *Outbox) Cancel(id int64) (*db.OutboxItem, error) {
+	it, err := o.app.Store.GetOutboxItem(id)
+	if err != nil || it == nil {
+		return nil, err
+	}
+	ok, err := o.app.Store.DeleteOutboxItem(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrOutboxItemBusy
+	}
+	return it, nil
+}
+
+// Wake makes the worker look for due items now, as after a reconnect. It
+// never blocks.
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends queued items until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	if n, err := o.app.Store.FailInterruptedOutbox(o.now().UnixMilli()); err != nil {
+		o.app.Logger.Error().Err(err).Msg("Failed to recover outbox")
+	} else if n > 0 {
+		o.app.Logger.Warn().Int64("items", n).Msg("Outbox items were interrupted while sending; marked failed")
+	}
+
+	for {
+		var timer <-chan time.Time
+		if wait, ok := o.drain(); ok {
+			timer = o.after(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-timer:
+		}
+	}
+}
+
+// drain sends every item that is due. It returns how long until the next
+// retry is due, or false if there is nothing to wait for: the queue is
+// empty or the client is gone and only Wake can help.
+func (o *Outbox) drain() (time.Duration, bool) {
+	for {
+		it, err := o.app.Store.NextOutboxItem(o.now().UnixMilli())
+		if err != nil {
+			o.app.Logger.Error().Err(err).Msg("Failed to read outbox")
+			return o.MinDelay, true
+		}
+		if it == nil {
+			break
+		}
+		if err := o.process(it); errors.Is(err, ErrNotConnected) {
+			return 0, false
+		} else if err != nil {
+			return o.MinDelay, true
+		}
+	}
+
+	next, ok, err := o.app.Store.NextOutboxAttempt()
+	if err != nil {
+		o.app.Logger.Error().Err(err).Msg("Failed to read outbox")
+		return o.MinDelay, true
+	}
+	if !ok {
+		return 0, false
+	}
+	return max(time.Duration(next-o.now().UnixMilli())*time.Millisecond, 0), true
+}
+
+// process makes one attempt at sending it and records the outcome. It
+// returns an error only when the worker should stop for now: there is no
+// client, or the outbox can't be updated.
+func (o *Outbox) process(it *db.OutboxItem) error {
+	it.State = db.OutboxSending
+	it.Attempts++
+	it.UpdatedAt = o.now().UnixMilli()
+	if err := o.app.Store.UpdateOutboxItem(it); err != nil {
+		o.app.Logger.Error().Err(err).Int64("outbox_id", it.ID).Msg("Failed to update outbox item")
+		return err
+	}
+
+	res, err := o.send(SendRequest{
+		ConversationID: it.ConversationID,
+		PhoneNumber:    it.PhoneNumber,
+		Body:           it.Body,
+		ReplyToID:      it.ReplyToID,
+	})
+	if err == nil && !res.Success {
+		err = fmt.Errorf("Google Messages did not accept the message (status %s)", res.Status)
+	}
+
+	now := o.now()
+	it.UpdatedAt = now.UnixMilli()
+	switch {
+	case err == nil:
+		it.State = db.OutboxSent
+		it.ConversationID = res.ConversationID
+		it.MessageID = res.MessageID
+		it.LastError = ""
+	case errors.Is(err, ErrNotConnected):
+		// Not an attempt: nothing was sent. Wait for the connection.
+		it.State = db.OutboxQueued
+		it.Attempts--
+		it.LastError = err.Error()
+	case errors.Is(err, ErrNoRecipient), errors.Is(err, ErrEmptyMessage), errors.Is(err, ErrMaybeSent), it.Attempts >= o.MaxAttempts:
+		it.State = db.OutboxFailed
+		it.LastError = err.Error()
+	default:
+		it.State = db.OutboxQueued
+		it.NextAttemptAt = now.Add(backoff(it.Attempts, o.MinDelay, o.MaxDelay)).UnixMilli()
+		it.LastError = err.Error()
+	}
+	if uerr := o.app.Store.UpdateOutboxItem(it); uerr != nil {
+		o.app.Logger.Error().Err(uerr).Int64("outbox_id", it.ID).Msg("Failed to update outbox item")
+		return uerr
+	}
+
+	evt := o.app.Logger.Info()
+	if err != nil {
+		evt = o.app.Logger.Warn().Err(err)
+	}
+	evt.Int64("outbox_id", it.ID).Str("state", it.State).Int("attempt", it.Attempts).Msg("Outbox send attempt")
+	if errors.Is(err, ErrNotConnected) {
+		return err
+	}
+	return nil
+}
+
+// backoff returns the wait after the given failed attempt (1-based): the
+// delay starts at minDelay and doubles each time up to maxDelay.
+func backoff(attempt int, minDelay, maxDelay time.Duration) time.Duration {
+	d := minDelay
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxDelay)
+}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// fakeSends answers outbox sends from a script of errors; a nil entry, or
+// running off the end, is a successful send.
+type fakeSends struct {
+	errs []error
+	sent []SendRequest
+}
+
+func (f *fakeSends) send(req SendRequest) (*SendResult, error) {
+	var err error
+	if len(f.errs) > 0 {
+		err, f.errs = f.errs[0], f.errs[1:]
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, req)
+	return &SendResult{ConversationID: "conv-1", MessageID: "tmp_000000000001", Status: "SUCCESS", Success: true}, nil
+}
+
+func testOutbox(t *testing.T, errs ...error) (*Outbox, *fakeSends, *time.Time) {
+	t.Helper()
+	o := NewOutbox(newBackfillApp(t))
+	fake := &fakeSends{errs: errs}
+	o.send = fake.send
+	now := time.UnixMilli(1_000_000)
+	o.now = func() time.Time { return now }
+	return o, fake, &now
+}
+
+func outboxItem(t *testing.T, o *Outbox, id int64) *db.OutboxItem {
+	t.Helper()
+	it, err := o.app.Store.GetOutboxItem(id)
+	if err != nil || it == nil {
+		t.Fatalf("outbox item %d: %v, %v", id, it, err)
+	}
+	return it
+}
+
+func TestOutboxQueuesWhileDisconnected(t *testing.T) {
+	o, fake, _ := testOutbox(t, ErrNotConnected, ErrNotConnected)
+
+	res, queued, err := o.Submit(SendRequest{ConversationID: "conv-1", Body: "first"})
+	if err != nil || res != nil || queued == nil {
+		t.Fatalf("submit: %v, %v, %v", res, queued, err)
+	}
+	if queued.State != db.OutboxQueued || queued.LastError != ErrNotConnected.Error() {
+		t.Errorf("queued item: %+v", queued)
+	}
+
+	// Still offline: the worker leaves it queued without using an attempt.
+	if _, ok := o.drain(); ok {
+		t.Error("drain should wait for a wake while disconnected")
+	}
+	if it := outboxItem(t, o, queued.ID); it.State != db.OutboxQueued || it.Attempts != 0 {
+		t.Errorf("after offline drain: %+v", it)
+	}
+
+	// Back online.
+	if _, ok := o.drain(); ok {
+		t.Error("nothing left to wait for")
+	}
+	it := outboxItem(t, o, queued.ID)
+	if it.State != db.OutboxSent || it.Attempts != 1 || it.MessageID != "tmp_000000000001" || it.LastError != "" {
+		t.Errorf("after send: %+v", it)
+	}
+	if len(fake.sent) != 1 || fake.sent[0].Body != "first" {
+		t.Errorf("sent: %+v", fake.sent)
+	}
+}
+
+func TestOutboxKeepsOrderPerRecipient(t *testing.T) {
+	o, fake, _ := testOutbox(t, ErrNotConnected)
+
+	_, first, _ := o.Submit(SendRequest{ConversationID: "conv-1", Body: "one"})
+	// Connected again, but "two" mustn't overtake "one".
+	res, second, err := o.Submit(SendRequest{ConversationID: "conv-1", Body: "two"})
+	if err != nil || res != nil || second == nil {
+		t.Fatalf("second submit should queue: %v, %v, %v", res, second, err)
+	}
+	// Other recipients aren't held up.
+	if res, _, _ := o.Submit(SendRequest{ConversationID: "conv-2", Body: "other"}); res == nil {
+		t.Error("send to another conversation should go straight out")
+	}
+
+	o.drain()
+	var bodies []string
+	for _, r := range fake.sent {
+		bodies = append(bodies, r.Body)
+	}
+	if len(bodies) != 3 || bodies[1] != "one" || bodies[2] != "two" {
+		t.Errorf("send order: %v", bodies)
+	}
+	if outboxItem(t, o, first.ID).State != db.OutboxSent || outboxItem(t, o, second.ID).State != db.OutboxSent {
+		t.Error("queued items should be sent")
+	}
+}
+
+func TestOutboxRetriesWithBackoff(t *testing.T) {
+	boom := errors.New("phone not responding")
+	o, fake, now := testOutbox(t, boom, boom, boom)
+
+	it, err := o.Enqueue(SendRequest{ConversationID: "conv-1", Body: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		wait, ok := o.drain()
+		if !ok || wait != want {
+			t.Fatalf("attempt %d: wait %v, %v; want %v", i+1, wait, ok, want)
+		}
+		if got := outboxItem(t, o, it.ID); got.State != db.OutboxQueued || got.Attempts != i+1 || got.LastError != boom.Error() {
+			t.Fatalf("attempt %d: %+v", i+1, got)
+		}
+		// Not due yet.
+		o.drain()
+		if got := outboxItem(t, o, it.ID); got.Attempts != i+1 {
+			t.Fatalf("retried before the backoff elapsed: %+v", got)
+		}
+		*now = now.Add(want)
+	}
+
+	o.drain() // third failure
+	*now = now.Add(20 * time.Second)
+	o.drain()
+	if got := outboxItem(t, o, it.ID); got.State != db.OutboxSent || got.Attempts != 4 {
+		t.Errorf("final: %+v", got)
+	}
+	if len(fake.sent) != 1 {
+		t.Errorf("sent %d times, want 1", len(fake.sent))
+	}
+}
+
+func TestOutboxGivesUpAndRetries(t *testing.T) {
+	boom := errors.New("phone not responding")
+	o, _, now := testOutbox(t, boom, boom)
+	o.MaxAttempts = 2
+
+	it, _ := o.Enqueue(SendRequest{ConversationID: "conv-1", Body: "hi"})
+	o.drain()
+	*now = now.Add(time.Minute)
+	if _, ok := o.drain(); ok {
+		t.Error("a failed item leaves nothing to wait for")
+	}
+	if got := outboxItem(t, o, it.ID); got.State != db.OutboxFailed || got.Attempts != 2 {
+		t.Fatalf("after max attempts: %+v", got)
+	}
+
+	if _, err := o.Retry(it.ID); err != nil {
+		t.Fatal(err)
+	}
+	o.drain()
+	if got := outboxItem(t, o, it.ID); got.State != db.OutboxSent {
+		t.Errorf("after retry: %+v", got)
+	}
+	if _, err := o.Retry(it.ID); !errors.Is(err, ErrOutboxItemBusy) {
+		t.Errorf("retrying a sent item: got %v", err)
+	}
+	if _, err := o.Cancel(it.ID); !errors.Is(err, ErrOutboxItemBusy) {
+		t.Errorf("cancelling a sent item: got %v", err)
+	}
+}
+
+func TestOutboxDoesNotRetryMaybeSent(t *testing.T) {
+	maybe := fmt.Errorf("send message: timeout (%w)", ErrMaybeSent)
+	o, fake, _ := testOutbox(t, maybe, ErrNotConnected, maybe)
+
+	// Straight from Submit: not queued, but recorded as failed.
+	res, queued, err := o.Submit(SendRequest{ConversationID: "conv-1", Body: "first"})
+	if !errors.Is(err, ErrMaybeSent) || res != nil || queued != nil {
+		t.Fatalf("submit: %v, %v, %v", res, queued, err)
+	}
+	items, err := o.app.Store.ListOutbox("", 10)
+	if err != nil || len(items) != 1 || items[0].State != db.OutboxFailed || items[0].LastError != maybe.Error() {
+		t.Fatalf("outbox after submit: %+v, %v", items, err)
+	}
+
+	// From the worker: no second attempt.
+	_, it, _ := o.Submit(SendRequest{ConversationID: "conv-2", Body: "second"})
+	if _, ok := o.drain(); ok {
+		t.Error("a failed item leaves nothing to wait for")
+	}
+	if got := outboxItem(t, o, it.ID); got.State != db.OutboxFailed || got.Attempts != 1 {
+		t.Errorf("after ambiguous failure: %+v", got)
+	}
+	if len(fake.sent) != 0 {
+		t.Errorf("sent: %+v", fake.sent)
+	}
+}
+
+func TestOutboxRunSendsOnWake(t *testing.T) {
+	o, fake, _ := testOutbox(t, ErrNotConnected)
+	o.now = time.Now
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	_, it, _ := o.Submit(SendRequest{ConversationID: "conv-1", Body: "hi"})
+	waitFor(t, "queued item sent", func() bool {
+		got, _ := o.app.Store.GetOutboxItem(it.ID)
+		return got != nil && got.State == db.OutboxSent
+	})
+	if len(fake.sent) != 1 {
+		t.Errorf("sent %d times, want 1", len(fake.sent))
+	}
+}
@@ -0,0 +1,323 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/events"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+// ErrAlreadyPaired is returned by Pairer.Start while a phone is paired.
+var ErrAlreadyPaired = errors.New("already paired; unpair first")
+
+// PairState is where a web pairing attempt is.
+type PairState string
+
+const (
+	PairIdle PairState = "idle"
+	// PairWaiting means a QR code is being shown and the phone hasn't
+	// scanned it yet.
+	PairWaiting PairState = "waiting"
+	// PairConnecting means the phone scanned the code; the session is being
+	// saved and the app is connecting with it.
+	PairConnecting PairState = "connecting"
+	PairDone       PairState = "paired"
+	PairExpired    PairState = "expired"
+	PairFailed     PairState = "failed"
+)
+
+// PairStatus is a snapshot of a pairing attempt, as served by
+// /api/pair/status.
+type PairStatus struct {
+	State PairState `json:"state"`
+	// QRURL is the content of the QR code to show while waiting.
+	QRURL       string    `json:"qr_url,omitempty"`
+	QRRefreshes int       `json:"qr_refreshes"`
+	PhoneID     string    `json:"phone_id,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Active reports whether the attempt is still in progress.
+func (s PairStatus) Active() bool {
+	return s.State == PairWaiting || s.State == PairConnecting
+}
+
+// pairingClient is the part of libgm used while pairing.
+type pairingClient interface {
+	StartLogin() (string, error)
+	RefreshPhoneRelay() (string, error)
+	SessionData() (*client.SessionData, error)
+	Disconnect()
+}
+
+type gmPairingClient struct{ *client.Client }
+
+func (c gmPairingClient) StartLogin() (string, error)        { return c.GM.StartLogin() }
+func (c gmPairingClient) RefreshPhoneRelay() (string, error) { return c.GM.RefreshPhoneRelay() }
+func (c gmPairingClient) Disconnect()                        { c.GM.Disconnect() }
+
+// Pairer pairs a phone from inside the running server, so that the web UI
+// (or the macOS app) can show the QR code instead of a terminal. Once the
+// phone scans it, the session is saved and the app connects and backfills
+// without a restart.
+type Pairer struct {
+	app *App
+
+	// RefreshEvery is how often the QR code is replaced, and MaxRefreshes
+	// how many times before the attempt expires.
+	RefreshEvery time.Duration
+	MaxRefreshes int
+
+	mu     sync.Mutex
+	status PairStatus
+	cli    pairingClient
+	cancel context.CancelFunc
+	subs   map[chan PairStatus]struct{}
+
+	// Swapped out in tests.
+	newClient func(onPaired func(phoneID string)) pairingClient
+	connect   func() error
+	settle    time.Duration
+}
+
+// NewPairer returns an idle pairer for a.
+func NewPairer(a *App) *Pairer {
+	p := &Pairer{
+		app:          a,
+		RefreshEvery: 30 * time.Second,
+		MaxRefreshes: 5,
+		status:       PairStatus{State: PairIdle, UpdatedAt: time.Now()},
+		subs:         make(map[chan PairStatus]struct{}),
+		// libgm asks for a short pause between pairing and the first
+		// connection with the new session.
+		settle: 2 * time.Second,
+	}
+	p.newClient = p.newGMClient
+	p.connect = p.connectAndBackfill
+	return p
+}
+
+// Start begins pairing and returns the first QR code. While an attempt is
+// already in progress it returns that attempt's status instead of starting
+// another, so a reloaded page keeps showing the same code.
+func (p *Pairer) Start() (PairStatus, error) {
+	if p.app.Client() != nil {
+		return p.Status(), ErrAlreadyPaired
+	}
+
+	p.mu.Lock()
+	if p.status.Active() {
+		st := p.status
+		p.mu.Unlock()
+		return st, nil
+	}
+	// Hold the state while StartLogin runs so concurrent calls don't each
+	// start a login.
+	ctx, cancel := context.WithCancel(context.Background())
+	cli := p.newClient(func(phoneID string) { go p.finish(ctx, phoneID) })
+	p.cli = cli
+	p.cancel = cancel
+	p.setLocked(PairStatus{State: PairWaiting})
+	p.mu.Unlock()
+
+	qrURL, err := cli.StartLogin()
+	if err != nil {
+		p.fail(ctx, fmt.Errorf("start login: %w", err))
+		return p.Status(), err
+	}
+	p.mu.Lock()
+	if ctx.Err() == nil {
+		p.setLocked(PairStatus{State: PairWaiting, QRURL: qrURL})
+	}
+	st := p.status
+	p.mu.Unlock()
+
+	go p.refreshLoop(ctx)
+	p.app.Logger.Info().Msg("Pairing started; waiting for the phone to scan the QR code")
+	return st, nil
+}
+
+// Cancel abandons the attempt in progress, if any.
+func (p *Pairer) Cancel() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status.State != PairWaiting {
+		return
+	}
+	p.stopLocked()
+	p.setLocked(PairStatus{State: PairIdle})
+}
+
+// Status returns the current attempt's status.
+func (p *Pairer) Status() PairStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Subscribe returns a channel that receives the current status and then
+// every change, until cancel is called. Slow readers only miss intermediate
+// states; the latest one is always delivered.
+func (p *Pairer) Subscribe() (updates <-chan PairStatus, cancel func()) {
+	ch := make(chan PairStatus, 1)
+	p.mu.Lock()
+	ch <- p.status
+	p.subs[ch] = struct{}{}
+	p.mu.Unlock()
+	return ch, func() {
+		p.mu.Lock()
+		delete(p.subs, ch)
+		p.mu.Unlock()
+	}
+}
+
+func (p *Pairer) refreshLoop(ctx context.Context) {
+	for i := 1; ; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.RefreshEvery):
+		}
+		if i > p.MaxRefreshes {
+			p.mu.Lock()
+			if ctx.Err() == nil {
+				p.stopLocked()
+				p.setLocked(PairStatus{State: PairExpired, QRRefreshes: i - 1})
+			}
+			p.mu.Unlock()
+			p.app.Logger.Info().Msg("Pairing QR code expired")
+			return
+		}
+
+		p.mu.Lock()
+		cli := p.cli
+		p.mu.Unlock()
+		if cli == nil || ctx.Err() != nil {
+			return
+		}
+		qrURL, err := cli.RefreshPhoneRelay()
+		if err != nil {
+			p.fail(ctx, fmt.Errorf("refresh QR code: %w", err))
+			return
+		}
+		p.mu.Lock()
+		if ctx.Err() == nil && p.status.State == PairWaiting {
+			p.setLocked(PairStatus{State: PairWaiting, QRURL: qrURL, QRRefreshes: i})
+		}
+		p.mu.Unlock()
+	}
+}
+
+// finish runs after the phone scans the code: it saves the session, drops
+// the pairing connection and connects with the saved session.
+func (p *Pairer) finish(ctx context.Context, phoneID string) {
+	p.mu.Lock()
+	if ctx.Err() != nil {
+		p.mu.Unlock()
+		return
+	}
+	cli := p.cli
+	p.cancel() // stop refreshing; the code has been used
+	p.setLocked(PairStatus{State: PairConnecting, PhoneID: phoneID})
+	p.mu.Unlock()
+	p.app.Logger.Info().Str("phone_id", phoneID).Msg("Pairing successful")
+
+	err := p.saveSession(cli)
+	cli.Disconnect()
+	if err == nil {
+		time.Sleep(p.settle)
+		err = p.connect()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cli, p.cancel = nil, nil
+	if err != nil {
+		p.app.Logger.Error().Err(err).Msg("Failed to finish pairing")
+		p.setLocked(PairStatus{State: PairFailed, PhoneID: phoneID, Error: err.Error()})
+		return
+	}
+	p.setLocked(PairStatus{State: PairDone, PhoneID: phoneID})
+}
+
+func (p *Pairer) saveSession(cli pairingClient) error {
+	data, err := cli.SessionData()
+	if err != nil {
+		return fmt.Errorf("get session data: %w", err)
+	}
+	if err := client.SaveSession(p.app.SessionPath, data); err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+	return nil
+}
+
+// fail ends the attempt identified by ctx, unless it has already ended.
+func (p *Pairer) fail(ctx context.Context, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	p.app.Logger.Warn().Err(err).Msg("Pairing failed")
+	p.stopLocked()
+	p.setLocked(PairStatus{State: PairFailed, Error: err.Error()})
+}
+
+// stopLocked ends the current attempt's refresh loop and connection.
+func (p *Pairer) stopLocked() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	if p.cli != nil {
+		p.cli.Disconnect()
+	}
+	p.cli, p.cancel = nil, nil
+}
+
+func (p *Pairer) setLocked(st PairStatus) {
+	st.UpdatedAt = time.Now()
+	p.status = st
+	for ch := range p.subs {
+		// Replace an undelivered update rather than block.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- st
+	}
+}
+
+func (p *Pairer) newGMClient(onPaired func(phoneID string)) pairingClient {
+	logger := p.app.Logger
+	cli := client.NewForPairing(logger)
+	cb := func(data *gmproto.PairedData) {
+		onPaired(data.GetMobile().GetSourceID())
+	}
+	cli.GM.PairCallback.Store(&cb)
+	cli.GM.SetEventHandler(func(evt any) {
+		switch evt := evt.(type) {
+		case *events.ListenFatalError:
+			logger.Error().Err(evt.Error).Msg("Fatal error during pairing")
+		case *events.PairSuccessful:
+			// Handled by PairCallback
+		default:
+			logger.Debug().Type("type", evt).Msg("Event during pairing")
+		}
+	})
+	return gmPairingClient{cli}
+}
+
+func (p *Pairer) connectAndBackfill() error {
+	if err := p.app.LoadAndConnect(); err != nil {
+		return err
+	}
+	p.app.CatchUp()
+	return nil
+}
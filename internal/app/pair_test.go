@@ -0,0 +1,210 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+// fakePairingClient hands out numbered QR URLs and lets the test play the
+// phone by calling scan.
+type fakePairingClient struct {
+	mu           sync.Mutex
+	urls         int
+	disconnected bool
+	refreshErr   error
+	onPaired     func(phoneID string)
+}
+
+func (f *fakePairingClient) StartLogin() (string, error) { return f.next(), nil }
+
+func (f *fakePairingClient) RefreshPhoneRelay() (string, error) {
+	if f.refreshErr != nil {
+		return "", f.refreshErr
+	}
+	return f.next(), nil
+}
+
+func (f *fakePairingClient) next() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.urls++
+	return fmt.Sprintf("https://support.google.com/messages/?p=web_computer#?c=qr%d", f.urls)
+}
+
+func (f *fakePairingClient) SessionData() (*client.SessionData, error) {
+	return &client.SessionData{AuthDataJSON: []byte(`{"paired":true}`)}, nil
+}
+
+func (f *fakePairingClient) Disconnect() {
+	f.mu.Lock()
+	f.disconnected = true
+	f.mu.Unlock()
+}
+
+func (f *fakePairingClient) scan(phoneID string) { f.onPaired(phoneID) }
+
+func (f *fakePairingClient) isDisconnected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.disconnected
+}
+
+func testPairer(t *testing.T) (*Pairer, *[]*fakePairingClient, *int) {
+	t.Helper()
+	a := &App{
+		Clients:     client.NewProvider(nil),
+		Logger:      zerolog.Nop(),
+		SessionPath: filepath.Join(t.TempDir(), "session.json"),
+	}
+	p := NewPairer(a)
+	p.settle = 0
+	var clients []*fakePairingClient
+	p.newClient = func(onPaired func(string)) pairingClient {
+		f := &fakePairingClient{onPaired: onPaired}
+		clients = append(clients, f)
+		return f
+	}
+	connects := 0
+	p.connect = func() error {
+		connects++
+		return nil
+	}
+	return p, &clients, &connects
+}
+
+func TestPairerCompletesPairing(t *testing.T) {
+	p, clients, connects := testPairer(t)
+	updates, cancel := p.Subscribe()
+	defer cancel()
+	if st := <-updates; st.State != PairIdle {
+		t.Fatalf("initial state: got %s, want idle", st.State)
+	}
+
+	st, err := p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != PairWaiting || st.QRURL == "" {
+		t.Fatalf("after start: %+v", st)
+	}
+
+	// A second start while waiting reuses the same attempt.
+	again, err := p.Start()
+	if err != nil || again.QRURL != st.QRURL || len(*clients) != 1 {
+		t.Errorf("second start should reuse the attempt: %+v, %v, %d clients", again, err, len(*clients))
+	}
+
+	(*clients)[0].scan("phone-1")
+	waitFor(t, "paired", func() bool { return p.Status().State == PairDone })
+
+	if got := p.Status().PhoneID; got != "phone-1" {
+		t.Errorf("phone id: got %q", got)
+	}
+	if *connects != 1 {
+		t.Errorf("connect called %d times, want 1", *connects)
+	}
+	if !(*clients)[0].isDisconnected() {
+		t.Error("pairing connection should be closed")
+	}
+	data, err := client.LoadSession(p.app.SessionPath)
+	if err != nil || !strings.Contains(string(data.AuthDataJSON), `"paired": true`) {
+		t.Errorf("saved session: %+v, %v", data, err)
+	}
+
+	// Subscribers see the final state.
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case st := <-updates:
+			if st.State == PairDone {
+				return
+			}
+		case <-deadline:
+			t.Fatal("subscriber never saw paired state")
+		}
+	}
+}
+
+func TestPairerRefreshesThenExpires(t *testing.T) {
+	p, clients, _ := testPairer(t)
+	p.RefreshEvery = time.Millisecond
+	p.MaxRefreshes = 3
+
+	if _, err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "expired", func() bool { return p.Status().State == PairExpired })
+
+	st := p.Status()
+	if st.QRRefreshes != 3 {
+		t.Errorf("refreshes: got %d, want 3", st.QRRefreshes)
+	}
+	if st.QRURL != "" {
+		t.Error("expired attempt should not keep a QR code")
+	}
+	if !(*clients)[0].isDisconnected() {
+		t.Error("expired attempt should close its connection")
+	}
+
+	// Expired attempts can be restarted with a fresh code.
+	next, err := p.Start()
+	if err != nil || next.State != PairWaiting || len(*clients) != 2 {
+		t.Fatalf("restart: %+v, %v", next, err)
+	}
+}
+
+func TestPairerRefreshFailure(t *testing.T) {
+	p, clients, _ := testPairer(t)
+	p.RefreshEvery = time.Millisecond
+	p.newClient = func(onPaired func(string)) pairingClient {
+		f := &fakePairingClient{onPaired: onPaired, refreshErr: errors.New("relay gone")}
+		*clients = append(*clients, f)
+		return f
+	}
+
+	if _, err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "failed", func() bool { return p.Status().State == PairFailed })
+	if p.Status().Error == "" {
+		t.Error("failure should carry an error")
+	}
+}
+
+func TestPairerCancel(t *testing.T) {
+	p, clients, connects := testPairer(t)
+	if _, err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p.Cancel()
+	if st := p.Status(); st.State != PairIdle {
+		t.Fatalf("after cancel: got %s, want idle", st.State)
+	}
+	if !(*clients)[0].isDisconnected() {
+		t.Error("cancel should close the pairing connection")
+	}
+
+	// A scan that races the cancel is ignored.
+	(*clients)[0].scan("late-phone")
+	time.Sleep(10 * time.Millisecond)
+	if p.Status().State != PairIdle || *connects != 0 {
+		t.Error("scan after cancel should not pair")
+	}
+}
+
+func TestPairerRefusesWhilePaired(t *testing.T) {
+	p, _, _ := testPairer(t)
+	p.app.Clients.Set(client.NewForPairing(zerolog.Nop()))
+	if _, err := p.Start(); !errors.Is(err, ErrAlreadyPaired) {
+		t.Errorf("got %v, want ErrAlreadyPaired", err)
+	}
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+var (
+	// ErrSendTimeInPast is returned when scheduling a message for a time
+	// that has already passed.
+	ErrSendTimeInPast = errors.New("send time must be in the future")
+	// ErrDraftNotFound is returned when scheduling a draft that doesn't
+	// exist.
+	ErrDraftNotFound = errors.New("draft not found")
+	// ErrNoConversation is returned when scheduling a message with no
+	// conversation to send it to.
+	ErrNoConversation = errors.New("conversation_id is required")
+	// ErrDraftConversation is returned when scheduling a draft for a
+	// conversation other than its own.
+	ErrDraftConversation = errors.New("draft belongs to a different conversation")
+	// ErrScheduledNotPending is returned when cancelling a scheduled
+	// message that was already sent, cancelled or failed.
+	ErrScheduledNotPending = errors.New("scheduled message is no longer pending")
+)
+
+// schedulerMaxWait caps how long the scheduler sleeps between checks, so a
+// wall clock that jumps (a laptop waking from sleep) delays a message by at
+// most this much.
+const schedulerMaxWait = time.Minute
+
+// ScheduleRequest is a message to send to a conversation later. With a
+// DraftID and no Body, the draft's text at send time is sent; either way
+// the draft is removed once the message goes out.
+type ScheduleRequest struct {
+	ConversationID string
+	Body           string
+	DraftID        string
+	ReplyToID      string
+	SendAt         time.Time
+}
+
+// Scheduler hands scheduled messages to the outbox when they come due.
+// Scheduled messages live in the database, so ones that came due while
+// nothing was running go out as soon as Run starts.
+type Scheduler struct {
+	app *App
+
+	wake chan struct{}
+
+	// Swapped out in tests.
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+// NewScheduler returns a scheduler for a. Nothing is dispatched until Run
+// is called.
+func NewScheduler(a *App) *Scheduler {
+	return &Scheduler{
+		app:   a,
+		wake:  make(chan struct{}, 1),
+		now:   time.Now,
+		after: time.After,
+	}
+}
+
+// Schedule stores req to be sent at req.SendAt.
+func (s *Scheduler) Schedule(req ScheduleRequest) (*db.ScheduledMessage, error) {
+	req, err := s.Check(req)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	m := &db.ScheduledMessage{
+		ConversationID: req.ConversationID,
+		Body:           req.Body,
+		DraftID:        req.DraftID,
+		ReplyToID:      req.ReplyToID,
+		SendAt:         req.SendAt.UnixMilli(),
+		State:          db.ScheduledPending,
+		CreatedAt:      now.UnixMilli(),
+		UpdatedAt:      now.UnixMilli(),
+	}
+	if err := s.app.Store.AddScheduledMessage(m); err != nil {
+		return nil, fmt.Errorf("schedule message: %w", err)
+	}
+	s.Wake()
+	return m, nil
+}
+
+// Check returns the error Schedule would return for req, without
+// scheduling it. Otherwise it returns req with the conversation filled in
+// from its draft.
+func (s *Scheduler) Check(req ScheduleRequest) (ScheduleRequest, error) {
+	if req.DraftID != "" {
+		d, err := s.app.Store.GetDraft(req.DraftID)
+		if err != nil {
+			return req, fmt.Errorf("get draft: %w", err)
+		}
+		if d == nil {
+			return req, ErrDraftNotFound
+		}
+		if req.ConversationID == "" {
+			req.ConversationID = d.ConversationID
+		} else if req.ConversationID != d.ConversationID {
+			return req, ErrDraftConversation
+		}
+	} else if req.Body == "" {
+		return req, ErrEmptyMessage
+	}
+	if req.ConversationID == "" {
+		return req, ErrNoConversation
+	}
+	if !req.SendAt.After(s.now()) {
+		return req, ErrSendTimeInPast
+	}
+	return req, nil
+}
+
+// Cancel cancels a pending scheduled message. It returns nil, nil if there
+// is no such message.
+func (s *Scheduler) Cancel(id int64) (*db.ScheduledMessage, error) {
+	m, err := s.app.Store.GetScheduledMessage(id)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	now := s.now().UnixMilli()
+	ok, err := s.app.Store.CancelScheduledMessage(id, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrScheduledNotPending
+	}
+	m.State = db.ScheduledCancelled
+	m.UpdatedAt = now
+	return m, nil
+}
+
+// Wake makes the scheduler look for due messages now. It never blocks.
+func (s *Scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches scheduled messages as they come due until ctx is
+// cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		timer := s.after(s.dispatchDue())
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer:
+		}
+	}
+}
+
+// dispatchDue dispatches every message that is due and returns how long to
+// sleep before looking again.
+func (s *Scheduler) dispatchDue() time.Duration {
+	for {
+		m, err := s.app.Store.NextScheduledMessage(s.now().UnixMilli())
+		if err != nil {
+			s.app.Logger.Error().Err(err).Msg("Failed to read scheduled messages")
+			return schedulerMaxWait
+		}
+		if m == nil {
+			break
+		}
+		if err := s.dispatch(m); err != nil {
+			s.app.Logger.Error().Err(err).Int64("scheduled_id", m.ID).Msg("Failed to dispatch scheduled message")
+			return schedulerMaxWait
+		}
+	}
+
+	next, ok, err := s.app.Store.NextScheduledSendAt()
+	if err != nil {
+		s.app.Logger.Error().Err(err).Msg("Failed to read scheduled messages")
+		return schedulerMaxWait
+	}
+	if !ok {
+		return schedulerMaxWait
+	}
+	return min(max(time.Duration(next-s.now().UnixMilli())*time.Millisecond, 0), schedulerMaxWait)
+}
+
+// dispatch hands m to the outbox. A draft that has since been deleted
+// fails m rather than sending nothing.
+func (s *Scheduler) dispatch(m *db.ScheduledMessage) error {
+	now := s.now()
+	body := m.Body
+	if body == "" && m.DraftID != "" {
+		d, err := s.app.Store.GetDraft(m.DraftID)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			m.State = db.ScheduledFailed
+			m.LastError = "the draft was deleted before it could be sent"
+			m.UpdatedAt = now.UnixMilli()
+			return s.app.Store.UpdateScheduledMessage(m)
+		}
+		body = d.Body
+	}
+
+	it := newOutboxItem(SendRequest{ConversationID: m.ConversationID, Body: body, ReplyToID: m.ReplyToID}, now)
+	ok, err := s.app.Store.DispatchScheduledMessage(m, it)
+	if err != nil || !ok {
+		// Not ok: cancelled while we were reading it.
+		return err
+	}
+	s.app.Logger.Info().
+		Int64("scheduled_id", m.ID).
+		Int64("outbox_id", it.ID).
+		Str("conv_id", m.ConversationID).
+		Msg("Dispatched scheduled message")
+	if s.app.Outbox != nil {
+		s.app.Outbox.Wake()
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func testScheduler(t *testing.T) (*Scheduler, *time.Time) {
+	t.Helper()
+	a := newBackfillApp(t)
+	s := NewScheduler(a)
+	now := time.UnixMilli(1_000_000)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestSchedulerDispatchesWhenDue(t *testing.T) {
+	s, now := testScheduler(t)
+
+	m, err := s.Schedule(ScheduleRequest{ConversationID: "c1", Body: "good morning", SendAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := s.dispatchDue(); wait != schedulerMaxWait {
+		t.Errorf("wait an hour out: got %s, want the %s cap", wait, schedulerMaxWait)
+	}
+	*now = now.Add(59*time.Minute + 30*time.Second)
+	if wait := s.dispatchDue(); wait != 30*time.Second {
+		t.Errorf("wait: got %s, want 30s", wait)
+	}
+
+	// A scheduler started after the send time, as after a restart, sends it
+	// straight away.
+	*now = now.Add(time.Hour)
+	restarted := NewScheduler(s.app)
+	restarted.now = s.now
+	restarted.dispatchDue()
+
+	got, _ := s.app.Store.GetScheduledMessage(m.ID)
+	if got.State != db.ScheduledDispatched || got.OutboxID == 0 {
+		t.Fatalf("after due: %+v", got)
+	}
+	it, _ := s.app.Store.GetOutboxItem(got.OutboxID)
+	if it == nil || it.ConversationID != "c1" || it.Body != "good morning" || it.State != db.OutboxQueued {
+		t.Errorf("outbox item: %+v", it)
+	}
+}
+
+func TestSchedulerSendsDraftTextAtSendTime(t *testing.T) {
+	s, now := testScheduler(t)
+	s.app.Store.UpsertDraft(&db.Draft{DraftID: "d1", ConversationID: "c1", Body: "first take", CreatedAt: 1})
+	s.app.Store.UpsertDraft(&db.Draft{DraftID: "d2", ConversationID: "c1", Body: "gone soon", CreatedAt: 1})
+
+	m1, err := s.Schedule(ScheduleRequest{DraftID: "d1", SendAt: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m1.ConversationID != "c1" {
+		t.Errorf("conversation from draft: got %q", m1.ConversationID)
+	}
+	m2, _ := s.Schedule(ScheduleRequest{DraftID: "d2", SendAt: now.Add(time.Minute)})
+	s.app.Store.UpsertDraft(&db.Draft{DraftID: "d1", ConversationID: "c1", Body: "edited", CreatedAt: 2})
+	s.app.Store.DeleteDraft("d2")
+
+	*now = now.Add(time.Minute)
+	s.dispatchDue()
+
+	got, _ := s.app.Store.GetScheduledMessage(m1.ID)
+	if it, _ := s.app.Store.GetOutboxItem(got.OutboxID); it == nil || it.Body != "edited" {
+		t.Errorf("draft send: %+v", it)
+	}
+	if got, _ := s.app.Store.GetScheduledMessage(m2.ID); got.State != db.ScheduledFailed || got.LastError == "" {
+		t.Errorf("deleted draft: %+v", got)
+	}
+}
+
+func TestSchedulerRejectsAndCancels(t *testing.T) {
+	s, now := testScheduler(t)
+
+	if _, err := s.Schedule(ScheduleRequest{ConversationID: "c1", Body: "hi", SendAt: *now}); !errors.Is(err, ErrSendTimeInPast) {
+		t.Errorf("past time: got %v", err)
+	}
+	if _, err := s.Schedule(ScheduleRequest{ConversationID: "c1", SendAt: now.Add(time.Hour)}); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("no text: got %v", err)
+	}
+	if _, err := s.Schedule(ScheduleRequest{DraftID: "nope", SendAt: now.Add(time.Hour)}); !errors.Is(err, ErrDraftNotFound) {
+		t.Errorf("missing draft: got %v", err)
+	}
+
+	m, _ := s.Schedule(ScheduleRequest{ConversationID: "c1", Body: "hi", SendAt: now.Add(time.Hour)})
+	if got, err := s.Cancel(m.ID); err != nil || got.State != db.ScheduledCancelled {
+		t.Fatalf("cancel: %+v, %v", got, err)
+	}
+	if _, err := s.Cancel(m.ID); !errors.Is(err, ErrScheduledNotPending) {
+		t.Errorf("second cancel: got %v", err)
+	}
+	if got, err := s.Cancel(999); got != nil || err != nil {
+		t.Errorf("missing: %v, %v", got, err)
+	}
+
+	*now = now.Add(2 * time.Hour)
+	s.dispatchDue()
+	if items, _ := s.app.Store.ListOutbox("", 10); len(items) != 0 {
+		t.Errorf("cancelled message was dispatched: %+v", items)
+	}
+}
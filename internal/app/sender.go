@@ -0,0 +1,296 @@
+package app
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+var (
+	// ErrNoRecipient is returned for a send with neither a conversation ID
+	// nor a phone number.
+	ErrNoRecipient = errors.New("conversation_id or phone_number is required")
+	// ErrEmptyMessage is returned for a send with neither text nor media.
+	ErrEmptyMessage = errors.New("message or media is required")
+	// ErrMaybeSent wraps an error from the send request itself: the
+	// message may have reached the phone anyway, so sending it again
+	// could deliver it twice.
+	ErrMaybeSent = errors.New("it may or may not have been sent")
+)
+
+// SendRequest is one outgoing message. ConversationID wins over
+// PhoneNumber; with only a phone number, the conversation with that number
+// is found or created.
+type SendRequest struct {
+	ConversationID string
+	PhoneNumber    string
+	Body           string
+	ReplyToID      string
+	Media          *OutgoingMedia
+}
+
+func (r SendRequest) validate() error {
+	if r.ConversationID == "" && r.PhoneNumber == "" {
+		return ErrNoRecipient
+	}
+	if r.Body == "" && r.Media == nil {
+		return ErrEmptyMessage
+	}
+	return nil
+}
+
+// OutgoingMedia is an attachment to upload and send. A message with media
+// carries no text.
+type OutgoingMedia struct {
+	Data     []byte
+	Filename string
+	MimeType string
+}
+
+// SendResult is what Google answered. A send Google refused is not an error:
+// Success is false and Status says why.
+type SendResult struct {
+	ConversationID string `json:"conversation_id"`
+	// MessageID is the tmp_ placeholder stored locally until Google echoes
+	// the real message back.
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	Success   bool   `json:"success"`
+}
+
+// sendClient is the part of the Google Messages client that sending needs.
+type sendClient interface {
+	GetConversation(conversationID string) (*gmproto.Conversation, error)
+	GetOrCreateConversation(req *gmproto.GetOrCreateConversationRequest) (*gmproto.GetOrCreateConversationResponse, error)
+	UploadMedia(data []byte, fileName, mime string) (*gmproto.MediaContent, error)
+	SendMessage(payload *gmproto.SendMessageRequest) (*gmproto.SendMessageResponse, error)
+}
+
+// Sender is the one path every outgoing message takes, whether it comes
+// from the web UI, an MCP tool or the command line. It resolves the
+// recipient, picks the SIM, builds the payload, stores a placeholder for
+// the sent message and maps Google's answer to a SendResult.
+type Sender struct {
+	app *App
+
+	// client is swapped out in tests.
+	client func() sendClient
+}
+
+// NewSender returns a sender that uses a's current client.
+func NewSender(a *App) *Sender {
+	return &Sender{
+		app: a,
+		client: func() sendClient {
+			if cli := a.Client(); cli != nil {
+				return cli.GM
+			}
+			return nil
+		},
+	}
+}
+
+// Send sends req. It returns ErrNotConnected without a client, and wraps
+// errors from Google. Errors from the send request itself also wrap
+// ErrMaybeSent.
+func (s *Sender) Send(req SendRequest) (*SendResult, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	gm := s.client()
+	if gm == nil {
+		return nil, ErrNotConnected
+	}
+
+	conv, err := s.resolve(gm, req)
+	if err != nil {
+		return nil, err
+	}
+	convID := conv.GetConversationID()
+	participantID, sim := SendIdentity(conv)
+
+	var payload *gmproto.SendMessageRequest
+	var uploaded *gmproto.MediaContent
+	if req.Media != nil {
+		uploaded, err = gm.UploadMedia(req.Media.Data, req.Media.Filename, req.Media.MimeType)
+		if err != nil {
+			return nil, fmt.Errorf("upload media: %w", err)
+		}
+		payload = BuildSendMediaPayload(convID, uploaded, participantID, sim)
+	} else {
+		payload = BuildSendPayload(convID, req.Body, req.ReplyToID, participantID, sim)
+	}
+
+	evt := s.app.Logger.Info().
+		Str("conv_id", convID).
+		Str("participant_id", participantID).
+		Bool("has_sim", sim != nil)
+	if req.Media != nil {
+		evt = evt.Str("mime", req.Media.MimeType).Str("filename", req.Media.Filename).Int("size", len(req.Media.Data))
+	}
+	evt.Msg("Sending message")
+
+	resp, err := gm.SendMessage(payload)
+	if err != nil {
+		return nil, fmt.Errorf("send message: %w (%w)", err, ErrMaybeSent)
+	}
+	result := &SendResult{
+		ConversationID: convID,
+		MessageID:      payload.TmpID,
+		Status:         resp.GetStatus().String(),
+		Success:        resp.GetStatus() == gmproto.SendMessageResponse_SUCCESS,
+	}
+	if result.Success {
+		s.storeSent(req, payload.TmpID, convID, uploaded)
+	}
+	return result, nil
+}
+
+// resolve fetches the conversation to send to, creating one for a phone
+// number if needed.
+func (s *Sender) resolve(gm sendClient, req SendRequest) (*gmproto.Conversation, error) {
+	if req.ConversationID != "" {
+		conv, err := gm.GetConversation(req.ConversationID)
+		if err != nil {
+			return nil, fmt.Errorf("get conversation: %w", err)
+		}
+		return conv, nil
+	}
+
+	resp, err := gm.GetOrCreateConversation(&gmproto.GetOrCreateConversationRequest{
+		Numbers: []*gmproto.ContactNumber{
+			{
+				MysteriousInt: 7,
+				Number:        req.PhoneNumber,
+				Number2:       req.PhoneNumber,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get or create conversation: %w", err)
+	}
+	conv := resp.GetConversation()
+	if conv == nil {
+		return nil, errors.New("get or create conversation: no conversation returned")
+	}
+	// Store it so the placeholder message has a conversation to show in.
+	if err := s.app.storeConversation(conv, ""); err != nil {
+		s.app.Logger.Warn().Err(err).Msg("Failed to store conversation")
+	}
+	return conv, nil
+}
+
+// storeSent stores a placeholder for a message Google accepted, so it shows
+// before Google echoes it back, and bumps its conversation to the top.
+func (s *Sender) storeSent(req SendRequest, tmpID, convID string, uploaded *gmproto.MediaContent) {
+	now := time.Now().UnixMilli()
+	msg := &db.Message{
+		MessageID:      tmpID,
+		TmpID:          tmpID,
+		ConversationID: convID,
+		Body:           req.Body,
+		IsFromMe:       true,
+		TimestampMS:    now,
+		Status:         "OUTGOING_SENDING",
+		ReplyToID:      req.ReplyToID,
+	}
+	if uploaded != nil {
+		msg.Body = ""
+		msg.MediaID = uploaded.MediaID
+		msg.MimeType = uploaded.MimeType
+		msg.DecryptionKey = hex.EncodeToString(uploaded.DecryptionKey)
+		// We already have the plaintext; no need to download it back.
+		if s.app.Media != nil {
+			if err := s.app.Media.Put(uploaded.MediaID, req.Media.MimeType, req.Media.Data); err != nil {
+				s.app.Logger.Warn().Err(err).Msg("Failed to cache sent media")
+			}
+		}
+	}
+	// The echo can beat us here, in which case it is already stored.
+	if added, err := s.app.Store.AddPendingMessage(msg); err != nil {
+		s.app.Logger.Warn().Err(err).Msg("Failed to store sent message")
+	} else if added && s.app.Events != nil {
+		if stored, err := s.app.Store.GetMessageByID(tmpID); err == nil && stored != nil {
+			s.app.Events.Publish(bus.MessageAdded{Message: stored})
+		}
+	}
+	s.app.Store.UpdateConversationTimestamp(convID, now)
+}
+
+// SendIdentity returns our participant ID in conv and the SIM to send
+// from: our participant's SIM, else the conversation's.
+func SendIdentity(conv *gmproto.Conversation) (participantID string, sim *gmproto.SIMPayload) {
+	for _, p := range conv.GetParticipants() {
+		if p.GetIsMe() {
+			if id := p.GetID(); id != nil {
+				participantID = id.GetNumber()
+			}
+			sim = p.GetSimPayload()
+			break
+		}
+	}
+	if sim == nil {
+		if sc := conv.GetSimCard(); sc != nil {
+			sim = sc.GetSIMData().GetSIMPayload()
+		}
+	}
+	return participantID, sim
+}
+
+// BuildSendPayload constructs a SendMessageRequest matching the format used by
+// the mautrix bridge: MessageInfo array (not MessagePayloadContent), TmpID in 3
+// places, SIMPayload, and ParticipantID.
+func BuildSendPayload(conversationID, message, replyToID, participantID string, sim *gmproto.SIMPayload) *gmproto.SendMessageRequest {
+	tmpID := fmt.Sprintf("tmp_%012d", rand.Int63n(1e12))
+	req := &gmproto.SendMessageRequest{
+		ConversationID: conversationID,
+		MessagePayload: &gmproto.MessagePayload{
+			TmpID:                 tmpID,
+			MessagePayloadContent: nil,
+			MessageInfo: []*gmproto.MessageInfo{{
+				Data: &gmproto.MessageInfo_MessageContent{MessageContent: &gmproto.MessageContent{
+					Content: message,
+				}},
+			}},
+			ConversationID: conversationID,
+			ParticipantID:  participantID,
+			TmpID2:         tmpID,
+		},
+		SIMPayload: sim,
+		TmpID:      tmpID,
+	}
+	if replyToID != "" {
+		req.Reply = &gmproto.ReplyPayload{
+			MessageID: replyToID,
+		}
+	}
+	return req
+}
+
+// BuildSendMediaPayload constructs a SendMessageRequest with a MediaContent attachment
+// instead of text. Uses the same MessageInfo array format as BuildSendPayload.
+func BuildSendMediaPayload(conversationID string, media *gmproto.MediaContent, participantID string, sim *gmproto.SIMPayload) *gmproto.SendMessageRequest {
+	tmpID := fmt.Sprintf("tmp_%012d", rand.Int63n(1e12))
+	return &gmproto.SendMessageRequest{
+		ConversationID: conversationID,
+		MessagePayload: &gmproto.MessagePayload{
+			TmpID:                 tmpID,
+			MessagePayloadContent: nil,
+			MessageInfo: []*gmproto.MessageInfo{{
+				Data: &gmproto.MessageInfo_MediaContent{MediaContent: media},
+			}},
+			ConversationID: conversationID,
+			ParticipantID:  participantID,
+			TmpID2:         tmpID,
+		},
+		SIMPayload: sim,
+		TmpID:      tmpID,
+	}
+}
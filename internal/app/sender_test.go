@@ -0,0 +1,308 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// fakeSendClient knows one conversation, "conv-1", with +15550001111 and
+// records what is sent.
+type fakeSendClient struct {
+	status  gmproto.SendMessageResponse_Status
+	sendErr error
+	sent    []*gmproto.SendMessageRequest
+	created []string
+}
+
+func (f *fakeSendClient) conversation() *gmproto.Conversation {
+	return &gmproto.Conversation{
+		ConversationID: "conv-1",
+		Name:           "Alice",
+		Participants: []*gmproto.Participant{
+			{IsMe: true, ID: &gmproto.SmallInfo{Number: "me-7"}, SimPayload: &gmproto.SIMPayload{SIMNumber: 2}},
+			{FullName: "Alice", ID: &gmproto.SmallInfo{Number: "+15550001111"}},
+		},
+	}
+}
+
+func (f *fakeSendClient) GetConversation(convID string) (*gmproto.Conversation, error) {
+	if convID != "conv-1" {
+		return nil, errors.New("no such conversation")
+	}
+	return f.conversation(), nil
+}
+
+func (f *fakeSendClient) GetOrCreateConversation(req *gmproto.GetOrCreateConversationRequest) (*gmproto.GetOrCreateConversationResponse, error) {
+	f.created = append(f.created, req.GetNumbers()[0].GetNumber())
+	return &gmproto.GetOrCreateConversationResponse{Conversation: f.conversation()}, nil
+}
+
+func (f *fakeSendClient) UploadMedia(data []byte, fileName, mime string) (*gmproto.MediaContent, error) {
+	return &gmproto.MediaContent{MediaID: "media-1", MediaName: fileName, MimeType: mime, DecryptionKey: []byte{0xab}}, nil
+}
+
+func (f *fakeSendClient) SendMessage(payload *gmproto.SendMessageRequest) (*gmproto.SendMessageResponse, error) {
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	f.sent = append(f.sent, payload)
+	return &gmproto.SendMessageResponse{Status: f.status}, nil
+}
+
+func testSender(t *testing.T) (*Sender, *fakeSendClient) {
+	t.Helper()
+	a := newBackfillApp(t)
+	fake := &fakeSendClient{status: gmproto.SendMessageResponse_SUCCESS}
+	s := NewSender(a)
+	s.client = func() sendClient { return fake }
+	return s, fake
+}
+
+func TestSenderSendsToConversation(t *testing.T) {
+	s, fake := testSender(t)
+	s.app.Store.UpsertConversation(&db.Conversation{ConversationID: "conv-1", Name: "Alice", LastMessageTS: 1})
+
+	res, err := s.Send(SendRequest{ConversationID: "conv-1", Body: "hi", ReplyToID: "orig"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || res.Status != "SUCCESS" || res.ConversationID != "conv-1" {
+		t.Errorf("result: %+v", res)
+	}
+
+	if len(fake.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(fake.sent))
+	}
+	p := fake.sent[0]
+	if p.MessagePayload.ParticipantID != "me-7" || p.SIMPayload.GetSIMNumber() != 2 {
+		t.Errorf("identity: participant %q, sim %v", p.MessagePayload.ParticipantID, p.SIMPayload)
+	}
+	if p.GetReply().GetMessageID() != "orig" || p.TmpID != res.MessageID {
+		t.Errorf("payload: reply %v, tmp id %q", p.GetReply(), p.TmpID)
+	}
+
+	msg, err := s.app.Store.GetMessageByID(res.MessageID)
+	if err != nil || msg == nil {
+		t.Fatalf("placeholder not stored: %v", err)
+	}
+	if msg.Body != "hi" || !msg.IsFromMe || msg.ReplyToID != "orig" || msg.Status != "OUTGOING_SENDING" {
+		t.Errorf("placeholder: %+v", msg)
+	}
+	conv, _ := s.app.Store.GetConversation("conv-1")
+	if conv.LastMessageTS != msg.TimestampMS {
+		t.Errorf("conversation not bumped: %d vs %d", conv.LastMessageTS, msg.TimestampMS)
+	}
+}
+
+func TestSenderResolvesPhoneNumber(t *testing.T) {
+	s, fake := testSender(t)
+
+	res, err := s.Send(SendRequest{PhoneNumber: "+15550001111", Body: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.created) != 1 || fake.created[0] != "+15550001111" {
+		t.Errorf("created: %v", fake.created)
+	}
+	if res.ConversationID != "conv-1" || fake.sent[0].MessagePayload.ParticipantID != "me-7" {
+		t.Errorf("result %+v, payload %+v", res, fake.sent[0].MessagePayload)
+	}
+	// The new conversation is stored so the placeholder has somewhere to show.
+	if conv, err := s.app.Store.GetConversation("conv-1"); err != nil || conv.Name != "Alice" {
+		t.Errorf("conversation: %+v, %v", conv, err)
+	}
+}
+
+func TestSenderSendsMedia(t *testing.T) {
+	s, fake := testSender(t)
+
+	res, err := s.Send(SendRequest{
+		ConversationID: "conv-1",
+		Media:          &OutgoingMedia{Data: []byte("jpeg"), Filename: "a.jpg", MimeType: "image/jpeg"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.sent[0].MessagePayload.MessageInfo[0].GetMediaContent().GetMediaID(); got != "media-1" {
+		t.Errorf("payload media id: %q", got)
+	}
+	msg, _ := s.app.Store.GetMessageByID(res.MessageID)
+	if msg == nil || msg.MediaID != "media-1" || msg.MimeType != "image/jpeg" || msg.DecryptionKey != "ab" {
+		t.Errorf("placeholder: %+v", msg)
+	}
+}
+
+func TestSenderRefusedSendStoresNothing(t *testing.T) {
+	s, fake := testSender(t)
+	fake.status = gmproto.SendMessageResponse_FAILURE_2
+
+	res, err := s.Send(SendRequest{ConversationID: "conv-1", Body: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success || res.Status != "FAILURE_2" {
+		t.Errorf("result: %+v", res)
+	}
+	if msg, _ := s.app.Store.GetMessageByID(res.MessageID); msg != nil {
+		t.Error("refused send should not leave a placeholder")
+	}
+}
+
+func TestSenderErrors(t *testing.T) {
+	s, fake := testSender(t)
+
+	if _, err := s.Send(SendRequest{Body: "hi"}); !errors.Is(err, ErrNoRecipient) {
+		t.Errorf("no recipient: got %v", err)
+	}
+	if _, err := s.Send(SendRequest{ConversationID: "conv-1"}); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("empty message: got %v", err)
+	}
+	if _, err := s.Send(SendRequest{ConversationID: "missing", Body: "hi"}); err == nil || !strings.Contains(err.Error(), "get conversation") {
+		t.Errorf("unknown conversation: got %v", err)
+	}
+	fake.sendErr = errors.New("boom")
+	if _, err := s.Send(SendRequest{ConversationID: "conv-1", Body: "hi"}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("send failure: got %v", err)
+	}
+
+	s.client = func() sendClient { return nil }
+	if _, err := s.Send(SendRequest{ConversationID: "conv-1", Body: "hi"}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("no client: got %v", err)
+	}
+}
+
+func TestBuildSendPayload(t *testing.T) {
+	sim := &gmproto.SIMPayload{SIMNumber: 1}
+	payload := BuildSendPayload("conv-1", "Hello world", "", "+15551234567", sim)
+
+	// Must use MessageInfo array (not MessagePayloadContent)
+	if payload.MessagePayload.MessagePayloadContent != nil {
+		t.Error("MessagePayloadContent must be nil; use MessageInfo instead")
+	}
+	if len(payload.MessagePayload.MessageInfo) != 1 {
+		t.Fatalf("expected 1 MessageInfo entry, got %d", len(payload.MessagePayload.MessageInfo))
+	}
+	mc := payload.MessagePayload.MessageInfo[0].GetMessageContent()
+	if mc == nil || mc.Content != "Hello world" {
+		t.Errorf("MessageContent mismatch: %+v", mc)
+	}
+
+	// TmpID format: tmp_ followed by 12 digits
+	if !strings.HasPrefix(payload.TmpID, "tmp_") || len(payload.TmpID) != 16 {
+		t.Errorf("TmpID format wrong: %q (want tmp_ + 12 digits)", payload.TmpID)
+	}
+	// TmpID must be in all 3 places
+	if payload.MessagePayload.TmpID != payload.TmpID {
+		t.Error("MessagePayload.TmpID must match root TmpID")
+	}
+	if payload.MessagePayload.TmpID2 != payload.TmpID {
+		t.Error("MessagePayload.TmpID2 must match root TmpID")
+	}
+
+	// SIM payload must be set
+	if payload.SIMPayload == nil {
+		t.Error("SIMPayload must not be nil")
+	}
+	if payload.SIMPayload.SIMNumber != 1 {
+		t.Errorf("SIMNumber = %d, want 1", payload.SIMPayload.SIMNumber)
+	}
+
+	// ParticipantID
+	if payload.MessagePayload.ParticipantID != "+15551234567" {
+		t.Errorf("ParticipantID = %q, want +15551234567", payload.MessagePayload.ParticipantID)
+	}
+
+	// ConversationID in both places
+	if payload.ConversationID != "conv-1" {
+		t.Errorf("root ConversationID = %q", payload.ConversationID)
+	}
+	if payload.MessagePayload.ConversationID != "conv-1" {
+		t.Errorf("payload ConversationID = %q", payload.MessagePayload.ConversationID)
+	}
+}
+
+func TestBuildSendPayloadWithReply(t *testing.T) {
+	payload := BuildSendPayload("conv-1", "Reply text", "orig-msg-id", "+15551234567", nil)
+	if payload.Reply == nil {
+		t.Fatal("Reply must be set when replyToID is provided")
+	}
+	if payload.Reply.MessageID != "orig-msg-id" {
+		t.Errorf("Reply.MessageID = %q, want orig-msg-id", payload.Reply.MessageID)
+	}
+}
+
+func TestBuildSendPayloadNoReply(t *testing.T) {
+	payload := BuildSendPayload("conv-1", "No reply", "", "+15551234567", nil)
+	if payload.Reply != nil {
+		t.Error("Reply must be nil when replyToID is empty")
+	}
+}
+
+func TestBuildSendMediaPayload(t *testing.T) {
+	sim := &gmproto.SIMPayload{SIMNumber: 1}
+	media := &gmproto.MediaContent{
+		Format:    4, // image
+		MediaID:   "media-abc-123",
+		MediaName: "photo.jpg",
+		Size:      54321,
+		MimeType:  "image/jpeg",
+	}
+	payload := BuildSendMediaPayload("conv-1", media, "+15551234567", sim)
+
+	// Must use MessageInfo with MediaContent (not MessageContent)
+	if payload.MessagePayload.MessagePayloadContent != nil {
+		t.Error("MessagePayloadContent must be nil; use MessageInfo instead")
+	}
+	if len(payload.MessagePayload.MessageInfo) != 1 {
+		t.Fatalf("expected 1 MessageInfo entry, got %d", len(payload.MessagePayload.MessageInfo))
+	}
+
+	// Should have MediaContent, not MessageContent
+	mc := payload.MessagePayload.MessageInfo[0].GetMessageContent()
+	if mc != nil {
+		t.Error("MessageContent should be nil for media messages")
+	}
+	mediaCont := payload.MessagePayload.MessageInfo[0].GetMediaContent()
+	if mediaCont == nil {
+		t.Fatal("MediaContent must be set")
+	}
+	if mediaCont.MediaID != "media-abc-123" {
+		t.Errorf("MediaID = %q, want media-abc-123", mediaCont.MediaID)
+	}
+	if mediaCont.MimeType != "image/jpeg" {
+		t.Errorf("MimeType = %q, want image/jpeg", mediaCont.MimeType)
+	}
+
+	// TmpID format: tmp_ followed by 12 digits
+	if !strings.HasPrefix(payload.TmpID, "tmp_") || len(payload.TmpID) != 16 {
+		t.Errorf("TmpID format wrong: %q (want tmp_ + 12 digits)", payload.TmpID)
+	}
+	// TmpID must be in all 3 places
+	if payload.MessagePayload.TmpID != payload.TmpID {
+		t.Error("MessagePayload.TmpID must match root TmpID")
+	}
+	if payload.MessagePayload.TmpID2 != payload.TmpID {
+		t.Error("MessagePayload.TmpID2 must match root TmpID")
+	}
+
+	// SIM payload must be set
+	if payload.SIMPayload == nil || payload.SIMPayload.SIMNumber != 1 {
+		t.Error("SIMPayload not set correctly")
+	}
+
+	// ParticipantID and ConversationID
+	if payload.MessagePayload.ParticipantID != "+15551234567" {
+		t.Errorf("ParticipantID = %q, want +15551234567", payload.MessagePayload.ParticipantID)
+	}
+	if payload.ConversationID != "conv-1" {
+		t.Errorf("root ConversationID = %q", payload.ConversationID)
+	}
+	if payload.MessagePayload.ConversationID != "conv-1" {
+		t.Errorf("payload ConversationID = %q", payload.MessagePayload.ConversationID)
+	}
+}
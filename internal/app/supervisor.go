@@ -0,0 +1,237 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/events"
+
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+// ErrUnpaired is recorded when the session is deleted on purpose.
+var ErrUnpaired = errors.New("unpaired")
+
+// ConnState is the supervisor's view of the connection.
+type ConnState string
+
+const (
+	StateConnected    ConnState = "connected"
+	StateReconnecting ConnState = "reconnecting"
+	// StateDisconnected means no connection has been attempted yet.
+	StateDisconnected ConnState = "disconnected"
+	// StateNeedsPairing means retrying can't help: the phone unpaired us,
+	// the account logged out or the credentials were revoked.
+	StateNeedsPairing ConnState = "needs_pairing"
+)
+
+// ReconnectEvent is one entry in the supervisor's history.
+type ReconnectEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"` // disconnected, retry_failed, reconnected, gave_up
+	Attempt int       `json:"attempt,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// ConnectionStatus is a snapshot for /api/status and get_status.
+type ConnectionStatus struct {
+	State         ConnState        `json:"state"`
+	LastError     string           `json:"last_error,omitempty"`
+	Attempt       int              `json:"attempt,omitempty"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	Reconnects    int              `json:"reconnects"`
+	History       []ReconnectEvent `json:"history"`
+}
+
+const maxReconnectHistory = 50
+
+// Supervisor restores the connection to Google Messages after the listener
+// dies. Transient failures are retried with exponential backoff and jitter;
+// unrecoverable ones stop retrying until the app is re-paired.
+type Supervisor struct {
+	reconnect     func() error
+	onReconnected func()
+	logger        zerolog.Logger
+
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	wake chan error
+
+	mu     sync.Mutex
+	status ConnectionStatus
+
+	// Swapped out in tests.
+	after  func(time.Duration) <-chan time.Time
+	jitter func() float64
+}
+
+// NewSupervisor returns a supervisor in the connected state. reconnect is
+// called to re-establish the connection; onReconnected runs after each
+// success.
+func NewSupervisor(reconnect func() error, onReconnected func(), logger zerolog.Logger) *Supervisor {
+	return &Supervisor{
+		reconnect:     reconnect,
+		onReconnected: onReconnected,
+		logger:        logger,
+		MinDelay:      2 * time.Second,
+		MaxDelay:      5 * time.Minute,
+		wake:          make(chan error, 1),
+		status:        ConnectionStatus{State: StateConnected},
+		after:         time.After,
+		jitter:        rand.Float64,
+	}
+}
+
+// Disconnected reports that the connection was lost. It never blocks; a
+// report that arrives while a reconnect is already pending is folded into it.
+func (s *Supervisor) Disconnected(err error) {
+	if err == nil {
+		err = errors.New("disconnected")
+	}
+	select {
+	case s.wake <- err:
+	default:
+	}
+}
+
+// Run handles disconnects until ctx is cancelled.
+func (s *Supervisor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-s.wake:
+			s.recover(ctx, err)
+		}
+	}
+}
+
+func (s *Supervisor) recover(ctx context.Context, cause error) {
+	s.record(ReconnectEvent{Kind: "disconnected", Error: cause.Error()})
+	if IsUnrecoverable(cause) {
+		s.GiveUp(cause)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		delay := s.backoff(attempt)
+		next := time.Now().Add(delay)
+		s.mu.Lock()
+		s.status.State = StateReconnecting
+		s.status.Attempt = attempt
+		s.status.NextAttemptAt = &next
+		s.mu.Unlock()
+		s.logger.Warn().Err(cause).Int("attempt", attempt).Dur("delay", delay).Msg("Reconnecting to Google Messages")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.after(delay):
+		}
+
+		// Anything reported while we waited is about the connection we're
+		// replacing.
+		select {
+		case <-s.wake:
+		default:
+		}
+
+		err := s.reconnect()
+		if err == nil {
+			s.mu.Lock()
+			s.status.State = StateConnected
+			s.status.LastError = ""
+			s.status.Attempt = 0
+			s.status.NextAttemptAt = nil
+			s.status.Reconnects++
+			s.mu.Unlock()
+			s.record(ReconnectEvent{Kind: "reconnected", Attempt: attempt})
+			s.logger.Info().Int("attempt", attempt).Msg("Reconnected to Google Messages")
+			if s.onReconnected != nil {
+				s.onReconnected()
+			}
+			return
+		}
+
+		cause = err
+		s.record(ReconnectEvent{Kind: "retry_failed", Attempt: attempt, Error: err.Error()})
+		if IsUnrecoverable(err) {
+			s.GiveUp(err)
+			return
+		}
+	}
+}
+
+// GiveUp records that the connection can't come back without re-pairing.
+func (s *Supervisor) GiveUp(cause error) {
+	s.mu.Lock()
+	s.status.State = StateNeedsPairing
+	s.status.Attempt = 0
+	s.status.NextAttemptAt = nil
+	s.mu.Unlock()
+	s.record(ReconnectEvent{Kind: "gave_up", Error: cause.Error()})
+	s.logger.Error().Err(cause).Msg("Connection lost for good; pair again to reconnect")
+}
+
+// backoff returns the wait before the given attempt (1-based): the delay
+// doubles each time up to MaxDelay, and a random half of it is kept so that
+// many clients don't retry in lockstep.
+func (s *Supervisor) backoff(attempt int) time.Duration {
+	d := s.MinDelay
+	for i := 1; i < attempt && d < s.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, s.MaxDelay)
+	return d/2 + time.Duration(s.jitter()*float64(d/2))
+}
+
+func (s *Supervisor) record(evt ReconnectEvent) {
+	evt.Time = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if evt.Error != "" {
+		s.status.LastError = evt.Error
+	}
+	s.status.History = append(s.status.History, evt)
+	if n := len(s.status.History); n > maxReconnectHistory {
+		s.status.History = append([]ReconnectEvent(nil), s.status.History[n-maxReconnectHistory:]...)
+	}
+}
+
+// Status returns a snapshot of the connection state and recent history,
+// newest event last.
+func (s *Supervisor) Status() ConnectionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	st.History = append([]ReconnectEvent{}, s.status.History...)
+	return st
+}
+
+// IsUnrecoverable reports whether err means the pairing itself is gone, so
+// reconnecting with the same session can never succeed.
+func IsUnrecoverable(err error) bool {
+	switch {
+	case errors.Is(err, ErrUnpaired),
+		errors.Is(err, client.ErrLoggedOut),
+		errors.Is(err, events.ErrInvalidCredentials),
+		errors.Is(err, events.ErrRequestedEntityNotFound):
+		return true
+	}
+	var httpErr events.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Resp != nil {
+		code := httpErr.Resp.StatusCode
+		return code == http.StatusUnauthorized || code == http.StatusForbidden
+	}
+	// libgm's Connect checks these before touching the network and only
+	// reports them as plain strings.
+	msg := err.Error()
+	return strings.Contains(msg, "no auth token") || strings.Contains(msg, "not logged in")
+}
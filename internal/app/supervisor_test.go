@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/events"
+
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+// testSupervisor returns a supervisor whose waits complete immediately and
+// whose requested delays are recorded, with no jitter.
+func testSupervisor(reconnect func() error, onReconnected func()) (*Supervisor, *[]time.Duration) {
+	s := NewSupervisor(reconnect, onReconnected, zerolog.Nop())
+	var mu sync.Mutex
+	var delays []time.Duration
+	s.after = func(d time.Duration) <-chan time.Time {
+		mu.Lock()
+		delays = append(delays, d)
+		mu.Unlock()
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	s.jitter = func() float64 { return 1 }
+	return s, &delays
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSupervisorRetriesTransientErrors(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	reconnected := make(chan struct{}, 1)
+	s, delays := testSupervisor(func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls < 4 {
+			return errors.New("dial tcp: connection refused")
+		}
+		return nil
+	}, func() { reconnected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	s.Disconnected(errors.New("http 502 while polling"))
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("never reconnected")
+	}
+
+	st := s.Status()
+	if st.State != StateConnected {
+		t.Errorf("state: got %s, want connected", st.State)
+	}
+	if st.Reconnects != 1 {
+		t.Errorf("reconnects: got %d, want 1", st.Reconnects)
+	}
+	if st.LastError != "" {
+		t.Errorf("last error should clear on success, got %q", st.LastError)
+	}
+
+	var kinds []string
+	for _, evt := range st.History {
+		kinds = append(kinds, evt.Kind)
+	}
+	want := []string{"disconnected", "retry_failed", "retry_failed", "retry_failed", "reconnected"}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("history: got %v, want %v", kinds, want)
+	}
+
+	// With jitter pinned to 1, delays double from MinDelay.
+	wantDelays := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second}
+	if fmt.Sprint(*delays) != fmt.Sprint(wantDelays) {
+		t.Errorf("delays: got %v, want %v", *delays, wantDelays)
+	}
+}
+
+func TestSupervisorGivesUpOnUnrecoverable(t *testing.T) {
+	calls := 0
+	s, _ := testSupervisor(func() error {
+		calls++
+		return nil
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	s.Disconnected(fmt.Errorf("failed to refresh auth token: %w", events.ErrInvalidCredentials))
+	waitFor(t, "needs_pairing", func() bool { return s.Status().State == StateNeedsPairing })
+
+	if calls != 0 {
+		t.Errorf("reconnect called %d times for revoked credentials", calls)
+	}
+	if s.Status().LastError == "" {
+		t.Error("expected last error to be recorded")
+	}
+}
+
+func TestSupervisorStopsWhenRetryTurnsUnrecoverable(t *testing.T) {
+	calls := 0
+	s, _ := testSupervisor(func() error {
+		calls++
+		if calls == 1 {
+			return errors.New("timeout")
+		}
+		return errors.New("not logged in")
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	s.Disconnected(errors.New("timeout"))
+	waitFor(t, "needs_pairing", func() bool { return s.Status().State == StateNeedsPairing })
+	if calls != 2 {
+		t.Errorf("reconnect called %d times, want 2", calls)
+	}
+}
+
+func TestSupervisorBackoffIsCappedAndJittered(t *testing.T) {
+	s := NewSupervisor(nil, nil, zerolog.Nop())
+	s.MinDelay = time.Second
+	s.MaxDelay = 10 * time.Second
+
+	s.jitter = func() float64 { return 1 }
+	if d := s.backoff(20); d != 10*time.Second {
+		t.Errorf("capped backoff: got %v, want 10s", d)
+	}
+	s.jitter = func() float64 { return 0 }
+	if d := s.backoff(20); d != 5*time.Second {
+		t.Errorf("minimum jittered backoff: got %v, want 5s", d)
+	}
+	if d := s.backoff(1); d != 500*time.Millisecond {
+		t.Errorf("first backoff: got %v, want 500ms", d)
+	}
+}
+
+func TestSupervisorHistoryIsBounded(t *testing.T) {
+	s := NewSupervisor(nil, nil, zerolog.Nop())
+	for i := 0; i < maxReconnectHistory+10; i++ {
+		s.record(ReconnectEvent{Kind: "retry_failed", Attempt: i})
+	}
+	st := s.Status()
+	if len(st.History) != maxReconnectHistory {
+		t.Fatalf("history length: got %d, want %d", len(st.History), maxReconnectHistory)
+	}
+	if st.History[len(st.History)-1].Attempt != maxReconnectHistory+9 {
+		t.Error("newest event should be kept last")
+	}
+}
+
+func TestIsUnrecoverable(t *testing.T) {
+	httpErr := func(code int) error {
+		return events.HTTPError{Action: "polling", Resp: &http.Response{StatusCode: code}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unpaired", ErrUnpaired, true},
+		{"logged out", client.ErrLoggedOut, true},
+		{"invalid credentials", fmt.Errorf("refresh: %w", events.ErrInvalidCredentials), true},
+		{"pairing deleted", events.ErrRequestedEntityNotFound, true},
+		{"http 401", httpErr(401), true},
+		{"http 403", httpErr(403), true},
+		{"http 502", httpErr(502), false},
+		{"no auth token", errors.New("no auth token"), true},
+		{"network", errors.New("dial tcp: i/o timeout"), false},
+	}
+	for _, tt := range tests {
+		if got := IsUnrecoverable(tt.err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
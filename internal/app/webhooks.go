@@ -0,0 +1,398 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+var (
+	// ErrWebhookURL is returned when creating or updating a webhook without
+	// an http or https URL.
+	ErrWebhookURL = errors.New("webhook url must be an http or https URL")
+	// ErrWebhookDeliveryBusy is returned when redelivering a webhook
+	// delivery that hasn't failed.
+	ErrWebhookDeliveryBusy = errors.New("webhook delivery is queued, delivering or already delivered")
+)
+
+// WebhookEventMessage is the event of a webhook delivery for an incoming
+// message, sent as the payload's event and in the X-OpenMessage-Event
+// header.
+const WebhookEventMessage = "message.received"
+
+// Headers on every webhook delivery.
+const (
+	WebhookEventHeader     = "X-OpenMessage-Event"
+	WebhookDeliveryHeader  = "X-OpenMessage-Delivery"
+	WebhookTimestampHeader = "X-OpenMessage-Timestamp"
+	WebhookSignatureHeader = "X-OpenMessage-Signature"
+)
+
+// WebhookPayload is the JSON body posted to a webhook.
+type WebhookPayload struct {
+	Event        string           `json:"event"`
+	WebhookID    int64            `json:"webhook_id"`
+	Message      *db.Message      `json:"message"`
+	Conversation *db.Conversation `json:"conversation,omitempty"`
+}
+
+// WebhookRequest creates or replaces a webhook. An empty Secret is
+// generated on create and kept on update; a nil Enabled means enabled.
+type WebhookRequest struct {
+	URL            string
+	Secret         string
+	ConversationID string
+	Sender         string
+	Keyword        string
+	Enabled        *bool
+}
+
+// SignWebhook returns the X-OpenMessage-Signature of a delivery: the
+// hex HMAC-SHA256, keyed with the webhook's secret, of the timestamp
+// header, a dot and the body, prefixed with "sha256=". Receivers compute
+// the same and compare.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhooks posts incoming messages to the webhooks whose filters they
+// match. Each post is logged as a delivery and retried with exponential
+// backoff until MaxAttempts, or until the receiver answers with a client
+// error that retrying won't fix.
+type Webhooks struct {
+	app *App
+
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+
+	wake chan struct{}
+
+	// Swapped out in tests.
+	client *http.Client
+	now    func() time.Time
+	after  func(time.Duration) <-chan time.Time
+}
+
+// NewWebhooks returns the webhook deliverer for a. Nothing is delivered
+// until Run is called.
+func NewWebhooks(a *App) *Webhooks {
+	return &Webhooks{
+		app:         a,
+		MinDelay:    10 * time.Second,
+		MaxDelay:    time.Hour,
+		MaxAttempts: 10,
+		wake:        make(chan struct{}, 1),
+		client:      &http.Client{Timeout: 15 * time.Second},
+		now:         time.Now,
+		after:       time.After,
+	}
+}
+
+// Create adds a webhook.
+func (w *Webhooks) Create(req WebhookRequest) (*db.Webhook, error) {
+	if err := validateWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+	if req.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		req.Secret = secret
+	}
+	now := w.now().UnixMilli()
+	hook := &db.Webhook{CreatedAt: now}
+	applyWebhookRequest(hook, req, now)
+	if err := w.app.Store.AddWebhook(hook); err != nil {
+		return nil, fmt.Errorf("add webhook: %w", err)
+	}
+	return hook, nil
+}
+
+// Update replaces a webhook's URL, filters and whether it is enabled, and
+// its secret if one is given. It returns nil, nil if there is no such
+// webhook.
+func (w *Webhooks) Update(id int64, req WebhookRequest) (*db.Webhook, error) {
+	if err := validateWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+	hook, err := w.app.Store.GetWebhook(id)
+	if err != nil || hook == nil {
+		return nil, err
+	}
+	if req.Secret == "" {
+		req.Secret = hook.Secret
+	}
+	applyWebhookRequest(hook, req, w.now().UnixMilli())
+	if err := w.app.Store.UpdateWebhook(hook); err != nil {
+		return nil, fmt.Errorf("update webhook: %w", err)
+	}
+	return hook, nil
+}
+
+func applyWebhookRequest(hook *db.Webhook, req WebhookRequest, now int64) {
+	hook.URL = req.URL
+	hook.Secret = req.Secret
+	hook.ConversationID = req.ConversationID
+	hook.Sender = req.Sender
+	hook.Keyword = req.Keyword
+	hook.Enabled = req.Enabled == nil || *req.Enabled
+	hook.UpdatedAt = now
+}
+
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrWebhookURL
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Redeliver queues a failed delivery to be posted again with a fresh set
+// of attempts. It returns nil, nil if there is no such delivery.
+func (w *Webhooks) Redeliver(id int64) (*db.WebhookDelivery, error) {
+	d, err := w.app.Store.GetWebhookDelivery(id)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	if d.State != db.WebhookFailed {
+		return nil, ErrWebhookDeliveryBusy
+	}
+	now := w.now().UnixMilli()
+	d.State = db.WebhookQueued
+	d.Attempts = 0
+	d.NextAttemptAt = now
+	d.UpdatedAt = now
+	if err := w.app.Store.UpdateWebhookDelivery(d); err != nil {
+		return nil, err
+	}
+	w.Wake()
+	return d, nil
+}
+
+// Handle queues a delivery of each incoming message to every enabled
+// webhook it matches. Run subscribes it to the app's events.
+func (w *Webhooks) Handle(evt bus.Event) {
+	added, ok := evt.Data.(bus.MessageAdded)
+	if !ok || added.Message.IsFromMe {
+		return
+	}
+	m := added.Message
+	hooks, err := w.app.Store.ListWebhooks()
+	if err != nil {
+		w.app.Logger.Error().Err(err).Msg("Failed to read webhooks")
+		return
+	}
+	var conv *db.Conversation
+	queued := false
+	for _, hook := range hooks {
+		if !hook.Enabled || !hook.Matches(m) {
+			continue
+		}
+		if conv == nil {
+			conv, _ = w.app.Store.GetConversation(m.ConversationID)
+		}
+		payload, err := json.Marshal(WebhookPayload{
+			Event:        WebhookEventMessage,
+			WebhookID:    hook.ID,
+			Message:      m,
+			Conversation: conv,
+		})
+		if err != nil {
+			w.app.Logger.Error().Err(err).Msg("Failed to encode webhook payload")
+			return
+		}
+		now := w.now().UnixMilli()
+		d := &db.WebhookDelivery{
+			WebhookID:     hook.ID,
+			MessageID:     m.MessageID,
+			Payload:       string(payload),
+			State:         db.WebhookQueued,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := w.app.Store.AddWebhookDelivery(d); err != nil {
+			w.app.Logger.Error().Err(err).Int64("webhook_id", hook.ID).Msg("Failed to queue webhook delivery")
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		w.Wake()
+	}
+}
+
+// Wake makes the worker look for due deliveries now. It never blocks.
+func (w *Webhooks) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run queues deliveries for incoming messages and posts them until ctx is
+// cancelled.
+func (w *Webhooks) Run(ctx context.Context) {
+	if n, err := w.app.Store.RequeueInterruptedWebhookDeliveries(w.now().UnixMilli()); err != nil {
+		w.app.Logger.Error().Err(err).Msg("Failed to recover webhook deliveries")
+	} else if n > 0 {
+		w.app.Logger.Warn().Int64("deliveries", n).Msg("Webhook deliveries were interrupted; retrying them")
+	}
+
+	stop := w.app.Events.Consume(w.Handle, func() {
+		w.app.Logger.Warn().Msg("Webhooks fell behind; some incoming messages were not delivered")
+	})
+	defer stop()
+
+	for {
+		var timer <-chan time.Time
+		if wait, ok := w.drain(); ok {
+			timer = w.after(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		case <-timer:
+		}
+	}
+}
+
+// drain posts every delivery that is due. It returns how long until the
+// next retry is due, or false if nothing is queued.
+func (w *Webhooks) drain() (time.Duration, bool) {
+	for {
+		d, err := w.app.Store.NextWebhookDelivery(w.now().UnixMilli())
+		if err != nil {
+			w.app.Logger.Error().Err(err).Msg("Failed to read webhook deliveries")
+			return w.MinDelay, true
+		}
+		if d == nil {
+			break
+		}
+		if err := w.process(d); err != nil {
+			return w.MinDelay, true
+		}
+	}
+
+	next, ok, err := w.app.Store.NextWebhookAttempt()
+	if err != nil {
+		w.app.Logger.Error().Err(err).Msg("Failed to read webhook deliveries")
+		return w.MinDelay, true
+	}
+	if !ok {
+		return 0, false
+	}
+	return max(time.Duration(next-w.now().UnixMilli())*time.Millisecond, 0), true
+}
+
+// process makes one attempt at posting d and records the outcome. It
+// returns an error only when the delivery log can't be updated.
+func (w *Webhooks) process(d *db.WebhookDelivery) error {
+	hook, err := w.app.Store.GetWebhook(d.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	d.State = db.WebhookDelivering
+	d.Attempts++
+	d.UpdatedAt = w.now().UnixMilli()
+	if err := w.app.Store.UpdateWebhookDelivery(d); err != nil {
+		w.app.Logger.Error().Err(err).Int64("delivery_id", d.ID).Msg("Failed to update webhook delivery")
+		return err
+	}
+
+	var retry bool
+	switch {
+	case hook == nil || !hook.Enabled:
+		err = errors.New("webhook was disabled")
+	default:
+		d.StatusCode, err = w.post(hook, d)
+		// Client errors other than timeouts and rate limits won't go away
+		// by trying again.
+		retry = err != nil && (d.StatusCode == 0 || d.StatusCode >= 500 ||
+			d.StatusCode == http.StatusRequestTimeout || d.StatusCode == http.StatusTooManyRequests)
+	}
+
+	now := w.now()
+	d.UpdatedAt = now.UnixMilli()
+	switch {
+	case err == nil:
+		d.State = db.WebhookDelivered
+		d.LastError = ""
+	case retry && d.Attempts < w.MaxAttempts:
+		d.State = db.WebhookQueued
+		d.NextAttemptAt = now.Add(backoff(d.Attempts, w.MinDelay, w.MaxDelay)).UnixMilli()
+		d.LastError = err.Error()
+	default:
+		d.State = db.WebhookFailed
+		d.LastError = err.Error()
+	}
+	if uerr := w.app.Store.UpdateWebhookDelivery(d); uerr != nil {
+		w.app.Logger.Error().Err(uerr).Int64("delivery_id", d.ID).Msg("Failed to update webhook delivery")
+		return uerr
+	}
+
+	evt := w.app.Logger.Debug()
+	if err != nil {
+		evt = w.app.Logger.Warn().Err(err)
+	}
+	evt.Int64("delivery_id", d.ID).Int64("webhook_id", d.WebhookID).Str("state", d.State).Int("attempt", d.Attempts).Msg("Webhook delivery attempt")
+	return nil
+}
+
+// post sends d's payload to hook, signed. It returns the response status,
+// or 0 if there was none, and an error unless it was a 2xx.
+func (w *Webhooks) post(hook *db.Webhook, d *db.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "openmessage-webhook")
+	req.Header.Set(WebhookEventHeader, WebhookEventMessage)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, timestamp, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
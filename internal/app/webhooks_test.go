@@ -0,0 +1,163 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// receiver is a webhook endpoint answering from a script of status codes;
+// running off the end answers 200.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	got      []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.got = append(rc.got, r)
+	rc.bodies = append(rc.bodies, body)
+	status := 200
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func testWebhooks(t *testing.T, statuses ...int) (*Webhooks, *receiver, *httptest.Server, *time.Time) {
+	t.Helper()
+	w := NewWebhooks(newBackfillApp(t))
+	now := time.Unix(1_700_000_000, 0)
+	w.now = func() time.Time { return now }
+	rc := &receiver{statuses: statuses}
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+	return w, rc, srv, &now
+}
+
+func incoming(m *db.Message) bus.Event {
+	return bus.Event{Type: "message", Data: bus.MessageAdded{Message: m}}
+}
+
+func TestWebhookDeliversSignedPayload(t *testing.T) {
+	w, rc, srv, _ := testWebhooks(t)
+	w.app.Store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Alice"})
+	hook, err := w.Create(WebhookRequest{URL: srv.URL, Keyword: "code"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.Secret) != 64 || !hook.Enabled {
+		t.Fatalf("created: %+v", hook)
+	}
+
+	w.Handle(incoming(&db.Message{MessageID: "m1", ConversationID: "c1", SenderName: "Alice", Body: "Your code is 1234"}))
+	w.Handle(incoming(&db.Message{MessageID: "m2", ConversationID: "c1", SenderName: "Alice", Body: "no match"}))
+	w.Handle(incoming(&db.Message{MessageID: "m3", ConversationID: "c1", Body: "my code", IsFromMe: true}))
+	if _, ok := w.drain(); ok {
+		t.Error("nothing should be left to retry")
+	}
+
+	if len(rc.got) != 1 {
+		t.Fatalf("receiver got %d posts, want 1", len(rc.got))
+	}
+	r, body := rc.got[0], rc.bodies[0]
+	if want := SignWebhook(hook.Secret, r.Header.Get(WebhookTimestampHeader), body); r.Header.Get(WebhookSignatureHeader) != want {
+		t.Errorf("signature %q, want %q", r.Header.Get(WebhookSignatureHeader), want)
+	}
+	if r.Header.Get(WebhookTimestampHeader) != "1700000000" || r.Header.Get(WebhookEventHeader) != WebhookEventMessage {
+		t.Errorf("headers: %v", r.Header)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != WebhookEventMessage || payload.WebhookID != hook.ID ||
+		payload.Message.MessageID != "m1" || payload.Conversation == nil || payload.Conversation.Name != "Alice" {
+		t.Errorf("payload: %s", body)
+	}
+
+	log, _ := w.app.Store.ListWebhookDeliveries(hook.ID, "", 10)
+	if len(log) != 1 || log[0].State != db.WebhookDelivered || log[0].StatusCode != 200 || log[0].Attempts != 1 {
+		t.Errorf("delivery log: %+v", log)
+	}
+	if r.Header.Get(WebhookDeliveryHeader) != "1" {
+		t.Errorf("delivery header %q", r.Header.Get(WebhookDeliveryHeader))
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	w, rc, srv, now := testWebhooks(t, 503, 500, 503)
+	w.MaxAttempts = 3
+	hook, _ := w.Create(WebhookRequest{URL: srv.URL})
+	w.Handle(incoming(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi"}))
+
+	// Each failure waits twice as long as the one before.
+	for _, want := range []time.Duration{10 * time.Second, 20 * time.Second} {
+		wait, ok := w.drain()
+		if !ok || wait != want {
+			t.Fatalf("wait: %v, %v; want %v", wait, ok, want)
+		}
+		*now = now.Add(wait)
+	}
+	if _, ok := w.drain(); ok {
+		t.Error("out of attempts: nothing to wait for")
+	}
+	log, _ := w.app.Store.ListWebhookDeliveries(hook.ID, "", 10)
+	if len(rc.got) != 3 || len(log) != 1 || log[0].State != db.WebhookFailed || log[0].StatusCode != 503 || log[0].LastError == "" {
+		t.Fatalf("after %d posts: %+v", len(rc.got), log)
+	}
+
+	// Redelivering starts over; this time it goes through.
+	if _, err := w.Redeliver(log[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	w.drain()
+	if d, _ := w.app.Store.GetWebhookDelivery(log[0].ID); d.State != db.WebhookDelivered || d.Attempts != 1 {
+		t.Errorf("after redelivery: %+v", d)
+	}
+	if _, err := w.Redeliver(log[0].ID); err != ErrWebhookDeliveryBusy {
+		t.Errorf("redelivering a delivered one: %v", err)
+	}
+}
+
+func TestWebhookGivesUpOnClientError(t *testing.T) {
+	w, rc, srv, _ := testWebhooks(t, 404)
+	hook, _ := w.Create(WebhookRequest{URL: srv.URL})
+	w.Handle(incoming(&db.Message{MessageID: "m1", ConversationID: "c1"}))
+	if _, ok := w.drain(); ok {
+		t.Error("a 404 shouldn't be retried")
+	}
+	log, _ := w.app.Store.ListWebhookDeliveries(hook.ID, "", 10)
+	if len(rc.got) != 1 || log[0].State != db.WebhookFailed || log[0].StatusCode != 404 {
+		t.Errorf("delivery: %+v", log[0])
+	}
+}
+
+func TestWebhookRequestValidation(t *testing.T) {
+	w, _, _, _ := testWebhooks(t)
+	for _, u := range []string{"", "ftp://example.com", "not a url", "http://"} {
+		if _, err := w.Create(WebhookRequest{URL: u}); err != ErrWebhookURL {
+			t.Errorf("create %q: %v", u, err)
+		}
+	}
+	off := false
+	hook, _ := w.Create(WebhookRequest{URL: "https://example.com/hook", Secret: "shh"})
+	got, err := w.Update(hook.ID, WebhookRequest{URL: "https://example.com/other", Enabled: &off})
+	if err != nil || got.Secret != "shh" || got.Enabled || got.URL != "https://example.com/other" {
+		t.Errorf("update keeps the secret: %+v, %v", got, err)
+	}
+	if got, err := w.Update(hook.ID+1, WebhookRequest{URL: "https://example.com"}); got != nil || err != nil {
+		t.Errorf("update of a missing webhook: %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,241 @@
+// Package auth checks API tokens and browser sessions. Tokens live in a
+// file in the data directory, generated on first run; each has a scope
+// saying what it may do.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes. A send token can do everything a read token can.
+const (
+	// ScopeRead reads conversations, messages and status.
+	ScopeRead = "read"
+	// ScopeSend also sends, drafts and schedules messages and changes
+	// settings.
+	ScopeSend = "send"
+)
+
+// SessionCookie is the cookie that keeps the web UI logged in.
+const SessionCookie = "openmessage_session"
+
+// sessionTTL is how long a browser stays logged in.
+const sessionTTL = 30 * 24 * time.Hour
+
+// Token is an API token as stored in the tokens file.
+type Token struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Scope string `json:"scope"`
+}
+
+// Allows reports whether a token with scope have may do what needs need.
+func Allows(have, need string) bool {
+	return have == ScopeSend || have == need
+}
+
+// Auth holds the API tokens and the browser sessions logged in with them.
+// Sessions are kept in memory, so a restart logs browsers out.
+type Auth struct {
+	tokens []Token
+
+	mu       sync.Mutex
+	sessions map[string]session
+
+	// Swapped out in tests.
+	now func() time.Time
+}
+
+type session struct {
+	scope   string
+	csrf    string
+	expires time.Time
+}
+
+// New returns an Auth accepting tokens.
+func New(tokens []Token) *Auth {
+	return &Auth{
+		tokens:   tokens,
+		sessions: make(map[string]session),
+		now:      time.Now,
+	}
+}
+
+// Load reads the tokens file at path. If there is none it writes one with
+// two new tokens, named "send" and "read" after their scopes, and reports
+// created.
+func Load(path string) (a *Auth, created bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		tokens, err := generateTokens()
+		if err != nil {
+			return nil, false, err
+		}
+		data, _ := json.MarshalIndent(tokens, "", "  ")
+		if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+			return nil, false, fmt.Errorf("write tokens: %w", err)
+		}
+		return New(tokens), true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read tokens: %w", err)
+	}
+
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, false, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, t := range tokens {
+		if len(t.Token) < 16 {
+			return nil, false, fmt.Errorf("%s: token %d (%q) is shorter than 16 characters", path, i+1, t.Name)
+		}
+		if t.Scope != ScopeRead && t.Scope != ScopeSend {
+			return nil, false, fmt.Errorf("%s: token %d (%q) has scope %q; want %q or %q", path, i+1, t.Name, t.Scope, ScopeRead, ScopeSend)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, false, fmt.Errorf("%s has no tokens", path)
+	}
+	return New(tokens), false, nil
+}
+
+func generateTokens() ([]Token, error) {
+	var tokens []Token
+	for _, scope := range []string{ScopeSend, ScopeRead} {
+		tok, err := randomHex(32)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, Token{Name: scope, Token: "om_" + tok, Scope: scope})
+	}
+	return tokens, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Lookup returns the token matching s.
+func (a *Auth) Lookup(s string) (Token, bool) {
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(s), []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
+
+// Login starts a browser session for token s and returns its cookie.
+func (a *Auth) Login(s string, secure bool) (*http.Cookie, bool) {
+	t, ok := a.Lookup(s)
+	if !ok {
+		return nil, false
+	}
+	id, err := randomHex(32)
+	if err != nil {
+		return nil, false
+	}
+	csrf, err := randomHex(32)
+	if err != nil {
+		return nil, false
+	}
+	expires := a.now().Add(sessionTTL)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for sid, sess := range a.sessions {
+		if a.now().After(sess.expires) {
+			delete(a.sessions, sid)
+		}
+	}
+	a.sessions[id] = session{scope: t.Scope, csrf: csrf, expires: expires}
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}, true
+}
+
+// Logout ends r's browser session, if any, and returns a cookie that
+// clears it.
+func (a *Auth) Logout(r *http.Request) *http.Cookie {
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		a.mu.Lock()
+		delete(a.sessions, c.Value)
+		a.mu.Unlock()
+	}
+	return &http.Cookie{Name: SessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+}
+
+// Authenticate returns the scope r is allowed, from its bearer token or
+// its session cookie.
+func (a *Auth) Authenticate(r *http.Request) (string, bool) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, tok, _ := strings.Cut(h, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return "", false
+		}
+		t, ok := a.Lookup(strings.TrimSpace(tok))
+		return t.Scope, ok
+	}
+	sess, ok := a.session(r)
+	return sess.scope, ok
+}
+
+// CSRFToken returns the token r's browser session must send back with
+// requests that change something, or "" if r has no session.
+func (a *Auth) CSRFToken(r *http.Request) string {
+	sess, _ := a.session(r)
+	return sess.csrf
+}
+
+func (a *Auth) session(r *http.Request) (session, bool) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sess, ok := a.sessions[c.Value]
+	if !ok {
+		return session{}, false
+	}
+	if a.now().After(sess.expires) {
+		delete(a.sessions, c.Value)
+		return session{}, false
+	}
+	return sess, true
+}
+
+type scopeKey struct{}
+
+// WithScope returns ctx carrying the scope of the request it belongs to.
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// ScopeFrom returns the scope WithScope put in ctx. It reports false when
+// there is none, as for the stdio MCP server, which needs no token.
+func ScopeFrom(ctx context.Context) (string, bool) {
+	scope, ok := ctx.Value(scopeKey{}).(string)
+	return scope, ok
+}
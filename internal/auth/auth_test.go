@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadGeneratesTokensOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	a, created, err := Load(path)
+	if err != nil || !created {
+		t.Fatalf("first load: %v, %v", created, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("tokens file: %v, %v", info, err)
+	}
+	if len(a.tokens) != 2 || a.tokens[0].Scope != ScopeSend || a.tokens[1].Scope != ScopeRead || a.tokens[0].Token == a.tokens[1].Token {
+		t.Fatalf("generated: %+v", a.tokens)
+	}
+
+	again, created, err := Load(path)
+	if err != nil || created {
+		t.Fatalf("second load: %v, %v", created, err)
+	}
+	if tok, ok := again.Lookup(a.tokens[1].Token); !ok || tok.Scope != ScopeRead {
+		t.Errorf("reloaded tokens don't match: %+v", again.tokens)
+	}
+}
+
+func TestLoadRejectsBadTokens(t *testing.T) {
+	for name, content := range map[string]string{
+		"empty":     `[]`,
+		"short":     `[{"name": "x", "token": "abc", "scope": "send"}]`,
+		"bad scope": `[{"name": "x", "token": "0123456789abcdef", "scope": "admin"}]`,
+		"not json":  `tokens`,
+	} {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		os.WriteFile(path, []byte(content), 0600)
+		if _, _, err := Load(path); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := New([]Token{
+		{Name: "send", Token: "send-token-0123456789", Scope: ScopeSend},
+		{Name: "read", Token: "read-token-0123456789", Scope: ScopeRead},
+	})
+	now := time.Unix(1_700_000_000, 0)
+	a.now = func() time.Time { return now }
+
+	req := func(header, cookie string) *http.Request {
+		r := httptest.NewRequest("GET", "/api/conversations", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: SessionCookie, Value: cookie})
+		}
+		return r
+	}
+	for _, c := range []struct {
+		header, scope string
+		ok            bool
+	}{
+		{"Bearer send-token-0123456789", ScopeSend, true},
+		{"bearer read-token-0123456789", ScopeRead, true},
+		{"Bearer nope", "", false},
+		{"Basic send-token-0123456789", "", false},
+		{"", "", false},
+	} {
+		if scope, ok := a.Authenticate(req(c.header, "")); scope != c.scope || ok != c.ok {
+			t.Errorf("%q: got %q, %v", c.header, scope, ok)
+		}
+	}
+
+	if _, ok := a.Login("nope", false); ok {
+		t.Error("logged in with a bad token")
+	}
+	cookie, ok := a.Login("read-token-0123456789", false)
+	if !ok || !cookie.HttpOnly || cookie.Name != SessionCookie {
+		t.Fatalf("login: %+v, %v", cookie, ok)
+	}
+	if scope, ok := a.Authenticate(req("", cookie.Value)); !ok || scope != ScopeRead {
+		t.Errorf("session: %q, %v", scope, ok)
+	}
+
+	// Sessions expire, and logging out ends one.
+	now = now.Add(sessionTTL + time.Second)
+	if _, ok := a.Authenticate(req("", cookie.Value)); ok {
+		t.Error("expired session still works")
+	}
+	cookie, _ = a.Login("send-token-0123456789", false)
+	a.Logout(req("", cookie.Value))
+	if _, ok := a.Authenticate(req("", cookie.Value)); ok {
+		t.Error("session works after logout")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	if !Allows(ScopeSend, ScopeRead) || !Allows(ScopeSend, ScopeSend) || !Allows(ScopeRead, ScopeRead) {
+		t.Error("a scope should allow itself, and send everything")
+	}
+	if Allows(ScopeRead, ScopeSend) || Allows("", ScopeRead) {
+		t.Error("read can't send, and no scope can't read")
+	}
+}
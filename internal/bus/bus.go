@@ -0,0 +1,262 @@
+// Package bus is the in-process event bus. The Google Messages client
+// publishes what the phone sends; storage, the web UI's event stream and
+// anything else that wants to react to incoming traffic subscribe.
+package bus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// Payload is the data of an event. Each payload type is one kind of event,
+// named by EventType on the web UI's event stream.
+type Payload interface {
+	EventType() string
+}
+
+// MessageReceived is a message as the phone sent it, new or changed, before
+// it is stored. IsOld is set for messages replayed on connect.
+type MessageReceived struct {
+	*db.Message
+	IsOld bool `json:",omitempty"`
+}
+
+// MessageAdded is a message stored for the first time.
+type MessageAdded struct{ *db.Message }
+
+// MessageUpdated is a stored message that changed: its delivery status,
+// reactions, or a sent placeholder replaced by the real message.
+type MessageUpdated struct{ *db.Message }
+
+// ConversationReceived is a conversation as the phone sent it, before it is
+// stored.
+type ConversationReceived struct{ *db.Conversation }
+
+// ConversationUpdated is a conversation as stored.
+type ConversationUpdated struct{ *db.Conversation }
+
+// DraftUpdated says a conversation's drafts changed.
+type DraftUpdated struct {
+	ConversationID string `json:"conversation_id"`
+	DraftID        string `json:"draft_id"`
+}
+
+// Typing says someone started or stopped typing in a conversation.
+type Typing struct {
+	ConversationID string `json:"conversation_id"`
+	Number         string `json:"number,omitempty"`
+	Typing         bool   `json:"typing"`
+}
+
+// ConnectionChanged says whether we're connected to Google Messages.
+type ConnectionChanged struct {
+	Connected bool `json:"connected"`
+}
+
+// ApprovalUpdated is a message an agent asked to send that was held for
+// the user's approval, when it is held and when it is approved or rejected.
+type ApprovalUpdated struct{ *db.Approval }
+
+// Resync tells listeners that more changed than was published, as after a
+// backfill or when a reconnecting listener missed events, and that they
+// should reload.
+type Resync struct{}
+
+func (MessageReceived) EventType() string      { return "message_received" }
+func (MessageAdded) EventType() string         { return "message" }
+func (MessageUpdated) EventType() string       { return "message_updated" }
+func (ConversationReceived) EventType() string { return "conversation_received" }
+func (ConversationUpdated) EventType() string  { return "conversation" }
+func (DraftUpdated) EventType() string         { return "draft" }
+func (Typing) EventType() string               { return "typing" }
+func (ConnectionChanged) EventType() string    { return "connection" }
+func (ApprovalUpdated) EventType() string      { return "approval" }
+func (Resync) EventType() string               { return "resync" }
+
+// Event is one published event. IDs increase across restarts, so a
+// listener can ask for everything after the last one it saw.
+type Event struct {
+	ID   int64     `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data Payload   `json:"data,omitempty"`
+}
+
+// Bus publishes events to subscribers and keeps the most recent ones so
+// that a subscriber that falls behind or reconnects can catch up. Publish
+// never blocks: each subscriber has its own bounded queue. A nil *Bus
+// discards everything published to it.
+type Bus struct {
+	// QueueSize is how many events a subscriber may fall behind by before
+	// it is dropped.
+	QueueSize int
+
+	mu      sync.Mutex
+	lastID  int64
+	history []Event
+	keep    int
+	subs    map[*Subscription]struct{}
+}
+
+// New returns a bus that keeps the last keep events for catching up.
+func New(keep int) *Bus {
+	return &Bus{
+		QueueSize: 256,
+		// Seeding IDs from the clock keeps them increasing across
+		// restarts, so a listener's last ID from before one is recognised
+		// as too old rather than mistaken for a recent event.
+		lastID: time.Now().UnixMicro(),
+		keep:   keep,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives events on C until it is closed, or until it falls
+// more than QueueSize events behind, when C is closed. Either way it can
+// resubscribe from the last event it got.
+type Subscription struct {
+	C <-chan Event
+
+	c   chan Event
+	bus *Bus
+}
+
+// Close stops the subscription. Events already queued stay readable.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.c)
+	}
+}
+
+// Publish sends an event to every subscriber.
+func (b *Bus) Publish(data Payload) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	evt := Event{ID: b.lastID, Type: data.EventType(), Time: time.Now(), Data: data}
+	if b.keep > 0 {
+		if len(b.history) >= b.keep {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, evt)
+	}
+	for s := range b.subs {
+		select {
+		case s.c <- evt:
+		default:
+			// Too far behind: drop it rather than block publishers. It
+			// resumes from its last event.
+			delete(b.subs, s)
+			close(s.c)
+		}
+	}
+}
+
+// Subscribe starts a subscription. With afterID > 0 it also returns the
+// kept events after that ID; complete is false if some of them are no
+// longer kept, and the subscriber should reload instead.
+func (b *Bus) Subscribe(afterID int64) (sub *Subscription, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := make(chan Event, max(b.QueueSize, 1))
+	sub = &Subscription{C: c, c: c, bus: b}
+	b.subs[sub] = struct{}{}
+
+	if afterID <= 0 || afterID == b.lastID {
+		return sub, nil, true
+	}
+	if afterID > b.lastID || len(b.history) == 0 || b.history[0].ID > afterID+1 {
+		return sub, nil, false
+	}
+	for _, evt := range b.history {
+		if evt.ID > afterID {
+			missed = append(missed, evt)
+		}
+	}
+	return sub, missed, true
+}
+
+// Consume calls handle with every event published from now on, in order,
+// from its own goroutine. If handle falls more than QueueSize events
+// behind, it catches up from the kept events; if those no longer reach
+// back far enough, lost is called and it carries on from the latest.
+//
+// stop ends it once the events published before the call are handled, or
+// those of them still kept, and waits for that.
+func (b *Bus) Consume(handle func(Event), lost func()) (stop func()) {
+	if b == nil {
+		return func() {}
+	}
+	// Start from an ID rather than 0, so that after being dropped it knows
+	// where to resume from even if it hadn't handled anything yet.
+	last := b.LastID()
+	sub, missed, _ := b.Subscribe(last)
+	var (
+		mu      sync.Mutex
+		stopped bool
+		stopAt  int64
+		done    = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for _, evt := range missed {
+			handle(evt)
+			last = evt.ID
+		}
+		for {
+			for evt := range sub.C {
+				handle(evt)
+				last = evt.ID
+			}
+			// Dropped for falling behind, or stopped: either way, pick up
+			// from the last event handled.
+			mu.Lock()
+			var missed []Event
+			var complete bool
+			sub, missed, complete = b.Subscribe(last)
+			end := stopped
+			if end {
+				sub.Close()
+			}
+			mu.Unlock()
+			if !complete && !end && lost != nil {
+				lost()
+			}
+			for _, evt := range missed {
+				if end && evt.ID > stopAt {
+					break
+				}
+				handle(evt)
+				last = evt.ID
+			}
+			if end {
+				return
+			}
+		}
+	}()
+	return func() {
+		mu.Lock()
+		if !stopped {
+			stopped = true
+			stopAt = b.LastID()
+			sub.Close()
+		}
+		mu.Unlock()
+		<-done
+	}
+}
+
+// LastID returns the ID of the last event published.
+func (b *Bus) LastID() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastID
+}
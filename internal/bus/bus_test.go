@@ -0,0 +1,162 @@
+package bus
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// n is a payload for tests.
+type n int
+
+func (n) EventType() string { return "n" }
+
+func TestPublishReachesSubscribers(t *testing.T) {
+	b := New(10)
+	sub, missed, complete := b.Subscribe(0)
+	defer sub.Close()
+	if len(missed) != 0 || !complete {
+		t.Fatalf("fresh subscription: %v, %v", missed, complete)
+	}
+
+	b.Publish(Typing{ConversationID: "c1", Typing: true})
+	evt := <-sub.C
+	if evt.Type != "typing" || evt.Data.(Typing).ConversationID != "c1" || evt.ID != b.LastID() {
+		t.Errorf("got %+v", evt)
+	}
+
+	sub.Close()
+	sub.Close() // twice is fine
+	b.Publish(Resync{})
+	if _, ok := <-sub.C; ok {
+		t.Error("closed subscription still receives")
+	}
+}
+
+func TestSubscribeResumesAfterID(t *testing.T) {
+	b := New(3)
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		b.Publish(n(i))
+		ids = append(ids, b.LastID())
+	}
+
+	// The last three are kept.
+	sub, missed, complete := b.Subscribe(ids[1])
+	sub.Close()
+	if !complete || len(missed) != 3 || missed[0].ID != ids[2] || missed[2].Data != n(4) {
+		t.Errorf("resume after #2: %+v, %v", missed, complete)
+	}
+	sub, missed, complete = b.Subscribe(ids[4])
+	sub.Close()
+	if !complete || len(missed) != 0 {
+		t.Errorf("up to date: %+v, %v", missed, complete)
+	}
+
+	// Older than what's kept, or from before a restart: reload.
+	for _, after := range []int64{ids[0], ids[0] - 1000, ids[4] + 1} {
+		sub, missed, complete = b.Subscribe(after)
+		sub.Close()
+		if complete || len(missed) != 0 {
+			t.Errorf("after %d: %+v, %v; want incomplete", after, missed, complete)
+		}
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := New(10)
+	b.QueueSize = 2
+	slow, _, _ := b.Subscribe(0)
+	fast, _, _ := b.Subscribe(0)
+	defer fast.Close()
+
+	for i := 0; i < 3; i++ {
+		b.Publish(n(i))
+		<-fast.C
+	}
+	got := 0
+	for range slow.C {
+		got++
+	}
+	if got != 2 {
+		t.Errorf("slow subscriber got %d events before being dropped, want 2", got)
+	}
+}
+
+func TestConsumeCatchesUpInOrder(t *testing.T) {
+	b := New(100)
+	b.QueueSize = 2
+	release := make(chan struct{})
+	var got []n
+	stop := b.Consume(func(evt Event) {
+		<-release
+		got = append(got, evt.Data.(n))
+	}, func() { t.Error("lost events that were still kept") })
+
+	// The consumer is stuck on the first event while the rest pile up
+	// past its queue; publishing doesn't wait for it.
+	for i := 0; i < 10; i++ {
+		b.Publish(n(i))
+	}
+	close(release)
+	b.Publish(n(10))
+	stop()
+
+	if len(got) != 11 {
+		t.Fatalf("got %v, want 0 through 10", got)
+	}
+	for i, v := range got {
+		if v != n(i) {
+			t.Fatalf("got %v, want 0 through 10 in order", got)
+		}
+	}
+}
+
+func TestConsumeReportsLostEvents(t *testing.T) {
+	b := New(2)
+	b.QueueSize = 1
+	release := make(chan struct{})
+	var (
+		mu   sync.Mutex
+		got  []n
+		lost int
+	)
+	stop := b.Consume(func(evt Event) {
+		<-release
+		mu.Lock()
+		got = append(got, evt.Data.(n))
+		mu.Unlock()
+	}, func() {
+		mu.Lock()
+		lost++
+		mu.Unlock()
+	})
+
+	for i := 0; i < 10; i++ {
+		b.Publish(n(i))
+	}
+	close(release)
+	// Let it find out before stopping, which doesn't report losses.
+	for {
+		mu.Lock()
+		found := lost > 0
+		mu.Unlock()
+		if found {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	// It got what was queued before it was dropped and nothing after, and
+	// was told so.
+	if lost != 1 || len(got) == 0 || len(got) >= 10 {
+		t.Errorf("got %v with %d losses, want some events and one loss", got, lost)
+	}
+}
+
+func TestNilBusDiscards(t *testing.T) {
+	var b *Bus
+	b.Publish(Resync{})
+	b.Consume(func(Event) {}, nil)()
+}
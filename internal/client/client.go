@@ -1,12 +1,15 @@
 package client
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 type Client struct {
@@ -157,6 +160,16 @@ func ExtractReplyToID(msg *gmproto.Message) string {
 	return ""
 }
 
+// ExtractStatus returns the message's raw status, or "unknown" if it has
+// none, and for a failed message, what went wrong.
+func ExtractStatus(msg *gmproto.Message) (status, errMsg string) {
+	ms := msg.GetMessageStatus()
+	if ms == nil {
+		return "unknown", ""
+	}
+	return ms.GetStatus().String(), cmp.Or(ms.GetErrMsg(), ms.GetStatusText())
+}
+
 // ExtractSenderInfo gets the sender name and number from a Message.
 func ExtractSenderInfo(msg *gmproto.Message) (name, number string) {
 	if p := msg.GetSenderParticipant(); p != nil {
@@ -173,3 +186,49 @@ func ExtractSenderInfo(msg *gmproto.Message) (name, number string) {
 	}
 	return
 }
+
+// ExtractParticipantsJSON encodes a conversation's participants as the JSON
+// array stored in db.Conversation.Participants, or "[]" if it has none.
+func ExtractParticipantsJSON(conv *gmproto.Conversation) string {
+	type pInfo struct {
+		Name   string `json:"name"`
+		Number string `json:"number"`
+		IsMe   bool   `json:"is_me,omitempty"`
+	}
+	var infos []pInfo
+	for _, p := range conv.GetParticipants() {
+		info := pInfo{
+			Name: p.GetFullName(),
+			IsMe: p.GetIsMe(),
+		}
+		if id := p.GetID(); id != nil {
+			info.Number = id.GetNumber()
+		}
+		if info.Number == "" {
+			info.Number = p.GetFormattedNumber()
+		}
+		infos = append(infos, info)
+	}
+	if len(infos) == 0 {
+		return "[]"
+	}
+	b, err := json.Marshal(infos)
+	if err != nil {
+		return "[]"
+	}
+	return string(b)
+}
+
+// ExtractFolder maps a conversation's status to the folder it's shown in,
+// or "" when the status doesn't say.
+func ExtractFolder(conv *gmproto.Conversation) string {
+	switch conv.GetStatus() {
+	case gmproto.ConversationStatus_ACTIVE:
+		return db.FolderInbox
+	case gmproto.ConversationStatus_ARCHIVED, gmproto.ConversationStatus_KEEP_ARCHIVED:
+		return db.FolderArchive
+	case gmproto.ConversationStatus_SPAM_FOLDER, gmproto.ConversationStatus_BLOCKED_FOLDER:
+		return db.FolderSpamBlocked
+	}
+	return ""
+}
@@ -3,25 +3,35 @@ package client
 import (
 	"encoding/hex"
 	"encoding/json"
-	"strings"
+	"errors"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/events"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
+	"github.com/maxghenis/openmessage/internal/bus"
 	"github.com/maxghenis/openmessage/internal/db"
 )
 
-// OnDisconnect is called when the client fatally disconnects (e.g. unpaired).
-type OnDisconnect func()
+// ErrLoggedOut is passed to OnDisconnect when the Google account behind the
+// session logs out.
+var ErrLoggedOut = errors.New("logged out of Google account")
 
+// OnDisconnect is called when the client fatally disconnects (e.g. unpaired),
+// with the reason.
+type OnDisconnect func(err error)
+
+// EventHandler turns what libgm reports into bus events. It runs on
+// libgm's event loop, so it only converts and publishes; storing is left
+// to subscribers such as Storage.
 type EventHandler struct {
-	Store        *db.Store
 	Logger       zerolog.Logger
 	SessionPath  string
-	Client       *Client
+	Clients      *Provider
 	OnDisconnect OnDisconnect
+	// Events receives every message, conversation and typing change.
+	Events *bus.Bus
 }
 
 func (h *EventHandler) Handle(rawEvt any) {
@@ -32,6 +42,12 @@ func (h *EventHandler) Handle(rawEvt any) {
 		h.handleMessage(evt)
 	case *gmproto.Conversation:
 		h.handleConversation(evt)
+	case *gmproto.TypingData:
+		h.Events.Publish(bus.Typing{
+			ConversationID: evt.GetConversationID(),
+			Number:         evt.GetUser().GetNumber(),
+			Typing:         evt.GetType() == gmproto.TypingTypes_STARTED_TYPING,
+		})
 	case *events.AuthTokenRefreshed:
 		h.handleAuthRefresh()
 	case *events.PairSuccessful:
@@ -39,7 +55,12 @@ func (h *EventHandler) Handle(rawEvt any) {
 	case *events.ListenFatalError:
 		h.Logger.Error().Err(evt.Error).Msg("Listen fatal error")
 		if h.OnDisconnect != nil {
-			h.OnDisconnect()
+			h.OnDisconnect(evt.Error)
+		}
+	case *events.GaiaLoggedOut:
+		h.Logger.Error().Msg("Google account logged out")
+		if h.OnDisconnect != nil {
+			h.OnDisconnect(ErrLoggedOut)
 		}
 	case *events.ListenTemporaryError:
 		h.Logger.Warn().Err(evt.Error).Msg("Listen temporary error")
@@ -70,10 +91,7 @@ func (h *EventHandler) handleMessage(evt *libgm.WrappedMessage) {
 	body := ExtractMessageBody(msg)
 	senderName, senderNumber := ExtractSenderInfo(msg)
 
-	status := "unknown"
-	if ms := msg.GetMessageStatus(); ms != nil {
-		status = ms.GetStatus().String()
-	}
+	status, statusErr := ExtractStatus(msg)
 
 	dbMsg := &db.Message{
 		MessageID:      msg.GetMessageID(),
@@ -83,6 +101,7 @@ func (h *EventHandler) handleMessage(evt *libgm.WrappedMessage) {
 		Body:           body,
 		TimestampMS:    msg.GetTimestamp() / 1000, // proto timestamp is microseconds
 		Status:         status,
+		StatusError:    statusErr,
 		IsFromMe:       msg.GetSenderParticipant() != nil && msg.GetSenderParticipant().GetIsMe(),
 	}
 
@@ -98,54 +117,19 @@ func (h *EventHandler) handleMessage(evt *libgm.WrappedMessage) {
 		}
 	}
 	dbMsg.ReplyToID = ExtractReplyToID(msg)
-
-	if err := h.Store.UpsertMessage(dbMsg); err != nil {
-		h.Logger.Error().Err(err).Str("msg_id", dbMsg.MessageID).Msg("Failed to store message")
-		return
-	}
-
-	// When our sent message echoes back with a real server ID, clean up the
-	// tmp_ placeholder we stored at send time to avoid duplicates in the UI.
-	if dbMsg.IsFromMe && !strings.HasPrefix(dbMsg.MessageID, "tmp_") {
-		if n, err := h.Store.DeleteTmpMessages(dbMsg.ConversationID); err == nil && n > 0 {
-			h.Logger.Debug().Int64("deleted", n).Str("conv_id", dbMsg.ConversationID).Msg("Cleaned up tmp messages")
-		}
+	if dbMsg.IsFromMe {
+		dbMsg.TmpID = msg.GetTmpID()
 	}
 
+	h.Events.Publish(bus.MessageReceived{Message: dbMsg, IsOld: evt.IsOld})
 	h.Logger.Debug().
 		Str("msg_id", dbMsg.MessageID).
 		Str("from", senderName).
 		Bool("is_old", evt.IsOld).
-		Msg("Stored message")
+		Msg("Received message")
 }
 
 func (h *EventHandler) handleConversation(conv *gmproto.Conversation) {
-	participantsJSON := "[]"
-	if ps := conv.GetParticipants(); len(ps) > 0 {
-		type pInfo struct {
-			Name   string `json:"name"`
-			Number string `json:"number"`
-			IsMe   bool   `json:"is_me,omitempty"`
-		}
-		var infos []pInfo
-		for _, p := range ps {
-			info := pInfo{
-				Name: p.GetFullName(),
-				IsMe: p.GetIsMe(),
-			}
-			if id := p.GetID(); id != nil {
-				info.Number = id.GetNumber()
-			}
-			if info.Number == "" {
-				info.Number = p.GetFormattedNumber()
-			}
-			infos = append(infos, info)
-		}
-		if b, err := json.Marshal(infos); err == nil {
-			participantsJSON = string(b)
-		}
-	}
-
 	unread := 0
 	if conv.GetUnread() {
 		unread = 1
@@ -155,23 +139,22 @@ func (h *EventHandler) handleConversation(conv *gmproto.Conversation) {
 		ConversationID: conv.GetConversationID(),
 		Name:           conv.GetName(),
 		IsGroup:        conv.GetIsGroupChat(),
-		Participants:   participantsJSON,
+		Participants:   ExtractParticipantsJSON(conv),
 		LastMessageTS:  conv.GetLastMessageTimestamp() / 1000, // microseconds to milliseconds
 		UnreadCount:    unread,
+		Folder:         ExtractFolder(conv),
 	}
 
-	if err := h.Store.UpsertConversation(dbConv); err != nil {
-		h.Logger.Error().Err(err).Str("conv_id", dbConv.ConversationID).Msg("Failed to store conversation")
-		return
-	}
-	h.Logger.Debug().Str("conv_id", dbConv.ConversationID).Str("name", dbConv.Name).Msg("Stored conversation")
+	h.Events.Publish(bus.ConversationReceived{Conversation: dbConv})
+	h.Logger.Debug().Str("conv_id", dbConv.ConversationID).Str("name", dbConv.Name).Msg("Received conversation")
 }
 
 func (h *EventHandler) handleAuthRefresh() {
-	if h.Client == nil || h.SessionPath == "" {
+	cli := h.Clients.Get()
+	if cli == nil || h.SessionPath == "" {
 		return
 	}
-	sessionData, err := h.Client.SessionData()
+	sessionData, err := cli.SessionData()
 	if err != nil {
 		h.Logger.Error().Err(err).Msg("Failed to get session data for save")
 		return
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/events"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestHandleReportsDisconnectReason(t *testing.T) {
+	var got []error
+	h := &EventHandler{
+		Logger:       zerolog.Nop(),
+		OnDisconnect: func(err error) { got = append(got, err) },
+	}
+
+	fatal := errors.New("http 401 while polling")
+	h.Handle(&events.ListenFatalError{Error: fatal})
+	h.Handle(&events.GaiaLoggedOut{})
+	h.Handle(&events.ListenTemporaryError{Error: errors.New("blip")})
+
+	if len(got) != 2 {
+		t.Fatalf("OnDisconnect called %d times, want 2", len(got))
+	}
+	if got[0] != fatal {
+		t.Errorf("fatal error: got %v, want %v", got[0], fatal)
+	}
+	if !errors.Is(got[1], ErrLoggedOut) {
+		t.Errorf("logout: got %v, want ErrLoggedOut", got[1])
+	}
+}
+
+// pipeline is an EventHandler with Storage subscribed, run synchronously.
+type pipeline struct {
+	h     *EventHandler
+	store *db.Store
+	feed  *bus.Subscription
+	s     *Storage
+}
+
+func newPipeline(t *testing.T, b *bus.Bus) *pipeline {
+	t.Helper()
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	feed, _, _ := b.Subscribe(0)
+	t.Cleanup(feed.Close)
+	return &pipeline{
+		h:     &EventHandler{Logger: zerolog.Nop(), Events: b},
+		store: store,
+		feed:  feed,
+		s:     &Storage{Store: store, Events: b, Logger: zerolog.Nop()},
+	}
+}
+
+// handle passes raw to the handler and stores what it publishes.
+func (p *pipeline) handle(raw any) {
+	p.h.Handle(raw)
+	for {
+		select {
+		case evt := <-p.feed.C:
+			p.s.Handle(evt)
+		default:
+			return
+		}
+	}
+}
+
+func TestHandleMessageReplacesOnlyItsPlaceholder(t *testing.T) {
+	p := newPipeline(t, bus.New(0))
+	store := p.store
+
+	// Three sends in flight in the same conversation.
+	for _, tmpID := range []string{"tmp_000000000001", "tmp_000000000002", "tmp_000000000003"} {
+		store.AddPendingMessage(&db.Message{MessageID: tmpID, TmpID: tmpID, ConversationID: "c1", Body: tmpID, IsFromMe: true})
+	}
+
+	p.handle(&libgm.WrappedMessage{Message: &gmproto.Message{
+		MessageID:         "real-2",
+		TmpID:             "tmp_000000000002",
+		ConversationID:    "c1",
+		SenderParticipant: &gmproto.Participant{IsMe: true},
+		MessageStatus:     &gmproto.MessageStatus{Status: gmproto.MessageStatusType_OUTGOING_COMPLETE},
+	}})
+
+	msgs, err := store.GetMessagesByConversation("c1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for _, m := range msgs {
+		ids[m.MessageID] = true
+	}
+	want := []string{"real-2", "tmp_000000000001", "tmp_000000000003"}
+	if len(ids) != len(want) {
+		t.Fatalf("got messages %v, want %v", ids, want)
+	}
+	for _, id := range want {
+		if !ids[id] {
+			t.Errorf("missing %s in %v", id, ids)
+		}
+	}
+}
+
+func TestHandlePublishesEvents(t *testing.T) {
+	b := bus.New(10)
+	p := newPipeline(t, b)
+	sub, _, _ := b.Subscribe(0)
+	defer sub.Close()
+
+	msg := &gmproto.Message{
+		MessageID:         "m1",
+		ConversationID:    "c1",
+		SenderParticipant: &gmproto.Participant{IsMe: true},
+		MessageStatus:     &gmproto.MessageStatus{Status: gmproto.MessageStatusType_OUTGOING_COMPLETE},
+	}
+	p.handle(&libgm.WrappedMessage{Message: msg})
+	msg.MessageStatus.Status = gmproto.MessageStatusType_OUTGOING_DISPLAYED
+	p.handle(&libgm.WrappedMessage{Message: msg})
+	p.handle(&gmproto.TypingData{ConversationID: "c1", User: &gmproto.User{Number: "+15551234567"}, Type: gmproto.TypingTypes_STARTED_TYPING})
+	p.handle(&gmproto.Conversation{ConversationID: "c1", Name: "Alice"})
+
+	// What the phone sent, each followed by what was stored.
+	want := []struct {
+		typ    string
+		status string
+	}{
+		{"message_received", ""}, {"message", db.DeliverySent},
+		{"message_received", ""}, {"message_updated", db.DeliveryRead},
+		{"typing", ""},
+		{"conversation_received", ""}, {"conversation", ""},
+	}
+	for _, w := range want {
+		evt := <-sub.C
+		if evt.Type != w.typ {
+			t.Fatalf("event type: got %s, want %s", evt.Type, w.typ)
+		}
+		var m *db.Message
+		switch data := evt.Data.(type) {
+		case bus.MessageAdded:
+			m = data.Message
+		case bus.MessageUpdated:
+			m = data.Message
+		}
+		if m != nil && m.DeliveryStatus != w.status {
+			t.Errorf("%s: delivery status %q, want %q", evt.Type, m.DeliveryStatus, w.status)
+		}
+	}
+	if conv, _ := p.store.GetConversation("c1"); conv == nil || conv.Name != "Alice" {
+		t.Errorf("conversation not stored: %+v", conv)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 func TestExtractMediaInfo_NoMedia(t *testing.T) {
@@ -149,4 +151,32 @@ func TestExtractReplyToID_WithReply(t *testing.T) {
 	}
 }
 
+func TestExtractFolder(t *testing.T) {
+	tests := map[gmproto.ConversationStatus]string{
+		gmproto.ConversationStatus_ACTIVE:                      db.FolderInbox,
+		gmproto.ConversationStatus_KEEP_ARCHIVED:               db.FolderArchive,
+		gmproto.ConversationStatus_BLOCKED_FOLDER:              db.FolderSpamBlocked,
+		gmproto.ConversationStatus_UNKNOWN_CONVERSATION_STATUS: "",
+	}
+	for status, want := range tests {
+		if got := ExtractFolder(&gmproto.Conversation{Status: status}); got != want {
+			t.Errorf("%s: got %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestExtractParticipantsJSON(t *testing.T) {
+	conv := &gmproto.Conversation{Participants: []*gmproto.Participant{
+		{FullName: "Me", IsMe: true, ID: &gmproto.SmallInfo{Number: "+15550000"}},
+		{FullName: "Alice", FormattedNumber: "(555) 123-4567"},
+	}}
+	want := `[{"name":"Me","number":"+15550000","is_me":true},{"name":"Alice","number":"(555) 123-4567"}]`
+	if got := ExtractParticipantsJSON(conv); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := ExtractParticipantsJSON(&gmproto.Conversation{}); got != "[]" {
+		t.Errorf("no participants: got %s, want []", got)
+	}
+}
+
 func strPtr(s string) *string { return &s }
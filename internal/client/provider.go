@@ -0,0 +1,36 @@
+package client
+
+import "sync"
+
+// Provider holds the current Client. The app swaps it when pairing or
+// unpairing; everything else calls Get each time it needs the client
+// instead of keeping the pointer, so swaps take effect immediately.
+//
+// A nil *Provider behaves like one holding no client.
+type Provider struct {
+	mu  sync.RWMutex
+	cli *Client
+}
+
+func NewProvider(cli *Client) *Provider {
+	return &Provider{cli: cli}
+}
+
+// Get returns the current client, or nil when not paired.
+func (p *Provider) Get() *Client {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cli
+}
+
+// Set replaces the current client and returns the previous one, which the
+// caller is responsible for disconnecting.
+func (p *Provider) Set(cli *Client) (old *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old, p.cli = p.cli, cli
+	return old
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestProviderSwap(t *testing.T) {
+	var nilProvider *Provider
+	if nilProvider.Get() != nil {
+		t.Error("nil provider should hold no client")
+	}
+
+	first := NewForPairing(zerolog.Nop())
+	second := NewForPairing(zerolog.Nop())
+	p := NewProvider(first)
+	if p.Get() != first {
+		t.Fatal("expected initial client")
+	}
+	if old := p.Set(second); old != first {
+		t.Error("Set should return the replaced client")
+	}
+	if p.Get() != second {
+		t.Error("Get should return the new client")
+	}
+	if old := p.Set(nil); old != second || p.Get() != nil {
+		t.Error("Set(nil) should clear the client")
+	}
+}
+
+func TestProviderConcurrentAccess(t *testing.T) {
+	p := NewProvider(nil)
+	clients := []*Client{NewForPairing(zerolog.Nop()), NewForPairing(zerolog.Nop())}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.Set(clients[(i+j)%2])
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if cli := p.Get(); cli != nil && cli != clients[0] && cli != clients[1] {
+					t.Error("unexpected client")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// Storage is the bus subscriber that stores what the phone sends. After
+// storing a message or conversation it publishes the stored row, so that
+// listeners which read back from the store, like the web UI, see it.
+type Storage struct {
+	Store  *db.Store
+	Events *bus.Bus
+	Logger zerolog.Logger
+}
+
+// Handle stores a MessageReceived or ConversationReceived event and ignores
+// the rest. Use it with bus.Consume.
+func (s *Storage) Handle(evt bus.Event) {
+	switch data := evt.Data.(type) {
+	case bus.MessageReceived:
+		s.storeMessage(data.Message)
+	case bus.ConversationReceived:
+		s.storeConversation(data.Conversation)
+	}
+}
+
+func (s *Storage) storeMessage(msg *db.Message) {
+	existing, err := s.Store.GetMessageByID(msg.MessageID)
+	if err != nil {
+		s.Logger.Error().Err(err).Str("msg_id", msg.MessageID).Msg("Failed to look up message")
+	}
+
+	// When our sent message echoes back with its real ID, it replaces the
+	// tmp_ placeholder stored at send time rather than appearing twice.
+	reconciled, err := s.Store.ReconcileMessage(msg)
+	if err != nil {
+		s.Logger.Error().Err(err).Str("msg_id", msg.MessageID).Msg("Failed to store message")
+		return
+	}
+	if reconciled {
+		s.Logger.Debug().Str("msg_id", msg.MessageID).Str("tmp_id", msg.TmpID).Msg("Replaced placeholder with sent message")
+	}
+
+	// Publish what was stored, which has the merged delivery status.
+	stored, err := s.Store.GetMessageByID(msg.MessageID)
+	if err != nil || stored == nil {
+		return
+	}
+	if existing != nil || reconciled {
+		s.Events.Publish(bus.MessageUpdated{Message: stored})
+	} else {
+		s.Events.Publish(bus.MessageAdded{Message: stored})
+	}
+}
+
+func (s *Storage) storeConversation(conv *db.Conversation) {
+	if err := s.Store.UpsertConversation(conv); err != nil {
+		s.Logger.Error().Err(err).Str("conv_id", conv.ConversationID).Msg("Failed to store conversation")
+		return
+	}
+	s.Events.Publish(bus.ConversationUpdated{Conversation: conv})
+}
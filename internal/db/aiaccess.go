@@ -0,0 +1,79 @@
+package db
+
+import "encoding/json"
+
+// AgentView returns a view of s for agents: MCP clients, and HTTP API
+// requests made with an API token. Reads through it leave out
+// conversations hidden from AI, with their messages, participants, drafts,
+// scheduled messages, approvals and outbox items, as if they didn't
+// exist. Writes are the same as on s.
+func (s *Store) AgentView() *Store {
+	return &Store{db: s.db, agent: true}
+}
+
+// visible returns an SQL condition that holds for rows whose conversation
+// ID, in column col, an agent view may see. Outside one it always holds.
+func (s *Store) visible(col string) string {
+	if !s.agent {
+		return "1"
+	}
+	return col + ` NOT IN (SELECT conversation_id FROM conversations WHERE ai_access = 'hidden')`
+}
+
+// SetAIAccess sets what MCP clients may do with a conversation. It reports
+// false if there is no such conversation.
+func (s *Store) SetAIAccess(conversationID, access string) (bool, error) {
+	result, err := s.db.Exec(`UPDATE conversations SET ai_access = ? WHERE conversation_id = ?`, access, conversationID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// AIAccess returns what MCP clients may do with a conversation. Ones we
+// don't know of are AIReadWrite, like new ones.
+func (s *Store) AIAccess(conversationID string) (string, error) {
+	var access string
+	err := s.db.QueryRow(`SELECT ai_access FROM conversations WHERE conversation_id = ?`, conversationID).Scan(&access)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return AIReadWrite, nil
+		}
+		return "", err
+	}
+	return access, nil
+}
+
+// NumberAIAccess returns what MCP clients may do with one-to-one
+// conversations with number: the most restrictive access among them, or
+// AIReadWrite if there are none.
+func (s *Store) NumberAIAccess(number string) (string, error) {
+	rows, err := s.db.Query(`
+		SELECT participants, ai_access FROM conversations
+		WHERE is_group = 0 AND ai_access != 'read-write'
+	`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	access := AIReadWrite
+	for rows.Next() {
+		var participantsJSON, convAccess string
+		if err := rows.Scan(&participantsJSON, &convAccess); err != nil {
+			return "", err
+		}
+		var participants []struct {
+			Number string `json:"number"`
+			IsMe   bool   `json:"is_me"`
+		}
+		json.Unmarshal([]byte(participantsJSON), &participants)
+		for _, p := range participants {
+			if !p.IsMe && SamePhone(p.Number, number) && (access == AIReadWrite || convAccess == AIHidden) {
+				access = convAccess
+			}
+		}
+	}
+	return access, rows.Err()
+}
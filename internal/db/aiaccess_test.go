@@ -0,0 +1,105 @@
+package db
+
+import "testing"
+
+func TestAgentViewLeavesOutHiddenConversations(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "family", Name: "Mom", Participants: `[{"name":"Mom","number":"+15551110000"}]`, LastMessageTS: 2000})
+	store.UpsertConversation(&Conversation{ConversationID: "work", Name: "Boss", Participants: `[{"name":"Boss","number":"+15552220000"}]`, LastMessageTS: 1000})
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "family", SenderNumber: "+15551110000", Body: "call me about the test results", TimestampMS: 2000, MediaID: "img"})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "work", SenderNumber: "+15552220000", Body: "call me about the report", TimestampMS: 1000})
+	store.AddOutboxItem(&OutboxItem{ConversationID: "family", Body: "ok", State: OutboxQueued})
+	store.UpsertDraft(&Draft{DraftID: "d1", ConversationID: "family", Body: "see you"})
+	store.AddApproval(&Approval{ConversationID: "family", Body: "on my way", State: ApprovalPending})
+	store.AddScheduledMessage(&ScheduledMessage{ConversationID: "family", Body: "happy birthday", SendAt: 5000, State: ScheduledPending})
+
+	if ok, err := store.SetAIAccess("family", AIHidden); err != nil || !ok {
+		t.Fatalf("set: %v, %v", ok, err)
+	}
+	if ok, _ := store.SetAIAccess("nope", AIHidden); ok {
+		t.Error("set access on a missing conversation")
+	}
+	// The phone sending the conversation again doesn't change it.
+	store.UpsertConversation(&Conversation{ConversationID: "family", Name: "Mom", LastMessageTS: 3000})
+	if access, _ := store.AIAccess("family"); access != AIHidden {
+		t.Errorf("after upsert: %q", access)
+	}
+	if access, _ := store.AIAccess("nope"); access != AIReadWrite {
+		t.Errorf("unknown conversation: %q", access)
+	}
+
+	// Everything is still there outside the agent view...
+	if convs, _ := store.ListConversations(10); len(convs) != 2 || convs[0].AIAccess != AIHidden {
+		t.Errorf("conversations: %+v", convs)
+	}
+
+	// ...and nothing of the hidden conversation in it.
+	agent := store.AgentView()
+	if convs, _ := agent.ListConversations(10); len(convs) != 1 || convs[0].ConversationID != "work" {
+		t.Errorf("agent conversations: %+v", convs)
+	}
+	if c, err := agent.GetConversation("family"); c != nil || err == nil {
+		t.Errorf("agent got the hidden conversation: %+v", c)
+	}
+	if msgs, _ := agent.GetMessagesByConversation("family", 10); len(msgs) != 0 {
+		t.Errorf("agent got %d hidden messages", len(msgs))
+	}
+	if msgs, _ := agent.GetMessages("", 0, 0, 10); len(msgs) != 1 || msgs[0].MessageID != "m2" {
+		t.Errorf("agent messages: %+v", msgs)
+	}
+	if m, _ := agent.GetMessageByID("m1"); m != nil {
+		t.Error("agent got a hidden message by ID")
+	}
+	for _, q := range []string{"call", "has:image", "results"} {
+		results, err := agent.SearchMessages(q, "", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range results {
+			if r.ConversationID == "family" {
+				t.Errorf("search %q found a hidden message", q)
+			}
+		}
+	}
+	if contacts, _ := agent.ListContactsFromConversations("", 10); len(contacts) != 1 || contacts[0].Name != "Boss" {
+		t.Errorf("agent contacts: %+v", contacts)
+	}
+	if items, _ := agent.ListOutbox("", 10); len(items) != 0 {
+		t.Errorf("agent outbox: %+v", items)
+	}
+	if d, _ := agent.GetDraft("d1"); d != nil {
+		t.Error("agent got a hidden draft")
+	}
+	if drafts, _ := agent.ListDrafts("family"); len(drafts) != 0 {
+		t.Errorf("agent drafts: %+v", drafts)
+	}
+	if msgs, _ := agent.ListScheduledMessages("", "", 10); len(msgs) != 0 {
+		t.Errorf("agent scheduled messages: %+v", msgs)
+	}
+	if list, _ := agent.ListApprovals("", 10); len(list) != 0 {
+		t.Errorf("agent approvals: %+v", list)
+	}
+}
+
+func TestNumberAIAccess(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "mom", Participants: `[{"name":"Mom","number":"+15551110000"}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "mom-rcs", Participants: `[{"name":"Mom","number":"+15551110000"}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "family", IsGroup: true, Participants: `[{"name":"Dad","number":"+15553330000"}]`})
+	store.SetAIAccess("mom", AIReadOnly)
+	store.SetAIAccess("family", AIHidden)
+
+	for number, want := range map[string]string{
+		"(555) 111-0000": AIReadOnly,
+		"+15553330000":   AIReadWrite, // only in a group
+		"+15559999999":   AIReadWrite,
+	} {
+		if got, err := store.NumberAIAccess(number); err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", number, got, err, want)
+		}
+	}
+	store.SetAIAccess("mom-rcs", AIHidden)
+	if got, _ := store.NumberAIAccess("+15551110000"); got != AIHidden {
+		t.Errorf("hidden wins over read-only: got %q", got)
+	}
+}
@@ -0,0 +1,84 @@
+package db
+
+const approvalColumns = `id, conversation_id, phone_number, body, draft_id, send_at, reason, state, outbox_id, scheduled_id, last_error, created_at, decided_at`
+
+func scanApproval(row interface{ Scan(...any) error }) (*Approval, error) {
+	a := &Approval{}
+	err := row.Scan(&a.ID, &a.ConversationID, &a.PhoneNumber, &a.Body, &a.DraftID, &a.SendAt, &a.Reason, &a.State, &a.OutboxID, &a.ScheduledID, &a.LastError, &a.CreatedAt, &a.DecidedAt)
+	return a, err
+}
+
+// AddApproval saves a and sets its ID.
+func (s *Store) AddApproval(a *Approval) error {
+	result, err := s.db.Exec(`
+		INSERT INTO approvals (conversation_id, phone_number, body, draft_id, send_at, reason, state, outbox_id, scheduled_id, last_error, created_at, decided_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.ConversationID, a.PhoneNumber, a.Body, a.DraftID, a.SendAt, a.Reason, a.State, a.OutboxID, a.ScheduledID, a.LastError, a.CreatedAt, a.DecidedAt)
+	if err != nil {
+		return err
+	}
+	a.ID, err = result.LastInsertId()
+	return err
+}
+
+// UpdateApproval saves a's state, what it was handed to and its error.
+func (s *Store) UpdateApproval(a *Approval) error {
+	_, err := s.db.Exec(`
+		UPDATE approvals SET
+			state = ?, outbox_id = ?, scheduled_id = ?, last_error = ?, decided_at = ?
+		WHERE id = ?
+	`, a.State, a.OutboxID, a.ScheduledID, a.LastError, a.DecidedAt, a.ID)
+	return err
+}
+
+// DecideApproval moves a pending approval to state at decidedAt (ms). It
+// reports false if there is no such approval or it was already decided, so
+// that two decisions can't both take effect.
+func (s *Store) DecideApproval(id int64, state string, decidedAt int64) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE approvals SET state = ?, decided_at = ?
+		WHERE id = ? AND state = 'pending'
+	`, state, decidedAt, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetApproval returns the approval with id, or nil if there is none.
+func (s *Store) GetApproval(id int64) (*Approval, error) {
+	a, err := scanApproval(s.db.QueryRow(`SELECT `+approvalColumns+` FROM approvals WHERE id = ?`, id))
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// ListApprovals returns approvals in the given state, or in any state if
+// state is empty, newest first.
+func (s *Store) ListApprovals(state string, limit int) ([]*Approval, error) {
+	rows, err := s.db.Query(`
+		SELECT `+approvalColumns+` FROM approvals
+		WHERE (? = '' OR state = ?) AND `+s.visible("conversation_id")+`
+		ORDER BY id DESC
+		LIMIT ?
+	`, state, state, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var approvals []*Approval
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, rows.Err()
+}
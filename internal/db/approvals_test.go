@@ -0,0 +1,49 @@
+package db
+
+import "testing"
+
+func TestDecideApproval(t *testing.T) {
+	store := newTestStore(t)
+	for _, a := range []*Approval{
+		{PhoneNumber: "+15551234567", Body: "first", State: ApprovalPending, CreatedAt: 1},
+		{ConversationID: "c1", Body: "second", SendAt: 500, State: ApprovalPending, CreatedAt: 2},
+	} {
+		if err := store.AddApproval(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ok, err := store.DecideApproval(1, ApprovalApproved, 10); err != nil || !ok {
+		t.Fatalf("approve: %v, %v", ok, err)
+	}
+	// A second decision doesn't take effect.
+	if ok, _ := store.DecideApproval(1, ApprovalRejected, 20); ok {
+		t.Error("rejected an approved approval")
+	}
+	if ok, _ := store.DecideApproval(99, ApprovalRejected, 20); ok {
+		t.Error("decided a missing approval")
+	}
+
+	a, _ := store.GetApproval(1)
+	if a.State != ApprovalApproved || a.DecidedAt != 10 {
+		t.Errorf("after approving: %+v", a)
+	}
+	a.OutboxID = 7
+	if err := store.UpdateApproval(a); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := store.GetApproval(1); a.OutboxID != 7 {
+		t.Errorf("outbox ID not saved: %+v", a)
+	}
+	if a, err := store.GetApproval(99); a != nil || err != nil {
+		t.Errorf("missing approval: %+v, %v", a, err)
+	}
+
+	all, _ := store.ListApprovals("", 10)
+	if len(all) != 2 || all[0].Body != "second" {
+		t.Errorf("all approvals, newest first: %+v", all)
+	}
+	if pending, _ := store.ListApprovals(ApprovalPending, 10); len(pending) != 1 || pending[0].SendAt != 500 {
+		t.Errorf("pending approvals: %+v", pending)
+	}
+}
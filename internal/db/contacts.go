@@ -57,6 +57,7 @@ func (s *Store) ListContacts(query string, limit int) ([]*Contact, error) {
 func (s *Store) ListContactsFromConversations(query string, limit int) ([]*Contact, error) {
 	rows, err := s.db.Query(`
 		SELECT conversation_id, name, participants FROM conversations
+		WHERE ` + s.visible("conversation_id") + `
 		ORDER BY last_message_ts DESC
 	`)
 	if err != nil {
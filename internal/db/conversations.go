@@ -2,24 +2,25 @@ package db
 
 func (s *Store) UpsertConversation(c *Conversation) error {
 	_, err := s.db.Exec(`
-		INSERT INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count, folder)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, COALESCE(NULLIF(?7, ''), 'inbox'))
 		ON CONFLICT(conversation_id) DO UPDATE SET
 			name=excluded.name,
 			is_group=excluded.is_group,
 			participants=excluded.participants,
 			last_message_ts=excluded.last_message_ts,
-			unread_count=excluded.unread_count
-	`, c.ConversationID, c.Name, c.IsGroup, c.Participants, c.LastMessageTS, c.UnreadCount)
+			unread_count=excluded.unread_count,
+			folder=CASE WHEN ?7 = '' THEN folder ELSE excluded.folder END
+	`, c.ConversationID, c.Name, c.IsGroup, c.Participants, c.LastMessageTS, c.UnreadCount, c.Folder)
 	return err
 }
 
 func (s *Store) GetConversation(id string) (*Conversation, error) {
 	c := &Conversation{}
 	err := s.db.QueryRow(`
-		SELECT conversation_id, name, is_group, participants, last_message_ts, unread_count
-		FROM conversations WHERE conversation_id = ?
-	`, id).Scan(&c.ConversationID, &c.Name, &c.IsGroup, &c.Participants, &c.LastMessageTS, &c.UnreadCount)
+		SELECT conversation_id, name, is_group, participants, last_message_ts, unread_count, folder, ai_access
+		FROM conversations WHERE conversation_id = ? AND `+s.visible("conversation_id")+`
+	`, id).Scan(&c.ConversationID, &c.Name, &c.IsGroup, &c.Participants, &c.LastMessageTS, &c.UnreadCount, &c.Folder, &c.AIAccess)
 	if err != nil {
 		return nil, err
 	}
@@ -38,8 +39,9 @@ func (s *Store) MarkConversationRead(id string) error {
 
 func (s *Store) ListConversations(limit int) ([]*Conversation, error) {
 	rows, err := s.db.Query(`
-		SELECT conversation_id, name, is_group, participants, last_message_ts, unread_count
+		SELECT conversation_id, name, is_group, participants, last_message_ts, unread_count, folder, ai_access
 		FROM conversations
+		WHERE `+s.visible("conversation_id")+`
 		ORDER BY last_message_ts DESC
 		LIMIT ?
 	`, limit)
@@ -51,7 +53,7 @@ func (s *Store) ListConversations(limit int) ([]*Conversation, error) {
 	var convs []*Conversation
 	for rows.Next() {
 		c := &Conversation{}
-		if err := rows.Scan(&c.ConversationID, &c.Name, &c.IsGroup, &c.Participants, &c.LastMessageTS, &c.UnreadCount); err != nil {
+		if err := rows.Scan(&c.ConversationID, &c.Name, &c.IsGroup, &c.Participants, &c.LastMessageTS, &c.UnreadCount, &c.Folder, &c.AIAccess); err != nil {
 			return nil, err
 		}
 		convs = append(convs, c)
@@ -291,3 +291,20 @@ func TestListConversations_ManyEntries(t *testing.T) {
 		}
 	})
 }
+
+func TestUpsertConversation_Folder(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice"})
+	if got, _ := store.GetConversation("c1"); got.Folder != FolderInbox {
+		t.Errorf("default folder: got %q, want inbox", got.Folder)
+	}
+
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice", Folder: FolderArchive})
+	// An update that doesn't know the folder keeps it.
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice B."})
+	got, _ := store.GetConversation("c1")
+	if got.Folder != FolderArchive || got.Name != "Alice B." {
+		t.Errorf("got folder %q name %q, want archive and the new name", got.Folder, got.Name)
+	}
+}
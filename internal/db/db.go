@@ -9,6 +9,9 @@ import (
 
 type Store struct {
 	db *sql.DB
+	// agent leaves conversations hidden from AI out of reads; see
+	// AgentView.
+	agent bool
 }
 
 type Conversation struct {
@@ -18,8 +21,32 @@ type Conversation struct {
 	Participants   string // JSON array
 	LastMessageTS  int64
 	UnreadCount    int
+	// Folder is FolderInbox, FolderArchive or FolderSpamBlocked. Left
+	// empty, UpsertConversation keeps the stored folder.
+	Folder string
+	// AIAccess is what MCP clients may do with the conversation: AIHidden,
+	// AIReadOnly or AIReadWrite. UpsertConversation leaves it alone; it
+	// is changed with SetAIAccess.
+	AIAccess string
 }
 
+// Conversation folders, matching Google Messages' own.
+const (
+	FolderInbox       = "inbox"
+	FolderArchive     = "archive"
+	FolderSpamBlocked = "spam_blocked"
+)
+
+// What MCP clients may do with a conversation.
+const (
+	// AIHidden conversations don't exist as far as MCP clients can tell.
+	AIHidden = "hidden"
+	// AIReadOnly conversations can be read but not sent to or drafted in.
+	AIReadOnly = "read-only"
+	// AIReadWrite conversations can be read and sent to. It is the default.
+	AIReadWrite = "read-write"
+)
+
 type Message struct {
 	MessageID      string
 	ConversationID string
@@ -31,9 +58,20 @@ type Message struct {
 	IsFromMe       bool
 	MediaID        string `json:",omitempty"`
 	MimeType       string `json:",omitempty"`
-	DecryptionKey  string `json:"-"` // hex-encoded, never exposed in API
+	DecryptionKey  string `json:"-"`          // hex-encoded, never exposed in API
 	Reactions      string `json:",omitempty"` // JSON array of {emoji, count}
 	ReplyToID      string `json:",omitempty"`
+	// TmpID is the ID we gave a message when sending it. A placeholder
+	// stored at send time has it as its MessageID until the real message
+	// echoes back; see ReconcileMessage.
+	TmpID string `json:",omitempty"`
+	// DeliveryStatus is Status boiled down to one of the Delivery*
+	// constants; it only moves forward (see UpsertMessage). StatusError is
+	// why a failed message failed, and StatusAt when DeliveryStatus last
+	// changed (ms).
+	DeliveryStatus string `json:",omitempty"`
+	StatusError    string `json:",omitempty"`
+	StatusAt       int64  `json:",omitempty"`
 }
 
 type Contact struct {
@@ -49,6 +87,155 @@ type Draft struct {
 	CreatedAt      int64
 }
 
+// Media is a cached attachment. Several media IDs can share one blob when
+// the same file was sent more than once.
+type Media struct {
+	MediaID        string
+	SHA256         string // hex; names the blob on disk
+	MimeType       string
+	Size           int64
+	CreatedAt      int64
+	LastAccessedAt int64
+}
+
+// SyncState is how much of a conversation's history has been backfilled.
+// OldestTS and NewestTS bound the synced range (ms); the cursor points at
+// the next older page to fetch, and Complete means there is none.
+type SyncState struct {
+	ConversationID string
+	CursorItemID   string
+	CursorTS       int64
+	OldestTS       int64
+	NewestTS       int64
+	Complete       bool
+	UpdatedAt      int64
+}
+
+// OutboxItem is a text message waiting to be sent, or the record of one
+// that was. Times are ms. MessageID is the sent message's tmp_ placeholder.
+type OutboxItem struct {
+	ID             int64
+	ConversationID string
+	PhoneNumber    string
+	Body           string
+	ReplyToID      string
+	State          string
+	Attempts       int
+	NextAttemptAt  int64
+	LastError      string
+	MessageID      string
+	CreatedAt      int64
+	UpdatedAt      int64
+}
+
+// Outbox states. Items move from queued to sending and then to sent, or
+// back to queued to retry, or to failed when out of attempts.
+const (
+	OutboxQueued  = "queued"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// ScheduledMessage is a message to send to a conversation at SendAt (ms).
+// With a DraftID, the draft's text at that time is sent and the draft is
+// removed. Once due it is handed to the outbox as OutboxID, which does the
+// sending and retrying from there.
+type ScheduledMessage struct {
+	ID             int64
+	ConversationID string
+	Body           string
+	DraftID        string `json:",omitempty"`
+	ReplyToID      string `json:",omitempty"`
+	SendAt         int64
+	State          string
+	OutboxID       int64  `json:",omitempty"`
+	LastError      string `json:",omitempty"`
+	CreatedAt      int64
+	UpdatedAt      int64
+}
+
+// Scheduled message states. A scheduled message is dispatched to the outbox
+// when due, or cancelled before then; it fails if its draft is gone.
+const (
+	ScheduledPending    = "scheduled"
+	ScheduledDispatched = "dispatched"
+	ScheduledCancelled  = "cancelled"
+	ScheduledFailed     = "failed"
+)
+
+// Webhook is a URL that incoming messages are posted to, signed with
+// Secret, which is never marshalled. Each filter that is set must match: ConversationID exactly,
+// Sender the sender's number or name, Keyword part of the body (any case).
+type Webhook struct {
+	ID             int64
+	URL            string
+	Secret         string `json:"-"`
+	ConversationID string `json:",omitempty"`
+	Sender         string `json:",omitempty"`
+	Keyword        string `json:",omitempty"`
+	Enabled        bool
+	CreatedAt      int64
+	UpdatedAt      int64
+}
+
+// WebhookDelivery is one post of a message to a webhook, kept as a log.
+// Payload is the JSON body; StatusCode is the receiver's last response, or
+// 0 if it couldn't be reached. Times are ms.
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	MessageID     string
+	Payload       string
+	State         string
+	Attempts      int
+	NextAttemptAt int64
+	StatusCode    int    `json:",omitempty"`
+	LastError     string `json:",omitempty"`
+	CreatedAt     int64
+	UpdatedAt     int64
+}
+
+// Webhook delivery states. Like outbox items, deliveries move from queued
+// to delivering and then to delivered, or back to queued to retry, or to
+// failed when out of attempts.
+const (
+	WebhookQueued     = "queued"
+	WebhookDelivering = "delivering"
+	WebhookDelivered  = "delivered"
+	WebhookFailed     = "failed"
+)
+
+// Approval is a message an agent asked to send that the send policy held
+// for the user to approve or reject. A SendAt (ms) makes it a scheduled
+// message once approved; without one it is sent right away. Once approved
+// it is handed to the outbox as OutboxID, or to the scheduler as
+// ScheduledID.
+type Approval struct {
+	ID             int64
+	ConversationID string `json:",omitempty"`
+	PhoneNumber    string `json:",omitempty"`
+	Body           string
+	DraftID        string `json:",omitempty"`
+	SendAt         int64  `json:",omitempty"`
+	Reason         string
+	State          string
+	OutboxID       int64  `json:",omitempty"`
+	ScheduledID    int64  `json:",omitempty"`
+	LastError      string `json:",omitempty"`
+	CreatedAt      int64
+	DecidedAt      int64 `json:",omitempty"`
+}
+
+// Approval states. Approvals start pending and are approved or rejected by
+// the user; failed ones were approved but couldn't be handed on.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalFailed   = "failed"
+)
+
 func New(dsn string) (*Store, error) {
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
@@ -79,49 +266,49 @@ func (s *Store) Close() error {
 // SeedDemo populates the database with fake data for screenshots/demos.
 func (s *Store) SeedDemo() error {
 	inserts := `
-INSERT OR IGNORE INTO conversations VALUES('conv3','Weekend Hiking Group',1,'[{"name":"Emily Park","number":"+13105553456"},{"name":"David Kim","number":"+14085557890"},{"name":"Alex Thompson","number":"+17185552222"}]',1738960200000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv1','Sarah Chen',0,'[{"name":"Sarah Chen","number":"+14155551234"}]',1738958400000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv2','Marcus Johnson',0,'[{"name":"Marcus Johnson","number":"+12125559876"}]',1738956600000,2);
-INSERT OR IGNORE INTO conversations VALUES('conv4','Emily Park',0,'[{"name":"Emily Park","number":"+13105553456"}]',1738951200000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv5','Lisa Rodriguez',0,'[{"name":"Lisa Rodriguez","number":"+12025551111"}]',1738947600000,1);
-INSERT OR IGNORE INTO conversations VALUES('conv6','David Kim',0,'[{"name":"David Kim","number":"+14085557890"}]',1738944000000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv7','Rachel Green',0,'[{"name":"Rachel Green","number":"+16505553333"}]',1738940400000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv8','Alex Thompson',0,'[{"name":"Alex Thompson","number":"+17185552222"}]',1738936800000,0);
-
-INSERT OR IGNORE INTO messages VALUES('m3a','conv3','Emily Park','+13105553456','Anyone up for a hike this Saturday? Weather looks amazing',1738951200000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m3b','conv3','David Kim','+14085557890','I''m in! Lands End or Battery to Bluffs?',1738953000000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m3c','conv3','Alex Thompson','+17185552222','Lands End! The wildflowers should be gorgeous right now',1738955400000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m3d','conv3','Emily Park','+13105553456','Lands End it is! 9am at the trailhead?',1738957800000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m3e','conv3','David Kim','+14085557890','Perfect. I''ll bring coffee for everyone',1738960200000,'delivered',0,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m1a','conv1','Sarah Chen','+14155551234','Hey! Are you free for dinner tonight?',1738951200000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1b','conv1','Me','+15551234567','Yes! What did you have in mind?',1738952100000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1c','conv1','Sarah Chen','+14155551234','There is a new Thai place on Valencia that just opened. Heard great things about their pad see ew',1738953000000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1d','conv1','Me','+15551234567','That sounds perfect! What time works for you?',1738954800000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1e','conv1','Sarah Chen','+14155551234','How about 7:30? I can make a reservation',1738956600000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1f','conv1','Me','+15551234567','Perfect, see you there!',1738958400000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m2a','conv2','Marcus Johnson','+12125559876','Quick update on the project - we hit our Q1 milestone early!',1738944000000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m2b','conv2','Me','+15551234567','That is awesome news! The team did a great job.',1738945800000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m2c','conv2','Marcus Johnson','+12125559876','Agreed. Want to hop on a call Monday to discuss next steps?',1738947600000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m2d','conv2','Marcus Johnson','+12125559876','Also, I sent over the slide deck to review when you get a chance',1738956600000,'delivered',0,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m4a','conv4','Emily Park','+13105553456','Thanks for the book recommendation! I am already halfway through it',1738940400000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m4b','conv4','Me','+15551234567','Glad you are enjoying it! The second half gets even better',1738951200000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m5a','conv5','Lisa Rodriguez','+12025551111','Are we still on for coffee tomorrow morning?',1738936800000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m5b','conv5','Me','+15551234567','Absolutely! Blue Bottle at 10?',1738938600000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m5c','conv5','Lisa Rodriguez','+12025551111','Sounds great! I have some exciting news to share',1738947600000,'delivered',0,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m6a','conv6','Me','+15551234567','Hey, did you see the Warriors game last night?',1738933200000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m6b','conv6','David Kim','+14085557890','Incredible comeback! Curry was unreal in the 4th quarter',1738936800000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m6c','conv6','Me','+15551234567','We should catch the next home game together',1738944000000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m7a','conv7','Rachel Green','+16505553333','Just landed! Flight was smooth. Thanks for the ride to the airport',1738929600000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m7b','conv7','Me','+15551234567','Anytime! Have an amazing trip',1738940400000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m8a','conv8','Alex Thompson','+17185552222','Found that restaurant we were talking about - it is called Nopa',1738929600000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m8b','conv8','Me','+15551234567','Nice find! Let us go next week',1738936800000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv3','Weekend Hiking Group',1,'[{"name":"Emily Park","number":"+13105553456"},{"name":"David Kim","number":"+14085557890"},{"name":"Alex Thompson","number":"+17185552222"}]',1738960200000,0);
+INSERT OR IGNORE INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv1','Sarah Chen',0,'[{"name":"Sarah Chen","number":"+14155551234"}]',1738958400000,0);
+INSERT OR IGNORE INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv2','Marcus Johnson',0,'[{"name":"Marcus Johnson","number":"+12125559876"}]',1738956600000,2);
+INSERT OR IGNORE INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv4','Emily Park',0,'[{"name":"Emily Park","number":"+13105553456"}]',1738951200000,0);
+INSERT OR IGNORE INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv5','Lisa Rodriguez',0,'[{"name":"Lisa Rodriguez","number":"+12025551111"}]',1738947600000,1);
+INSERT OR IGNORE INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv6','David Kim',0,'[{"name":"David Kim","number":"+14085557890"}]',1738944000000,0);
+INSERT OR IGNORE INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv7','Rachel Green',0,'[{"name":"Rachel Green","number":"+16505553333"}]',1738940400000,0);
+INSERT OR IGNORE INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv8','Alex Thompson',0,'[{"name":"Alex Thompson","number":"+17185552222"}]',1738936800000,0);
+
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3a','conv3','Emily Park','+13105553456','Anyone up for a hike this Saturday? Weather looks amazing',1738951200000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3b','conv3','David Kim','+14085557890','I''m in! Lands End or Battery to Bluffs?',1738953000000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3c','conv3','Alex Thompson','+17185552222','Lands End! The wildflowers should be gorgeous right now',1738955400000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3d','conv3','Emily Park','+13105553456','Lands End it is! 9am at the trailhead?',1738957800000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3e','conv3','David Kim','+14085557890','Perfect. I''ll bring coffee for everyone',1738960200000,'delivered',0,'','','','','');
+
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1a','conv1','Sarah Chen','+14155551234','Hey! Are you free for dinner tonight?',1738951200000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1b','conv1','Me','+15551234567','Yes! What did you have in mind?',1738952100000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1c','conv1','Sarah Chen','+14155551234','There is a new Thai place on Valencia that just opened. Heard great things about their pad see ew',1738953000000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1d','conv1','Me','+15551234567','That sounds perfect! What time works for you?',1738954800000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1e','conv1','Sarah Chen','+14155551234','How about 7:30? I can make a reservation',1738956600000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1f','conv1','Me','+15551234567','Perfect, see you there!',1738958400000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m2a','conv2','Marcus Johnson','+12125559876','Quick update on the project - we hit our Q1 milestone early!',1738944000000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m2b','conv2','Me','+15551234567','That is awesome news! The team did a great job.',1738945800000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m2c','conv2','Marcus Johnson','+12125559876','Agreed. Want to hop on a call Monday to discuss next steps?',1738947600000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m2d','conv2','Marcus Johnson','+12125559876','Also, I sent over the slide deck to review when you get a chance',1738956600000,'delivered',0,'','','','','');
+
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m4a','conv4','Emily Park','+13105553456','Thanks for the book recommendation! I am already halfway through it',1738940400000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m4b','conv4','Me','+15551234567','Glad you are enjoying it! The second half gets even better',1738951200000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m5a','conv5','Lisa Rodriguez','+12025551111','Are we still on for coffee tomorrow morning?',1738936800000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m5b','conv5','Me','+15551234567','Absolutely! Blue Bottle at 10?',1738938600000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m5c','conv5','Lisa Rodriguez','+12025551111','Sounds great! I have some exciting news to share',1738947600000,'delivered',0,'','','','','');
+
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m6a','conv6','Me','+15551234567','Hey, did you see the Warriors game last night?',1738933200000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m6b','conv6','David Kim','+14085557890','Incredible comeback! Curry was unreal in the 4th quarter',1738936800000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m6c','conv6','Me','+15551234567','We should catch the next home game together',1738944000000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m7a','conv7','Rachel Green','+16505553333','Just landed! Flight was smooth. Thanks for the ride to the airport',1738929600000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m7b','conv7','Me','+15551234567','Anytime! Have an amazing trip',1738940400000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m8a','conv8','Alex Thompson','+17185552222','Found that restaurant we were talking about - it is called Nopa',1738929600000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m8b','conv8','Me','+15551234567','Nice find! Let us go next week',1738936800000,'delivered',1,'','','','','');
 
 INSERT OR IGNORE INTO contacts VALUES('c1','Sarah Chen','+14155551234');
 INSERT OR IGNORE INTO contacts VALUES('c2','Marcus Johnson','+12125559876');
@@ -136,62 +323,3 @@ INSERT OR IGNORE INTO drafts VALUES('draft1','conv3','Count me in for Saturday!
 	_, err := s.db.Exec(inserts)
 	return err
 }
-
-func (s *Store) migrate() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS conversations (
-		conversation_id TEXT PRIMARY KEY,
-		name TEXT NOT NULL DEFAULT '',
-		is_group INTEGER NOT NULL DEFAULT 0,
-		participants TEXT NOT NULL DEFAULT '[]',
-		last_message_ts INTEGER NOT NULL DEFAULT 0,
-		unread_count INTEGER NOT NULL DEFAULT 0
-	);
-
-	CREATE TABLE IF NOT EXISTS messages (
-		message_id TEXT PRIMARY KEY,
-		conversation_id TEXT NOT NULL DEFAULT '',
-		sender_name TEXT NOT NULL DEFAULT '',
-		sender_number TEXT NOT NULL DEFAULT '',
-		body TEXT NOT NULL DEFAULT '',
-		timestamp_ms INTEGER NOT NULL DEFAULT 0,
-		status TEXT NOT NULL DEFAULT '',
-		is_from_me INTEGER NOT NULL DEFAULT 0,
-		media_id TEXT NOT NULL DEFAULT '',
-		mime_type TEXT NOT NULL DEFAULT '',
-		decryption_key TEXT NOT NULL DEFAULT '',
-		reactions TEXT NOT NULL DEFAULT '',
-		reply_to_id TEXT NOT NULL DEFAULT ''
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_messages_conv_ts ON messages(conversation_id, timestamp_ms);
-	CREATE INDEX IF NOT EXISTS idx_messages_ts ON messages(timestamp_ms DESC);
-
-	CREATE TABLE IF NOT EXISTS contacts (
-		contact_id TEXT PRIMARY KEY,
-		name TEXT NOT NULL DEFAULT '',
-		number TEXT NOT NULL DEFAULT ''
-	);
-
-	CREATE TABLE IF NOT EXISTS drafts (
-		draft_id TEXT PRIMARY KEY,
-		conversation_id TEXT NOT NULL,
-		body TEXT NOT NULL DEFAULT '',
-		created_at INTEGER NOT NULL DEFAULT 0
-	);
-	`
-	if _, err := s.db.Exec(schema); err != nil {
-		return err
-	}
-	// Migrate existing DBs: add media columns if missing (ignore errors if they already exist)
-	for _, col := range []string{
-		"ALTER TABLE messages ADD COLUMN media_id TEXT NOT NULL DEFAULT ''",
-		"ALTER TABLE messages ADD COLUMN mime_type TEXT NOT NULL DEFAULT ''",
-		"ALTER TABLE messages ADD COLUMN decryption_key TEXT NOT NULL DEFAULT ''",
-		"ALTER TABLE messages ADD COLUMN reactions TEXT NOT NULL DEFAULT ''",
-		"ALTER TABLE messages ADD COLUMN reply_to_id TEXT NOT NULL DEFAULT ''",
-	} {
-		s.db.Exec(col) // ignore "duplicate column" errors
-	}
-	return nil
-}
@@ -16,7 +16,7 @@ func (s *Store) ListDrafts(conversationID string) ([]*Draft, error) {
 	rows, err := s.db.Query(`
 		SELECT draft_id, conversation_id, body, created_at
 		FROM drafts
-		WHERE conversation_id = ?
+		WHERE conversation_id = ? AND `+s.visible("conversation_id")+`
 		ORDER BY created_at DESC
 	`, conversationID)
 	if err != nil {
@@ -38,7 +38,7 @@ func (s *Store) ListDrafts(conversationID string) ([]*Draft, error) {
 func (s *Store) GetDraft(draftID string) (*Draft, error) {
 	row := s.db.QueryRow(`
 		SELECT draft_id, conversation_id, body, created_at
-		FROM drafts WHERE draft_id = ?
+		FROM drafts WHERE draft_id = ? AND `+s.visible("conversation_id")+`
 	`, draftID)
 	d := &Draft{}
 	err := row.Scan(&d.DraftID, &d.ConversationID, &d.Body, &d.CreatedAt)
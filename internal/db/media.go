@@ -0,0 +1,91 @@
+package db
+
+// PutMedia records that a blob holds the content of m.MediaID.
+func (s *Store) PutMedia(m *Media) error {
+	_, err := s.db.Exec(`
+		INSERT INTO media (media_id, sha256, mime_type, size, created_at, last_accessed_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(media_id) DO UPDATE SET
+			sha256=excluded.sha256,
+			mime_type=excluded.mime_type,
+			size=excluded.size,
+			last_accessed_at=MAX(last_accessed_at, excluded.last_accessed_at)
+	`, m.MediaID, m.SHA256, m.MimeType, m.Size, m.CreatedAt, m.LastAccessedAt)
+	return err
+}
+
+func (s *Store) GetMedia(mediaID string) (*Media, error) {
+	row := s.db.QueryRow(`
+		SELECT media_id, sha256, mime_type, size, created_at, last_accessed_at
+		FROM media WHERE media_id = ?
+	`, mediaID)
+	m := &Media{}
+	err := row.Scan(&m.MediaID, &m.SHA256, &m.MimeType, &m.Size, &m.CreatedAt, &m.LastAccessedAt)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// TouchMedia marks a media ID as used at the given time, for LRU eviction.
+func (s *Store) TouchMedia(mediaID string, atMS int64) error {
+	_, err := s.db.Exec(`UPDATE media SET last_accessed_at = MAX(last_accessed_at, ?) WHERE media_id = ?`, atMS, mediaID)
+	return err
+}
+
+// MediaUsage returns the total size of all cached blobs, counting a blob
+// shared by several media IDs once.
+func (s *Store) MediaUsage() (int64, error) {
+	var total int64
+	err := s.db.QueryRow(`
+		SELECT COALESCE(SUM(size), 0) FROM (SELECT MAX(size) AS size FROM media GROUP BY sha256)
+	`).Scan(&total)
+	return total, err
+}
+
+// MediaBlob is a cached file on disk and when any of its media IDs was last used.
+type MediaBlob struct {
+	SHA256         string
+	Size           int64
+	LastAccessedAt int64
+}
+
+// LeastRecentlyUsedMedia returns up to limit blobs, least recently used first.
+func (s *Store) LeastRecentlyUsedMedia(limit int) ([]*MediaBlob, error) {
+	rows, err := s.db.Query(`
+		SELECT sha256, MAX(size), MAX(last_accessed_at) AS last_used
+		FROM media
+		GROUP BY sha256
+		ORDER BY last_used ASC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []*MediaBlob
+	for rows.Next() {
+		b := &MediaBlob{}
+		if err := rows.Scan(&b.SHA256, &b.Size, &b.LastAccessedAt); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+// DeleteMediaBlob forgets every media ID stored in the given blob.
+func (s *Store) DeleteMediaBlob(sha256 string) error {
+	_, err := s.db.Exec(`DELETE FROM media WHERE sha256 = ?`, sha256)
+	return err
+}
+
+// DeleteMedia forgets a single media ID, e.g. when its blob has gone missing.
+func (s *Store) DeleteMedia(mediaID string) error {
+	_, err := s.db.Exec(`DELETE FROM media WHERE media_id = ?`, mediaID)
+	return err
+}
@@ -1,14 +1,53 @@
 package db
 
 import (
+	"cmp"
+	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 )
 
 func (s *Store) UpsertMessage(m *Message) error {
-	_, err := s.db.Exec(`
-		INSERT INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	return upsertMessage(s.db, m)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// upsertMessage stores m, working out its delivery status from m.Status.
+// The delivery status only moves forward: an update that would move it
+// back, like a late "sent" after "read", keeps the stored status. Changes
+// to our own messages are added to their status history.
+func upsertMessage(ex execer, m *Message) error {
+	var prev Message
+	err := ex.QueryRow(`
+		SELECT status, delivery_status, status_error, status_at FROM messages WHERE message_id = ?
+	`, m.MessageID).Scan(&prev.Status, &prev.DeliveryStatus, &prev.StatusError, &prev.StatusAt)
+	if err != nil && err.Error() != "sql: no rows in result set" {
+		return err
+	}
+	found := err == nil
+
+	status, delivery, statusErr, statusAt := m.Status, DeliveryStatusOf(m.Status), m.StatusError, prev.StatusAt
+	if delivery != DeliveryFailed {
+		statusErr = ""
+	}
+	changed := false
+	switch {
+	case found && !statusAdvances(prev.DeliveryStatus, delivery):
+		status, delivery, statusErr = prev.Status, prev.DeliveryStatus, prev.StatusError
+	case delivery != prev.DeliveryStatus || statusErr != prev.StatusError:
+		statusAt = time.Now().UnixMilli()
+		changed = true
+	}
+
+	_, err = ex.Exec(`
+		INSERT INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id, tmp_id, delivery_status, status_error, status_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(message_id) DO UPDATE SET
 			conversation_id=excluded.conversation_id,
 			sender_name=excluded.sender_name,
@@ -21,16 +60,108 @@ func (s *Store) UpsertMessage(m *Message) error {
 			mime_type=excluded.mime_type,
 			decryption_key=excluded.decryption_key,
 			reactions=excluded.reactions,
-			reply_to_id=excluded.reply_to_id
-	`, m.MessageID, m.ConversationID, m.SenderName, m.SenderNumber, m.Body, m.TimestampMS, m.Status, m.IsFromMe, m.MediaID, m.MimeType, m.DecryptionKey, m.Reactions, m.ReplyToID)
+			reply_to_id=excluded.reply_to_id,
+			tmp_id=CASE WHEN excluded.tmp_id = '' THEN tmp_id ELSE excluded.tmp_id END,
+			delivery_status=excluded.delivery_status,
+			status_error=excluded.status_error,
+			status_at=excluded.status_at
+	`, m.MessageID, m.ConversationID, m.SenderName, m.SenderNumber, m.Body, m.TimestampMS, status, m.IsFromMe, m.MediaID, m.MimeType, m.DecryptionKey, m.Reactions, m.ReplyToID, m.TmpID, delivery, statusErr, statusAt)
+	if err != nil {
+		return err
+	}
+	if changed && m.IsFromMe {
+		return addStatusChange(ex, &StatusChange{MessageID: m.MessageID, Status: delivery, RawStatus: status, Error: statusErr, At: statusAt})
+	}
+	return nil
+}
+
+func addStatusChange(ex execer, c *StatusChange) error {
+	_, err := ex.Exec(`
+		INSERT INTO message_status_history (message_id, status, raw_status, error, at)
+		VALUES (?, ?, ?, ?, ?)
+	`, c.MessageID, c.Status, c.RawStatus, c.Error, c.At)
 	return err
 }
 
+// AddPendingMessage stores the placeholder for a message we just sent, with
+// m.MessageID and m.TmpID both set to the send's TmpID. If the real message
+// has already echoed back it stores nothing and reports false.
+func (s *Store) AddPendingMessage(m *Message) (bool, error) {
+	now := time.Now().UnixMilli()
+	delivery := DeliveryStatusOf(m.Status)
+	result, err := s.db.Exec(`
+		INSERT INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id, tmp_id, delivery_status, status_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM messages WHERE tmp_id = ?14)
+		ON CONFLICT(message_id) DO NOTHING
+	`, m.MessageID, m.ConversationID, m.SenderName, m.SenderNumber, m.Body, m.TimestampMS, m.Status, m.IsFromMe, m.MediaID, m.MimeType, m.DecryptionKey, m.Reactions, m.ReplyToID, m.TmpID, delivery, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, addStatusChange(s.db, &StatusChange{MessageID: m.MessageID, Status: delivery, RawStatus: m.Status, At: now})
+}
+
+// ReconcileMessage stores m, an echo of a message we sent, in place of the
+// placeholder stored under m.TmpID at send time. Reply and media fields the
+// echo leaves empty are kept from the placeholder. Without a placeholder it
+// is a plain UpsertMessage. It reports whether a placeholder was replaced.
+func (s *Store) ReconcileMessage(m *Message) (bool, error) {
+	if m.TmpID == "" || m.TmpID == m.MessageID {
+		return false, s.UpsertMessage(m)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var placeholderID string
+	var p Message
+	err = tx.QueryRow(`
+		SELECT message_id, reply_to_id, media_id, mime_type, decryption_key
+		FROM messages WHERE tmp_id = ? AND message_id != ?
+	`, m.TmpID, m.MessageID).Scan(&placeholderID, &p.ReplyToID, &p.MediaID, &p.MimeType, &p.DecryptionKey)
+	if err != nil && err.Error() != "sql: no rows in result set" {
+		return false, err
+	}
+	found := err == nil
+	if found {
+		merged := *m
+		m = &merged
+		m.ReplyToID = cmp.Or(m.ReplyToID, p.ReplyToID)
+		if m.MediaID == "" {
+			m.MediaID, m.MimeType, m.DecryptionKey = p.MediaID, p.MimeType, p.DecryptionKey
+		}
+		// Rename the placeholder so the message keeps its row, unless the
+		// real message is already stored, as when backfill got there first.
+		if _, err := tx.Exec(`
+			UPDATE messages SET message_id = ?
+			WHERE message_id = ? AND NOT EXISTS (SELECT 1 FROM messages WHERE message_id = ?)
+		`, m.MessageID, placeholderID, m.MessageID); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(`DELETE FROM messages WHERE message_id = ?`, placeholderID); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(`UPDATE message_status_history SET message_id = ? WHERE message_id = ?`, m.MessageID, placeholderID); err != nil {
+			return false, err
+		}
+	}
+	if err := upsertMessage(tx, m); err != nil {
+		return false, err
+	}
+	return found, tx.Commit()
+}
+
 func (s *Store) GetMessagesByConversation(conversationID string, limit int) ([]*Message, error) {
 	rows, err := s.db.Query(`
-		SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id
+		SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id, tmp_id, delivery_status, status_error, status_at
 		FROM messages
-		WHERE conversation_id = ?
+		WHERE conversation_id = ? AND `+s.visible("conversation_id")+`
 		ORDER BY timestamp_ms DESC
 		LIMIT ?
 	`, conversationID, limit)
@@ -42,7 +173,7 @@ func (s *Store) GetMessagesByConversation(conversationID string, limit int) ([]*
 }
 
 func (s *Store) GetMessages(phoneNumber string, afterMS, beforeMS int64, limit int) ([]*Message, error) {
-	var conditions []string
+	conditions := []string{s.visible("conversation_id")}
 	var args []any
 
 	if phoneNumber != "" {
@@ -58,10 +189,8 @@ func (s *Store) GetMessages(phoneNumber string, afterMS, beforeMS int64, limit i
 		args = append(args, beforeMS)
 	}
 
-	query := `SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id FROM messages`
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
-	}
+	query := `SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id, tmp_id, delivery_status, status_error, status_at FROM messages`
+	query += " WHERE " + strings.Join(conditions, " AND ")
 	query += " ORDER BY timestamp_ms DESC LIMIT ?"
 	args = append(args, limit)
 
@@ -73,40 +202,13 @@ func (s *Store) GetMessages(phoneNumber string, afterMS, beforeMS int64, limit i
 	return scanMessages(rows)
 }
 
-func (s *Store) SearchMessages(query, phoneNumber string, limit int) ([]*Message, error) {
-	var conditions []string
-	var args []any
-
-	conditions = append(conditions, "body LIKE ?")
-	args = append(args, "%"+query+"%")
-
-	if phoneNumber != "" {
-		conditions = append(conditions, "sender_number = ?")
-		args = append(args, phoneNumber)
-	}
-
-	q := `SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id FROM messages`
-	if len(conditions) > 0 {
-		q += " WHERE " + strings.Join(conditions, " AND ")
-	}
-	q += " ORDER BY timestamp_ms DESC LIMIT ?"
-	args = append(args, limit)
-
-	rows, err := s.db.Query(q, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return scanMessages(rows)
-}
-
 func (s *Store) GetMessageByID(messageID string) (*Message, error) {
 	row := s.db.QueryRow(`
-		SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id
-		FROM messages WHERE message_id = ?
+		SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id, tmp_id, delivery_status, status_error, status_at
+		FROM messages WHERE message_id = ? AND `+s.visible("conversation_id")+`
 	`, messageID)
 	m := &Message{}
-	err := row.Scan(&m.MessageID, &m.ConversationID, &m.SenderName, &m.SenderNumber, &m.Body, &m.TimestampMS, &m.Status, &m.IsFromMe, &m.MediaID, &m.MimeType, &m.DecryptionKey, &m.Reactions, &m.ReplyToID)
+	err := row.Scan(&m.MessageID, &m.ConversationID, &m.SenderName, &m.SenderNumber, &m.Body, &m.TimestampMS, &m.Status, &m.IsFromMe, &m.MediaID, &m.MimeType, &m.DecryptionKey, &m.Reactions, &m.ReplyToID, &m.TmpID, &m.DeliveryStatus, &m.StatusError, &m.StatusAt)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
 			return nil, nil
@@ -116,19 +218,6 @@ func (s *Store) GetMessageByID(messageID string) (*Message, error) {
 	return m, nil
 }
 
-// DeleteTmpMessages removes locally-created tmp_ messages for a conversation.
-// Called when the server echo arrives with a real message ID.
-func (s *Store) DeleteTmpMessages(conversationID string) (int64, error) {
-	result, err := s.db.Exec(
-		`DELETE FROM messages WHERE conversation_id = ? AND message_id LIKE 'tmp_%'`,
-		conversationID,
-	)
-	if err != nil {
-		return 0, err
-	}
-	return result.RowsAffected()
-}
-
 func scanMessages(rows interface {
 	Next() bool
 	Scan(...any) error
@@ -137,7 +226,7 @@ func scanMessages(rows interface {
 	var msgs []*Message
 	for rows.Next() {
 		m := &Message{}
-		if err := rows.Scan(&m.MessageID, &m.ConversationID, &m.SenderName, &m.SenderNumber, &m.Body, &m.TimestampMS, &m.Status, &m.IsFromMe, &m.MediaID, &m.MimeType, &m.DecryptionKey, &m.Reactions, &m.ReplyToID); err != nil {
+		if err := rows.Scan(&m.MessageID, &m.ConversationID, &m.SenderName, &m.SenderNumber, &m.Body, &m.TimestampMS, &m.Status, &m.IsFromMe, &m.MediaID, &m.MimeType, &m.DecryptionKey, &m.Reactions, &m.ReplyToID, &m.TmpID, &m.DeliveryStatus, &m.StatusError, &m.StatusAt); err != nil {
 			return nil, err
 		}
 		msgs = append(msgs, m)
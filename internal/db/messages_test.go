@@ -321,8 +321,7 @@ func TestSearchMessages_Comprehensive(t *testing.T) {
 		}
 	}
 
-	t.Run("case insensitive search via LIKE", func(t *testing.T) {
-		// SQLite LIKE is case-insensitive for ASCII by default.
+	t.Run("case insensitive search", func(t *testing.T) {
 		got, err := store.SearchMessages("hello", "", 100)
 		if err != nil {
 			t.Fatalf("search: %v", err)
@@ -406,8 +405,8 @@ func TestSearchMessages_Comprehensive(t *testing.T) {
 		}
 	})
 
-	t.Run("partial word match", func(t *testing.T) {
-		got, err := store.SearchMessages("ell", "", 100)
+	t.Run("prefix match", func(t *testing.T) {
+		got, err := store.SearchMessages("hel*", "", 100)
 		if err != nil {
 			t.Fatalf("search: %v", err)
 		}
@@ -462,64 +461,6 @@ func TestGetMessageByID_EdgeCases(t *testing.T) {
 	})
 }
 
-func TestDeleteTmpMessages_Comprehensive(t *testing.T) {
-	store := newTestStore(t)
-
-	t.Run("deletes only tmp_ messages in specified conversation", func(t *testing.T) {
-		store.UpsertMessage(&Message{MessageID: "tmp_aaa", ConversationID: "c1", TimestampMS: 1000})
-		store.UpsertMessage(&Message{MessageID: "tmp_bbb", ConversationID: "c1", TimestampMS: 1001})
-		store.UpsertMessage(&Message{MessageID: "real-1", ConversationID: "c1", TimestampMS: 900})
-		store.UpsertMessage(&Message{MessageID: "tmp_ccc", ConversationID: "c2", TimestampMS: 1000})
-
-		n, err := store.DeleteTmpMessages("c1")
-		if err != nil {
-			t.Fatalf("delete: %v", err)
-		}
-		if n != 2 {
-			t.Errorf("deleted count: got %d, want 2", n)
-		}
-
-		// c1 should have only the real message.
-		c1Msgs, _ := store.GetMessagesByConversation("c1", 100)
-		if len(c1Msgs) != 1 {
-			t.Fatalf("c1 messages: got %d, want 1", len(c1Msgs))
-		}
-		if c1Msgs[0].MessageID != "real-1" {
-			t.Errorf("remaining message: got %q, want real-1", c1Msgs[0].MessageID)
-		}
-
-		// c2 should be untouched.
-		c2Msgs, _ := store.GetMessagesByConversation("c2", 100)
-		if len(c2Msgs) != 1 {
-			t.Fatalf("c2 messages: got %d, want 1", len(c2Msgs))
-		}
-	})
-
-	t.Run("no tmp_ messages returns zero", func(t *testing.T) {
-		store2 := newTestStore(t)
-		store2.UpsertMessage(&Message{MessageID: "real-only", ConversationID: "c1", TimestampMS: 1000})
-
-		n, err := store2.DeleteTmpMessages("c1")
-		if err != nil {
-			t.Fatalf("delete: %v", err)
-		}
-		if n != 0 {
-			t.Errorf("deleted count: got %d, want 0", n)
-		}
-	})
-
-	t.Run("nonexistent conversation returns zero", func(t *testing.T) {
-		store3 := newTestStore(t)
-		n, err := store3.DeleteTmpMessages("no-such-conv")
-		if err != nil {
-			t.Fatalf("delete: %v", err)
-		}
-		if n != 0 {
-			t.Errorf("deleted count: got %d, want 0", n)
-		}
-	})
-}
-
 func TestGetMessages_OrderingIsDescending(t *testing.T) {
 	store := newTestStore(t)
 
@@ -567,3 +508,110 @@ func TestUpsertMessage_EmptyBody(t *testing.T) {
 		t.Errorf("MediaID: got %q, want mid-1", got.MediaID)
 	}
 }
+
+func pending(tmpID, body string) *Message {
+	return &Message{
+		MessageID:      tmpID,
+		TmpID:          tmpID,
+		ConversationID: "c1",
+		Body:           body,
+		IsFromMe:       true,
+		TimestampMS:    1000,
+		Status:         "OUTGOING_SENDING",
+	}
+}
+
+func TestReconcileMessage_SeveralInFlight(t *testing.T) {
+	store := newTestStore(t)
+
+	reply := pending("tmp_000000000001", "replying")
+	reply.ReplyToID = "orig-1"
+	photo := pending("tmp_000000000002", "")
+	photo.MediaID, photo.MimeType, photo.DecryptionKey = "media-1", "image/jpeg", "abcd"
+	plain := pending("tmp_000000000003", "plain")
+	failed := pending("tmp_000000000004", "never made it")
+	failed.Status = "OUTGOING_FAILED_GENERIC"
+	for _, m := range []*Message{reply, photo, plain, failed} {
+		if ok, err := store.AddPendingMessage(m); err != nil || !ok {
+			t.Fatalf("add %s: %v, %v", m.MessageID, ok, err)
+		}
+	}
+
+	// Echoes arrive out of order and without the reply and media fields.
+	echo := func(id, tmpID, body string) *Message {
+		return &Message{MessageID: id, TmpID: tmpID, ConversationID: "c1", Body: body, IsFromMe: true, TimestampMS: 2000, Status: "OUTGOING_COMPLETE"}
+	}
+	for _, e := range []*Message{
+		echo("real-2", photo.TmpID, ""),
+		echo("real-1", reply.TmpID, "replying"),
+	} {
+		ok, err := store.ReconcileMessage(e)
+		if err != nil || !ok {
+			t.Fatalf("reconcile %s: %v, %v", e.MessageID, ok, err)
+		}
+	}
+
+	got := map[string]*Message{}
+	msgs, _ := store.GetMessagesByConversation("c1", 10)
+	for _, m := range msgs {
+		got[m.MessageID] = m
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d messages, want 4: %v", len(got), got)
+	}
+	if m := got["real-1"]; m == nil || m.ReplyToID != "orig-1" || m.Status != "OUTGOING_COMPLETE" || m.TmpID != reply.TmpID {
+		t.Errorf("promoted reply: %+v", m)
+	}
+	if m := got["real-2"]; m == nil || m.MediaID != "media-1" || m.MimeType != "image/jpeg" || m.DecryptionKey != "abcd" {
+		t.Errorf("promoted photo: %+v", m)
+	}
+	// The sends still in flight, and the failed one, are untouched.
+	if got[plain.MessageID] == nil || got[failed.MessageID] == nil {
+		t.Errorf("pending placeholders were removed: %v", got)
+	}
+	if got[failed.MessageID].Status != "OUTGOING_FAILED_GENERIC" {
+		t.Errorf("failed placeholder status: %s", got[failed.MessageID].Status)
+	}
+
+	// A repeated echo updates the real message and finds no placeholder.
+	ok, err := store.ReconcileMessage(echo("real-1", reply.TmpID, "replying"))
+	if err != nil || ok {
+		t.Errorf("repeat echo: %v, %v", ok, err)
+	}
+	if msgs, _ := store.GetMessagesByConversation("c1", 10); len(msgs) != 4 {
+		t.Errorf("repeat echo: got %d messages, want 4", len(msgs))
+	}
+}
+
+func TestReconcileMessage_RealMessageAlreadyStored(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.AddPendingMessage(pending("tmp_000000000001", "hi")); err != nil {
+		t.Fatal(err)
+	}
+	// Backfill stored the real message without knowing its TmpID.
+	store.UpsertMessage(&Message{MessageID: "real-1", ConversationID: "c1", Body: "hi", IsFromMe: true, TimestampMS: 2000})
+
+	ok, err := store.ReconcileMessage(&Message{MessageID: "real-1", TmpID: "tmp_000000000001", ConversationID: "c1", Body: "hi", IsFromMe: true, TimestampMS: 2000})
+	if err != nil || !ok {
+		t.Fatalf("reconcile: %v, %v", ok, err)
+	}
+	msgs, _ := store.GetMessagesByConversation("c1", 10)
+	if len(msgs) != 1 || msgs[0].MessageID != "real-1" {
+		t.Errorf("want only real-1, got %+v", msgs)
+	}
+}
+
+func TestAddPendingMessage_AfterEcho(t *testing.T) {
+	store := newTestStore(t)
+	// The echo beats the sender back from SendMessage.
+	if _, err := store.ReconcileMessage(&Message{MessageID: "real-1", TmpID: "tmp_000000000001", ConversationID: "c1", Body: "hi", IsFromMe: true}); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := store.AddPendingMessage(pending("tmp_000000000001", "hi"))
+	if err != nil || ok {
+		t.Errorf("add after echo: %v, %v; want nothing stored", ok, err)
+	}
+	if m, _ := store.GetMessageByID("tmp_000000000001"); m != nil {
+		t.Error("placeholder stored after its echo")
+	}
+}
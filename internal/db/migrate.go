@@ -0,0 +1,403 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned by New when the database was written by a newer
+// build of openmessage than this one. Opening it anyway could silently drop
+// data the newer schema depends on.
+var ErrSchemaTooNew = errors.New("database schema is newer than this build supports")
+
+// migration is one forward step of the schema. Migration N (1-based) is
+// migrations[N-1]. Each runs in its own transaction together with the bump
+// of PRAGMA user_version, so a failure leaves the database at the previous
+// version. Released migrations must never be edited — append a new one.
+type migration struct {
+	name string
+	up   func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{"initial schema", migrateInitialSchema},
+	{"message media, reaction and reply columns", migrateMessageExtras},
+	{"full-text search index", migrateSearchIndex},
+	{"media cache", migrateMediaCache},
+	{"sync checkpoints", migrateSyncState},
+	{"conversation folders", migrateConversationFolder},
+	{"message tmp ids", migrateMessageTmpID},
+	{"outbox", migrateOutbox},
+	{"scheduled messages", migrateScheduledMessages},
+	{"message delivery status", migrateDeliveryStatus},
+	{"webhooks", migrateWebhooks},
+	{"send approvals", migrateApprovals},
+	{"conversation ai access", migrateAIAccess},
+}
+
+// migrate brings the database up to the latest schema version.
+//
+// Databases created before versioning existed report user_version 0 but
+// already contain some or all of the tables, so the early migrations are
+// written to adopt whatever subset of the historical schema is present.
+func (s *Store) migrate() error {
+	current, err := s.schemaVersion()
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("%w (database is at version %d, this build knows up to %d)", ErrSchemaTooNew, current, len(migrations))
+	}
+	if _, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	for v := current + 1; v <= len(migrations); v++ {
+		if err := s.applyMigration(v, migrations[v-1]); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", v, migrations[v-1].name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) applyMigration(version int, m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		version, m.name, time.Now().UnixMilli(),
+	); err != nil {
+		return fmt.Errorf("record migration: %w", err)
+	}
+	// PRAGMA doesn't take bound parameters; version is an int we control.
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("set user_version: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *Store) schemaVersion() (int, error) {
+	var v int
+	err := s.db.QueryRow("PRAGMA user_version").Scan(&v)
+	return v, err
+}
+
+// columnExists reports whether table has a column with the given name.
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var n int
+	err := tx.QueryRow(`SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	return n > 0, err
+}
+
+// addColumnIfMissing adds a column unless a pre-versioning build already did.
+// Unlike blindly running ALTER TABLE and ignoring the error, any failure here
+// is a real failure.
+func addColumnIfMissing(tx *sql.Tx, table, column, def string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil {
+		return fmt.Errorf("inspect %s.%s: %w", table, column, err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def)); err != nil {
+		return fmt.Errorf("add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func migrateInitialSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS conversations (
+		conversation_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		is_group INTEGER NOT NULL DEFAULT 0,
+		participants TEXT NOT NULL DEFAULT '[]',
+		last_message_ts INTEGER NOT NULL DEFAULT 0,
+		unread_count INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		message_id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL DEFAULT '',
+		sender_name TEXT NOT NULL DEFAULT '',
+		sender_number TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		timestamp_ms INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT '',
+		is_from_me INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_messages_conv_ts ON messages(conversation_id, timestamp_ms);
+	CREATE INDEX IF NOT EXISTS idx_messages_ts ON messages(timestamp_ms DESC);
+
+	CREATE TABLE IF NOT EXISTS contacts (
+		contact_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		number TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS drafts (
+		draft_id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL,
+		body TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL DEFAULT 0
+	);
+	`)
+	return err
+}
+
+func migrateMessageExtras(tx *sql.Tx) error {
+	for _, col := range []string{"media_id", "mime_type", "decryption_key", "reactions", "reply_to_id"} {
+		if err := addColumnIfMissing(tx, "messages", col, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSearchIndex creates an external-content FTS5 index over
+// messages.body. Triggers keep it in step with every insert, update and
+// delete on messages, so UpsertMessage and friends never touch it directly.
+func migrateSearchIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+		body,
+		content='messages',
+		content_rowid='rowid',
+		tokenize="unicode61 remove_diacritics 2 categories 'L* N* Co So'"
+	);
+
+	CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, body) VALUES (new.rowid, new.body);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF body ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+		INSERT INTO messages_fts(rowid, body) VALUES (new.rowid, new.body);
+	END;
+	`); err != nil {
+		return err
+	}
+	// Index everything stored before the index existed. Rebuilding is also
+	// correct for pre-versioning databases that already had the index.
+	_, err := tx.Exec(`INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')`)
+	return err
+}
+
+func migrateMediaCache(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE media (
+		media_id TEXT PRIMARY KEY,
+		sha256 TEXT NOT NULL,
+		mime_type TEXT NOT NULL DEFAULT '',
+		size INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_accessed_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX idx_media_sha256 ON media(sha256);
+	CREATE INDEX idx_media_last_accessed ON media(last_accessed_at);
+	`)
+	return err
+}
+
+func migrateSyncState(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE sync_state (
+		conversation_id TEXT PRIMARY KEY,
+		cursor_item_id TEXT NOT NULL DEFAULT '',
+		cursor_ts INTEGER NOT NULL DEFAULT 0,
+		oldest_ts INTEGER NOT NULL DEFAULT 0,
+		newest_ts INTEGER NOT NULL DEFAULT 0,
+		complete INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL DEFAULT 0
+	);
+	`)
+	return err
+}
+
+func migrateConversationFolder(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "conversations", "folder", "TEXT NOT NULL DEFAULT 'inbox'")
+}
+
+func migrateMessageTmpID(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "messages", "tmp_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`CREATE INDEX idx_messages_tmp_id ON messages(tmp_id) WHERE tmp_id != ''`)
+	return err
+}
+
+func migrateOutbox(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id TEXT NOT NULL DEFAULT '',
+		phone_number TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		reply_to_id TEXT NOT NULL DEFAULT '',
+		state TEXT NOT NULL DEFAULT 'queued',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		message_id TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX idx_outbox_state ON outbox(state, next_attempt_at);
+	`)
+	return err
+}
+
+func migrateScheduledMessages(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE scheduled_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id TEXT NOT NULL,
+		body TEXT NOT NULL DEFAULT '',
+		draft_id TEXT NOT NULL DEFAULT '',
+		reply_to_id TEXT NOT NULL DEFAULT '',
+		send_at INTEGER NOT NULL,
+		state TEXT NOT NULL DEFAULT 'scheduled',
+		outbox_id INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX idx_scheduled_messages_state ON scheduled_messages(state, send_at);
+	`)
+	return err
+}
+
+func migrateDeliveryStatus(tx *sql.Tx) error {
+	for _, col := range []struct{ name, def string }{
+		{"delivery_status", "TEXT NOT NULL DEFAULT ''"},
+		{"status_error", "TEXT NOT NULL DEFAULT ''"},
+		{"status_at", "INTEGER NOT NULL DEFAULT 0"},
+	} {
+		if err := addColumnIfMissing(tx, "messages", col.name, col.def); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+	CREATE TABLE message_status_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		raw_status TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		at INTEGER NOT NULL
+	);
+
+	CREATE INDEX idx_message_status_history_message ON message_status_history(message_id, at);
+	`); err != nil {
+		return err
+	}
+
+	// Fill in the delivery status of messages stored before it existed.
+	rows, err := tx.Query(`SELECT DISTINCT status FROM messages`)
+	if err != nil {
+		return err
+	}
+	var raws []string
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			rows.Close()
+			return err
+		}
+		raws = append(raws, raw)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, raw := range raws {
+		if _, err := tx.Exec(`UPDATE messages SET delivery_status = ? WHERE status = ?`, DeliveryStatusOf(raw), raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateWebhooks(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		conversation_id TEXT NOT NULL DEFAULT '',
+		sender TEXT NOT NULL DEFAULT '',
+		keyword TEXT NOT NULL DEFAULT '',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		message_id TEXT NOT NULL DEFAULT '',
+		payload TEXT NOT NULL,
+		state TEXT NOT NULL DEFAULT 'queued',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at INTEGER NOT NULL DEFAULT 0,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX idx_webhook_deliveries_state ON webhook_deliveries(state, next_attempt_at);
+	CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id);
+	`)
+	return err
+}
+
+func migrateApprovals(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE approvals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id TEXT NOT NULL DEFAULT '',
+		phone_number TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		draft_id TEXT NOT NULL DEFAULT '',
+		send_at INTEGER NOT NULL DEFAULT 0,
+		reason TEXT NOT NULL DEFAULT '',
+		state TEXT NOT NULL DEFAULT 'pending',
+		outbox_id INTEGER NOT NULL DEFAULT 0,
+		scheduled_id INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		decided_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX idx_approvals_state ON approvals(state, id);
+	`)
+	return err
+}
+
+func migrateAIAccess(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "conversations", "ai_access", "TEXT NOT NULL DEFAULT 'read-write'")
+}
@@ -0,0 +1,257 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Historical schemas, exactly as earlier builds created them before the
+// schema was versioned. Every one of them reports user_version 0.
+const (
+	legacyBaseSchema = `
+	CREATE TABLE conversations (
+		conversation_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		is_group INTEGER NOT NULL DEFAULT 0,
+		participants TEXT NOT NULL DEFAULT '[]',
+		last_message_ts INTEGER NOT NULL DEFAULT 0,
+		unread_count INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE messages (
+		message_id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL DEFAULT '',
+		sender_name TEXT NOT NULL DEFAULT '',
+		sender_number TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		timestamp_ms INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT '',
+		is_from_me INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX idx_messages_conv_ts ON messages(conversation_id, timestamp_ms);
+	CREATE INDEX idx_messages_ts ON messages(timestamp_ms DESC);
+	CREATE TABLE contacts (
+		contact_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		number TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE drafts (
+		draft_id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL,
+		body TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL DEFAULT 0
+	);
+	INSERT INTO conversations (conversation_id, name, last_message_ts) VALUES ('c1', 'Alice', 1000);
+	INSERT INTO messages (message_id, conversation_id, sender_name, body, timestamp_ms)
+		VALUES ('m1', 'c1', 'Alice', 'written by an old build', 1000);
+	INSERT INTO contacts VALUES ('ct1', 'Alice', '+15551234567');
+	INSERT INTO drafts VALUES ('d1', 'c1', 'old draft', 1000);
+	`
+	legacyMediaColumns = `
+	ALTER TABLE messages ADD COLUMN media_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN mime_type TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN decryption_key TEXT NOT NULL DEFAULT '';
+	`
+	legacyReactionsColumn = `ALTER TABLE messages ADD COLUMN reactions TEXT NOT NULL DEFAULT '';`
+	legacyReplyColumn     = `ALTER TABLE messages ADD COLUMN reply_to_id TEXT NOT NULL DEFAULT '';`
+	legacySearchIndex     = `
+	CREATE VIRTUAL TABLE messages_fts USING fts5(
+		body,
+		content='messages',
+		content_rowid='rowid',
+		tokenize="unicode61 remove_diacritics 2 categories 'L* N* Co So'"
+	);
+	CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, body) VALUES (new.rowid, new.body);
+	END;
+	CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+	END;
+	CREATE TRIGGER messages_fts_update AFTER UPDATE OF body ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+		INSERT INTO messages_fts(rowid, body) VALUES (new.rowid, new.body);
+	END;
+	INSERT INTO messages_fts(messages_fts) VALUES ('rebuild');
+	`
+)
+
+var legacyFixtures = []struct {
+	name  string
+	steps []string
+}{
+	{"base tables", []string{legacyBaseSchema}},
+	{"with media columns", []string{legacyBaseSchema, legacyMediaColumns}},
+	{"with reactions", []string{legacyBaseSchema, legacyMediaColumns, legacyReactionsColumn}},
+	{"with reply_to_id", []string{legacyBaseSchema, legacyMediaColumns, legacyReactionsColumn, legacyReplyColumn}},
+	{"with search index", []string{legacyBaseSchema, legacyMediaColumns, legacyReactionsColumn, legacyReplyColumn, legacySearchIndex}},
+}
+
+// writeFixture creates a database file by replaying SQL directly, bypassing
+// New so no migrations run.
+func writeFixture(t *testing.T, steps ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.db")
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for _, stmt := range steps {
+		if _, err := raw.Exec(stmt); err != nil {
+			t.Fatalf("write fixture: %v", err)
+		}
+	}
+	return path
+}
+
+func TestMigrateUpgradesLegacySchemas(t *testing.T) {
+	for _, fx := range legacyFixtures {
+		t.Run(fx.name, func(t *testing.T) {
+			path := writeFixture(t, fx.steps...)
+
+			store, err := New(path)
+			if err != nil {
+				t.Fatalf("open legacy db: %v", err)
+			}
+			defer store.Close()
+
+			v, err := store.schemaVersion()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != len(migrations) {
+				t.Errorf("user_version: got %d, want %d", v, len(migrations))
+			}
+
+			// Existing rows survive.
+			msg, err := store.GetMessageByID("m1")
+			if err != nil || msg == nil {
+				t.Fatalf("legacy message lost: %v", err)
+			}
+			if msg.Body != "written by an old build" {
+				t.Errorf("body: got %q", msg.Body)
+			}
+			if d, err := store.GetDraft("d1"); err != nil || d == nil {
+				t.Errorf("legacy draft lost: %v", err)
+			}
+
+			// New columns are usable.
+			if err := store.UpsertMessage(&Message{
+				MessageID: "m2", ConversationID: "c1", Body: "new row",
+				MediaID: "mid", MimeType: "image/png", Reactions: "[]", ReplyToID: "m1",
+			}); err != nil {
+				t.Errorf("upsert with new columns: %v", err)
+			}
+
+			// Old messages are searchable.
+			got, err := store.SearchMessages("old build", "", 10)
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			if len(got) != 1 || got[0].MessageID != "m1" {
+				t.Errorf("search: got %v, want [m1]", resultIDs(got))
+			}
+		})
+	}
+}
+
+func TestMigrateRecordsEveryVersion(t *testing.T) {
+	store := newTestStore(t)
+
+	rows, err := store.db.Query(`SELECT version, name FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var n int
+	for rows.Next() {
+		var version int
+		var name string
+		if err := rows.Scan(&version, &name); err != nil {
+			t.Fatal(err)
+		}
+		n++
+		if version != n {
+			t.Errorf("row %d: got version %d", n, version)
+		}
+		if name != migrations[version-1].name {
+			t.Errorf("version %d: got name %q, want %q", version, name, migrations[version-1].name)
+		}
+	}
+	if n != len(migrations) {
+		t.Errorf("recorded %d migrations, want %d", n, len(migrations))
+	}
+}
+
+func TestMigrateIsIdempotentAcrossReopens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	for i := 0; i < 3; i++ {
+		store, err := New(path)
+		if err != nil {
+			t.Fatalf("open #%d: %v", i+1, err)
+		}
+		var n int
+		if err := store.db.QueryRow(`SELECT count(*) FROM schema_migrations`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != len(migrations) {
+			t.Errorf("open #%d: %d migration rows, want %d", i+1, n, len(migrations))
+		}
+		store.Close()
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := writeFixture(t, legacyBaseSchema, fmt.Sprintf("PRAGMA user_version = %d", len(migrations)+1))
+
+	store, err := New(path)
+	if err == nil {
+		store.Close()
+		t.Fatal("expected error opening a database from a newer build")
+	}
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("got %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestMigrateFailureRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	store, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	migrations = append(append([]migration{}, saved...), migration{
+		name: "broken",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`CREATE TABLE half_done (id INTEGER)`); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		},
+	})
+
+	if _, err := New(path); err == nil {
+		t.Fatal("expected failing migration to fail New")
+	}
+
+	migrations = saved
+	store, err = New(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if v, _ := store.schemaVersion(); v != len(saved) {
+		t.Errorf("user_version: got %d, want %d", v, len(saved))
+	}
+	var n int
+	store.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'half_done'`).Scan(&n)
+	if n != 0 {
+		t.Error("failed migration's table was not rolled back")
+	}
+}
@@ -0,0 +1,142 @@
+package db
+
+import "database/sql"
+
+const outboxColumns = `id, conversation_id, phone_number, body, reply_to_id, state, attempts, next_attempt_at, last_error, message_id, created_at, updated_at`
+
+// AddOutboxItem queues it and sets its ID.
+func (s *Store) AddOutboxItem(it *OutboxItem) error {
+	result, err := s.db.Exec(`
+		INSERT INTO outbox (conversation_id, phone_number, body, reply_to_id, state, attempts, next_attempt_at, last_error, message_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, it.ConversationID, it.PhoneNumber, it.Body, it.ReplyToID, it.State, it.Attempts, it.NextAttemptAt, it.LastError, it.MessageID, it.CreatedAt, it.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	it.ID, err = result.LastInsertId()
+	return err
+}
+
+// UpdateOutboxItem saves its state, attempts, error and where it was sent.
+func (s *Store) UpdateOutboxItem(it *OutboxItem) error {
+	_, err := s.db.Exec(`
+		UPDATE outbox SET
+			conversation_id = ?, state = ?, attempts = ?, next_attempt_at = ?,
+			last_error = ?, message_id = ?, updated_at = ?
+		WHERE id = ?
+	`, it.ConversationID, it.State, it.Attempts, it.NextAttemptAt, it.LastError, it.MessageID, it.UpdatedAt, it.ID)
+	return err
+}
+
+// GetOutboxItem returns the item with id, or nil if there is none.
+func (s *Store) GetOutboxItem(id int64) (*OutboxItem, error) {
+	row := s.db.QueryRow(`SELECT `+outboxColumns+` FROM outbox WHERE id = ?`, id)
+	it := &OutboxItem{}
+	err := row.Scan(&it.ID, &it.ConversationID, &it.PhoneNumber, &it.Body, &it.ReplyToID, &it.State, &it.Attempts, &it.NextAttemptAt, &it.LastError, &it.MessageID, &it.CreatedAt, &it.UpdatedAt)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return it, nil
+}
+
+// ListOutbox returns items in the given state, or in any state if state is
+// empty, oldest first.
+func (s *Store) ListOutbox(state string, limit int) ([]*OutboxItem, error) {
+	rows, err := s.db.Query(`
+		SELECT `+outboxColumns+` FROM outbox
+		WHERE (? = '' OR state = ?) AND `+s.visible("conversation_id")+`
+		ORDER BY id
+		LIMIT ?
+	`, state, state, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*OutboxItem
+	for rows.Next() {
+		it := &OutboxItem{}
+		if err := rows.Scan(&it.ID, &it.ConversationID, &it.PhoneNumber, &it.Body, &it.ReplyToID, &it.State, &it.Attempts, &it.NextAttemptAt, &it.LastError, &it.MessageID, &it.CreatedAt, &it.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// outboxHead limits a query on outbox o to items that no older unsent item
+// to the same recipient is waiting in front of.
+const outboxHead = `NOT EXISTS (
+	SELECT 1 FROM outbox p
+	WHERE p.id < o.id AND p.state IN ('queued', 'sending')
+		AND p.conversation_id = o.conversation_id AND p.phone_number = o.phone_number
+)`
+
+// NextOutboxItem returns the oldest queued item due by nowMS, or nil. An
+// item waits behind older unsent items to the same recipient so that
+// messages arrive in the order they were written.
+func (s *Store) NextOutboxItem(nowMS int64) (*OutboxItem, error) {
+	row := s.db.QueryRow(`
+		SELECT `+outboxColumns+` FROM outbox o
+		WHERE state = 'queued' AND next_attempt_at <= ? AND `+outboxHead+`
+		ORDER BY id
+		LIMIT 1
+	`, nowMS)
+	it := &OutboxItem{}
+	err := row.Scan(&it.ID, &it.ConversationID, &it.PhoneNumber, &it.Body, &it.ReplyToID, &it.State, &it.Attempts, &it.NextAttemptAt, &it.LastError, &it.MessageID, &it.CreatedAt, &it.UpdatedAt)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return it, nil
+}
+
+// NextOutboxAttempt returns when NextOutboxItem will next have something
+// (ms). It reports false if nothing is queued.
+func (s *Store) NextOutboxAttempt() (int64, bool, error) {
+	var next sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT MIN(next_attempt_at) FROM outbox o
+		WHERE state = 'queued' AND ` + outboxHead).Scan(&next)
+	return next.Int64, next.Valid, err
+}
+
+// HasPendingOutbox reports whether anything to the recipient is still
+// queued or sending.
+func (s *Store) HasPendingOutbox(conversationID, phoneNumber string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM outbox
+		WHERE state IN ('queued', 'sending') AND conversation_id = ? AND phone_number = ?
+	`, conversationID, phoneNumber).Scan(&n)
+	return n > 0, err
+}
+
+// FailInterruptedOutbox marks items left sending by a previous run as
+// failed, since they may or may not have gone out. It returns how many.
+func (s *Store) FailInterruptedOutbox(nowMS int64) (int64, error) {
+	result, err := s.db.Exec(`
+		UPDATE outbox SET state = 'failed', last_error = 'interrupted while sending; it may or may not have been sent', updated_at = ?
+		WHERE state = 'sending'
+	`, nowMS)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteOutboxItem removes an item that is queued or failed. It reports
+// false for one that is sending, sent or missing.
+func (s *Store) DeleteOutboxItem(id int64) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM outbox WHERE id = ? AND state IN ('queued', 'failed')`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,74 @@
+package db
+
+import "testing"
+
+func addOutbox(t *testing.T, store *Store, convID, state string, nextAttemptAt int64) *OutboxItem {
+	t.Helper()
+	it := &OutboxItem{ConversationID: convID, Body: "hi", State: state, NextAttemptAt: nextAttemptAt, CreatedAt: 1, UpdatedAt: 1}
+	if err := store.AddOutboxItem(it); err != nil {
+		t.Fatal(err)
+	}
+	return it
+}
+
+func TestNextOutboxItem(t *testing.T) {
+	store := newTestStore(t)
+
+	if it, err := store.NextOutboxItem(100); err != nil || it != nil {
+		t.Fatalf("empty outbox: %v, %v", it, err)
+	}
+
+	backingOff := addOutbox(t, store, "c1", OutboxQueued, 500)
+	behind := addOutbox(t, store, "c1", OutboxQueued, 0)
+	other := addOutbox(t, store, "c2", OutboxQueued, 0)
+	addOutbox(t, store, "c3", OutboxSent, 0)
+
+	// c1's second message waits for its first, which isn't due.
+	it, err := store.NextOutboxItem(100)
+	if err != nil || it == nil || it.ID != other.ID {
+		t.Fatalf("next at 100: %+v, %v; want #%d", it, err, other.ID)
+	}
+	other.State = OutboxSent
+	store.UpdateOutboxItem(other)
+	if it, _ := store.NextOutboxItem(100); it != nil {
+		t.Errorf("nothing should be due at 100, got #%d", it.ID)
+	}
+	// behind is due already but blocked, so the next attempt is c1's first.
+	if next, ok, _ := store.NextOutboxAttempt(); !ok || next != 500 {
+		t.Errorf("next attempt: got %d, %v; want 500", next, ok)
+	}
+
+	if it, _ := store.NextOutboxItem(500); it == nil || it.ID != backingOff.ID {
+		t.Errorf("next at 500: %+v; want #%d", it, backingOff.ID)
+	}
+	backingOff.State = OutboxFailed
+	store.UpdateOutboxItem(backingOff)
+	if it, _ := store.NextOutboxItem(500); it == nil || it.ID != behind.ID {
+		t.Errorf("after the first failed: %+v; want #%d", it, behind.ID)
+	}
+}
+
+func TestFailInterruptedOutbox(t *testing.T) {
+	store := newTestStore(t)
+	sending := addOutbox(t, store, "c1", OutboxSending, 0)
+	queued := addOutbox(t, store, "c1", OutboxQueued, 0)
+
+	n, err := store.FailInterruptedOutbox(99)
+	if err != nil || n != 1 {
+		t.Fatalf("got %d, %v; want 1", n, err)
+	}
+	got, _ := store.GetOutboxItem(sending.ID)
+	if got.State != OutboxFailed || got.LastError == "" || got.UpdatedAt != 99 {
+		t.Errorf("interrupted item: %+v", got)
+	}
+	if got, _ := store.GetOutboxItem(queued.ID); got.State != OutboxQueued {
+		t.Errorf("queued item changed: %+v", got)
+	}
+
+	if ok, _ := store.DeleteOutboxItem(queued.ID); !ok {
+		t.Error("queued item should be deletable")
+	}
+	if items, _ := store.ListOutbox(OutboxQueued, 10); len(items) != 0 {
+		t.Errorf("queued items left: %d", len(items))
+	}
+}
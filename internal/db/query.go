@@ -0,0 +1,276 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidSearch is wrapped by ParseSearchQuery errors: an operator with a
+// missing or unknown value, or a date it can't read.
+var ErrInvalidSearch = errors.New("invalid search query")
+
+// SearchQuery is a parsed search: free text for the full-text index plus
+// structured filters, all of which must hold.
+type SearchQuery struct {
+	// Text is everything that wasn't an operator, in the free-text syntax
+	// described on SearchMessages. It may be empty if Filters isn't.
+	Text    string
+	Filters []SearchFilter
+	// SenderNumber, if set, restricts results to this exact sender number.
+	SenderNumber string
+}
+
+// SearchFilter is one operator from a query, e.g. has:image or -from:me.
+type SearchFilter struct {
+	Op      string // from, in, has, is, after, before
+	Value   string
+	Negated bool
+
+	ms int64 // after/before, resolved by ParseSearchQuery
+}
+
+// Values accepted by has: and is:, with the condition each compiles to.
+// Conversations are LEFT JOINed as c, so their columns may be NULL for
+// messages whose conversation hasn't been stored.
+var (
+	hasFilters = map[string]string{
+		"image":      "m.mime_type LIKE 'image/%'",
+		"video":      "m.mime_type LIKE 'video/%'",
+		"audio":      "m.mime_type LIKE 'audio/%'",
+		"media":      "m.media_id != ''",
+		"attachment": "m.media_id != ''",
+		"link":       "(m.body LIKE '%http://%' OR m.body LIKE '%https://%' OR m.body LIKE '%www.%')",
+		"reaction":   "m.reactions NOT IN ('', '[]')",
+		"reply":      "m.reply_to_id != ''",
+	}
+	isFilters = map[string]string{
+		"unread":   unreadCondition,
+		"read":     "NOT " + unreadCondition,
+		"sent":     "m.is_from_me = 1",
+		"received": "m.is_from_me = 0",
+		"group":    "COALESCE(c.is_group, 0) = 1",
+		"direct":   "COALESCE(c.is_group, 0) = 0",
+	}
+)
+
+// unreadCondition matches the incoming messages a conversation's unread
+// count covers: its newest unread_count messages not sent by me. Google
+// Messages only tracks unread state per conversation.
+const unreadCondition = `(m.is_from_me = 0 AND COALESCE(c.unread_count, 0) > (
+	SELECT count(*) FROM messages newer
+	WHERE newer.conversation_id = m.conversation_id AND newer.is_from_me = 0 AND newer.timestamp_ms > m.timestamp_ms))`
+
+// ParseSearchQuery splits a query into operators and free text:
+//
+//	from:"Sarah Chen"        sender name or number contains the value; from:me for my own messages
+//	in:conv3, in:"Book club" conversation ID, or conversation name contains the value
+//	has:image                also video, audio, media (any attachment), link, reaction, reply
+//	is:unread                also read, sent, received, group, direct
+//	after:2026-01-01         on or after that day (UTC), or an RFC 3339 time
+//	before:2026-02-01        strictly before that day (UTC), or an RFC 3339 time
+//
+// Prefixing an operator with '-' negates it. Words that look like operators
+// but aren't one of these (e.g. "re:") are left in the free text.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	q := &SearchQuery{}
+	var text []string
+	rs := []rune(query)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+		start := i
+		negated := false
+		if rs[i] == '-' && i+1 < len(rs) && !unicode.IsSpace(rs[i+1]) {
+			negated = true
+			i++
+		}
+		op, end, isOp := scanOperator(rs, i)
+		if !isOp {
+			end = skipFreeText(rs, i)
+			text = append(text, string(rs[start:end]))
+			i = end
+			continue
+		}
+
+		i = end + 1 // past the ':'
+		var value string
+		if i < len(rs) && rs[i] == '"' {
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			value = string(rs[i+1 : j])
+			i = j + 1
+		} else {
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) {
+				j++
+			}
+			value = string(rs[i:j])
+			i = j
+		}
+
+		f, err := newSearchFilter(op, strings.TrimSpace(value), negated)
+		if err != nil {
+			return nil, err
+		}
+		q.Filters = append(q.Filters, f)
+	}
+	q.Text = strings.Join(text, " ")
+	return q, nil
+}
+
+// scanOperator reports whether rs[i:] starts with a known "op:" prefix,
+// returning the operator and the index of its colon.
+func scanOperator(rs []rune, i int) (op string, colon int, ok bool) {
+	j := i
+	for j < len(rs) && unicode.IsLetter(rs[j]) {
+		j++
+	}
+	if j == i || j >= len(rs) || rs[j] != ':' {
+		return "", 0, false
+	}
+	op = strings.ToLower(string(rs[i:j]))
+	switch op {
+	case "from", "in", "has", "is", "after", "before":
+		return op, j, true
+	}
+	return "", 0, false
+}
+
+// skipFreeText returns the end of the free-text word or quoted phrase
+// starting at rs[i], matching how splitSearchQuery will later read it.
+func skipFreeText(rs []rune, i int) int {
+	if rs[i] == '"' {
+		j := i + 1
+		for j < len(rs) && rs[j] != '"' {
+			j++
+		}
+		return min(j+1, len(rs))
+	}
+	for i < len(rs) && !unicode.IsSpace(rs[i]) {
+		i++
+	}
+	return i
+}
+
+func newSearchFilter(op, value string, negated bool) (SearchFilter, error) {
+	f := SearchFilter{Op: op, Value: value, Negated: negated}
+	if value == "" {
+		return f, fmt.Errorf("%w: %s: needs a value", ErrInvalidSearch, op)
+	}
+	switch op {
+	case "has":
+		f.Value = strings.ToLower(value)
+		if _, ok := hasFilters[f.Value]; !ok {
+			return f, fmt.Errorf("%w: unknown has:%s (want one of %s)", ErrInvalidSearch, value, filterNames(hasFilters))
+		}
+	case "is":
+		f.Value = strings.ToLower(value)
+		if _, ok := isFilters[f.Value]; !ok {
+			return f, fmt.Errorf("%w: unknown is:%s (want one of %s)", ErrInvalidSearch, value, filterNames(isFilters))
+		}
+	case "after", "before":
+		t, err := parseSearchTime(value)
+		if err != nil {
+			return f, fmt.Errorf("%w: %s:%s is not a date (want YYYY-MM-DD)", ErrInvalidSearch, op, value)
+		}
+		f.ms = t.UnixMilli()
+	}
+	return f, nil
+}
+
+func parseSearchTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func filterNames(m map[string]string) string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// sql compiles the filter to a condition over messages m LEFT JOIN
+// conversations c.
+func (f SearchFilter) sql() (string, []any) {
+	var cond string
+	var args []any
+	switch f.Op {
+	case "from":
+		if strings.EqualFold(f.Value, "me") {
+			cond = "m.is_from_me = 1"
+			break
+		}
+		cond = `(m.sender_name LIKE ? ESCAPE '\' OR m.sender_number LIKE ? ESCAPE '\'`
+		args = []any{likeContains(f.Value), likeContains(f.Value)}
+		// Let "(555) 123-4567" find "+15551234567".
+		if digits := phoneDigits(f.Value); digits != "" {
+			cond += ` OR m.sender_number LIKE ?`
+			args = append(args, "%"+digits+"%")
+		}
+		cond += ")"
+	case "in":
+		cond = `(m.conversation_id = ? OR COALESCE(c.name, '') LIKE ? ESCAPE '\')`
+		args = []any{f.Value, likeContains(f.Value)}
+	case "has":
+		cond = hasFilters[f.Value]
+	case "is":
+		cond = isFilters[f.Value]
+	case "after":
+		cond = "m.timestamp_ms >= ?"
+		args = []any{f.ms}
+	case "before":
+		cond = "m.timestamp_ms < ?"
+		args = []any{f.ms}
+	}
+	if f.Negated {
+		cond = "NOT (" + cond + ")"
+	}
+	return cond, args
+}
+
+// likeContains builds a LIKE pattern (with ESCAPE '\') matching any string
+// that contains s literally.
+func likeContains(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// SamePhone reports whether a and b look like the same phone number: both
+// are phone numbers and the digits of one end with the other's, so that
+// numbers with and without a country code match.
+func SamePhone(a, b string) bool {
+	da, db := phoneDigits(a), phoneDigits(b)
+	return da != "" && db != "" && (strings.HasSuffix(da, db) || strings.HasSuffix(db, da))
+}
+
+// phoneDigits returns the digits of s if it looks like a phone number (only
+// digits and the usual separators, at least three digits), else "".
+func phoneDigits(s string) string {
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case strings.ContainsRune("+-.() ", r):
+		default:
+			return ""
+		}
+	}
+	if digits.Len() < 3 {
+		return ""
+	}
+	return digits.String()
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := ParseSearchQuery(`from:"Sarah Chen" in:conv3 has:image after:2026-01-01 before:2026-02-01 is:unread "dinner"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Text != `"dinner"` {
+		t.Errorf("text: got %q", q.Text)
+	}
+	want := []SearchFilter{
+		{Op: "from", Value: "Sarah Chen"},
+		{Op: "in", Value: "conv3"},
+		{Op: "has", Value: "image"},
+		{Op: "after", Value: "2026-01-01", ms: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()},
+		{Op: "before", Value: "2026-02-01", ms: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC).UnixMilli()},
+		{Op: "is", Value: "unread"},
+	}
+	if len(q.Filters) != len(want) {
+		t.Fatalf("filters: got %+v", q.Filters)
+	}
+	for i := range want {
+		if q.Filters[i] != want[i] {
+			t.Errorf("filter %d: got %+v, want %+v", i, q.Filters[i], want[i])
+		}
+	}
+}
+
+func TestParseSearchQuery_FreeText(t *testing.T) {
+	tests := []struct {
+		query string
+		text  string
+		n     int
+	}{
+		{`-has:image dinner -work`, `dinner -work`, 1},
+		{`re: lunch`, `re: lunch`, 0},
+		{`time:7pm`, `time:7pm`, 0},
+		{`"from:alice" hello`, `"from:alice" hello`, 0},
+		{`FROM:alice`, ``, 1},
+	}
+	for _, tt := range tests {
+		q, err := ParseSearchQuery(tt.query)
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if q.Text != tt.text || len(q.Filters) != tt.n {
+			t.Errorf("%q: got text %q with %d filters, want %q with %d", tt.query, q.Text, len(q.Filters), tt.text, tt.n)
+		}
+	}
+}
+
+func TestParseSearchQuery_Invalid(t *testing.T) {
+	for _, query := range []string{"has:banana", "is:starred", "after:yesterday", "before:2026-13-01", "from:", `in:""`} {
+		if _, err := ParseSearchQuery(query); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("%q: got err %v, want ErrInvalidSearch", query, err)
+		}
+	}
+}
+
+func seedFiltered(t *testing.T, store *Store) {
+	t.Helper()
+	day := func(d int) int64 { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC).UnixMilli() }
+	convs := []Conversation{
+		{ConversationID: "conv1", Name: "Sarah Chen", LastMessageTS: day(20), UnreadCount: 1},
+		{ConversationID: "conv3", Name: "Book Club", IsGroup: true, LastMessageTS: day(25)},
+	}
+	for i := range convs {
+		if err := store.UpsertConversation(&convs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs := []Message{
+		{MessageID: "a", ConversationID: "conv3", SenderName: "Sarah Chen", SenderNumber: "+15551234567", Body: "dinner pics", MediaID: "x", MimeType: "image/jpeg", TimestampMS: day(10)},
+		{MessageID: "b", ConversationID: "conv3", SenderName: "Sarah Chen", SenderNumber: "+15551234567", Body: "dinner was great", TimestampMS: day(11)},
+		{MessageID: "c", ConversationID: "conv3", SenderName: "Bob", SenderNumber: "+15559999999", Body: "dinner recap https://example.com", TimestampMS: day(25), Reactions: `[{"emoji":"👍"}]`},
+		{MessageID: "d", ConversationID: "conv1", SenderName: "Sarah Chen", SenderNumber: "+15551234567", Body: "older dinner note", TimestampMS: day(2)},
+		{MessageID: "e", ConversationID: "conv1", SenderName: "Sarah Chen", SenderNumber: "+15551234567", Body: "are we still on for dinner", TimestampMS: day(20)},
+		{MessageID: "f", ConversationID: "conv1", IsFromMe: true, Body: "yes, dinner at 8", TimestampMS: day(19), ReplyToID: "d"},
+		{MessageID: "g", ConversationID: "conv1", SenderName: "Sarah Chen", SenderNumber: "+15551234567", Body: "photo", MediaID: "y", MimeType: "image/png", TimestampMS: day(3)},
+	}
+	for i := range msgs {
+		if err := store.UpsertMessage(&msgs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSearchMessages_Filters(t *testing.T) {
+	store := newTestStore(t)
+	seedFiltered(t, store)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`from:"Sarah Chen" in:conv3 has:image after:2026-01-01 before:2026-02-01 "dinner"`, []string{"a"}},
+		{`from:sarah dinner`, []string{"a", "b", "d", "e"}},
+		{`from:"(555) 123-4567" dinner`, []string{"a", "b", "d", "e"}},
+		{`from:me`, []string{"f"}},
+		{`-from:me in:conv1`, []string{"d", "e", "g"}},
+		{`in:"book club"`, []string{"a", "b", "c"}},
+		{`has:image`, []string{"a", "g"}},
+		{`has:media -has:video`, []string{"a", "g"}},
+		{`has:link`, []string{"c"}},
+		{`has:reaction`, []string{"c"}},
+		{`has:reply`, []string{"f"}},
+		{`is:unread`, []string{"e"}},
+		{`is:unread dinner`, []string{"e"}},
+		{`is:sent`, []string{"f"}},
+		{`is:group dinner -pics`, []string{"b", "c"}},
+		{`is:direct has:image`, []string{"g"}},
+		{`after:2026-01-20 before:2026-01-25`, []string{"e"}},
+		{`in:conv1 -dinner`, []string{"g"}},
+		{`in:nowhere`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := store.SearchMessages(tt.query, "", 100)
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			if ids := resultIDs(got); !sameIDs(ids, tt.want...) {
+				t.Errorf("got %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMessages_FiltersOnlyNewestFirst(t *testing.T) {
+	store := newTestStore(t)
+	seedFiltered(t, store)
+
+	got, err := store.SearchMessages("in:conv3", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := resultIDs(got); len(ids) != 3 || ids[0] != "c" || ids[1] != "b" || ids[2] != "a" {
+		t.Errorf("got %v, want [c b a]", ids)
+	}
+	if got[0].Snippet != "" {
+		t.Errorf("filter-only results should have no snippet, got %q", got[0].Snippet)
+	}
+}
+
+func TestSearchMessages_LikeWildcardsAreLiteral(t *testing.T) {
+	store := newTestStore(t)
+	seedFiltered(t, store)
+
+	got, err := store.SearchMessages("from:%", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("from:%% should match no sender, got %v", resultIDs(got))
+	}
+}
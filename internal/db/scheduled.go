@@ -0,0 +1,160 @@
+package db
+
+import "database/sql"
+
+const scheduledColumns = `id, conversation_id, body, draft_id, reply_to_id, send_at, state, outbox_id, last_error, created_at, updated_at`
+
+func scanScheduled(row interface{ Scan(...any) error }) (*ScheduledMessage, error) {
+	m := &ScheduledMessage{}
+	err := row.Scan(&m.ID, &m.ConversationID, &m.Body, &m.DraftID, &m.ReplyToID, &m.SendAt, &m.State, &m.OutboxID, &m.LastError, &m.CreatedAt, &m.UpdatedAt)
+	return m, err
+}
+
+// AddScheduledMessage stores m and sets its ID.
+func (s *Store) AddScheduledMessage(m *ScheduledMessage) error {
+	result, err := s.db.Exec(`
+		INSERT INTO scheduled_messages (conversation_id, body, draft_id, reply_to_id, send_at, state, outbox_id, last_error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, m.ConversationID, m.Body, m.DraftID, m.ReplyToID, m.SendAt, m.State, m.OutboxID, m.LastError, m.CreatedAt, m.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	m.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetScheduledMessage returns the scheduled message with id, or nil if
+// there is none.
+func (s *Store) GetScheduledMessage(id int64) (*ScheduledMessage, error) {
+	m, err := scanScheduled(s.db.QueryRow(`SELECT `+scheduledColumns+` FROM scheduled_messages WHERE id = ?`, id))
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// ListScheduledMessages returns scheduled messages, soonest first. An empty
+// conversationID or state matches any.
+func (s *Store) ListScheduledMessages(conversationID, state string, limit int) ([]*ScheduledMessage, error) {
+	rows, err := s.db.Query(`
+		SELECT `+scheduledColumns+` FROM scheduled_messages
+		WHERE (? = '' OR conversation_id = ?) AND (? = '' OR state = ?) AND `+s.visible("conversation_id")+`
+		ORDER BY send_at, id
+		LIMIT ?
+	`, conversationID, conversationID, state, state, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*ScheduledMessage
+	for rows.Next() {
+		m, err := scanScheduled(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// NextScheduledMessage returns the earliest pending message due by nowMS,
+// or nil.
+func (s *Store) NextScheduledMessage(nowMS int64) (*ScheduledMessage, error) {
+	m, err := scanScheduled(s.db.QueryRow(`
+		SELECT `+scheduledColumns+` FROM scheduled_messages
+		WHERE state = 'scheduled' AND send_at <= ?
+		ORDER BY send_at, id
+		LIMIT 1
+	`, nowMS))
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// NextScheduledSendAt returns when the next pending message is due (ms).
+// It reports false if none is pending.
+func (s *Store) NextScheduledSendAt() (int64, bool, error) {
+	var next sql.NullInt64
+	err := s.db.QueryRow(`SELECT MIN(send_at) FROM scheduled_messages WHERE state = 'scheduled'`).Scan(&next)
+	return next.Int64, next.Valid, err
+}
+
+// UpdateScheduledMessage saves m's state and error.
+func (s *Store) UpdateScheduledMessage(m *ScheduledMessage) error {
+	_, err := s.db.Exec(`
+		UPDATE scheduled_messages SET state = ?, outbox_id = ?, last_error = ?, updated_at = ?
+		WHERE id = ?
+	`, m.State, m.OutboxID, m.LastError, m.UpdatedAt, m.ID)
+	return err
+}
+
+// CancelScheduledMessage cancels a message that is still pending. It
+// reports false for one already dispatched, cancelled, failed or missing.
+func (s *Store) CancelScheduledMessage(id, nowMS int64) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE scheduled_messages SET state = 'cancelled', updated_at = ?
+		WHERE id = ? AND state = 'scheduled'
+	`, nowMS, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DispatchScheduledMessage hands a pending message over to the outbox as
+// it, deleting its draft if it had one, all in one transaction so a crash
+// can neither lose nor duplicate it. It sets m's state and OutboxID, and
+// reports false if m was no longer pending.
+func (s *Store) DispatchScheduledMessage(m *ScheduledMessage, it *OutboxItem) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE scheduled_messages SET state = 'dispatched', updated_at = ?
+		WHERE id = ? AND state = 'scheduled'
+	`, it.CreatedAt, m.ID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	result, err = tx.Exec(`
+		INSERT INTO outbox (conversation_id, phone_number, body, reply_to_id, state, attempts, next_attempt_at, last_error, message_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, it.ConversationID, it.PhoneNumber, it.Body, it.ReplyToID, it.State, it.Attempts, it.NextAttemptAt, it.LastError, it.MessageID, it.CreatedAt, it.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	if it.ID, err = result.LastInsertId(); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE scheduled_messages SET outbox_id = ? WHERE id = ?`, it.ID, m.ID); err != nil {
+		return false, err
+	}
+	if m.DraftID != "" {
+		if _, err := tx.Exec(`DELETE FROM drafts WHERE draft_id = ?`, m.DraftID); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	m.State = ScheduledDispatched
+	m.OutboxID = it.ID
+	m.UpdatedAt = it.CreatedAt
+	return true, nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import "testing"
+
+func TestDispatchScheduledMessage(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertDraft(&Draft{DraftID: "d1", ConversationID: "c1", Body: "draft text", CreatedAt: 1})
+
+	m := &ScheduledMessage{ConversationID: "c1", DraftID: "d1", SendAt: 100, State: ScheduledPending, CreatedAt: 1, UpdatedAt: 1}
+	if err := store.AddScheduledMessage(m); err != nil {
+		t.Fatal(err)
+	}
+	if next, ok, _ := store.NextScheduledSendAt(); !ok || next != 100 {
+		t.Errorf("next send: got %d, %v; want 100", next, ok)
+	}
+	if got, _ := store.NextScheduledMessage(99); got != nil {
+		t.Errorf("nothing should be due at 99, got #%d", got.ID)
+	}
+	due, err := store.NextScheduledMessage(100)
+	if err != nil || due == nil || due.ID != m.ID {
+		t.Fatalf("due at 100: %+v, %v", due, err)
+	}
+
+	it := &OutboxItem{ConversationID: "c1", Body: "draft text", State: OutboxQueued, NextAttemptAt: 100, CreatedAt: 100, UpdatedAt: 100}
+	ok, err := store.DispatchScheduledMessage(due, it)
+	if err != nil || !ok {
+		t.Fatalf("dispatch: %v, %v", ok, err)
+	}
+	got, _ := store.GetScheduledMessage(m.ID)
+	if got.State != ScheduledDispatched || got.OutboxID != it.ID || it.ID == 0 {
+		t.Errorf("after dispatch: %+v (outbox #%d)", got, it.ID)
+	}
+	if queued, _ := store.GetOutboxItem(it.ID); queued == nil || queued.Body != "draft text" {
+		t.Errorf("outbox item: %+v", queued)
+	}
+	if d, _ := store.GetDraft("d1"); d != nil {
+		t.Error("draft should be deleted once dispatched")
+	}
+
+	// Neither a second dispatch nor a cancel touches it now.
+	if ok, err := store.DispatchScheduledMessage(due, &OutboxItem{ConversationID: "c1", Body: "x", State: OutboxQueued}); err != nil || ok {
+		t.Errorf("second dispatch: %v, %v", ok, err)
+	}
+	if items, _ := store.ListOutbox("", 10); len(items) != 1 {
+		t.Errorf("outbox items: got %d, want 1", len(items))
+	}
+	if ok, _ := store.CancelScheduledMessage(m.ID, 200); ok {
+		t.Error("cancel after dispatch should fail")
+	}
+}
+
+func TestListScheduledMessages(t *testing.T) {
+	store := newTestStore(t)
+	for _, m := range []*ScheduledMessage{
+		{ConversationID: "c1", Body: "later", SendAt: 300},
+		{ConversationID: "c1", Body: "sooner", SendAt: 200},
+		{ConversationID: "c2", Body: "other", SendAt: 100},
+	} {
+		m.State = ScheduledPending
+		if err := store.AddScheduledMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.CancelScheduledMessage(1, 50)
+
+	msgs, err := store.ListScheduledMessages("c1", "", 10)
+	if err != nil || len(msgs) != 2 || msgs[0].Body != "sooner" || msgs[1].State != ScheduledCancelled {
+		t.Fatalf("c1: %+v, %v", msgs, err)
+	}
+	if msgs, _ := store.ListScheduledMessages("", ScheduledPending, 10); len(msgs) != 2 || msgs[0].Body != "other" {
+		t.Errorf("pending: %+v", msgs)
+	}
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// SnippetOpen and SnippetClose bracket the matched terms inside
+// SearchResult.Snippet. They are control characters so they can never be
+// confused with message text; callers swap them for their own markup.
+const (
+	SnippetOpen  = "\x02"
+	SnippetClose = "\x03"
+)
+
+// ErrNoSearchTerms is returned when a search query has nothing to match on,
+// e.g. it only excludes words ("-spam") and has no operators to narrow it.
+var ErrNoSearchTerms = errors.New("search query needs at least one word or filter to match")
+
+// SearchResult is a message matched by SearchMessages along with a
+// highlighted excerpt of its body and its bm25 relevance (lower is better).
+// Queries made only of filters have neither.
+type SearchResult struct {
+	Message
+	Snippet string  `json:",omitempty"`
+	Rank    float64 `json:",omitempty"`
+}
+
+// SearchMessages parses query with ParseSearchQuery and runs it. A non-empty
+// phoneNumber additionally restricts results to that exact sender number.
+//
+// The free-text part of the query is deliberately small:
+//
+//	dinner tonight     both words, in any order
+//	"dinner tonight"   the exact phrase
+//	din*               words starting with "din"
+//	-work, NOT work    exclude messages containing "work"
+//	pizza OR tacos     either word
+func (s *Store) SearchMessages(query, phoneNumber string, limit int) ([]*SearchResult, error) {
+	q, err := ParseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	q.SenderNumber = phoneNumber
+	return s.Search(q, limit)
+}
+
+// Search runs a parsed query. With free text it returns the best full-text
+// matches first, newest first among equally relevant ones; with filters
+// alone it returns the newest matching messages.
+func (s *Store) Search(q *SearchQuery, limit int) ([]*SearchResult, error) {
+	var conditions []string
+	var args []any
+	for _, f := range q.Filters {
+		cond, fargs := f.sql()
+		conditions = append(conditions, cond)
+		args = append(args, fargs...)
+	}
+	if q.SenderNumber != "" {
+		conditions = append(conditions, "m.sender_number = ?")
+		args = append(args, q.SenderNumber)
+	}
+
+	positive, negative := ftsTerms(q.Text)
+	if len(positive) == 0 && len(conditions) == 0 {
+		return nil, ErrNoSearchTerms
+	}
+	conditions = append(conditions, s.visible("m.conversation_id"))
+
+	const columns = `m.message_id, m.conversation_id, m.sender_name, m.sender_number, m.body, m.timestamp_ms, m.status, m.is_from_me, m.media_id, m.mime_type, m.decryption_key, m.reactions, m.reply_to_id, m.tmp_id, m.delivery_status, m.status_error, m.status_at`
+	var stmt string
+	if len(positive) > 0 {
+		conditions = append([]string{"messages_fts MATCH ?"}, conditions...)
+		args = append([]any{SnippetOpen, SnippetClose, ftsExpr(positive, negative)}, args...)
+		stmt = `SELECT ` + columns + `,
+				snippet(messages_fts, 0, ?, ?, '…', 16), bm25(messages_fts)
+			FROM messages_fts
+			JOIN messages m ON m.rowid = messages_fts.rowid
+			LEFT JOIN conversations c ON c.conversation_id = m.conversation_id
+			WHERE ` + strings.Join(conditions, " AND ") + `
+			ORDER BY bm25(messages_fts), m.timestamp_ms DESC
+			LIMIT ?`
+	} else {
+		if len(negative) > 0 {
+			conditions = append(conditions, "m.rowid NOT IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)")
+			args = append(args, strings.Join(negative, " OR "))
+		}
+		stmt = `SELECT ` + columns + `, '', 0
+			FROM messages m
+			LEFT JOIN conversations c ON c.conversation_id = m.conversation_id
+			WHERE ` + strings.Join(conditions, " AND ") + `
+			ORDER BY m.timestamp_ms DESC
+			LIMIT ?`
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	for rows.Next() {
+		r := &SearchResult{}
+		m := &r.Message
+		if err := rows.Scan(&m.MessageID, &m.ConversationID, &m.SenderName, &m.SenderNumber, &m.Body, &m.TimestampMS, &m.Status, &m.IsFromMe, &m.MediaID, &m.MimeType, &m.DecryptionKey, &m.Reactions, &m.ReplyToID, &m.TmpID, &m.DeliveryStatus, &m.StatusError, &m.StatusAt, &r.Snippet, &r.Rank); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// ftsExpr builds an FTS5 MATCH expression from the terms ftsTerms
+// returns. positive must not be empty.
+func ftsExpr(positive, negative []string) string {
+	expr := "(" + strings.Join(positive, " ") + ")"
+	for _, n := range negative {
+		expr += " NOT " + n
+	}
+	return expr
+}
+
+// ftsTerms splits free text into quoted FTS5 terms to match (with OR
+// between them where the query asked for it) and terms to exclude. Every
+// term is a quoted FTS5 string, so punctuation in the query ("$100",
+// "50%", "don't") can never be parsed as FTS5 syntax.
+func ftsTerms(query string) (positive, negative []string) {
+	negateNext, orNext := false, false
+
+	add := func(term string) {
+		if negateNext {
+			negative = append(negative, term)
+		} else {
+			if orNext && len(positive) > 0 {
+				positive = append(positive, "OR")
+			}
+			positive = append(positive, term)
+		}
+		negateNext, orNext = false, false
+	}
+
+	for _, tok := range splitSearchQuery(query) {
+		var term string
+		switch {
+		case tok.phrase:
+			if !hasSearchableRune(tok.text) {
+				continue
+			}
+			term = quoteFTS(tok.text)
+		case tok.text == "NOT":
+			negateNext = true
+			continue
+		case tok.text == "OR":
+			orNext = true
+			continue
+		case tok.text == "AND":
+			continue
+		default:
+			word, prefix := strings.CutSuffix(tok.text, "*")
+			if !hasSearchableRune(word) {
+				continue
+			}
+			term = quoteFTS(word)
+			if prefix {
+				term += "*"
+			}
+		}
+		if tok.negated {
+			negateNext = true
+		}
+		add(term)
+	}
+	return positive, negative
+}
+
+type searchToken struct {
+	text    string
+	phrase  bool
+	negated bool
+}
+
+// splitSearchQuery breaks a query into whitespace-separated words and
+// double-quoted phrases. A leading '-' negates the word or phrase it's on.
+// An unterminated quote runs to the end of the query.
+func splitSearchQuery(query string) []searchToken {
+	var toks []searchToken
+	rs := []rune(query)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+		negated := false
+		if rs[i] == '-' && i+1 < len(rs) && !unicode.IsSpace(rs[i+1]) {
+			negated = true
+			i++
+		}
+		if rs[i] == '"' {
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			toks = append(toks, searchToken{text: strings.TrimSpace(string(rs[i+1 : j])), phrase: true, negated: negated})
+			i = j + 1
+			continue
+		}
+		j := i
+		for j < len(rs) && !unicode.IsSpace(rs[j]) {
+			j++
+		}
+		toks = append(toks, searchToken{text: string(rs[i:j]), negated: negated})
+		i = j
+	}
+	return toks
+}
+
+func quoteFTS(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// hasSearchableRune reports whether s contains anything the FTS tokenizer
+// indexes (letters, digits, symbols such as emoji). Bare punctuation would
+// otherwise turn into an empty phrase that silently matches nothing.
+func hasSearchableRune(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.So, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func seedSearch(t *testing.T, store *Store) {
+	t.Helper()
+	msgs := []Message{
+		{MessageID: "d1", ConversationID: "c1", Body: "Are you free for dinner tonight?", TimestampMS: 1000},
+		{MessageID: "d2", ConversationID: "c1", Body: "Tonight I have dinner plans with work people", TimestampMS: 2000},
+		{MessageID: "d3", ConversationID: "c2", Body: "Dinner dinner dinner", TimestampMS: 3000},
+		{MessageID: "d4", ConversationID: "c2", Body: "Let's get sushi", TimestampMS: 4000},
+		{MessageID: "d5", ConversationID: "c3", Body: "Thai food at the café?", TimestampMS: 5000},
+		{MessageID: "d6", ConversationID: "c3", Body: "Dinosaurs at the museum", TimestampMS: 6000},
+	}
+	for i := range msgs {
+		if err := store.UpsertMessage(&msgs[i]); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+}
+
+func resultIDs(results []*SearchResult) []string {
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.MessageID)
+	}
+	return ids
+}
+
+func sameIDs(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, id := range got {
+		seen[id] = true
+	}
+	for _, id := range want {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearchMessages_Operators(t *testing.T) {
+	store := newTestStore(t)
+	seedSearch(t, store)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"implicit AND", "dinner tonight", []string{"d1", "d2"}},
+		{"exact phrase", `"dinner tonight"`, []string{"d1"}},
+		{"prefix", "din*", []string{"d1", "d2", "d3", "d6"}},
+		{"exclude with dash", "dinner -work", []string{"d1", "d3"}},
+		{"exclude with NOT", "dinner NOT work", []string{"d1", "d3"}},
+		{"exclude phrase", `dinner -"dinner tonight"`, []string{"d2", "d3"}},
+		{"OR", "sushi OR thai", []string{"d4", "d5"}},
+		{"diacritics folded", "cafe", []string{"d5"}},
+		{"apostrophe", "let's", []string{"d4"}},
+		{"unterminated quote", `"dinner tonight`, []string{"d1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.SearchMessages(tt.query, "", 100)
+			if err != nil {
+				t.Fatalf("search %q: %v", tt.query, err)
+			}
+			if ids := resultIDs(got); !sameIDs(ids, tt.want...) {
+				t.Errorf("search %q: got %v, want %v", tt.query, ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMessages_NoPositiveTerms(t *testing.T) {
+	store := newTestStore(t)
+	seedSearch(t, store)
+
+	for _, q := range []string{"-dinner", "NOT dinner", "OR", "?!", `""`} {
+		_, err := store.SearchMessages(q, "", 10)
+		if !errors.Is(err, ErrNoSearchTerms) {
+			t.Errorf("search %q: got err %v, want ErrNoSearchTerms", q, err)
+		}
+	}
+}
+
+func TestSearchMessages_RankedBestFirst(t *testing.T) {
+	store := newTestStore(t)
+	seedSearch(t, store)
+
+	got, err := store.SearchMessages("dinner", "", 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("count: got %d, want 3", len(got))
+	}
+	// "Dinner dinner dinner" is the densest match.
+	if got[0].MessageID != "d3" {
+		t.Errorf("top result: got %s, want d3", got[0].MessageID)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Rank < got[i-1].Rank {
+			t.Errorf("results not ordered by rank: %v then %v", got[i-1].Rank, got[i].Rank)
+		}
+	}
+}
+
+func TestSearchMessages_Snippet(t *testing.T) {
+	store := newTestStore(t)
+	seedSearch(t, store)
+
+	got, err := store.SearchMessages(`"dinner tonight"`, "", 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("count: got %d, want 1", len(got))
+	}
+	want := "Are you free for " + SnippetOpen + "dinner tonight" + SnippetClose + "?"
+	if got[0].Snippet != want {
+		t.Errorf("snippet: got %q, want %q", got[0].Snippet, want)
+	}
+	if got[0].Body != "Are you free for dinner tonight?" {
+		t.Errorf("body should be unmodified, got %q", got[0].Body)
+	}
+}
+
+func TestSearchMessages_IndexFollowsUpdatesAndDeletes(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "original wording", TimestampMS: 1000})
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "edited wording", TimestampMS: 1000})
+
+	if got, _ := store.SearchMessages("original", "", 10); len(got) != 0 {
+		t.Errorf("stale body still indexed: %v", resultIDs(got))
+	}
+	if got, _ := store.SearchMessages("edited", "", 10); len(got) != 1 {
+		t.Errorf("updated body not indexed: got %d results", len(got))
+	}
+
+	// Reconciling an echo backfill already stored deletes the placeholder,
+	// which must drop it from the index.
+	store.AddPendingMessage(&Message{MessageID: "tmp_1", ConversationID: "c1", Body: "pending wording", TimestampMS: 2000, TmpID: "tmp_1", IsFromMe: true})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", Body: "delivered wording", TimestampMS: 2000, IsFromMe: true})
+	if _, err := store.ReconcileMessage(&Message{MessageID: "m2", ConversationID: "c1", Body: "delivered wording", TimestampMS: 2000, TmpID: "tmp_1", IsFromMe: true}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got, _ := store.SearchMessages("pending", "", 10); len(got) != 0 {
+		t.Errorf("deleted message still indexed: %v", resultIDs(got))
+	}
+}
+
+func TestFTSMatchQuotesTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"hello", `("hello")`},
+		{"hello world", `("hello" "world")`},
+		{`"hello world"`, `("hello world")`},
+		{"hel*", `("hel"*)`},
+		{"a OR b", `("a" OR "b")`},
+		{"a -b NOT c", `("a") NOT "b" NOT "c"`},
+		{`say "hi"there`, `("say" "hi" "there")`},
+		{`5"`, `("5""")`},
+	}
+	for _, tt := range tests {
+		positive, negative := ftsTerms(tt.query)
+		if len(positive) == 0 {
+			t.Errorf("ftsTerms(%q): no terms to match", tt.query)
+			continue
+		}
+		got := ftsExpr(positive, negative)
+		if got != tt.want {
+			t.Errorf("ftsExpr(ftsTerms(%q)) = %s, want %s", tt.query, got, tt.want)
+		}
+		if strings.Count(got, `"`)%2 != 0 {
+			t.Errorf("ftsExpr(ftsTerms(%q)) produced unbalanced quotes: %s", tt.query, got)
+		}
+	}
+	for _, query := range []string{"", "   ", "-alone", `"!!"`} {
+		if positive, _ := ftsTerms(query); len(positive) != 0 {
+			t.Errorf("ftsTerms(%q) = %v, want no terms to match", query, positive)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package db
+
+import "strings"
+
+// Delivery statuses: Message.Status, Google's raw status, boiled down to
+// what the sender cares about. Incoming messages are delivered or read;
+// tombstones and unknown statuses have none.
+const (
+	DeliveryPending   = "pending"
+	DeliverySent      = "sent"
+	DeliveryDelivered = "delivered"
+	DeliveryRead      = "read"
+	DeliveryFailed    = "failed"
+)
+
+// StatusChange is one entry in a sent message's status history. At is when
+// the status was seen (ms), which for a message first fetched by backfill
+// can be long after it happened.
+type StatusChange struct {
+	MessageID string
+	Status    string
+	RawStatus string
+	Error     string `json:",omitempty"`
+	At        int64
+}
+
+// DeliveryStatusOf maps a raw Google Messages status, such as
+// OUTGOING_DELIVERED, to a delivery status, or "" if it has none.
+func DeliveryStatusOf(raw string) string {
+	switch {
+	case strings.HasPrefix(raw, "OUTGOING_FAILED"), raw == "OUTGOING_CANCELED":
+		return DeliveryFailed
+	case raw == "OUTGOING_DISPLAYED", raw == "INCOMING_DISPLAYED":
+		return DeliveryRead
+	case raw == "OUTGOING_DELIVERED", strings.HasPrefix(raw, "INCOMING_"):
+		return DeliveryDelivered
+	case raw == "OUTGOING_COMPLETE", raw == "OUTGOING_REVOCATION_PENDING":
+		return DeliverySent
+	case strings.HasPrefix(raw, "OUTGOING_"):
+		// Sending, waiting to retry, not delivered yet, scheduled...
+		return DeliveryPending
+	default:
+		return ""
+	}
+}
+
+var deliveryRank = map[string]int{
+	DeliveryPending:   1,
+	DeliverySent:      2,
+	DeliveryDelivered: 3,
+	DeliveryRead:      4,
+}
+
+// statusAdvances reports whether a message at delivery status prev should
+// move to next. Statuses only move forward, so a late "sent" never hides a
+// "read" that arrived first; a failure ends anything short of delivery, and
+// a failed message can be retried.
+func statusAdvances(prev, next string) bool {
+	switch {
+	case next == "":
+		// An unknown status never hides a known one.
+		return prev == ""
+	case prev == "", prev == DeliveryFailed:
+		return true
+	case next == DeliveryFailed:
+		return deliveryRank[prev] < deliveryRank[DeliveryDelivered]
+	default:
+		return deliveryRank[next] >= deliveryRank[prev]
+	}
+}
+
+// GetStatusHistory returns a message's status changes, oldest first.
+func (s *Store) GetStatusHistory(messageID string) ([]*StatusChange, error) {
+	rows, err := s.db.Query(`
+		SELECT message_id, status, raw_status, error, at
+		FROM message_status_history
+		WHERE message_id = ?
+		ORDER BY at, id
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*StatusChange
+	for rows.Next() {
+		c := &StatusChange{}
+		if err := rows.Scan(&c.MessageID, &c.Status, &c.RawStatus, &c.Error, &c.At); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
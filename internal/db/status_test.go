@@ -0,0 +1,120 @@
+package db
+
+import "testing"
+
+func TestDeliveryStatusOf(t *testing.T) {
+	for raw, want := range map[string]string{
+		"OUTGOING_SENDING":                   DeliveryPending,
+		"OUTGOING_AWAITING_RETRY":            DeliveryPending,
+		"OUTGOING_NOT_DELIVERED_YET":         DeliveryPending,
+		"OUTGOING_COMPLETE":                  DeliverySent,
+		"OUTGOING_DELIVERED":                 DeliveryDelivered,
+		"OUTGOING_DISPLAYED":                 DeliveryRead,
+		"OUTGOING_FAILED_GENERIC":            DeliveryFailed,
+		"OUTGOING_FAILED_RECIPIENT_LOST_RCS": DeliveryFailed,
+		"OUTGOING_CANCELED":                  DeliveryFailed,
+		"INCOMING_COMPLETE":                  DeliveryDelivered,
+		"INCOMING_DISPLAYED":                 DeliveryRead,
+		"TOMBSTONE_PARTICIPANT_JOINED":       "",
+		"unknown":                            "",
+	} {
+		if got := DeliveryStatusOf(raw); got != want {
+			t.Errorf("%s: got %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestUpsertMessageTracksDeliveryStatus(t *testing.T) {
+	store := newTestStore(t)
+	send := func(raw, errMsg string) *Message {
+		t.Helper()
+		m := &Message{MessageID: "m1", ConversationID: "c1", Body: "hi", IsFromMe: true, Status: raw, StatusError: errMsg}
+		if err := store.UpsertMessage(m); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := store.GetMessageByID("m1")
+		return got
+	}
+
+	if m := send("OUTGOING_COMPLETE", ""); m.DeliveryStatus != DeliverySent || m.StatusAt == 0 {
+		t.Errorf("sent: %+v", m)
+	}
+	send("OUTGOING_DISPLAYED", "")
+	// A late delivery report doesn't undo the read receipt, nor does an
+	// update with no status at all.
+	if m := send("OUTGOING_DELIVERED", ""); m.DeliveryStatus != DeliveryRead || m.Status != "OUTGOING_DISPLAYED" {
+		t.Errorf("after late delivered: %s (%s)", m.DeliveryStatus, m.Status)
+	}
+	if m := send("unknown", ""); m.DeliveryStatus != DeliveryRead {
+		t.Errorf("after unknown: %s", m.DeliveryStatus)
+	}
+
+	history, err := store.GetStatusHistory("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range history {
+		got = append(got, c.Status)
+	}
+	if len(got) != 2 || got[0] != DeliverySent || got[1] != DeliveryRead {
+		t.Errorf("history: got %v, want [sent read]", got)
+	}
+
+	// Incoming messages get a status but no history.
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", Status: "INCOMING_DISPLAYED"})
+	if m, _ := store.GetMessageByID("m2"); m.DeliveryStatus != DeliveryRead {
+		t.Errorf("incoming: %s", m.DeliveryStatus)
+	}
+	if h, _ := store.GetStatusHistory("m2"); len(h) != 0 {
+		t.Errorf("incoming history: %v", h)
+	}
+}
+
+func TestUpsertMessageRecordsFailure(t *testing.T) {
+	store := newTestStore(t)
+	upsert := func(raw, errMsg string) {
+		t.Helper()
+		if err := store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", IsFromMe: true, Status: raw, StatusError: errMsg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upsert("OUTGOING_SENDING", "")
+	upsert("OUTGOING_FAILED_GENERIC", "no signal")
+	m, _ := store.GetMessageByID("m1")
+	if m.DeliveryStatus != DeliveryFailed || m.StatusError != "no signal" {
+		t.Errorf("failed: %+v", m)
+	}
+	// A resend clears the failure.
+	upsert("OUTGOING_RESENDING", "")
+	upsert("OUTGOING_COMPLETE", "")
+	m, _ = store.GetMessageByID("m1")
+	if m.DeliveryStatus != DeliverySent || m.StatusError != "" {
+		t.Errorf("after resend: %+v", m)
+	}
+	if h, _ := store.GetStatusHistory("m1"); len(h) != 4 || h[1].Error != "no signal" {
+		t.Errorf("history: %d entries", len(h))
+	}
+}
+
+func TestReconcileMessageKeepsStatusHistory(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.AddPendingMessage(&Message{MessageID: "tmp_1", TmpID: "tmp_1", ConversationID: "c1", IsFromMe: true, Status: "OUTGOING_SENDING"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReconcileMessage(&Message{MessageID: "real-1", TmpID: "tmp_1", ConversationID: "c1", IsFromMe: true, Status: "OUTGOING_DELIVERED"}); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := store.GetStatusHistory("real-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h) != 2 || h[0].Status != DeliveryPending || h[1].Status != DeliveryDelivered {
+		t.Errorf("history: %+v", h)
+	}
+	if h, _ := store.GetStatusHistory("tmp_1"); len(h) != 0 {
+		t.Errorf("placeholder history left behind: %+v", h)
+	}
+}
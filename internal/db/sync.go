@@ -0,0 +1,35 @@
+package db
+
+// PutSyncState records a conversation's backfill checkpoint.
+func (s *Store) PutSyncState(st *SyncState) error {
+	_, err := s.db.Exec(`
+		INSERT INTO sync_state (conversation_id, cursor_item_id, cursor_ts, oldest_ts, newest_ts, complete, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(conversation_id) DO UPDATE SET
+			cursor_item_id=excluded.cursor_item_id,
+			cursor_ts=excluded.cursor_ts,
+			oldest_ts=excluded.oldest_ts,
+			newest_ts=excluded.newest_ts,
+			complete=excluded.complete,
+			updated_at=excluded.updated_at
+	`, st.ConversationID, st.CursorItemID, st.CursorTS, st.OldestTS, st.NewestTS, st.Complete, st.UpdatedAt)
+	return err
+}
+
+// GetSyncState returns a conversation's checkpoint, or nil if it has never
+// been backfilled.
+func (s *Store) GetSyncState(conversationID string) (*SyncState, error) {
+	row := s.db.QueryRow(`
+		SELECT conversation_id, cursor_item_id, cursor_ts, oldest_ts, newest_ts, complete, updated_at
+		FROM sync_state WHERE conversation_id = ?
+	`, conversationID)
+	st := &SyncState{}
+	err := row.Scan(&st.ConversationID, &st.CursorItemID, &st.CursorTS, &st.OldestTS, &st.NewestTS, &st.Complete, &st.UpdatedAt)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return st, nil
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+)
+
+const webhookColumns = `id, url, secret, conversation_id, sender, keyword, enabled, created_at, updated_at`
+
+func scanWebhook(row interface{ Scan(...any) error }) (*Webhook, error) {
+	w := &Webhook{}
+	err := row.Scan(&w.ID, &w.URL, &w.Secret, &w.ConversationID, &w.Sender, &w.Keyword, &w.Enabled, &w.CreatedAt, &w.UpdatedAt)
+	return w, err
+}
+
+// AddWebhook saves w and sets its ID.
+func (s *Store) AddWebhook(w *Webhook) error {
+	result, err := s.db.Exec(`
+		INSERT INTO webhooks (url, secret, conversation_id, sender, keyword, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, w.URL, w.Secret, w.ConversationID, w.Sender, w.Keyword, w.Enabled, w.CreatedAt, w.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	w.ID, err = result.LastInsertId()
+	return err
+}
+
+// UpdateWebhook saves w's URL, secret, filters and whether it is enabled.
+func (s *Store) UpdateWebhook(w *Webhook) error {
+	_, err := s.db.Exec(`
+		UPDATE webhooks SET
+			url = ?, secret = ?, conversation_id = ?, sender = ?, keyword = ?,
+			enabled = ?, updated_at = ?
+		WHERE id = ?
+	`, w.URL, w.Secret, w.ConversationID, w.Sender, w.Keyword, w.Enabled, w.UpdatedAt, w.ID)
+	return err
+}
+
+// GetWebhook returns the webhook with id, or nil if there is none.
+func (s *Store) GetWebhook(id int64) (*Webhook, error) {
+	w, err := scanWebhook(s.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return w, nil
+}
+
+// ListWebhooks returns every webhook, oldest first.
+func (s *Store) ListWebhooks() ([]*Webhook, error) {
+	rows, err := s.db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []*Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook and its delivery log. It reports false if
+// there was no such webhook.
+func (s *Store) DeleteWebhook(id int64) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Matches reports whether m passes w's filters.
+func (w *Webhook) Matches(m *Message) bool {
+	if w.ConversationID != "" && w.ConversationID != m.ConversationID {
+		return false
+	}
+	if w.Sender != "" && !senderMatches(w.Sender, m) {
+		return false
+	}
+	if w.Keyword != "" && !strings.Contains(strings.ToLower(m.Body), strings.ToLower(w.Keyword)) {
+		return false
+	}
+	return true
+}
+
+// senderMatches compares a phone number by its digits, allowing for a
+// country code on either side, and anything else to the sender's name.
+func senderMatches(sender string, m *Message) bool {
+	if phoneDigits(sender) != "" {
+		return SamePhone(sender, m.SenderNumber)
+	}
+	return strings.EqualFold(strings.TrimSpace(sender), m.SenderName)
+}
+
+const webhookDeliveryColumns = `id, webhook_id, message_id, payload, state, attempts, next_attempt_at, status_code, last_error, created_at, updated_at`
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	err := row.Scan(&d.ID, &d.WebhookID, &d.MessageID, &d.Payload, &d.State, &d.Attempts, &d.NextAttemptAt, &d.StatusCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt)
+	return d, err
+}
+
+// AddWebhookDelivery queues d and sets its ID.
+func (s *Store) AddWebhookDelivery(d *WebhookDelivery) error {
+	result, err := s.db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, message_id, payload, state, attempts, next_attempt_at, status_code, last_error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.WebhookID, d.MessageID, d.Payload, d.State, d.Attempts, d.NextAttemptAt, d.StatusCode, d.LastError, d.CreatedAt, d.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	d.ID, err = result.LastInsertId()
+	return err
+}
+
+// UpdateWebhookDelivery saves d's state, attempts and last response.
+func (s *Store) UpdateWebhookDelivery(d *WebhookDelivery) error {
+	_, err := s.db.Exec(`
+		UPDATE webhook_deliveries SET
+			state = ?, attempts = ?, next_attempt_at = ?, status_code = ?,
+			last_error = ?, updated_at = ?
+		WHERE id = ?
+	`, d.State, d.Attempts, d.NextAttemptAt, d.StatusCode, d.LastError, d.UpdatedAt, d.ID)
+	return err
+}
+
+// GetWebhookDelivery returns the delivery with id, or nil if there is none.
+func (s *Store) GetWebhookDelivery(id int64) (*WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(s.db.QueryRow(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListWebhookDeliveries returns a webhook's deliveries in the given state,
+// or in any state if state is empty, newest first.
+func (s *Store) ListWebhookDeliveries(webhookID int64, state string, limit int) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(`
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = ? AND (? = '' OR state = ?)
+		ORDER BY id DESC
+		LIMIT ?
+	`, webhookID, state, state, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// NextWebhookDelivery returns the oldest queued delivery due by nowMS, or
+// nil.
+func (s *Store) NextWebhookDelivery(nowMS int64) (*WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(s.db.QueryRow(`
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE state = 'queued' AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT 1
+	`, nowMS))
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// NextWebhookAttempt returns when NextWebhookDelivery will next have
+// something (ms). It reports false if nothing is queued.
+func (s *Store) NextWebhookAttempt() (int64, bool, error) {
+	var next sql.NullInt64
+	err := s.db.QueryRow(`SELECT MIN(next_attempt_at) FROM webhook_deliveries WHERE state = 'queued'`).Scan(&next)
+	return next.Int64, next.Valid, err
+}
+
+// RequeueInterruptedWebhookDeliveries queues deliveries left delivering by
+// a previous run to be tried again. Receivers may see them twice, which
+// the delivery ID header lets them detect. It returns how many.
+func (s *Store) RequeueInterruptedWebhookDeliveries(nowMS int64) (int64, error) {
+	result, err := s.db.Exec(`
+		UPDATE webhook_deliveries SET state = 'queued', next_attempt_at = ?, updated_at = ?
+		WHERE state = 'delivering'
+	`, nowMS, nowMS)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
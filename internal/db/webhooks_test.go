@@ -0,0 +1,86 @@
+package db
+
+import "testing"
+
+func TestWebhookMatches(t *testing.T) {
+	m := &Message{ConversationID: "c1", SenderName: "Alice", SenderNumber: "+1 (555) 123-4567", Body: "Your code is 1234"}
+	cases := []struct {
+		hook Webhook
+		want bool
+	}{
+		{Webhook{}, true},
+		{Webhook{ConversationID: "c1"}, true},
+		{Webhook{ConversationID: "c2"}, false},
+		{Webhook{Sender: "555-123-4567"}, true},
+		{Webhook{Sender: "+15551234567"}, true},
+		{Webhook{Sender: "555-000-4567"}, false},
+		{Webhook{Sender: "alice"}, true},
+		{Webhook{Sender: "Bob"}, false},
+		{Webhook{Keyword: "CODE"}, true},
+		{Webhook{Keyword: "password"}, false},
+		{Webhook{ConversationID: "c1", Sender: "Alice", Keyword: "code"}, true},
+		{Webhook{ConversationID: "c1", Sender: "Alice", Keyword: "password"}, false},
+	}
+	for _, c := range cases {
+		if got := c.hook.Matches(m); got != c.want {
+			t.Errorf("%+v matches: got %v, want %v", c.hook, got, c.want)
+		}
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	store := newTestStore(t)
+	hook := &Webhook{URL: "http://localhost/hook", Secret: "s", Enabled: true, CreatedAt: 1, UpdatedAt: 1}
+	if err := store.AddWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+	hook.Keyword = "code"
+	hook.Enabled = false
+	if err := store.UpdateWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetWebhook(hook.ID); got == nil || got.Keyword != "code" || got.Enabled {
+		t.Errorf("after update: %+v", got)
+	}
+
+	for i, at := range []int64{200, 100} {
+		d := &WebhookDelivery{WebhookID: hook.ID, MessageID: "m", Payload: "{}", State: WebhookQueued, NextAttemptAt: at, CreatedAt: int64(i), UpdatedAt: int64(i)}
+		if err := store.AddWebhookDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next, ok, _ := store.NextWebhookAttempt(); !ok || next != 100 {
+		t.Errorf("next attempt: %d, %v; want 100", next, ok)
+	}
+	if d, _ := store.NextWebhookDelivery(99); d != nil {
+		t.Errorf("nothing is due at 99, got #%d", d.ID)
+	}
+	d, err := store.NextWebhookDelivery(100)
+	if err != nil || d == nil || d.NextAttemptAt != 100 {
+		t.Fatalf("due at 100: %+v, %v", d, err)
+	}
+
+	// Left delivering by a crash: tried again.
+	d.State = WebhookDelivering
+	store.UpdateWebhookDelivery(d)
+	if n, _ := store.RequeueInterruptedWebhookDeliveries(300); n != 1 {
+		t.Errorf("requeued %d, want 1", n)
+	}
+	if got, _ := store.GetWebhookDelivery(d.ID); got.State != WebhookQueued || got.NextAttemptAt != 300 {
+		t.Errorf("after requeue: %+v", got)
+	}
+	if log, _ := store.ListWebhookDeliveries(hook.ID, "", 10); len(log) != 2 || log[0].ID < log[1].ID {
+		t.Errorf("log should list both, newest first: %+v", log)
+	}
+
+	// Deleting the webhook takes its log with it.
+	if ok, err := store.DeleteWebhook(hook.ID); !ok || err != nil {
+		t.Fatalf("delete: %v, %v", ok, err)
+	}
+	if got, _ := store.GetWebhookDelivery(d.ID); got != nil {
+		t.Error("delivery outlived its webhook")
+	}
+	if ok, _ := store.DeleteWebhook(hook.ID); ok {
+		t.Error("deleted a missing webhook")
+	}
+}
@@ -0,0 +1,249 @@
+// Package media keeps downloaded attachments on disk so they can be served
+// again without going back to Google, including while offline.
+//
+// Blobs are stored by the SHA-256 of their decrypted content; the media
+// table in the database maps Google media IDs to blobs and tracks size, MIME
+// type and last use. When the cache grows past its cap, the least recently
+// used blobs are deleted.
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// DefaultMaxBytes is the cache cap used when none is configured.
+const DefaultMaxBytes = 1 << 30
+
+// ErrNotCached is returned by Fetch when the media isn't cached and there's
+// no way to download it.
+var ErrNotCached = errors.New("media not cached and not connected to Google Messages")
+
+// Downloader fetches and decrypts an attachment from Google, e.g.
+// client.GM.DownloadMedia.
+type Downloader func(mediaID string, key []byte) ([]byte, error)
+
+// Cache is safe for concurrent use.
+type Cache struct {
+	store    *db.Store
+	dir      string
+	maxBytes int64
+	logger   zerolog.Logger
+
+	// mu serialises writes and eviction so usage accounting stays exact.
+	mu sync.Mutex
+	// now is swapped out in tests.
+	now func() time.Time
+}
+
+// New opens (creating if needed) a cache rooted at dir holding at most
+// maxBytes of attachments. maxBytes <= 0 means DefaultMaxBytes.
+func New(store *db.Store, dir string, maxBytes int64, logger zerolog.Logger) (*Cache, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create media dir: %w", err)
+	}
+	return &Cache{
+		store:    store,
+		dir:      dir,
+		maxBytes: maxBytes,
+		logger:   logger,
+		now:      time.Now,
+	}, nil
+}
+
+// Get returns the cached content and MIME type of a media ID. ok is false on
+// a miss. A hit counts as a use for eviction purposes.
+func (c *Cache) Get(mediaID string) (data []byte, mimeType string, ok bool, err error) {
+	entry, err := c.store.GetMedia(mediaID)
+	if err != nil || entry == nil {
+		return nil, "", false, err
+	}
+	data, err = os.ReadFile(c.blobPath(entry.SHA256))
+	if os.IsNotExist(err) {
+		// Deleted behind our back; forget it and let the caller re-download.
+		c.logger.Warn().Str("media_id", mediaID).Msg("Cached media file missing")
+		return nil, "", false, c.store.DeleteMedia(mediaID)
+	}
+	if err != nil {
+		return nil, "", false, fmt.Errorf("read cached media: %w", err)
+	}
+	if err := c.store.TouchMedia(mediaID, c.now().UnixMilli()); err != nil {
+		c.logger.Warn().Err(err).Str("media_id", mediaID).Msg("Failed to update media access time")
+	}
+	return data, entry.MimeType, true, nil
+}
+
+// Has reports whether a media ID is cached, without counting as a use.
+func (c *Cache) Has(mediaID string) bool {
+	entry, err := c.store.GetMedia(mediaID)
+	return err == nil && entry != nil
+}
+
+// Put stores content for a media ID and evicts old blobs if the cache is now
+// over its cap. Content larger than the whole cache is not stored.
+func (c *Cache) Put(mediaID, mimeType string, data []byte) error {
+	return c.put(mediaID, mimeType, data, c.now().UnixMilli())
+}
+
+// put stores content with an explicit last-use time. Prefetch passes the
+// message's own timestamp so that old attachments pulled in by backfill are
+// evicted before anything the user actually opened.
+func (c *Cache) put(mediaID, mimeType string, data []byte, usedAtMS int64) error {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.writeBlob(hash, data); err != nil {
+		return err
+	}
+	if err := c.store.PutMedia(&db.Media{
+		MediaID:        mediaID,
+		SHA256:         hash,
+		MimeType:       mimeType,
+		Size:           size,
+		CreatedAt:      c.now().UnixMilli(),
+		LastAccessedAt: usedAtMS,
+	}); err != nil {
+		return fmt.Errorf("record media: %w", err)
+	}
+	return c.evict()
+}
+
+// Fetch returns the content of a message's attachment, from the cache when
+// possible and otherwise through download, caching the result. A nil Cache
+// always downloads. download may be nil when disconnected, in which case
+// only cached media can be returned.
+func (c *Cache) Fetch(msg *db.Message, download Downloader) ([]byte, error) {
+	if c != nil {
+		data, _, ok, err := c.Get(msg.MediaID)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("media_id", msg.MediaID).Msg("Media cache read failed")
+		}
+		if ok {
+			return data, nil
+		}
+	}
+	if download == nil {
+		return nil, ErrNotCached
+	}
+	key, err := hex.DecodeString(msg.DecryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid decryption key: %w", err)
+	}
+	data, err := download(msg.MediaID, key)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		if err := c.Put(msg.MediaID, msg.MimeType, data); err != nil {
+			c.logger.Warn().Err(err).Str("media_id", msg.MediaID).Msg("Failed to cache media")
+		}
+	}
+	return data, nil
+}
+
+// Prefetch downloads a message's attachment into the cache unless it's
+// already there.
+func (c *Cache) Prefetch(msg *db.Message, download Downloader) error {
+	if msg.MediaID == "" || c.Has(msg.MediaID) {
+		return nil
+	}
+	key, err := hex.DecodeString(msg.DecryptionKey)
+	if err != nil {
+		return fmt.Errorf("invalid decryption key: %w", err)
+	}
+	data, err := download(msg.MediaID, key)
+	if err != nil {
+		return err
+	}
+	return c.put(msg.MediaID, msg.MimeType, data, msg.TimestampMS)
+}
+
+// Usage returns the bytes currently held by the cache.
+func (c *Cache) Usage() (int64, error) {
+	return c.store.MediaUsage()
+}
+
+// evict deletes least recently used blobs until the cache fits its cap.
+// Callers hold c.mu.
+func (c *Cache) evict() error {
+	usage, err := c.store.MediaUsage()
+	if err != nil {
+		return fmt.Errorf("media usage: %w", err)
+	}
+	for usage > c.maxBytes {
+		blobs, err := c.store.LeastRecentlyUsedMedia(16)
+		if err != nil {
+			return fmt.Errorf("list media: %w", err)
+		}
+		if len(blobs) == 0 {
+			return nil
+		}
+		for _, b := range blobs {
+			if usage <= c.maxBytes {
+				break
+			}
+			if err := c.store.DeleteMediaBlob(b.SHA256); err != nil {
+				return fmt.Errorf("forget media: %w", err)
+			}
+			if err := os.Remove(c.blobPath(b.SHA256)); err != nil && !os.IsNotExist(err) {
+				c.logger.Warn().Err(err).Str("sha256", b.SHA256).Msg("Failed to delete evicted media")
+			}
+			usage -= b.Size
+			c.logger.Debug().Str("sha256", b.SHA256).Int64("size", b.Size).Msg("Evicted cached media")
+		}
+	}
+	return nil
+}
+
+// blobPath shards blobs into 256 subdirectories by the first hash byte.
+func (c *Cache) blobPath(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+// writeBlob writes content to its hash-named file. Identical content is
+// already on disk under the same name, so an existing file is kept as is.
+func (c *Cache) writeBlob(hash string, data []byte) error {
+	path := c.blobPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create media dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write media: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write media: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write media: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write media: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func newTestCache(t *testing.T, maxBytes int64) *Cache {
+	t.Helper()
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	c, err := New(store, filepath.Join(t.TempDir(), "media"), maxBytes, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A clock that ticks one second per call keeps access order unambiguous.
+	clock := time.Unix(1_700_000_000, 0)
+	c.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	return c
+}
+
+// fakeDownloader serves fixed content per media ID and counts calls.
+type fakeDownloader struct {
+	content map[string][]byte
+	calls   int
+}
+
+func (f *fakeDownloader) download(mediaID string, key []byte) ([]byte, error) {
+	f.calls++
+	data, ok := f.content[mediaID]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func mediaMessage(mediaID string) *db.Message {
+	return &db.Message{MessageID: "msg-" + mediaID, MediaID: mediaID, MimeType: "image/png", DecryptionKey: hex.EncodeToString([]byte("key"))}
+}
+
+func TestFetchCachesDownloads(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	dl := &fakeDownloader{content: map[string][]byte{"m1": []byte("png bytes")}}
+
+	for i := 0; i < 3; i++ {
+		data, err := c.Fetch(mediaMessage("m1"), dl.download)
+		if err != nil {
+			t.Fatalf("fetch #%d: %v", i+1, err)
+		}
+		if string(data) != "png bytes" {
+			t.Fatalf("fetch #%d: got %q", i+1, data)
+		}
+	}
+	if dl.calls != 1 {
+		t.Errorf("downloaded %d times, want 1", dl.calls)
+	}
+
+	data, mime, ok, err := c.Get("m1")
+	if err != nil || !ok {
+		t.Fatalf("get: ok=%v err=%v", ok, err)
+	}
+	if string(data) != "png bytes" || mime != "image/png" {
+		t.Errorf("get: got %q %q", data, mime)
+	}
+}
+
+func TestFetchOfflineServesOnlyCached(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	if err := c.Put("m1", "image/png", []byte("cached")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.Fetch(mediaMessage("m1"), nil)
+	if err != nil || string(data) != "cached" {
+		t.Fatalf("cached fetch: %q, %v", data, err)
+	}
+	if _, err := c.Fetch(mediaMessage("m2"), nil); !errors.Is(err, ErrNotCached) {
+		t.Errorf("uncached fetch: got %v, want ErrNotCached", err)
+	}
+}
+
+func TestNilCacheAlwaysDownloads(t *testing.T) {
+	var c *Cache
+	dl := &fakeDownloader{content: map[string][]byte{"m1": []byte("x")}}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Fetch(mediaMessage("m1"), dl.download); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dl.calls != 2 {
+		t.Errorf("downloaded %d times, want 2", dl.calls)
+	}
+}
+
+func TestIdenticalContentSharesOneBlob(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	content := []byte("the same photo, forwarded")
+	c.Put("m1", "image/jpeg", content)
+	c.Put("m2", "image/jpeg", content)
+
+	usage, err := c.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != int64(len(content)) {
+		t.Errorf("usage: got %d, want %d", usage, len(content))
+	}
+	for _, id := range []string{"m1", "m2"} {
+		if data, _, ok, _ := c.Get(id); !ok || !bytes.Equal(data, content) {
+			t.Errorf("%s: not served from shared blob", id)
+		}
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestCache(t, 25)
+	c.Put("a", "", bytes.Repeat([]byte("a"), 10))
+	c.Put("b", "", bytes.Repeat([]byte("b"), 10))
+	// Using a makes b the least recently used.
+	if _, _, ok, _ := c.Get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	c.Put("c", "", bytes.Repeat([]byte("c"), 10))
+
+	if c.Has("b") {
+		t.Error("b should have been evicted")
+	}
+	if !c.Has("a") || !c.Has("c") {
+		t.Error("a and c should still be cached")
+	}
+	if usage, _ := c.Usage(); usage > 25 {
+		t.Errorf("usage %d over cap", usage)
+	}
+
+	// The evicted blob is gone from disk too.
+	sum := sha256Hex(bytes.Repeat([]byte("b"), 10))
+	if _, err := os.Stat(c.blobPath(sum)); !os.IsNotExist(err) {
+		t.Errorf("evicted blob still on disk: %v", err)
+	}
+}
+
+func TestSkipsContentLargerThanCap(t *testing.T) {
+	c := newTestCache(t, 8)
+	c.Put("small", "", []byte("1234"))
+	if err := c.Put("huge", "", bytes.Repeat([]byte("x"), 100)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Has("huge") {
+		t.Error("oversized media should not be cached")
+	}
+	if !c.Has("small") {
+		t.Error("oversized media should not evict anything")
+	}
+}
+
+func TestMissingBlobIsRedownloaded(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	dl := &fakeDownloader{content: map[string][]byte{"m1": []byte("photo")}}
+	c.Fetch(mediaMessage("m1"), dl.download)
+
+	os.RemoveAll(c.dir)
+
+	data, err := c.Fetch(mediaMessage("m1"), dl.download)
+	if err != nil || string(data) != "photo" {
+		t.Fatalf("fetch after blob loss: %q, %v", data, err)
+	}
+	if dl.calls != 2 {
+		t.Errorf("downloaded %d times, want 2", dl.calls)
+	}
+}
+
+func TestPrefetchedMediaIsEvictedFirst(t *testing.T) {
+	c := newTestCache(t, 25)
+	dl := &fakeDownloader{content: map[string][]byte{
+		"old": bytes.Repeat([]byte("o"), 10),
+	}}
+	c.Put("viewed", "", bytes.Repeat([]byte("v"), 10))
+
+	msg := mediaMessage("old")
+	msg.TimestampMS = 1000 // long before anything was viewed
+	if err := c.Prefetch(msg, dl.download); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Prefetch(msg, dl.download); err != nil {
+		t.Fatal(err)
+	}
+	if dl.calls != 1 {
+		t.Errorf("prefetch downloaded %d times, want 1", dl.calls)
+	}
+
+	c.Put("new", "", bytes.Repeat([]byte("n"), 10))
+	if c.Has("old") {
+		t.Error("prefetched media should be evicted before viewed media")
+	}
+	if !c.Has("viewed") {
+		t.Error("viewed media was evicted")
+	}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,88 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+)
+
+func backfillStatusTool() mcp.Tool {
+	return mcp.NewTool("backfill_status",
+		mcp.WithDescription("Get progress of the current or last message history backfill"),
+		mcp.WithOutputSchema[backfillOutput](),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+// backfillOutput is backfill_status' structured output.
+type backfillOutput struct {
+	Available bool `json:"available" jsonschema:"Whether backfills can run in this process; the rest is empty if not"`
+	app.BackfillProgress
+}
+
+func backfillStatusHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if a.Backfills == nil {
+			return structuredResult("Backfill: not available\n", backfillOutput{}), nil
+		}
+		p := a.Backfills.Progress()
+		// Leave out which hidden conversations it's fetching.
+		if p.ConversationID != "" && hidden(a, p.ConversationID) {
+			p.ConversationID = ""
+		}
+		if p.Current != "" && hidden(a, p.Current) {
+			p.Current = ""
+		}
+		var errs []string
+		for _, e := range p.Errors {
+			if convID, _, ok := strings.Cut(e, ": "); ok && hidden(a, convID) {
+				continue
+			}
+			errs = append(errs, e)
+		}
+		p.Errors = errs
+		out := backfillOutput{Available: true, BackfillProgress: p}
+		if p.State == app.BackfillIdle {
+			return structuredResult("Backfill: idle (none has run since start)\n", out), nil
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Backfill: %s (%s", p.State, p.Kind)
+		if p.ConversationID != "" {
+			fmt.Fprintf(&sb, " %s", p.ConversationID)
+		}
+		if p.Until != nil {
+			fmt.Fprintf(&sb, " back to %s", p.Until.Format(time.DateOnly))
+		}
+		sb.WriteString(")\n")
+		if p.ConversationsTotal > 0 {
+			fmt.Fprintf(&sb, "Conversations: %d of %d\n", p.ConversationsDone, p.ConversationsTotal)
+		} else {
+			fmt.Fprintf(&sb, "Conversations: %d\n", p.ConversationsDone)
+		}
+		fmt.Fprintf(&sb, "Messages fetched: %d\n", p.MessagesFetched)
+		if p.Current != "" {
+			fmt.Fprintf(&sb, "Current conversation: %s\n", p.Current)
+		}
+		if p.StartedAt != nil {
+			fmt.Fprintf(&sb, "Started: %s\n", p.StartedAt.Format(time.RFC3339))
+		}
+		if p.FinishedAt != nil {
+			fmt.Fprintf(&sb, "Finished: %s\n", p.FinishedAt.Format(time.RFC3339))
+		}
+		if p.ErrorCount > 0 {
+			fmt.Fprintf(&sb, "Errors: %d\n", p.ErrorCount)
+			for _, e := range p.Errors {
+				fmt.Fprintf(&sb, "  %s\n", e)
+			}
+		}
+		return structuredResult(sb.String(), out), nil
+	}
+}
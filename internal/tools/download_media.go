@@ -2,7 +2,7 @@ package tools
 
 import (
 	"context"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,17 +12,26 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/media"
 )
 
 func downloadMediaTool() mcp.Tool {
 	return mcp.NewTool("download_media",
 		mcp.WithDescription("Download media (voice messages, images, videos) from a message and save to a local file. Returns the file path."),
 		mcp.WithString("message_id", mcp.Required(), mcp.Description("The message ID containing the media")),
+		mcp.WithOutputSchema[mediaOutput](),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
 }
 
+// mediaOutput is download_media's structured output.
+type mediaOutput struct {
+	Path     string `json:"path" jsonschema:"The local file it was saved to"`
+	MimeType string `json:"mime_type"`
+	Size     int    `json:"size" jsonschema:"In bytes"`
+}
+
 func downloadMediaHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
@@ -31,7 +40,7 @@ func downloadMediaHandler(a *app.App) server.ToolHandlerFunc {
 			return errorResult("message_id is required"), nil
 		}
 
-		msg, err := a.Store.GetMessageByID(msgID)
+		msg, err := agentStore(a).GetMessageByID(msgID)
 		if err != nil {
 			return errorResult(fmt.Sprintf("get message: %v", err)), nil
 		}
@@ -42,16 +51,14 @@ func downloadMediaHandler(a *app.App) server.ToolHandlerFunc {
 			return errorResult("this message has no media attachment"), nil
 		}
 
-		if a.Client == nil {
-			return errorResult("not connected to Google Messages"), nil
+		var download media.Downloader
+		if cli := a.Client(); cli != nil {
+			download = cli.GM.DownloadMedia
 		}
-
-		key, err := hex.DecodeString(msg.DecryptionKey)
-		if err != nil {
-			return errorResult(fmt.Sprintf("invalid decryption key: %v", err)), nil
+		data, err := a.Media.Fetch(msg, download)
+		if errors.Is(err, media.ErrNotCached) {
+			return errorResult("not connected to Google Messages"), nil
 		}
-
-		data, err := a.Client.GM.DownloadMedia(msg.MediaID, key)
 		if err != nil {
 			return errorResult(fmt.Sprintf("download media: %v", err)), nil
 		}
@@ -68,7 +75,8 @@ func downloadMediaHandler(a *app.App) server.ToolHandlerFunc {
 			return errorResult(fmt.Sprintf("write file: %v", err)), nil
 		}
 
-		return textResult(fmt.Sprintf("Downloaded %s (%d bytes) to:\n%s", msg.MimeType, len(data), filePath)), nil
+		return structuredResult(fmt.Sprintf("Downloaded %s (%d bytes) to:\n%s", msg.MimeType, len(data), filePath),
+			mediaOutput{Path: filePath, MimeType: msg.MimeType, Size: len(data)}), nil
 	}
 }
 
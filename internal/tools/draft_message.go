@@ -9,6 +9,7 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/bus"
 	"github.com/maxghenis/openmessage/internal/db"
 )
 
@@ -17,11 +18,18 @@ func draftMessageTool() mcp.Tool {
 		mcp.WithDescription("Create a draft message for a conversation. The user can review and send it from the app."),
 		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("The conversation ID to create a draft for")),
 		mcp.WithString("message", mcp.Required(), mcp.Description("The draft message text")),
+		mcp.WithOutputSchema[draftOutput](),
 		mcp.WithDestructiveHintAnnotation(false),
 		mcp.WithIdempotentHintAnnotation(false),
 	)
 }
 
+// draftOutput is draft_message's structured output.
+type draftOutput struct {
+	DraftID        string `json:"draft_id" jsonschema:"Pass it to schedule_message to send the draft later"`
+	ConversationID string `json:"conversation_id"`
+}
+
 func draftMessageHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
@@ -34,6 +42,9 @@ func draftMessageHandler(a *app.App) server.ToolHandlerFunc {
 		if message == "" {
 			return errorResult("message is required"), nil
 		}
+		if res := checkWritable(a, conversationID); res != nil {
+			return res, nil
+		}
 
 		now := time.Now()
 		draftID := fmt.Sprintf("draft_%d", now.UnixMilli())
@@ -47,7 +58,9 @@ func draftMessageHandler(a *app.App) server.ToolHandlerFunc {
 		if err != nil {
 			return errorResult(fmt.Sprintf("failed to create draft: %v", err)), nil
 		}
+		a.Events.Publish(bus.DraftUpdated{ConversationID: conversationID, DraftID: draftID})
 
-		return textResult("Draft created. The user can review and send it from the app."), nil
+		return structuredResult("Draft created. The user can review and send it from the app.",
+			draftOutput{DraftID: draftID, ConversationID: conversationID}), nil
 	}
 }
@@ -17,11 +17,19 @@ func getConversationTool() mcp.Tool {
 		mcp.WithDescription("Get messages in a specific conversation by ID"),
 		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("The conversation ID")),
 		mcp.WithNumber("limit", mcp.Description("Maximum messages to return (default 50)")),
+		mcp.WithOutputSchema[conversationOutput](),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
 }
 
+// conversationOutput is get_conversation's structured output.
+type conversationOutput struct {
+	Conversation *Conversation `json:"conversation,omitempty"`
+	untrusted
+	Messages []Message `json:"messages"`
+}
+
 func getConversationHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
@@ -31,43 +39,57 @@ func getConversationHandler(a *app.App) server.ToolHandlerFunc {
 		}
 		limit := intArg(args, "limit", 50)
 
-		msgs, err := a.Store.GetMessagesByConversation(convID, limit)
+		text, out, err := formatConversation(a, convID, limit)
 		if err != nil {
 			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 		}
+		return structuredResult(text, out), nil
+	}
+}
 
-		if len(msgs) == 0 {
-			return textResult("No messages found in this conversation."), nil
-		}
+// formatConversation returns a conversation's newest limit messages as
+// get_conversation and the conversation resource show them, as text and
+// as get_conversation's structured output.
+func formatConversation(a *app.App, convID string, limit int) (string, conversationOutput, error) {
+	msgs, err := agentStore(a).GetMessagesByConversation(convID, limit)
+	if err != nil {
+		return "", conversationOutput{}, err
+	}
 
-		var sb strings.Builder
-		// Show conversation info
-		conv, err := a.Store.GetConversation(convID)
-		if err == nil && conv != nil {
-			fmt.Fprintf(&sb, "Conversation: %s (ID: %s)\n", conv.Name, conv.ConversationID)
-			if conv.IsGroup {
-				sb.WriteString("Type: Group\n")
-			}
-			sb.WriteString("---\n")
+	out := conversationOutput{untrusted: untrustedWarning, Messages: toMessages(msgs)}
+	if len(msgs) == 0 {
+		return "No messages found in this conversation.", out, nil
+	}
+
+	var sb strings.Builder
+	// Show conversation info
+	conv, err := agentStore(a).GetConversation(convID)
+	if err == nil && conv != nil {
+		c := toConversation(conv)
+		out.Conversation = &c
+		fmt.Fprintf(&sb, "Conversation: %s (ID: %s)\n", conv.Name, conv.ConversationID)
+		if conv.IsGroup {
+			sb.WriteString("Type: Group\n")
 		}
+		sb.WriteString("---\n")
+	}
 
-		sb.WriteString(messagePreamble)
-		for _, m := range msgs {
-			ts := time.UnixMilli(m.TimestampMS).Format(time.RFC3339)
-			direction := "←"
-			if m.IsFromMe {
-				direction = "→"
-			}
-			sender := m.SenderName
-			if sender == "" {
-				sender = m.SenderNumber
-			}
-			if sender == "" {
-				sender = "Unknown"
-			}
-			display := formatMessageBody(m.Body, m.MediaID, m.MimeType, m.MessageID)
-			fmt.Fprintf(&sb, "[%s] %s %s: «%s»\n", ts, direction, sender, display)
+	sb.WriteString(messagePreamble)
+	for _, m := range msgs {
+		ts := time.UnixMilli(m.TimestampMS).Format(time.RFC3339)
+		direction := "←"
+		if m.IsFromMe {
+			direction = "→"
+		}
+		sender := m.SenderName
+		if sender == "" {
+			sender = m.SenderNumber
+		}
+		if sender == "" {
+			sender = "Unknown"
 		}
-		return textResult(sb.String()), nil
+		display := formatMessageBody(m.Body, m.MediaID, m.MimeType, m.MessageID)
+		fmt.Fprintf(&sb, "[%s] %s %s: «%s»%s\n", ts, direction, sender, display, formatDeliveryStatus(m))
 	}
+	return sb.String(), out, nil
 }
@@ -19,11 +19,18 @@ func getMessagesTool() mcp.Tool {
 		mcp.WithString("after", mcp.Description("Only messages after this ISO-8601 date (e.g., 2026-02-01)")),
 		mcp.WithString("before", mcp.Description("Only messages before this ISO-8601 date")),
 		mcp.WithNumber("limit", mcp.Description("Maximum messages to return (default 20)")),
+		mcp.WithOutputSchema[messagesOutput](),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
 }
 
+// messagesOutput is get_messages' structured output.
+type messagesOutput struct {
+	untrusted
+	Messages []Message `json:"messages"`
+}
+
 func getMessagesHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
@@ -46,13 +53,14 @@ func getMessagesHandler(a *app.App) server.ToolHandlerFunc {
 			beforeMS = t.Add(24*time.Hour - time.Millisecond).UnixMilli()
 		}
 
-		msgs, err := a.Store.GetMessages(phone, afterMS, beforeMS, limit)
+		msgs, err := agentStore(a).GetMessages(phone, afterMS, beforeMS, limit)
 		if err != nil {
 			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 		}
 
+		out := messagesOutput{untrusted: untrustedWarning, Messages: toMessages(msgs)}
 		if len(msgs) == 0 {
-			return textResult("No messages found."), nil
+			return structuredResult("No messages found.", out), nil
 		}
 
 		var sb strings.Builder
@@ -71,8 +79,8 @@ func getMessagesHandler(a *app.App) server.ToolHandlerFunc {
 				sender = "Unknown"
 			}
 			display := formatMessageBody(m.Body, m.MediaID, m.MimeType, m.MessageID)
-			fmt.Fprintf(&sb, "[%s] %s %s: «%s»\n", ts, direction, sender, display)
+			fmt.Fprintf(&sb, "[%s] %s %s: «%s»%s\n", ts, direction, sender, display, formatDeliveryStatus(m))
 		}
-		return textResult(sb.String()), nil
+		return structuredResult(sb.String(), out), nil
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -14,23 +15,48 @@ import (
 func getStatusTool() mcp.Tool {
 	return mcp.NewTool("get_status",
 		mcp.WithDescription("Get connection status and paired phone information"),
+		mcp.WithOutputSchema[statusOutput](),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
 }
 
+// statusOutput is get_status' structured output.
+type statusOutput struct {
+	Connected  bool                  `json:"connected"`
+	LoggedIn   bool                  `json:"logged_in"`
+	Connection *app.ConnectionStatus `json:"connection,omitempty" jsonschema:"The reconnect state, with the most recent events"`
+	PhoneID    string                `json:"phone_id,omitempty"`
+	BrowserID  string                `json:"browser_id,omitempty"`
+	SessionID  string                `json:"session_id,omitempty"`
+	DataDir    string                `json:"data_dir,omitempty"`
+}
+
 func getStatusHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		var sb strings.Builder
+		var out statusOutput
+		if sup := a.Supervisor(); sup != nil {
+			st := sup.Status()
+			if len(st.History) > recentConnectionEvents {
+				st.History = st.History[len(st.History)-recentConnectionEvents:]
+			}
+			out.Connection = &st
+		}
 
-		if a.Client == nil {
+		cli := a.Client()
+		if cli == nil {
 			sb.WriteString("Status: not connected\n")
 			sb.WriteString("Run 'gmessages-mcp pair' to connect.\n")
-			return textResult(sb.String()), nil
+			if out.Connection != nil {
+				writeConnectionStatus(&sb, *out.Connection)
+			}
+			return structuredResult(sb.String(), out), nil
 		}
 
-		connected := a.Client.GM.IsConnected()
-		loggedIn := a.Client.GM.IsLoggedIn()
+		connected := cli.GM.IsConnected()
+		loggedIn := cli.GM.IsLoggedIn()
+		out.Connected, out.LoggedIn = connected, loggedIn
 
 		sb.WriteString("Status: ")
 		if connected {
@@ -40,19 +66,62 @@ func getStatusHandler(a *app.App) server.ToolHandlerFunc {
 		}
 
 		fmt.Fprintf(&sb, "Logged in: %v\n", loggedIn)
+		if out.Connection != nil {
+			writeConnectionStatus(&sb, *out.Connection)
+		}
 
-		if ad := a.Client.GM.AuthData; ad != nil {
+		if ad := cli.GM.AuthData; ad != nil {
 			if ad.Mobile != nil {
-				fmt.Fprintf(&sb, "Phone ID: %s\n", ad.Mobile.GetSourceID())
+				out.PhoneID = ad.Mobile.GetSourceID()
+				fmt.Fprintf(&sb, "Phone ID: %s\n", out.PhoneID)
 			}
 			if ad.Browser != nil {
-				fmt.Fprintf(&sb, "Browser ID: %s\n", ad.Browser.GetSourceID())
+				out.BrowserID = ad.Browser.GetSourceID()
+				fmt.Fprintf(&sb, "Browser ID: %s\n", out.BrowserID)
 			}
-			fmt.Fprintf(&sb, "Session ID: %s\n", ad.SessionID.String())
+			out.SessionID = ad.SessionID.String()
+			fmt.Fprintf(&sb, "Session ID: %s\n", out.SessionID)
 		}
 
+		out.DataDir = a.DataDir
 		fmt.Fprintf(&sb, "Data dir: %s\n", a.DataDir)
 
-		return textResult(sb.String()), nil
+		return structuredResult(sb.String(), out), nil
+	}
+}
+
+// recentConnectionEvents is how much reconnect history get_status shows.
+const recentConnectionEvents = 5
+
+func writeConnectionStatus(sb *strings.Builder, st app.ConnectionStatus) {
+	fmt.Fprintf(sb, "Connection: %s", st.State)
+	if st.State == app.StateReconnecting && st.NextAttemptAt != nil {
+		fmt.Fprintf(sb, " (attempt %d, next try at %s)", st.Attempt, st.NextAttemptAt.Format(time.RFC3339))
+	}
+	sb.WriteString("\n")
+	if st.State == app.StateNeedsPairing {
+		sb.WriteString("The session can't be restored automatically; pair again to reconnect.\n")
+	}
+	if st.LastError != "" {
+		fmt.Fprintf(sb, "Last error: %s\n", st.LastError)
+	}
+	fmt.Fprintf(sb, "Reconnects since start: %d\n", st.Reconnects)
+
+	history := st.History
+	if len(history) > recentConnectionEvents {
+		history = history[len(history)-recentConnectionEvents:]
+	}
+	if len(history) > 0 {
+		sb.WriteString("Recent connection events:\n")
+		for _, evt := range history {
+			fmt.Fprintf(sb, "  [%s] %s", evt.Time.Format(time.RFC3339), evt.Kind)
+			if evt.Attempt > 0 {
+				fmt.Fprintf(sb, " (attempt %d)", evt.Attempt)
+			}
+			if evt.Error != "" {
+				fmt.Fprintf(sb, ": %s", evt.Error)
+			}
+			sb.WriteString("\n")
+		}
 	}
 }
@@ -17,11 +17,22 @@ func listContactsTool() mcp.Tool {
 		mcp.WithDescription("List or search contacts by name or phone number"),
 		mcp.WithString("query", mcp.Description("Search by name or number")),
 		mcp.WithNumber("limit", mcp.Description("Maximum contacts to return (default 50)")),
+		mcp.WithOutputSchema[contactsOutput](),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
 }
 
+// contactsOutput is list_contacts' structured output.
+type contactsOutput struct {
+	Contacts []contact `json:"contacts"`
+}
+
+type contact struct {
+	Name   string `json:"name"`
+	Number string `json:"number,omitempty"`
+}
+
 func listContactsHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
@@ -29,28 +40,32 @@ func listContactsHandler(a *app.App) server.ToolHandlerFunc {
 		limit := intArg(args, "limit", 50)
 
 		// If no contacts in DB yet, try fetching from phone
-		contacts, err := a.Store.ListContacts("", 1)
-		if err == nil && len(contacts) == 0 && a.Client != nil {
+		contacts, err := agentStore(a).ListContacts("", 1)
+		if err == nil && len(contacts) == 0 && a.Client() != nil {
 			if err := fetchAndCacheContacts(a); err != nil {
 				a.Logger.Warn().Err(err).Msg("Failed to fetch contacts from phone")
 			}
 		}
 
-		contacts, err = a.Store.ListContacts(query, limit)
+		contacts, err = agentStore(a).ListContacts(query, limit)
 		if err != nil {
 			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 		}
 
 		// Fall back to conversation participants if contacts table is empty
 		if len(contacts) == 0 {
-			contacts, err = a.Store.ListContactsFromConversations(query, limit)
+			contacts, err = agentStore(a).ListContactsFromConversations(query, limit)
 			if err != nil {
 				return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 			}
 		}
 
+		out := contactsOutput{Contacts: make([]contact, 0, len(contacts))}
+		for _, c := range contacts {
+			out.Contacts = append(out.Contacts, contact{Name: c.Name, Number: c.Number})
+		}
 		if len(contacts) == 0 {
-			return textResult("No contacts found."), nil
+			return structuredResult("No contacts found.", out), nil
 		}
 
 		var sb strings.Builder
@@ -62,15 +77,16 @@ func listContactsHandler(a *app.App) server.ToolHandlerFunc {
 				fmt.Fprintf(&sb, "- %s\n", c.Name)
 			}
 		}
-		return textResult(sb.String()), nil
+		return structuredResult(sb.String(), out), nil
 	}
 }
 
 func fetchAndCacheContacts(a *app.App) error {
-	if a.Client == nil {
+	cli := a.Client()
+	if cli == nil {
 		return fmt.Errorf("not connected")
 	}
-	resp, err := a.Client.GM.ListContacts()
+	resp, err := cli.GM.ListContacts()
 	if err != nil {
 		return err
 	}
@@ -10,29 +10,40 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 func listConversationsTool() mcp.Tool {
 	return mcp.NewTool("list_conversations",
 		mcp.WithDescription("List recent conversations, sorted by most recent message"),
 		mcp.WithNumber("limit", mcp.Description("Maximum conversations to return (default 20)")),
+		mcp.WithOutputSchema[conversationsOutput](),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
 }
 
+// conversationsOutput is list_conversations' structured output.
+type conversationsOutput struct {
+	Conversations []Conversation `json:"conversations" jsonschema:"Most recent first"`
+}
+
 func listConversationsHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
 		limit := intArg(args, "limit", 20)
 
-		convs, err := a.Store.ListConversations(limit)
+		convs, err := agentStore(a).ListConversations(limit)
 		if err != nil {
 			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 		}
 
+		out := conversationsOutput{Conversations: make([]Conversation, 0, len(convs))}
+		for _, c := range convs {
+			out.Conversations = append(out.Conversations, toConversation(c))
+		}
 		if len(convs) == 0 {
-			return textResult("No conversations found. Messages may not have synced yet."), nil
+			return structuredResult("No conversations found. Messages may not have synced yet.", out), nil
 		}
 
 		var sb strings.Builder
@@ -43,12 +54,15 @@ func listConversationsHandler(a *app.App) server.ToolHandlerFunc {
 			if c.IsGroup {
 				group = " [group]"
 			}
+			if c.AIAccess == db.AIReadOnly {
+				group += " [read-only]"
+			}
 			unread := ""
 			if c.UnreadCount > 0 {
 				unread = fmt.Sprintf(" (%d unread)", c.UnreadCount)
 			}
 			fmt.Fprintf(&sb, "- %s%s%s (ID: %s, last: %s)\n", c.Name, group, unread, c.ConversationID, ts)
 		}
-		return textResult(sb.String()), nil
+		return structuredResult(sb.String(), out), nil
 	}
 }
@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func listOutboxTool() mcp.Tool {
+	return mcp.NewTool("list_outbox",
+		mcp.WithDescription("List messages waiting in the outbox to be sent, and recent sent or failed ones"),
+		mcp.WithString("state", mcp.Description("Only items in this state: queued, sending, sent or failed (default: all)")),
+		mcp.WithNumber("limit", mcp.Description("Maximum items to return (default 50)")),
+		mcp.WithOutputSchema[outboxOutput](),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+// outboxOutput is list_outbox's structured output.
+type outboxOutput struct {
+	Items []outboxItem `json:"items" jsonschema:"Newest first"`
+}
+
+type outboxItem struct {
+	ID             int64      `json:"id"`
+	State          string     `json:"state" jsonschema:"queued, sending, sent or failed"`
+	ConversationID string     `json:"conversation_id,omitempty"`
+	PhoneNumber    string     `json:"phone_number,omitempty"`
+	Body           string     `json:"body"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty" jsonschema:"When a queued item that failed before is tried again"`
+	LastError      string     `json:"last_error,omitempty"`
+	MessageID      string     `json:"message_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func listOutboxHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		state := strArg(args, "state")
+		limit := intArg(args, "limit", 50)
+
+		switch state {
+		case "", db.OutboxQueued, db.OutboxSending, db.OutboxSent, db.OutboxFailed:
+		default:
+			return errorResult("state must be queued, sending, sent or failed"), nil
+		}
+
+		items, err := agentStore(a).ListOutbox(state, limit)
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		out := outboxOutput{Items: make([]outboxItem, 0, len(items))}
+		for _, it := range items {
+			item := outboxItem{
+				ID:             it.ID,
+				State:          it.State,
+				ConversationID: it.ConversationID,
+				PhoneNumber:    it.PhoneNumber,
+				Body:           it.Body,
+				Attempts:       it.Attempts,
+				MessageID:      it.MessageID,
+				CreatedAt:      time.UnixMilli(it.CreatedAt).UTC(),
+			}
+			if it.State == db.OutboxQueued && it.Attempts > 0 {
+				next := time.UnixMilli(it.NextAttemptAt).UTC()
+				item.NextAttemptAt = &next
+			}
+			if it.State != db.OutboxSent {
+				item.LastError = it.LastError
+			}
+			out.Items = append(out.Items, item)
+		}
+		if len(items) == 0 {
+			return structuredResult("The outbox is empty.", out), nil
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%d outbox items:\n\n", len(items))
+		for _, it := range items {
+			to := it.ConversationID
+			if to == "" {
+				to = it.PhoneNumber
+			}
+			fmt.Fprintf(&sb, "- #%d [%s] to %s, queued %s", it.ID, it.State, to, time.UnixMilli(it.CreatedAt).Format(time.RFC3339))
+			if it.Attempts > 0 {
+				fmt.Fprintf(&sb, ", %d attempts", it.Attempts)
+			}
+			if it.State == db.OutboxQueued && it.Attempts > 0 {
+				fmt.Fprintf(&sb, ", next try %s", time.UnixMilli(it.NextAttemptAt).Format(time.RFC3339))
+			}
+			fmt.Fprintf(&sb, ": %s\n", it.Body)
+			if it.LastError != "" && it.State != db.OutboxSent {
+				fmt.Fprintf(&sb, "  last error: %s\n", it.LastError)
+			}
+		}
+		return structuredResult(sb.String(), out), nil
+	}
+}
@@ -0,0 +1,256 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/bus"
+)
+
+// Resource URIs.
+const (
+	// UnreadURI lists unread incoming messages, grouped by conversation.
+	UnreadURI = "openmessage://unread"
+	// conversationURIPrefix, followed by a conversation ID, is one
+	// conversation's newest messages.
+	conversationURIPrefix = "openmessage://conversation/"
+)
+
+// conversationResourceLimit is how many messages a conversation resource
+// shows, as many as get_conversation does by default.
+const conversationResourceLimit = 50
+
+// ConversationURI returns the URI of a conversation's resource.
+func ConversationURI(conversationID string) string {
+	return conversationURIPrefix + url.PathEscape(conversationID)
+}
+
+// Resources serves conversations and unread messages as MCP resources and
+// tells clients subscribed to one when it changes, so they can watch a
+// conversation instead of polling get_conversation.
+type Resources struct {
+	a *app.App
+	s *server.MCPServer
+
+	mu   sync.Mutex
+	subs map[string]map[string]bool // session ID → subscribed URIs
+}
+
+// NewResources returns Resources for a. Make the server with its
+// ServerOptions, then Register it and Run it.
+func NewResources(a *app.App) *Resources {
+	return &Resources{a: a, subs: make(map[string]map[string]bool)}
+}
+
+// ServerOptions returns the options the MCP server needs to accept
+// subscriptions and track who made them.
+func (r *Resources) ServerOptions() []server.ServerOption {
+	hooks := &server.Hooks{}
+	hooks.AddAfterSubscribe(func(ctx context.Context, _ any, req *mcp.SubscribeRequest, _ *mcp.EmptyResult) {
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			r.subscribe(session.SessionID(), req.Params.URI)
+		}
+	})
+	hooks.AddAfterUnsubscribe(func(ctx context.Context, _ any, req *mcp.UnsubscribeRequest, _ *mcp.EmptyResult) {
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			r.unsubscribe(session.SessionID(), req.Params.URI)
+		}
+	})
+	hooks.AddOnUnregisterSession(func(_ context.Context, session server.ClientSession) {
+		r.mu.Lock()
+		delete(r.subs, session.SessionID())
+		r.mu.Unlock()
+	})
+	return []server.ServerOption{
+		server.WithResourceCapabilities(true, false),
+		server.WithHooks(hooks),
+	}
+}
+
+// Register adds the resources to s.
+func (r *Resources) Register(s *server.MCPServer) {
+	r.s = s
+	s.AddResource(mcp.NewResource(UnreadURI, "Unread messages",
+		mcp.WithResourceDescription("Unread incoming messages, grouped by conversation"),
+		mcp.WithMIMEType("text/plain"),
+	), r.readUnread)
+	s.AddResourceTemplate(mcp.NewResourceTemplate(conversationURIPrefix+"{id}", "Conversation",
+		mcp.WithTemplateDescription(fmt.Sprintf("The newest %d messages in a conversation, by conversation ID", conversationResourceLimit)),
+		mcp.WithTemplateMIMEType("text/plain"),
+	), r.readConversation)
+}
+
+// Run notifies subscribers of changes to their resources until ctx is
+// done.
+func (r *Resources) Run(ctx context.Context) {
+	stop := r.a.Events.Consume(r.Handle, r.notifyAll)
+	defer stop()
+	<-ctx.Done()
+}
+
+// Handle notifies subscribers of the resources an event changes. New and
+// changed messages change their conversation, incoming ones the unread
+// list too, and so does a conversation's unread count. Nothing in
+// conversations hidden from AI changes anything subscribers can see.
+func (r *Resources) Handle(evt bus.Event) {
+	switch p := evt.Data.(type) {
+	case bus.MessageAdded:
+		if hidden(r.a, p.ConversationID) {
+			return
+		}
+		r.notify(ConversationURI(p.ConversationID))
+		if !p.IsFromMe {
+			r.notify(UnreadURI)
+		}
+	case bus.MessageUpdated:
+		if !hidden(r.a, p.ConversationID) {
+			r.notify(ConversationURI(p.ConversationID))
+		}
+	case bus.ConversationUpdated:
+		if !hidden(r.a, p.ConversationID) {
+			r.notify(UnreadURI)
+		}
+	}
+}
+
+func (r *Resources) subscribe(sessionID, uri string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subs[sessionID] == nil {
+		r.subs[sessionID] = make(map[string]bool)
+	}
+	r.subs[sessionID][uri] = true
+}
+
+func (r *Resources) unsubscribe(sessionID, uri string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subs[sessionID], uri)
+	if len(r.subs[sessionID]) == 0 {
+		delete(r.subs, sessionID)
+	}
+}
+
+// notify sends notifications/resources/updated for uri to the sessions
+// subscribed to it.
+func (r *Resources) notify(uri string) {
+	r.mu.Lock()
+	var sessions []string
+	for id, uris := range r.subs {
+		if uris[uri] {
+			sessions = append(sessions, id)
+		}
+	}
+	r.mu.Unlock()
+	for _, id := range sessions {
+		r.send(id, uri)
+	}
+}
+
+// notifyAll tells every subscriber that its resources may have changed,
+// for when events were missed.
+func (r *Resources) notifyAll() {
+	r.mu.Lock()
+	var all [][2]string
+	for id, uris := range r.subs {
+		for uri := range uris {
+			all = append(all, [2]string{id, uri})
+		}
+	}
+	r.mu.Unlock()
+	for _, sub := range all {
+		r.send(sub[0], sub[1])
+	}
+}
+
+func (r *Resources) send(sessionID, uri string) {
+	if r.s == nil {
+		return
+	}
+	err := r.s.SendNotificationToSpecificClient(sessionID, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri})
+	if errors.Is(err, server.ErrSessionNotFound) {
+		r.mu.Lock()
+		delete(r.subs, sessionID)
+		r.mu.Unlock()
+	} else if err != nil {
+		r.a.Logger.Debug().Err(err).Str("session", sessionID).Str("uri", uri).Msg("Failed to notify MCP client of resource update")
+	}
+}
+
+func (r *Resources) readConversation(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	id, err := url.PathUnescape(strings.TrimPrefix(req.Params.URI, conversationURIPrefix))
+	if err != nil || id == "" || !strings.HasPrefix(req.Params.URI, conversationURIPrefix) {
+		return nil, fmt.Errorf("invalid conversation URI: %s", req.Params.URI)
+	}
+	if _, err := agentStore(r.a).GetConversation(id); errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("conversation not found: %s", id)
+	} else if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	text, _, err := formatConversation(r.a, id, conversationResourceLimit)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return []mcp.ResourceContents{mcp.TextResourceContents{URI: req.Params.URI, MIMEType: "text/plain", Text: text}}, nil
+}
+
+func (r *Resources) readUnread(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	msgs, err := agentStore(r.a).SearchMessages("is:unread", "", 500)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	contents := func(text string) []mcp.ResourceContents {
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: UnreadURI, MIMEType: "text/plain", Text: text}}
+	}
+	if len(msgs) == 0 {
+		return contents("No unread messages."), nil
+	}
+
+	// Conversations with the newest unread message first; messages oldest
+	// first within each, to read in order.
+	var order []string
+	byConv := make(map[string][]string)
+	for _, m := range msgs {
+		if _, ok := byConv[m.ConversationID]; !ok {
+			order = append(order, m.ConversationID)
+		}
+		ts := time.UnixMilli(m.TimestampMS).Format(time.RFC3339)
+		sender := m.SenderName
+		if sender == "" {
+			sender = m.SenderNumber
+		}
+		if sender == "" {
+			sender = "Unknown"
+		}
+		display := formatMessageBody(m.Body, m.MediaID, m.MimeType, m.MessageID)
+		byConv[m.ConversationID] = append(byConv[m.ConversationID], fmt.Sprintf("[%s] ← %s: «%s»\n", ts, sender, display))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d unread messages in %d conversations\n---\n", len(msgs), len(order))
+	sb.WriteString(messagePreamble)
+	for _, id := range order {
+		name := id
+		if conv, err := agentStore(r.a).GetConversation(id); err == nil && conv != nil && conv.Name != "" {
+			name = conv.Name
+		}
+		fmt.Fprintf(&sb, "%s (ID: %s):\n", name, id)
+		lines := byConv[id]
+		slices.Reverse(lines)
+		for _, line := range lines {
+			sb.WriteString(line)
+		}
+	}
+	return contents(sb.String()), nil
+}
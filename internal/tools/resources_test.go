@@ -0,0 +1,141 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// testSession is an MCP client session whose notifications the test reads.
+type testSession struct {
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *testSession) SessionID() string { return "test-session" }
+func (s *testSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+func (s *testSession) Initialize()       {}
+func (s *testSession) Initialized() bool { return true }
+
+func TestResources(t *testing.T) {
+	a := testApp(t)
+	a.Events = bus.New(100)
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Alice", UnreadCount: 1})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c2", Name: "Bob"})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", SenderName: "Alice", Body: "are you coming?", TimestampMS: 1000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c2", SenderName: "Bob", Body: "thanks", TimestampMS: 2000})
+
+	r := NewResources(a)
+	s := server.NewMCPServer("test", "0.1.0", r.ServerOptions()...)
+	r.Register(s)
+	// What Run does, but subscribed before anything is published.
+	defer a.Events.Consume(r.Handle, r.notifyAll)()
+	ctx := context.Background()
+
+	session := &testSession{notifications: make(chan mcp.JSONRPCNotification, 10)}
+	if err := s.RegisterSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	sctx := s.WithContext(ctx, session)
+	call := func(method string, params any) (json.RawMessage, *mcp.JSONRPCError) {
+		t.Helper()
+		raw, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+		switch resp := s.HandleMessage(sctx, raw).(type) {
+		case mcp.JSONRPCResponse:
+			result, _ := json.Marshal(resp.Result)
+			return result, nil
+		case mcp.JSONRPCError:
+			return nil, &resp
+		default:
+			t.Fatalf("%s: unexpected response %#v", method, resp)
+			return nil, nil
+		}
+	}
+	read := func(uri string) string {
+		t.Helper()
+		result, rpcErr := call("resources/read", map[string]any{"uri": uri})
+		if rpcErr != nil {
+			t.Fatalf("read %s: %+v", uri, rpcErr.Error)
+		}
+		var got struct {
+			Contents []struct{ URI, Text string }
+		}
+		json.Unmarshal(result, &got)
+		if len(got.Contents) != 1 || got.Contents[0].URI != uri {
+			t.Fatalf("read %s: %s", uri, result)
+		}
+		return got.Contents[0].Text
+	}
+
+	if result, _ := call("resources/templates/list", map[string]any{}); !strings.Contains(string(result), "openmessage://conversation/{id}") {
+		t.Errorf("templates: %s", result)
+	}
+	if text := read(ConversationURI("c1")); !strings.Contains(text, "Conversation: Alice") || !strings.Contains(text, "«are you coming?»") {
+		t.Errorf("conversation:\n%s", text)
+	}
+	if text := read(UnreadURI); !strings.Contains(text, "1 unread messages in 1 conversations") || !strings.Contains(text, "«are you coming?»") || strings.Contains(text, "thanks") {
+		t.Errorf("unread:\n%s", text)
+	}
+	if _, rpcErr := call("resources/read", map[string]any{"uri": ConversationURI("nope")}); rpcErr == nil || !strings.Contains(rpcErr.Error.Message, "conversation not found") {
+		t.Errorf("read an unknown conversation: %+v", rpcErr)
+	}
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case n := <-session.notifications:
+			if n.Method != mcp.MethodNotificationResourceUpdated || n.Params.AdditionalFields["uri"] != want {
+				t.Errorf("got %s %v, want an update to %s", n.Method, n.Params.AdditionalFields, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no update to %s", want)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case n := <-session.notifications:
+			t.Errorf("unexpected %s %v", n.Method, n.Params.AdditionalFields)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	if _, rpcErr := call("resources/subscribe", map[string]any{"uri": ConversationURI("c1")}); rpcErr != nil {
+		t.Fatalf("subscribe: %+v", rpcErr.Error)
+	}
+	a.Events.Publish(bus.MessageAdded{Message: &db.Message{MessageID: "m3", ConversationID: "c2"}})
+	a.Events.Publish(bus.MessageAdded{Message: &db.Message{MessageID: "m4", ConversationID: "c1"}})
+	expect(ConversationURI("c1"))
+	expectNone()
+
+	if _, rpcErr := call("resources/subscribe", map[string]any{"uri": UnreadURI}); rpcErr != nil {
+		t.Fatalf("subscribe: %+v", rpcErr.Error)
+	}
+	a.Events.Publish(bus.ConversationUpdated{Conversation: &db.Conversation{ConversationID: "c2", UnreadCount: 1}})
+	expect(UnreadURI)
+
+	call("resources/unsubscribe", map[string]any{"uri": ConversationURI("c1")})
+	a.Events.Publish(bus.MessageUpdated{Message: &db.Message{MessageID: "m4", ConversationID: "c1"}})
+	expectNone()
+
+	// Conversations hidden from AI can't be read, and don't change the
+	// unread list.
+	a.Store.SetAIAccess("c1", db.AIHidden)
+	if _, rpcErr := call("resources/read", map[string]any{"uri": ConversationURI("c1")}); rpcErr == nil || !strings.Contains(rpcErr.Error.Message, "conversation not found") {
+		t.Errorf("read a hidden conversation: %+v", rpcErr)
+	}
+	if text := read(UnreadURI); strings.Contains(text, "are you coming?") {
+		t.Errorf("unread shows a hidden message:\n%s", text)
+	}
+	a.Events.Publish(bus.MessageAdded{Message: &db.Message{MessageID: "m5", ConversationID: "c1"}})
+	expectNone()
+}
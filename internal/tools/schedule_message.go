@@ -0,0 +1,132 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func scheduleMessageTool() mcp.Tool {
+	return mcp.NewTool("schedule_message",
+		mcp.WithDescription("Schedule a text message, or an existing draft, to be sent to a conversation at a later time. "+
+			"It is sent even if the server restarts in between; if the phone is offline then, it goes out as soon as it reconnects."),
+		mcp.WithString("conversation_id", mcp.Description("The conversation to send to (optional when draft_id is given)")),
+		mcp.WithString("message", mcp.Description("Message text to send. Required unless draft_id is given")),
+		mcp.WithString("draft_id", mcp.Description("Send this draft instead; its text at send time is used and the draft is removed once sent")),
+		mcp.WithString("send_at", mcp.Required(), mcp.Description("When to send: RFC 3339 (e.g. 2026-03-02T09:00:00-08:00), or YYYY-MM-DDTHH:MM in the server's local time zone")),
+		mcp.WithOutputSchema[scheduleOutput](),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	)
+}
+
+// scheduleOutput is schedule_message's structured output.
+type scheduleOutput struct {
+	Status         string    `json:"status" jsonschema:"scheduled; or pending_approval, not scheduled until the user approves it"`
+	ScheduledID    int64     `json:"scheduled_id,omitempty"`
+	ApprovalID     int64     `json:"approval_id,omitempty"`
+	ConversationID string    `json:"conversation_id"`
+	DraftID        string    `json:"draft_id,omitempty"`
+	SendAt         time.Time `json:"send_at"`
+}
+
+func scheduleMessageHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if a.Scheduler == nil {
+			return errorResult("scheduling is not available"), nil
+		}
+		args := req.GetArguments()
+		sendAt, err := parseSendAt(strArg(args, "send_at"))
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+
+		// A draft in a hidden conversation is reported like a missing one,
+		// without naming the conversation.
+		if draftID := strArg(args, "draft_id"); draftID != "" {
+			if d, err := a.Store.GetDraft(draftID); err == nil && d != nil && hidden(a, d.ConversationID) {
+				return errorResult(fmt.Sprintf("failed to schedule: %v", app.ErrDraftNotFound)), nil
+			}
+		}
+
+		// Checked before the send policy, so that a message that can't be
+		// scheduled isn't held for approval.
+		var m *db.ScheduledMessage
+		schedReq, err := a.Scheduler.Check(app.ScheduleRequest{
+			ConversationID: strArg(args, "conversation_id"),
+			Body:           strArg(args, "message"),
+			DraftID:        strArg(args, "draft_id"),
+			SendAt:         sendAt,
+		})
+		if err == nil {
+			if res := checkWritable(a, schedReq.ConversationID); res != nil {
+				return res, nil
+			}
+			pending, res := applyPolicy(a, app.AgentSend{
+				ConversationID: schedReq.ConversationID,
+				Body:           schedReq.Body,
+				DraftID:        schedReq.DraftID,
+				SendAt:         schedReq.SendAt,
+			})
+			if res != nil {
+				return res, nil
+			}
+			if pending != nil {
+				return structuredResult(pendingText(pending), scheduleOutput{
+					Status:         "pending_approval",
+					ApprovalID:     pending.ID,
+					ConversationID: schedReq.ConversationID,
+					DraftID:        schedReq.DraftID,
+					SendAt:         schedReq.SendAt,
+				}), nil
+			}
+			m, err = a.Scheduler.Schedule(schedReq)
+		}
+		switch {
+		case errors.Is(err, app.ErrEmptyMessage):
+			return errorResult("message or draft_id is required"), nil
+		case errors.Is(err, app.ErrSendTimeInPast):
+			return errorResult(fmt.Sprintf("send_at must be in the future (it is now %s)", time.Now().Format(time.RFC3339))), nil
+		case err != nil:
+			return errorResult(fmt.Sprintf("failed to schedule: %v", err)), nil
+		}
+
+		what := "Message"
+		if m.DraftID != "" {
+			what = "Draft " + m.DraftID
+		}
+		sendAt = time.UnixMilli(m.SendAt)
+		return structuredResult(fmt.Sprintf("%s scheduled (#%d) for %s in conversation %s.",
+			what, m.ID, sendAt.Format(time.RFC3339), m.ConversationID), scheduleOutput{
+			Status:         "scheduled",
+			ScheduledID:    m.ID,
+			ConversationID: m.ConversationID,
+			DraftID:        m.DraftID,
+			SendAt:         sendAt,
+		}), nil
+	}
+}
+
+// parseSendAt accepts an RFC 3339 time, or a date and time without an
+// offset, read in the local time zone.
+func parseSendAt(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("send_at is required")
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("send_at %q is not a time like 2026-03-02T09:00:00-08:00", s)
+}
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -10,19 +11,38 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 func searchMessagesTool() mcp.Tool {
 	return mcp.NewTool("search_messages",
-		mcp.WithDescription("Search messages by text content across all conversations"),
-		mcp.WithString("query", mcp.Required(), mcp.Description("Search text")),
+		mcp.WithDescription("Full-text search across all conversations, best matches first. "+
+			`Supports "exact phrases", prefix* matching, -excluded words (or NOT word), and OR, `+
+			`plus filters: from:NAME_OR_NUMBER (from:me for sent), in:CONVERSATION_ID_OR_NAME, `+
+			`has:image|video|audio|media|link|reaction|reply, is:unread|read|sent|received|group|direct, `+
+			`after:YYYY-MM-DD, before:YYYY-MM-DD (exclusive). Quote values with spaces; prefix a filter with - to negate it. `+
+			`A query may be filters alone.`),
+		mcp.WithString("query", mcp.Required(), mcp.Description(`Search query, e.g. thai OR sushi -work, or from:"Sarah Chen" has:image after:2026-01-01 dinner`)),
 		mcp.WithString("phone_number", mcp.Description("Filter by phone number")),
 		mcp.WithNumber("limit", mcp.Description("Maximum results (default 20)")),
+		mcp.WithOutputSchema[searchOutput](),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
 }
 
+// searchOutput is search_messages' structured output.
+type searchOutput struct {
+	Query string `json:"query"`
+	untrusted
+	Results []searchResult `json:"results" jsonschema:"Best matches first"`
+}
+
+type searchResult struct {
+	Message
+	Snippet string `json:"snippet,omitempty" jsonschema:"An excerpt of the body with the matched words in **bold**"`
+}
+
 func searchMessagesHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
@@ -33,13 +53,20 @@ func searchMessagesHandler(a *app.App) server.ToolHandlerFunc {
 		phone := strArg(args, "phone_number")
 		limit := intArg(args, "limit", 20)
 
-		msgs, err := a.Store.SearchMessages(query, phone, limit)
+		msgs, err := agentStore(a).SearchMessages(query, phone, limit)
+		if errors.Is(err, db.ErrNoSearchTerms) || errors.Is(err, db.ErrInvalidSearch) {
+			return errorResult(err.Error()), nil
+		}
 		if err != nil {
 			return errorResult(fmt.Sprintf("search failed: %v", err)), nil
 		}
 
+		out := searchOutput{Query: query, untrusted: untrustedWarning, Results: make([]searchResult, 0, len(msgs))}
+		for _, m := range msgs {
+			out.Results = append(out.Results, searchResult{Message: toMessage(&m.Message), Snippet: highlightSnippet(m.Snippet)})
+		}
 		if len(msgs) == 0 {
-			return textResult(fmt.Sprintf("No messages found matching '%s'.", query)), nil
+			return structuredResult(fmt.Sprintf("No messages found matching '%s'.", query), out), nil
 		}
 
 		var sb strings.Builder
@@ -60,7 +87,16 @@ func searchMessagesHandler(a *app.App) server.ToolHandlerFunc {
 			}
 			display := formatMessageBody(m.Body, m.MediaID, m.MimeType, m.MessageID)
 			fmt.Fprintf(&sb, "[%s] %s %s (conv: %s): «%s»\n", ts, direction, sender, m.ConversationID, display)
+			if m.Snippet != "" {
+				fmt.Fprintf(&sb, "    match: «%s»\n", highlightSnippet(m.Snippet))
+			}
 		}
-		return textResult(sb.String()), nil
+		return structuredResult(sb.String(), out), nil
 	}
 }
+
+// highlightSnippet renders the store's snippet markers as **bold** so the
+// matched words stand out in the tool's text output.
+func highlightSnippet(snippet string) string {
+	return strings.NewReplacer(db.SnippetOpen, "**", db.SnippetClose, "**").Replace(snippet)
+}
@@ -2,14 +2,14 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
-	"github.com/google/uuid"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
-	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 func sendMessageTool() mcp.Tool {
@@ -17,11 +17,22 @@ func sendMessageTool() mcp.Tool {
 		mcp.WithDescription("Send a text message (SMS/RCS) to a phone number"),
 		mcp.WithString("phone_number", mcp.Required(), mcp.Description("Recipient phone number with country code (e.g., +15551234567)")),
 		mcp.WithString("message", mcp.Required(), mcp.Description("Message text to send")),
+		mcp.WithOutputSchema[sendOutput](),
 		mcp.WithDestructiveHintAnnotation(false),
 		mcp.WithIdempotentHintAnnotation(false),
 	)
 }
 
+// sendOutput is send_message's structured output.
+type sendOutput struct {
+	Status         string `json:"status" jsonschema:"sent; queued to be sent once the connection is back; or pending_approval, not sent until the user approves it"`
+	PhoneNumber    string `json:"phone_number"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	MessageID      string `json:"message_id,omitempty" jsonschema:"A placeholder ID until Google Messages confirms the message"`
+	OutboxID       int64  `json:"outbox_id,omitempty"`
+	ApprovalID     int64  `json:"approval_id,omitempty"`
+}
+
 func sendMessageHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
@@ -34,53 +45,41 @@ func sendMessageHandler(a *app.App) server.ToolHandlerFunc {
 		if message == "" {
 			return errorResult("message is required"), nil
 		}
-		if a.Client == nil {
-			return errorResult("not connected to Google Messages"), nil
+		if res := checkNumberWritable(a, phone); res != nil {
+			return res, nil
 		}
-
-		// Get or create conversation for this phone number
-		convResp, err := a.Client.GM.GetOrCreateConversation(&gmproto.GetOrCreateConversationRequest{
-			Numbers: []*gmproto.ContactNumber{
-				{
-					MysteriousInt: 7,
-					Number:        phone,
-					Number2:       phone,
-				},
-			},
-		})
-		if err != nil {
-			return errorResult(fmt.Sprintf("failed to get/create conversation: %v", err)), nil
+		pending, res := applyPolicy(a, app.AgentSend{PhoneNumber: phone, Body: message})
+		if res != nil {
+			return res, nil
 		}
-
-		conv := convResp.GetConversation()
-		if conv == nil {
-			return errorResult("no conversation returned"), nil
+		if pending != nil {
+			return structuredResult(pendingText(pending), sendOutput{Status: "pending_approval", PhoneNumber: phone, ApprovalID: pending.ID}), nil
+		}
+		sendReq := app.SendRequest{PhoneNumber: phone, Body: message}
+		var sent *app.SendResult
+		var queued *db.OutboxItem
+		var err error
+		if a.Outbox != nil {
+			sent, queued, err = a.Outbox.Submit(sendReq)
+		} else {
+			sent, err = a.Sender.Send(sendReq)
+		}
+		if errors.Is(err, app.ErrNotConnected) {
+			return errorResult("not connected to Google Messages"), nil
 		}
-
-		tmpID := uuid.NewString()
-		_, err = a.Client.GM.SendMessage(&gmproto.SendMessageRequest{
-			ConversationID: conv.GetConversationID(),
-			TmpID:          tmpID,
-			MessagePayload: &gmproto.MessagePayload{
-				TmpID:          tmpID,
-				TmpID2:         tmpID,
-				ConversationID: conv.GetConversationID(),
-				ParticipantID:  conv.GetDefaultOutgoingID(),
-				MessageInfo: []*gmproto.MessageInfo{
-					{
-						Data: &gmproto.MessageInfo_MessageContent{
-							MessageContent: &gmproto.MessageContent{
-								Content: message,
-							},
-						},
-					},
-				},
-			},
-		})
 		if err != nil {
 			return errorResult(fmt.Sprintf("failed to send: %v", err)), nil
 		}
+		if queued != nil {
+			return structuredResult(fmt.Sprintf("Message to %s couldn't be sent right now, so it was queued (outbox item %d). "+
+				"It will be sent automatically when the connection is back; check progress with list_outbox.", phone, queued.ID),
+				sendOutput{Status: "queued", PhoneNumber: phone, OutboxID: queued.ID}), nil
+		}
+		if !sent.Success {
+			return errorResult(fmt.Sprintf("Google Messages did not accept the message (status %s)", sent.Status)), nil
+		}
 
-		return textResult(fmt.Sprintf("Message sent to %s: %s", phone, message)), nil
+		return structuredResult(fmt.Sprintf("Message sent to %s: %s", phone, message),
+			sendOutput{Status: "sent", PhoneNumber: phone, ConversationID: sent.ConversationID, MessageID: sent.MessageID}), nil
 	}
 }
@@ -2,6 +2,8 @@ package tools
 
 import (
 	"context"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,7 +12,9 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/auth"
 	"github.com/maxghenis/openmessage/internal/db"
+	"github.com/maxghenis/openmessage/internal/media"
 )
 
 func testApp(t *testing.T) *app.App {
@@ -20,10 +24,12 @@ func testApp(t *testing.T) *app.App {
 		t.Fatalf("create db: %v", err)
 	}
 	t.Cleanup(func() { store.Close() })
-	return &app.App{
+	a := &app.App{
 		Store:  store,
 		Logger: zerolog.Nop(),
 	}
+	a.Sender = app.NewSender(a)
+	return a
 }
 
 func TestRegisterTools(t *testing.T) {
@@ -153,6 +159,76 @@ func TestSearchMessages(t *testing.T) {
 	}
 }
 
+func TestSearchMessagesHighlightsMatch(t *testing.T) {
+	a := testApp(t)
+	now := time.Now().UnixMilli()
+
+	a.Store.UpsertMessage(&db.Message{
+		MessageID: "1", ConversationID: "c1", Body: "Dinner at the Thai place tonight", TimestampMS: now,
+	})
+	a.Store.UpsertMessage(&db.Message{
+		MessageID: "2", ConversationID: "c1", Body: "Work dinner on Friday", TimestampMS: now + 1,
+	})
+
+	handler := searchMessagesHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"query": "dinner -work"}
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "match: «**Dinner** at the Thai place tonight»") {
+		t.Errorf("expected highlighted snippet, got: %s", text)
+	}
+	if contains(text, "Work dinner") {
+		t.Errorf("excluded message should not appear, got: %s", text)
+	}
+
+	// Only exclusions is a usage error, not a failed search.
+	req.Params.Arguments = map[string]any{"query": "-work"}
+	result, err = handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	if !result.IsError {
+		t.Error("expected error for query without positive terms")
+	}
+}
+
+func TestSearchMessagesWithFilters(t *testing.T) {
+	a := testApp(t)
+	now := time.Now().UnixMilli()
+
+	a.Store.UpsertMessage(&db.Message{
+		MessageID: "1", ConversationID: "c1", SenderName: "Alice", Body: "Dinner?", TimestampMS: now,
+	})
+	a.Store.UpsertMessage(&db.Message{
+		MessageID: "2", ConversationID: "c1", IsFromMe: true, Body: "Dinner sounds good", TimestampMS: now + 1,
+	})
+
+	handler := searchMessagesHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"query": "from:me"}
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "Dinner sounds good") || contains(text, "«Dinner?»") {
+		t.Errorf("expected only my message, got: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"query": "has:banana"}
+	result, err = handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	if !result.IsError {
+		t.Error("expected error for unknown has: value")
+	}
+}
+
 func TestListConversations(t *testing.T) {
 	a := testApp(t)
 	now := time.Now().UnixMilli()
@@ -191,6 +267,14 @@ func TestGetConversation(t *testing.T) {
 	a.Store.UpsertMessage(&db.Message{
 		MessageID: "m1", ConversationID: "c1", Body: "Hi there", TimestampMS: now,
 	})
+	a.Store.UpsertMessage(&db.Message{
+		MessageID: "m2", ConversationID: "c1", Body: "See you", TimestampMS: now + 1,
+		IsFromMe: true, Status: "OUTGOING_DISPLAYED",
+	})
+	a.Store.UpsertMessage(&db.Message{
+		MessageID: "m3", ConversationID: "c1", Body: "Hello?", TimestampMS: now + 2,
+		IsFromMe: true, Status: "OUTGOING_FAILED_GENERIC", StatusError: "no signal",
+	})
 
 	handler := getConversationHandler(a)
 
@@ -205,6 +289,11 @@ func TestGetConversation(t *testing.T) {
 	if !contains(text, "Hi there") {
 		t.Errorf("expected 'Hi there', got: %s", text)
 	}
+	for _, want := range []string{"«See you» (read)", "«Hello?» (failed: no signal)"} {
+		if !contains(text, want) {
+			t.Errorf("expected %q, got: %s", want, text)
+		}
+	}
 
 	// Missing conversation_id
 	req.Params.Arguments = map[string]any{}
@@ -257,6 +346,26 @@ func TestGetStatus(t *testing.T) {
 	}
 }
 
+func TestGetStatusReportsNeedsPairing(t *testing.T) {
+	a := testApp(t)
+	sup := app.NewSupervisor(nil, nil, zerolog.Nop())
+	a.SetSupervisor(sup)
+	t.Cleanup(a.Close)
+	sup.GiveUp(app.ErrUnpaired)
+
+	handler := getStatusHandler(a)
+	result, err := handler(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{"Connection: needs_pairing", "pair again", "Last error: unpaired", "gave_up: unpaired"} {
+		if !contains(text, want) {
+			t.Errorf("expected %q in output, got: %s", want, text)
+		}
+	}
+}
+
 func TestListContacts(t *testing.T) {
 	a := testApp(t)
 
@@ -428,6 +537,45 @@ func TestDownloadMediaNotConnected(t *testing.T) {
 	}
 }
 
+func TestDownloadMediaFromCacheWhileDisconnected(t *testing.T) {
+	a := testApp(t)
+	cache, err := media.New(a.Store, t.TempDir(), 0, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Media = cache
+
+	a.Store.UpsertMessage(&db.Message{
+		MessageID: "media-msg", ConversationID: "c1", TimestampMS: time.Now().UnixMilli(),
+		MediaID: "mid-123", MimeType: "audio/ogg", DecryptionKey: "deadbeef",
+	})
+	if err := cache.Put("mid-123", "audio/ogg", []byte("voice note")); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := downloadMediaHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"message_id": "media-msg"}
+
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if result.IsError {
+		t.Fatalf("expected cached media to download offline, got: %s", text)
+	}
+	path := text[strings.LastIndex(text, "\n")+1:]
+	t.Cleanup(func() { os.Remove(path) })
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read downloaded file: %v", err)
+	}
+	if string(data) != "voice note" {
+		t.Errorf("file content: got %q", data)
+	}
+}
+
 func TestDownloadMediaMissingID(t *testing.T) {
 	a := testApp(t)
 
@@ -444,6 +592,58 @@ func TestDownloadMediaMissingID(t *testing.T) {
 	}
 }
 
+func TestBackfillStatus(t *testing.T) {
+	a := testApp(t)
+	handler := backfillStatusHandler(a)
+
+	result, err := handler(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !contains(text, "not available") {
+		t.Errorf("expected 'not available' without a job runner, got: %s", text)
+	}
+
+	a.Backfills = app.NewBackfillJobs(a)
+	if _, err := a.Backfills.StartDeep(); err != app.ErrNotConnected {
+		t.Fatalf("start: got %v, want ErrNotConnected", err)
+	}
+	result, err = handler(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !contains(text, "Backfill: idle") {
+		t.Errorf("expected idle status, got: %s", text)
+	}
+}
+
+func TestSendMessageQueuesWhileDisconnected(t *testing.T) {
+	a := testApp(t)
+	a.Outbox = app.NewOutbox(a)
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"phone_number": "+15551234567", "message": "on my way"}
+	result, err := sendMessageHandler(a)(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if result.IsError || !contains(text, "queued (outbox item 1)") {
+		t.Fatalf("expected queued result, got: %s", text)
+	}
+
+	result, err = listOutboxHandler(a)(context.Background(), mcp.CallToolRequest{})
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text = result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{"#1 [queued] to +15551234567", "on my way", "last error: client not connected"} {
+		if !contains(text, want) {
+			t.Errorf("expected %q in output, got: %s", want, text)
+		}
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsStr(s, substr))
 }
@@ -456,3 +656,182 @@ func containsStr(s, sub string) bool {
 	}
 	return false
 }
+
+func TestScheduleMessage(t *testing.T) {
+	a := testApp(t)
+	a.Scheduler = app.NewScheduler(a)
+	handler := scheduleMessageHandler(a)
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"conversation_id": "c1", "message": "good morning", "send_at": "2001-01-01T09:00:00Z"}
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	if !result.IsError || !contains(result.Content[0].(mcp.TextContent).Text, "must be in the future") {
+		t.Errorf("expected past time error, got: %v", result.Content)
+	}
+
+	sendAt := time.Now().Add(24 * time.Hour).Format("2006-01-02T15:04")
+	req.Params.Arguments = map[string]any{"conversation_id": "c1", "message": "good morning", "send_at": sendAt}
+	result, err = handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if result.IsError || !contains(text, "Message scheduled (#1)") {
+		t.Fatalf("expected scheduled result, got: %s", text)
+	}
+	msgs, _ := a.Store.ListScheduledMessages("c1", db.ScheduledPending, 10)
+	if len(msgs) != 1 || msgs[0].Body != "good morning" {
+		t.Errorf("stored: %+v", msgs)
+	}
+}
+
+func TestReadOnlyTokenCannotSend(t *testing.T) {
+	a := testApp(t)
+	a.Outbox = app.NewOutbox(a)
+	handler := requireSend(sendMessageHandler(a))
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"phone_number": "+15551234567", "message": "on my way"}
+	result, err := handler(auth.WithScope(context.Background(), auth.ScopeRead), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	if !result.IsError || !contains(result.Content[0].(mcp.TextContent).Text, "read-only") {
+		t.Errorf("expected read-only error, got: %v", result.Content)
+	}
+	if items, _ := a.Store.ListOutbox("", 10); len(items) != 0 {
+		t.Errorf("read-only call queued %d messages", len(items))
+	}
+
+	// A send token, or none at all over stdio, gets through.
+	for _, ctx := range []context.Context{auth.WithScope(context.Background(), auth.ScopeSend), context.Background()} {
+		result, _ = handler(ctx, req)
+		if result.IsError {
+			t.Errorf("expected the send to go through, got: %v", result.Content)
+		}
+	}
+}
+
+func TestSendPolicy(t *testing.T) {
+	a := testApp(t)
+	a.Outbox = app.NewOutbox(a)
+	a.Scheduler = app.NewScheduler(a)
+	a.Approvals = app.NewApprovals(a, app.Policy{Mode: app.PolicyApprove, RateLimit: app.RateLimit{PerMinute: 2}})
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"phone_number": "+15551234567", "message": "on my way"}
+	result, _ := sendMessageHandler(a)(context.Background(), req)
+	text := result.Content[0].(mcp.TextContent).Text
+	if result.IsError || !contains(text, "Approval pending") || !contains(text, "approval #1") {
+		t.Errorf("expected a pending approval, got: %s", text)
+	}
+	if items, _ := a.Store.ListOutbox("", 10); len(items) != 0 {
+		t.Errorf("queued %d messages before approval", len(items))
+	}
+
+	// Checked before the policy, so it isn't held.
+	req.Params.Arguments = map[string]any{"conversation_id": "c1", "message": "hi", "send_at": "2001-01-01T09:00:00Z"}
+	if result, _ := scheduleMessageHandler(a)(context.Background(), req); !result.IsError {
+		t.Errorf("expected past time error, got: %v", result.Content)
+	}
+
+	req.Params.Arguments = map[string]any{"conversation_id": "c1", "message": "later", "send_at": time.Now().Add(time.Hour).Format(time.RFC3339)}
+	result, _ = scheduleMessageHandler(a)(context.Background(), req)
+	if text := result.Content[0].(mcp.TextContent).Text; result.IsError || !contains(text, "approval #2") {
+		t.Errorf("expected a pending approval, got: %s", text)
+	}
+	if msgs, _ := a.Store.ListScheduledMessages("", "", 10); len(msgs) != 0 {
+		t.Errorf("scheduled %d messages before approval", len(msgs))
+	}
+
+	result, _ = scheduleMessageHandler(a)(context.Background(), req)
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !contains(text, "rate limit") {
+		t.Errorf("expected a rate limit error, got: %s", text)
+	}
+
+	a.Approvals = app.NewApprovals(a, app.Policy{Mode: app.PolicyAllowlist, Contacts: []string{"+15550000000"}})
+	req.Params.Arguments = map[string]any{"phone_number": "+15551234567", "message": "on my way"}
+	result, _ = sendMessageHandler(a)(context.Background(), req)
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !contains(text, "allowlisted") {
+		t.Errorf("expected an allowlist error, got: %s", text)
+	}
+}
+
+func TestAIAccess(t *testing.T) {
+	a := testApp(t)
+	a.Outbox = app.NewOutbox(a)
+	a.Scheduler = app.NewScheduler(a)
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "family", Name: "Mom", Participants: `[{"name":"Mom","number":"+15551110000"}]`, LastMessageTS: 2000})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "bank", Name: "Bank", Participants: `[{"name":"Bank","number":"+15552220000"}]`, LastMessageTS: 1000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "family", SenderNumber: "+15551110000", Body: "secret photo", TimestampMS: 2000, MediaID: "media-1", MimeType: "image/jpeg"})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "bank", SenderNumber: "+15552220000", Body: "your code is 123456", TimestampMS: 1000})
+	a.Store.SetAIAccess("family", db.AIHidden)
+	a.Store.SetAIAccess("bank", db.AIReadOnly)
+
+	call := func(h server.ToolHandlerFunc, args map[string]any) (string, bool) {
+		t.Helper()
+		req := mcp.CallToolRequest{}
+		req.Params.Arguments = args
+		result, err := h(context.Background(), req)
+		if err != nil {
+			t.Fatalf("handler error: %v", err)
+		}
+		return result.Content[0].(mcp.TextContent).Text, result.IsError
+	}
+
+	// Hidden: in no result at all.
+	for name, text := range map[string]string{
+		"list_conversations": first(call(listConversationsHandler(a), nil)),
+		"get_messages":       first(call(getMessagesHandler(a), nil)),
+		"search_messages":    first(call(searchMessagesHandler(a), map[string]any{"query": "photo"})),
+		"get_conversation":   first(call(getConversationHandler(a), map[string]any{"conversation_id": "family"})),
+		"list_contacts":      first(call(listContactsHandler(a), nil)),
+		"download_media":     first(call(downloadMediaHandler(a), map[string]any{"message_id": "m1"})),
+	} {
+		if contains(text, "Mom") || contains(text, "secret photo") {
+			t.Errorf("%s shows the hidden conversation: %s", name, text)
+		}
+	}
+	if text, isErr := call(draftMessageHandler(a), map[string]any{"conversation_id": "family", "message": "hi"}); !isErr || !contains(text, "not found") {
+		t.Errorf("draft in hidden conversation: %s", text)
+	}
+	if text, isErr := call(sendMessageHandler(a), map[string]any{"phone_number": "+15551110000", "message": "hi"}); !isErr || !contains(text, "not allowed") {
+		t.Errorf("send to hidden number: %s", text)
+	}
+	a.Store.UpsertDraft(&db.Draft{DraftID: "d1", ConversationID: "family", Body: "hi"})
+	later := time.Now().Add(time.Hour).Format(time.RFC3339)
+	for _, args := range []map[string]any{
+		{"draft_id": "d1", "send_at": later},
+		{"draft_id": "d1", "conversation_id": "bank", "send_at": later},
+	} {
+		if text, isErr := call(scheduleMessageHandler(a), args); !isErr || !contains(text, "draft not found") || contains(text, "family") {
+			t.Errorf("schedule a draft in a hidden conversation: %s", text)
+		}
+	}
+
+	// Read-only: readable, but nothing can be sent.
+	if text, _ := call(listConversationsHandler(a), nil); !contains(text, "Bank [read-only]") {
+		t.Errorf("list_conversations: %s", text)
+	}
+	if text, _ := call(getConversationHandler(a), map[string]any{"conversation_id": "bank"}); !contains(text, "123456") {
+		t.Errorf("get_conversation: %s", text)
+	}
+	sendAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	for name, text := range map[string]string{
+		"send_message":     first(call(sendMessageHandler(a), map[string]any{"phone_number": "+1 555 222 0000", "message": "hi"})),
+		"draft_message":    first(call(draftMessageHandler(a), map[string]any{"conversation_id": "bank", "message": "hi"})),
+		"schedule_message": first(call(scheduleMessageHandler(a), map[string]any{"conversation_id": "bank", "message": "hi", "send_at": sendAt})),
+	} {
+		if !contains(text, "read-only") {
+			t.Errorf("%s: %s", name, text)
+		}
+	}
+	if items, _ := a.Store.ListOutbox("", 10); len(items) != 0 {
+		t.Errorf("queued %d messages", len(items))
+	}
+}
+
+func first(s string, _ bool) string { return s }
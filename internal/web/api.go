@@ -2,49 +2,102 @@ package web
 
 import (
 	"embed"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"math/rand"
-	"time"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/auth"
+	"github.com/maxghenis/openmessage/internal/bus"
 	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/db"
+	"github.com/maxghenis/openmessage/internal/media"
 )
 
 //go:embed static/*
 var staticFS embed.FS
 
-// APIHandler creates the HTTP handler with JSON API routes and static file serving.
-// The client may be nil (disconnected state).
-// mcpHandler is an optional http.Handler for the MCP SSE endpoint (mounted at /mcp/).
-// onDeepBackfill is an optional callback triggered by POST /api/backfill.
 // StatusChecker returns whether the backend is connected.
 type StatusChecker func() bool
 
 // UnpairFunc deletes the session and disconnects.
 type UnpairFunc func() error
 
-func APIHandler(store *db.Store, cli *client.Client, logger zerolog.Logger, mcpHandler http.Handler, onDeepBackfill ...func()) http.Handler {
-	return APIHandlerFull(store, cli, logger, mcpHandler, nil, nil, onDeepBackfill...)
+// Config holds everything the HTTP handler serves from. Only Store is
+// required; the rest may be left zero.
+type Config struct {
+	Store *db.Store
+	// Clients provides the current client, resolved on every request so
+	// re-pairing takes effect immediately. It may be nil or hold nil
+	// (disconnected state).
+	Clients *client.Provider
+	Logger  zerolog.Logger
+	// MCPHandler is an optional http.Handler for the MCP endpoint, mounted
+	// at /mcp and /mcp/.
+	MCPHandler  http.Handler
+	IsConnected StatusChecker
+	// Connection, if set, adds reconnect state and history to /api/status.
+	Connection func() app.ConnectionStatus
+	Unpair     UnpairFunc
+	// Backfills, if set, serves /api/backfill: POST starts a backfill, GET
+	// reports its progress and DELETE cancels it.
+	Backfills *app.BackfillJobs
+	// Media, if set, serves attachments from the local cache before
+	// downloading them from Google.
+	Media *media.Cache
+	// Pairer, if set, serves /api/pair/* so a phone can be paired from the
+	// browser.
+	Pairer *app.Pairer
+	// Sender sends messages. If nil, one is made from Store, Clients,
+	// Media and Logger.
+	Sender *app.Sender
+	// Outbox, if set, queues messages that can't be sent right away and
+	// serves /api/outbox.
+	Outbox *app.Outbox
+	// Scheduler, if set, serves /api/scheduled.
+	Scheduler *app.Scheduler
+	// Events, if set, is streamed to the browser at /api/events.
+	Events *bus.Bus
+	// Webhooks, if set, serves /api/webhooks.
+	Webhooks *app.Webhooks
+	// Approvals, if set, serves /api/approvals.
+	Approvals *app.Approvals
+	// Auth, if set, requires an API token or a logged-in session for
+	// everything but the login page.
+	Auth *auth.Auth
+	// Hosts are the names, besides loopback addresses, that requests may
+	// use to reach the server. "*" allows any.
+	Hosts []string
+}
+
+// APIHandler creates the HTTP handler with JSON API routes and static file serving.
+// The client may be nil (disconnected state).
+func APIHandler(store *db.Store, cli *client.Client, logger zerolog.Logger, mcpHandler http.Handler) http.Handler {
+	return APIHandlerFull(Config{Store: store, Clients: client.NewProvider(cli), Logger: logger, MCPHandler: mcpHandler})
 }
 
-func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger, mcpHandler http.Handler, isConnected StatusChecker, unpair UnpairFunc, onDeepBackfill ...func()) http.Handler {
+func APIHandlerFull(cfg Config) http.Handler {
+	store, clients, logger, mcpHandler := cfg.Store, cfg.Clients, cfg.Logger, cfg.MCPHandler
 	mux := http.NewServeMux()
+	sender := cfg.Sender
+	if sender == nil {
+		sender = app.NewSender(&app.App{Store: store, Clients: clients, Media: cfg.Media, Logger: logger})
+	}
 
 	_ = mcpHandler // used in the return wrapper below
 
 	mux.HandleFunc("/api/conversations", func(w http.ResponseWriter, r *http.Request) {
 		limit := queryInt(r, "limit", 50)
-		convos, err := store.ListConversations(limit)
+		convos, err := storeFor(r, store).ListConversations(limit)
 		if err != nil {
 			httpError(w, "list conversations: "+err.Error(), 500)
 			return
@@ -56,16 +109,20 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 	})
 
 	mux.HandleFunc("/api/conversations/", func(w http.ResponseWriter, r *http.Request) {
-		// Parse: /api/conversations/{id}/messages
+		// Parse: /api/conversations/{id}/messages or /api/conversations/{id}/ai-access
 		path := strings.TrimPrefix(r.URL.Path, "/api/conversations/")
 		parts := strings.SplitN(path, "/", 2)
+		if len(parts) == 2 && parts[1] == "ai-access" {
+			handleAIAccess(w, r, store, parts[0])
+			return
+		}
 		if len(parts) != 2 || parts[1] != "messages" {
 			httpError(w, "not found", 404)
 			return
 		}
 		convID := parts[0]
 		limit := queryInt(r, "limit", 100)
-		msgs, err := store.GetMessagesByConversation(convID, limit)
+		msgs, err := storeFor(r, store).GetMessagesByConversation(convID, limit)
 		if err != nil {
 			httpError(w, "get messages: "+err.Error(), 500)
 			return
@@ -83,15 +140,19 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			return
 		}
 		limit := queryInt(r, "limit", 50)
-		msgs, err := store.SearchMessages(q, "", limit)
+		results, err := storeFor(r, store).SearchMessages(q, "", limit)
+		if errors.Is(err, db.ErrNoSearchTerms) || errors.Is(err, db.ErrInvalidSearch) {
+			httpError(w, err.Error(), 400)
+			return
+		}
 		if err != nil {
 			httpError(w, "search: "+err.Error(), 500)
 			return
 		}
-		if msgs == nil {
-			msgs = []*db.Message{}
+		if results == nil {
+			results = []*db.SearchResult{}
 		}
-		writeJSON(w, msgs)
+		writeJSON(w, results)
 	})
 
 	mux.HandleFunc("/api/send", func(w http.ResponseWriter, r *http.Request) {
@@ -112,71 +173,14 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "conversation_id and message are required", 400)
 			return
 		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
-			return
-		}
-		// Fetch conversation to get SIM and participant info
-		conv, err := cli.GM.GetConversation(req.ConversationID)
-		if err != nil {
-			httpError(w, "get conversation: "+err.Error(), 502)
+		if refuseAgentWrite(w, r, store, req.ConversationID) ||
+			holdAgentSend(w, r, cfg.Approvals, app.AgentSend{ConversationID: req.ConversationID, Body: req.Message}) {
 			return
 		}
-
-		// Find our participant ID and SIM payload
-		var myParticipantID string
-		var simPayload *gmproto.SIMPayload
-		for _, p := range conv.GetParticipants() {
-			if p.GetIsMe() {
-				if id := p.GetID(); id != nil {
-					myParticipantID = id.GetNumber()
-				}
-				simPayload = p.GetSimPayload()
-				break
-			}
-		}
-
-		// Also try SIM card from conversation itself
-		var convSIMPayload *gmproto.SIMPayload
-		if sc := conv.GetSimCard(); sc != nil {
-			convSIMPayload = sc.GetSIMData().GetSIMPayload()
-		}
-		if simPayload == nil {
-			simPayload = convSIMPayload
-		}
-
-		payload := BuildSendPayload(req.ConversationID, req.Message, req.ReplyToID, myParticipantID, simPayload)
-
-		logger.Info().
-			Str("conv_id", req.ConversationID).
-			Str("participant_id", myParticipantID).
-			Bool("has_sim", simPayload != nil).
-			Msg("Sending message")
-
-		resp, err := cli.GM.SendMessage(payload)
-		if err != nil {
-			httpError(w, "send message: "+err.Error(), 502)
-			return
-		}
-		success := resp.GetStatus() == gmproto.SendMessageResponse_SUCCESS
-		if success {
-			// Store sent message in DB immediately so UI shows it
-			now := time.Now().UnixMilli()
-			store.UpsertMessage(&db.Message{
-				MessageID:      payload.TmpID,
-				ConversationID: req.ConversationID,
-				Body:           req.Message,
-				IsFromMe:       true,
-				TimestampMS:    now,
-				Status:         "OUTGOING_SENDING",
-				ReplyToID:      req.ReplyToID,
-			})
-			// Bump conversation to top of list
-			store.UpdateConversationTimestamp(req.ConversationID, now)
-		}
-		writeJSON(w, map[string]any{
-			"status":  resp.GetStatus().String(),
-			"success": success,
+		sendMessage(w, sender, cfg.Outbox, app.SendRequest{
+			ConversationID: req.ConversationID,
+			Body:           req.Message,
+			ReplyToID:      req.ReplyToID,
 		})
 	})
 
@@ -185,11 +189,12 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "method not allowed", 405)
 			return
 		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
+		// Attachments can't be held for approval, so API tokens can't send
+		// them whatever the send policy.
+		if fromToken(r) {
+			httpError(w, "attachments are sent from the web UI, not with an API token", 403)
 			return
 		}
-
 		// Parse multipart form (max 10MB)
 		if err := r.ParseMultipartForm(10 << 20); err != nil {
 			httpError(w, "invalid multipart form: "+err.Error(), 400)
@@ -201,6 +206,9 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "conversation_id is required", 400)
 			return
 		}
+		if refuseAgentWrite(w, r, store, convID) {
+			return
+		}
 
 		file, header, err := r.FormFile("file")
 		if err != nil {
@@ -220,70 +228,9 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			mime = "application/octet-stream"
 		}
 
-		// Upload media via libgm
-		media, err := cli.GM.UploadMedia(data, header.Filename, mime)
-		if err != nil {
-			httpError(w, "upload media: "+err.Error(), 502)
-			return
-		}
-
-		// Get SIM and participant info
-		conv, err := cli.GM.GetConversation(convID)
-		if err != nil {
-			httpError(w, "get conversation: "+err.Error(), 502)
-			return
-		}
-
-		var myParticipantID string
-		var simPayload *gmproto.SIMPayload
-		for _, p := range conv.GetParticipants() {
-			if p.GetIsMe() {
-				if id := p.GetID(); id != nil {
-					myParticipantID = id.GetNumber()
-				}
-				simPayload = p.GetSimPayload()
-				break
-			}
-		}
-		if simPayload == nil {
-			if sc := conv.GetSimCard(); sc != nil {
-				simPayload = sc.GetSIMData().GetSIMPayload()
-			}
-		}
-
-		payload := BuildSendMediaPayload(convID, media, myParticipantID, simPayload)
-
-		logger.Info().
-			Str("conv_id", convID).
-			Str("mime", mime).
-			Str("filename", header.Filename).
-			Int("size", len(data)).
-			Msg("Sending media message")
-
-		resp, err := cli.GM.SendMessage(payload)
-		if err != nil {
-			httpError(w, "send message: "+err.Error(), 502)
-			return
-		}
-		success := resp.GetStatus() == gmproto.SendMessageResponse_SUCCESS
-		if success {
-			now := time.Now().UnixMilli()
-			store.UpsertMessage(&db.Message{
-				MessageID:      payload.TmpID,
-				ConversationID: convID,
-				Body:           "",
-				IsFromMe:       true,
-				TimestampMS:    now,
-				Status:         "OUTGOING_SENDING",
-				MediaID:        media.MediaID,
-				MimeType:       media.MimeType,
-				DecryptionKey:  hex.EncodeToString(media.DecryptionKey),
-			})
-			store.UpdateConversationTimestamp(convID, now)
-		}
-		writeJSON(w, map[string]any{
-			"status":  resp.GetStatus().String(),
-			"success": success,
+		sendMessage(w, sender, cfg.Outbox, app.SendRequest{
+			ConversationID: convID,
+			Media:          &app.OutgoingMedia{Data: data, Filename: header.Filename, MimeType: mime},
 		})
 	})
 
@@ -293,7 +240,7 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "message_id required", 400)
 			return
 		}
-		msg, err := store.GetMessageByID(msgID)
+		msg, err := storeFor(r, store).GetMessageByID(msgID)
 		if err != nil {
 			httpError(w, "get message: "+err.Error(), 500)
 			return
@@ -302,17 +249,15 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "no media for this message", 404)
 			return
 		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
-			return
+		var download media.Downloader
+		if cli := clients.Get(); cli != nil {
+			download = cli.GM.DownloadMedia
 		}
-		// Decode hex decryption key
-		key, err := hex.DecodeString(msg.DecryptionKey)
-		if err != nil {
-			httpError(w, "invalid decryption key", 500)
+		data, err := cfg.Media.Fetch(msg, download)
+		if errors.Is(err, media.ErrNotCached) {
+			httpError(w, "not connected to Google Messages", 503)
 			return
 		}
-		data, err := cli.GM.DownloadMedia(msg.MediaID, key)
 		if err != nil {
 			httpError(w, "download media: "+err.Error(), 502)
 			return
@@ -322,6 +267,40 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 		w.Write(data)
 	})
 
+	mux.HandleFunc("/api/messages/", func(w http.ResponseWriter, r *http.Request) {
+		// Parse: /api/messages/{id}/status
+		msgID, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/messages/"), "/")
+		if msgID == "" || rest != "status" {
+			httpError(w, "not found", 404)
+			return
+		}
+		msg, err := storeFor(r, store).GetMessageByID(msgID)
+		if err != nil {
+			httpError(w, "get message: "+err.Error(), 500)
+			return
+		}
+		if msg == nil {
+			httpError(w, "message not found", 404)
+			return
+		}
+		history, err := store.GetStatusHistory(msgID)
+		if err != nil {
+			httpError(w, "get status history: "+err.Error(), 500)
+			return
+		}
+		if history == nil {
+			history = []*db.StatusChange{}
+		}
+		writeJSON(w, map[string]any{
+			"message_id": msg.MessageID,
+			"status":     msg.DeliveryStatus,
+			"raw_status": msg.Status,
+			"error":      msg.StatusError,
+			"status_at":  msg.StatusAt,
+			"history":    history,
+		})
+	})
+
 	mux.HandleFunc("/api/react", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
@@ -341,6 +320,17 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "message_id and emoji are required", 400)
 			return
 		}
+		if fromToken(r) {
+			// Check the conversation the message is in, not just the one named.
+			convID := req.ConversationID
+			if m, err := store.GetMessageByID(req.MessageID); err == nil && m != nil {
+				convID = m.ConversationID
+			}
+			if refuseAgentWrite(w, r, store, convID) {
+				return
+			}
+		}
+		cli := clients.Get()
 		if cli == nil {
 			httpError(w, "not connected to Google Messages", 503)
 			return
@@ -383,6 +373,7 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "phone_number is required", 400)
 			return
 		}
+		cli := clients.Get()
 		if cli == nil {
 			httpError(w, "not connected to Google Messages", 503)
 			return
@@ -408,6 +399,9 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 		}
 
 		convoID := conv.GetConversationID()
+		if refuseAgentWrite(w, r, store, convoID) {
+			return
+		}
 		name := req.PhoneNumber
 		// Try to get a name from participants
 		for _, p := range conv.GetParticipants() {
@@ -450,6 +444,9 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "conversation_id is required", 400)
 			return
 		}
+		if refuseAgentWrite(w, r, store, req.ConversationID) {
+			return
+		}
 		if err := store.MarkConversationRead(req.ConversationID); err != nil {
 			httpError(w, "mark read: "+err.Error(), 500)
 			return
@@ -463,7 +460,7 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "conversation_id is required", 400)
 			return
 		}
-		drafts, err := store.ListDrafts(conversationID)
+		drafts, err := storeFor(r, store).ListDrafts(conversationID)
 		if err != nil {
 			httpError(w, "list drafts: "+err.Error(), 500)
 			return
@@ -491,13 +488,8 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "draft_id and body are required", 400)
 			return
 		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
-			return
-		}
-
 		// Look up the draft to get conversation_id
-		draft, err := store.GetDraft(req.DraftID)
+		draft, err := storeFor(r, store).GetDraft(req.DraftID)
 		if err != nil {
 			httpError(w, "get draft: "+err.Error(), 500)
 			return
@@ -506,61 +498,17 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "draft not found", 404)
 			return
 		}
-
-		// Use the same send logic as /api/send
-		conv, err := cli.GM.GetConversation(draft.ConversationID)
-		if err != nil {
-			httpError(w, "get conversation: "+err.Error(), 502)
+		if refuseAgentWrite(w, r, store, draft.ConversationID) ||
+			holdAgentSend(w, r, cfg.Approvals, app.AgentSend{ConversationID: draft.ConversationID, Body: req.Body, DraftID: req.DraftID}) {
 			return
 		}
 
-		var myParticipantID string
-		var simPayload *gmproto.SIMPayload
-		for _, p := range conv.GetParticipants() {
-			if p.GetIsMe() {
-				if id := p.GetID(); id != nil {
-					myParticipantID = id.GetNumber()
-				}
-				simPayload = p.GetSimPayload()
-				break
-			}
-		}
-		if simPayload == nil {
-			if sc := conv.GetSimCard(); sc != nil {
-				simPayload = sc.GetSIMData().GetSIMPayload()
-			}
-		}
-
-		payload := BuildSendPayload(draft.ConversationID, req.Body, "", myParticipantID, simPayload)
-
-		logger.Info().
-			Str("conv_id", draft.ConversationID).
-			Str("draft_id", req.DraftID).
-			Msg("Sending draft message")
-
-		resp, err := cli.GM.SendMessage(payload)
-		if err != nil {
-			httpError(w, "send message: "+err.Error(), 502)
-			return
-		}
-		success := resp.GetStatus() == gmproto.SendMessageResponse_SUCCESS
-		if success {
-			now := time.Now().UnixMilli()
-			store.UpsertMessage(&db.Message{
-				MessageID:      payload.TmpID,
-				ConversationID: draft.ConversationID,
-				Body:           req.Body,
-				IsFromMe:       true,
-				TimestampMS:    now,
-				Status:         "OUTGOING_SENDING",
-			})
-			store.UpdateConversationTimestamp(draft.ConversationID, now)
+		if sendMessage(w, sender, cfg.Outbox, app.SendRequest{
+			ConversationID: draft.ConversationID,
+			Body:           req.Body,
+		}) {
 			store.DeleteDraft(req.DraftID)
 		}
-		writeJSON(w, map[string]any{
-			"status":  resp.GetStatus().String(),
-			"success": success,
-		})
 	})
 
 	mux.HandleFunc("/api/drafts/", func(w http.ResponseWriter, r *http.Request) {
@@ -573,6 +521,16 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "draft_id required", 400)
 			return
 		}
+		if fromToken(r) {
+			draft, err := store.GetDraft(draftID)
+			if err != nil {
+				httpError(w, "get draft: "+err.Error(), 500)
+				return
+			}
+			if draft != nil && refuseAgentWrite(w, r, store, draft.ConversationID) {
+				return
+			}
+		}
 		if err := store.DeleteDraft(draftID); err != nil {
 			httpError(w, "delete draft: "+err.Error(), 500)
 			return
@@ -581,26 +539,89 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 	})
 
 	mux.HandleFunc("/api/backfill", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
+		jobs := cfg.Backfills
+		if jobs == nil {
+			httpError(w, "backfill not available", 501)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, jobs.Progress())
+			return
+		case http.MethodDelete:
+			if !jobs.Cancel() {
+				httpError(w, "no backfill is running", 409)
+				return
+			}
+			writeJSON(w, jobs.Progress())
+			return
+		case http.MethodPost:
+		default:
 			httpError(w, "method not allowed", 405)
 			return
 		}
-		if len(onDeepBackfill) > 0 && onDeepBackfill[0] != nil {
-			go onDeepBackfill[0]()
-			writeJSON(w, map[string]string{"status": "started"})
+
+		var req struct {
+			ConversationID string `json:"conversation_id"`
+			// Until is a date (YYYY-MM-DD) or RFC 3339 time; empty means
+			// the whole history.
+			Until string `json:"until"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				httpError(w, "invalid JSON: "+err.Error(), 400)
+				return
+			}
+		}
+		var until time.Time
+		if req.Until != "" {
+			if req.ConversationID == "" {
+				httpError(w, "until requires conversation_id", 400)
+				return
+			}
+			var err error
+			if until, err = parseDate(req.Until); err != nil {
+				httpError(w, "invalid until: "+err.Error(), 400)
+				return
+			}
+		}
+
+		var progress app.BackfillProgress
+		var err error
+		if req.ConversationID == "" {
+			progress, err = jobs.StartDeep()
 		} else {
-			httpError(w, "deep backfill not available", 501)
+			progress, err = jobs.StartConversation(req.ConversationID, until)
+		}
+		switch {
+		case errors.Is(err, app.ErrBackfillRunning):
+			httpError(w, err.Error(), 409)
+		case errors.Is(err, app.ErrNotConnected):
+			httpError(w, "not connected to Google Messages", 503)
+		case err != nil:
+			httpError(w, "start backfill: "+err.Error(), 500)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(202)
+			json.NewEncoder(w).Encode(progress)
 		}
 	})
 
 	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
-		connected := cli != nil
-		if isConnected != nil {
-			connected = isConnected()
+		connected := clients.Get() != nil
+		if cfg.IsConnected != nil {
+			connected = cfg.IsConnected()
 		}
-		writeJSON(w, map[string]any{
+		status := map[string]any{
 			"connected": connected,
-		})
+		}
+		if cfg.Connection != nil {
+			status["connection"] = cfg.Connection()
+		}
+		if cfg.Pairer != nil {
+			status["pairing"] = cfg.Pairer.Status()
+		}
+		writeJSON(w, status)
 	})
 
 	mux.HandleFunc("/api/unpair", func(w http.ResponseWriter, r *http.Request) {
@@ -608,8 +629,8 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			httpError(w, "method not allowed", 405)
 			return
 		}
-		if unpair != nil {
-			if err := unpair(); err != nil {
+		if cfg.Unpair != nil {
+			if err := cfg.Unpair(); err != nil {
 				httpError(w, "unpair: "+err.Error(), 500)
 				return
 			}
@@ -617,6 +638,13 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 		writeJSON(w, map[string]string{"status": "ok"})
 	})
 
+	registerPairRoutes(mux, cfg.Pairer)
+	registerOutboxRoutes(mux, store, cfg.Outbox)
+	registerScheduledRoutes(mux, store, cfg.Scheduler, cfg.Approvals)
+	registerEventRoutes(mux, store, cfg.Events)
+	registerWebhookRoutes(mux, store, cfg.Webhooks)
+	registerApprovalRoutes(mux, store, cfg.Approvals)
+
 	// Serve embedded static files at root
 	staticContent, err := fs.Sub(staticFS, "static")
 	if err != nil {
@@ -624,70 +652,31 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 	}
 	staticHandler := http.FileServer(http.FS(staticContent))
 	mux.Handle("/", staticHandler)
+	if cfg.Auth != nil {
+		registerAuthRoutes(mux, cfg.Auth, staticContent)
+	}
 
-	// Wrap the mux to intercept /mcp/ requests before the mux's catch-all
+	// Wrap the mux to intercept /mcp requests before the mux's catch-all
+	var handler http.Handler = mux
 	if mcpHandler != nil {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if strings.HasPrefix(r.URL.Path, "/mcp/") {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isMCP(r.URL.Path) {
 				mcpHandler.ServeHTTP(w, r)
 				return
 			}
 			mux.ServeHTTP(w, r)
 		})
 	}
-
-	return mux
-}
-
-// BuildSendPayload constructs a SendMessageRequest matching the format used by
-// the mautrix bridge: MessageInfo array (not MessagePayloadContent), TmpID in 3
-// places, SIMPayload, and ParticipantID.
-func BuildSendPayload(conversationID, message, replyToID, participantID string, sim *gmproto.SIMPayload) *gmproto.SendMessageRequest {
-	tmpID := fmt.Sprintf("tmp_%012d", rand.Int63n(1e12))
-	req := &gmproto.SendMessageRequest{
-		ConversationID: conversationID,
-		MessagePayload: &gmproto.MessagePayload{
-			TmpID:                 tmpID,
-			MessagePayloadContent: nil,
-			MessageInfo: []*gmproto.MessageInfo{{
-				Data: &gmproto.MessageInfo_MessageContent{MessageContent: &gmproto.MessageContent{
-					Content: message,
-				}},
-			}},
-			ConversationID: conversationID,
-			ParticipantID:  participantID,
-			TmpID2:         tmpID,
-		},
-		SIMPayload: sim,
-		TmpID:      tmpID,
+	if cfg.Auth != nil {
+		handler = requireAuth(cfg.Auth, requireCSRF(cfg.Auth, handler))
 	}
-	if replyToID != "" {
-		req.Reply = &gmproto.ReplyPayload{
-			MessageID: replyToID,
-		}
-	}
-	return req
+	return checkOrigin(cfg.Hosts, handler)
 }
 
-// BuildSendMediaPayload constructs a SendMessageRequest with a MediaContent attachment
-// instead of text. Uses the same MessageInfo array format as BuildSendPayload.
-func BuildSendMediaPayload(conversationID string, media *gmproto.MediaContent, participantID string, sim *gmproto.SIMPayload) *gmproto.SendMessageRequest {
-	tmpID := fmt.Sprintf("tmp_%012d", rand.Int63n(1e12))
-	return &gmproto.SendMessageRequest{
-		ConversationID: conversationID,
-		MessagePayload: &gmproto.MessagePayload{
-			TmpID:                 tmpID,
-			MessagePayloadContent: nil,
-			MessageInfo: []*gmproto.MessageInfo{{
-				Data: &gmproto.MessageInfo_MediaContent{MediaContent: media},
-			}},
-			ConversationID: conversationID,
-			ParticipantID:  participantID,
-			TmpID2:         tmpID,
-		},
-		SIMPayload: sim,
-		TmpID:      tmpID,
-	}
+// isMCP reports whether path belongs to the MCP endpoint: /mcp for
+// streamable HTTP, or under /mcp/ for SSE.
+func isMCP(path string) bool {
+	return path == "/mcp" || strings.HasPrefix(path, "/mcp/")
 }
 
 // BuildReactionPayload constructs a SendReactionRequest using gmproto.MakeReactionData
@@ -710,6 +699,41 @@ func BuildReactionPayload(messageID, emoji, action string, sim *gmproto.SIMPaylo
 	}
 }
 
+// sendMessage sends req and writes the result, mapping errors to status
+// codes. With an outbox, a message that can't go out now is queued and
+// answered with 202. It reports whether the message was sent or queued.
+func sendMessage(w http.ResponseWriter, sender *app.Sender, outbox *app.Outbox, req app.SendRequest) bool {
+	var result *app.SendResult
+	var queued *db.OutboxItem
+	var err error
+	if outbox != nil {
+		result, queued, err = outbox.Submit(req)
+	} else {
+		result, err = sender.Send(req)
+	}
+	switch {
+	case errors.Is(err, app.ErrNotConnected):
+		httpError(w, "not connected to Google Messages", 503)
+	case errors.Is(err, app.ErrNoRecipient), errors.Is(err, app.ErrEmptyMessage):
+		httpError(w, err.Error(), 400)
+	case err != nil:
+		httpError(w, err.Error(), 502)
+	case queued != nil:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(202)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":    db.OutboxQueued,
+			"queued":    true,
+			"outbox_id": queued.ID,
+		})
+		return true
+	default:
+		writeJSON(w, result)
+		return result.Success
+	}
+	return false
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(v)
@@ -721,6 +745,98 @@ func httpError(w http.ResponseWriter, msg string, code int) {
 	json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
+// storeFor returns the store to read for r: with an API token, the agent
+// view, which leaves out conversations hidden from AI as the MCP tools do.
+func storeFor(r *http.Request, store *db.Store) *db.Store {
+	if fromToken(r) {
+		return store.AgentView()
+	}
+	return store
+}
+
+// refuseAgentWrite answers a request made with an API token to send to or
+// change a conversation that is hidden from AI, as if it didn't exist, or
+// read-only for AI. It reports whether it did.
+func refuseAgentWrite(w http.ResponseWriter, r *http.Request, store *db.Store, convID string) bool {
+	if !fromToken(r) {
+		return false
+	}
+	access, err := store.AIAccess(convID)
+	switch {
+	case err != nil:
+		httpError(w, "get ai_access: "+err.Error(), 500)
+	case access == db.AIHidden:
+		httpError(w, "conversation not found", 404)
+	case access == db.AIReadOnly:
+		httpError(w, "conversation is read-only for AI; only the user can send to it", 403)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleAIAccess reads (GET) or sets (PUT, with {"ai_access": "hidden"})
+// what MCP clients may do with a conversation. Like deciding approvals,
+// setting it needs a browser session, so that an agent holding an API
+// token can't lift it.
+func handleAIAccess(w http.ResponseWriter, r *http.Request, store *db.Store, convID string) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if fromToken(r) {
+			httpError(w, "AI access is set in the web UI, not with an API token", 403)
+			return
+		}
+		var req struct {
+			AIAccess string `json:"ai_access"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		switch req.AIAccess {
+		case db.AIHidden, db.AIReadOnly, db.AIReadWrite:
+		default:
+			httpError(w, fmt.Sprintf("ai_access must be %q, %q or %q", db.AIHidden, db.AIReadOnly, db.AIReadWrite), 400)
+			return
+		}
+		ok, err := store.SetAIAccess(convID, req.AIAccess)
+		if err != nil {
+			httpError(w, "set ai_access: "+err.Error(), 500)
+			return
+		}
+		if !ok {
+			httpError(w, "conversation not found", 404)
+			return
+		}
+	default:
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	access, err := store.AIAccess(convID)
+	if err != nil {
+		httpError(w, "get ai_access: "+err.Error(), 500)
+		return
+	}
+	if access == db.AIHidden && fromToken(r) {
+		httpError(w, "conversation not found", 404)
+		return
+	}
+	writeJSON(w, map[string]string{"conversation_id": convID, "ai_access": access})
+}
+
+// parseDate accepts a date, read as midnight UTC, or an RFC 3339 time.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want YYYY-MM-DD or RFC 3339, got %q", s)
+	}
+	return t, nil
+}
+
 func queryInt(r *http.Request, key string, defaultVal int) int {
 	s := r.URL.Query().Get(key)
 	if s == "" {
@@ -2,15 +2,21 @@ package web
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/db"
+	"github.com/maxghenis/openmessage/internal/media"
 )
 
 type testServer struct {
@@ -187,6 +193,95 @@ func TestSearchMessages(t *testing.T) {
 	}
 }
 
+func TestSearchReturnsSnippets(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.store.UpsertMessage(&db.Message{
+		MessageID: "m1", ConversationID: "c1", Body: "lunch tomorrow?",
+		TimestampMS: 100,
+	})
+
+	resp, err := http.Get(ts.server.URL + "/api/search?q=" + url.QueryEscape(`"lunch tomorrow"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	var results []db.SearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	want := db.SnippetOpen + "lunch tomorrow" + db.SnippetClose + "?"
+	if results[0].Snippet != want {
+		t.Fatalf("got snippet %q, want %q", results[0].Snippet, want)
+	}
+}
+
+func TestSearchRejectsExclusionOnlyQuery(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, err := http.Get(ts.server.URL + "/api/search?q=-spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 400 {
+		t.Fatalf("got status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestSearchWithOperators(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Sarah Chen"})
+	ts.store.UpsertMessage(&db.Message{
+		MessageID: "m1", ConversationID: "c1", SenderName: "Sarah Chen", Body: "photo from dinner",
+		MediaID: "media1", MimeType: "image/jpeg", TimestampMS: 100,
+	})
+	ts.store.UpsertMessage(&db.Message{
+		MessageID: "m2", ConversationID: "c1", SenderName: "Sarah Chen", Body: "dinner?",
+		TimestampMS: 200,
+	})
+
+	resp, err := http.Get(ts.server.URL + "/api/search?q=" + url.QueryEscape(`from:"Sarah Chen" has:image`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	var results []db.SearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].MessageID != "m1" {
+		t.Fatalf("got %+v, want only m1", results)
+	}
+}
+
+func TestSearchRejectsInvalidOperator(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, err := http.Get(ts.server.URL + "/api/search?q=" + url.QueryEscape("after:yesterday dinner"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 400 {
+		t.Fatalf("got status %d, want 400", resp.StatusCode)
+	}
+}
+
 func TestSearchRequiresQuery(t *testing.T) {
 	ts := newTestServer(t)
 
@@ -305,6 +400,92 @@ func TestGetStatus(t *testing.T) {
 	}
 }
 
+func TestStatusReportsReconnectHistory(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	next := time.Now().Add(time.Minute)
+	srv := httptest.NewServer(APIHandlerFull(Config{
+		Store:       store,
+		Logger:      zerolog.Nop(),
+		IsConnected: func() bool { return false },
+		Connection: func() app.ConnectionStatus {
+			return app.ConnectionStatus{
+				State:         app.StateReconnecting,
+				LastError:     "http 502 while polling",
+				Attempt:       2,
+				NextAttemptAt: &next,
+				History: []app.ReconnectEvent{
+					{Time: time.Now(), Kind: "disconnected", Error: "http 502 while polling"},
+					{Time: time.Now(), Kind: "retry_failed", Attempt: 1, Error: "http 502 while polling"},
+				},
+			}
+		},
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Connected  bool                 `json:"connected"`
+		Connection app.ConnectionStatus `json:"connection"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Connected {
+		t.Error("expected connected=false")
+	}
+	if status.Connection.State != app.StateReconnecting || status.Connection.Attempt != 2 {
+		t.Errorf("connection: got %+v", status.Connection)
+	}
+	if len(status.Connection.History) != 2 || status.Connection.History[1].Kind != "retry_failed" {
+		t.Errorf("history: got %+v", status.Connection.History)
+	}
+}
+
+func TestHandlersResolveClientPerRequest(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	clients := client.NewProvider(nil)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Clients: clients, Logger: zerolog.Nop()}))
+	defer srv.Close()
+
+	connected := func() bool {
+		resp, err := http.Get(srv.URL + "/api/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status["connected"] == true
+	}
+
+	if connected() {
+		t.Fatal("expected disconnected before pairing")
+	}
+	clients.Set(client.NewForPairing(zerolog.Nop()))
+	if !connected() {
+		t.Fatal("handler should see a client set after it was built")
+	}
+	clients.Set(nil)
+	if connected() {
+		t.Fatal("handler should see the client cleared by unpair")
+	}
+}
+
 func TestGetMediaReturns404WhenNoMedia(t *testing.T) {
 	ts := newTestServer(t)
 
@@ -442,6 +623,51 @@ func TestMessagesIncludeReactionsAndReplyTo(t *testing.T) {
 	}
 }
 
+func TestMessagesIncludeDeliveryStatus(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, raw := range []string{"OUTGOING_COMPLETE", "OUTGOING_FAILED_GENERIC"} {
+		ts.store.UpsertMessage(&db.Message{
+			MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000,
+			IsFromMe: true, Status: raw, StatusError: "no signal",
+		})
+	}
+
+	resp, err := http.Get(ts.server.URL + "/api/conversations/c1/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []map[string]any
+	json.NewDecoder(resp.Body).Decode(&msgs)
+	resp.Body.Close()
+	if len(msgs) != 1 || msgs[0]["DeliveryStatus"] != "failed" || msgs[0]["StatusError"] != "no signal" {
+		t.Fatalf("messages: %v", msgs)
+	}
+
+	resp, err = http.Get(ts.server.URL + "/api/messages/m1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status struct {
+		Status  string
+		History []db.StatusChange
+	}
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || status.Status != "failed" || len(status.History) != 2 || status.History[0].Status != "sent" {
+		t.Errorf("status: %d %+v", resp.StatusCode, status)
+	}
+
+	resp, err = http.Get(ts.server.URL + "/api/messages/nope/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing message: got %d, want 404", resp.StatusCode)
+	}
+}
+
 func TestBuildReactionPayload(t *testing.T) {
 	sim := &gmproto.SIMPayload{SIMNumber: 1}
 
@@ -504,193 +730,327 @@ func TestSendReactionNoClient(t *testing.T) {
 	}
 }
 
-func TestBuildSendPayload(t *testing.T) {
-	sim := &gmproto.SIMPayload{SIMNumber: 1}
-	payload := BuildSendPayload("conv-1", "Hello world", "", "+15551234567", sim)
-
-	// Must use MessageInfo array (not MessagePayloadContent)
-	if payload.MessagePayload.MessagePayloadContent != nil {
-		t.Error("MessagePayloadContent must be nil; use MessageInfo instead")
-	}
-	if len(payload.MessagePayload.MessageInfo) != 1 {
-		t.Fatalf("expected 1 MessageInfo entry, got %d", len(payload.MessagePayload.MessageInfo))
-	}
-	mc := payload.MessagePayload.MessageInfo[0].GetMessageContent()
-	if mc == nil || mc.Content != "Hello world" {
-		t.Errorf("MessageContent mismatch: %+v", mc)
-	}
+func TestSendMediaEndpointNoClient(t *testing.T) {
+	ts := newTestServer(t)
 
-	// TmpID format: tmp_ followed by 12 digits
-	if !strings.HasPrefix(payload.TmpID, "tmp_") || len(payload.TmpID) != 16 {
-		t.Errorf("TmpID format wrong: %q (want tmp_ + 12 digits)", payload.TmpID)
-	}
-	// TmpID must be in all 3 places
-	if payload.MessagePayload.TmpID != payload.TmpID {
-		t.Error("MessagePayload.TmpID must match root TmpID")
-	}
-	if payload.MessagePayload.TmpID2 != payload.TmpID {
-		t.Error("MessagePayload.TmpID2 must match root TmpID")
+	// Multipart form with image data
+	body := strings.NewReader("")
+	resp, err := http.Post(ts.server.URL+"/api/send-media", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer resp.Body.Close()
 
-	// SIM payload must be set
-	if payload.SIMPayload == nil {
-		t.Error("SIMPayload must not be nil")
-	}
-	if payload.SIMPayload.SIMNumber != 1 {
-		t.Errorf("SIMNumber = %d, want 1", payload.SIMPayload.SIMNumber)
+	// Should return 405 for GET or 400/503 for POST without proper body
+	if resp.StatusCode != 400 && resp.StatusCode != 503 {
+		t.Fatalf("got status %d, want 400 or 503", resp.StatusCode)
 	}
+}
 
-	// ParticipantID
-	if payload.MessagePayload.ParticipantID != "+15551234567" {
-		t.Errorf("ParticipantID = %q, want +15551234567", payload.MessagePayload.ParticipantID)
-	}
+func TestSendMediaRefusesAPITokens(t *testing.T) {
+	// No send policy is configured, and attachments are still refused.
+	ts := newTestServer(t)
 
-	// ConversationID in both places
-	if payload.ConversationID != "conv-1" {
-		t.Errorf("root ConversationID = %q", payload.ConversationID)
+	req, _ := http.NewRequest("POST", ts.server.URL+"/api/send-media", strings.NewReader(""))
+	req.Header.Set("Authorization", "Bearer om_x")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if payload.MessagePayload.ConversationID != "conv-1" {
-		t.Errorf("payload ConversationID = %q", payload.MessagePayload.ConversationID)
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("got status %d, want 403", resp.StatusCode)
 	}
 }
 
-func TestBuildSendPayloadWithReply(t *testing.T) {
-	payload := BuildSendPayload("conv-1", "Reply text", "orig-msg-id", "+15551234567", nil)
-	if payload.Reply == nil {
-		t.Fatal("Reply must be set when replyToID is provided")
-	}
-	if payload.Reply.MessageID != "orig-msg-id" {
-		t.Errorf("Reply.MessageID = %q, want orig-msg-id", payload.Reply.MessageID)
+func TestMediaEndpointWithMimeTypeButNoMediaID(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Message has MimeType (from backfill) but no MediaID (expired)
+	// Historical media references are ephemeral and can't be re-fetched
+	ts.store.UpsertMessage(&db.Message{
+		MessageID:      "m-media-no-id",
+		ConversationID: "c1",
+		MimeType:       "image/png",
+		MediaID:        "", // empty — media reference expired
+		TimestampMS:    1000,
+	})
+
+	resp, err := http.Get(ts.server.URL + "/api/media/m-media-no-id")
+	if err != nil {
+		t.Fatal(err)
 	}
-}
+	defer resp.Body.Close()
 
-func TestBuildSendPayloadNoReply(t *testing.T) {
-	payload := BuildSendPayload("conv-1", "No reply", "", "+15551234567", nil)
-	if payload.Reply != nil {
-		t.Error("Reply must be nil when replyToID is empty")
+	// No MediaID means we can't download — return 404
+	if resp.StatusCode != 404 {
+		t.Fatalf("got status %d, want 404 (no media ID available)", resp.StatusCode)
 	}
 }
 
-func TestBuildSendMediaPayload(t *testing.T) {
-	sim := &gmproto.SIMPayload{SIMNumber: 1}
-	media := &gmproto.MediaContent{
-		Format:    4, // image
-		MediaID:   "media-abc-123",
-		MediaName: "photo.jpg",
-		Size:      54321,
-		MimeType:  "image/jpeg",
+func TestMediaEndpointServesFromCacheOffline(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
 	}
-	payload := BuildSendMediaPayload("conv-1", media, "+15551234567", sim)
-
-	// Must use MessageInfo with MediaContent (not MessageContent)
-	if payload.MessagePayload.MessagePayloadContent != nil {
-		t.Error("MessagePayloadContent must be nil; use MessageInfo instead")
+	defer store.Close()
+	cache, err := media.New(store, t.TempDir(), 0, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
 	}
-	if len(payload.MessagePayload.MessageInfo) != 1 {
-		t.Fatalf("expected 1 MessageInfo entry, got %d", len(payload.MessagePayload.MessageInfo))
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Media: cache}))
+	defer srv.Close()
+
+	store.UpsertMessage(&db.Message{
+		MessageID: "m1", ConversationID: "c1", MediaID: "mid-1", MimeType: "image/png",
+		DecryptionKey: "deadbeef", TimestampMS: 1000,
+	})
+	store.UpsertMessage(&db.Message{
+		MessageID: "m2", ConversationID: "c1", MediaID: "mid-2", MimeType: "image/png",
+		DecryptionKey: "deadbeef", TimestampMS: 2000,
+	})
+	if err := cache.Put("mid-1", "image/png", []byte("png bytes")); err != nil {
+		t.Fatal(err)
 	}
 
-	// Should have MediaContent, not MessageContent
-	mc := payload.MessagePayload.MessageInfo[0].GetMessageContent()
-	if mc != nil {
-		t.Error("MessageContent should be nil for media messages")
+	resp, err := http.Get(srv.URL + "/api/media/m1")
+	if err != nil {
+		t.Fatal(err)
 	}
-	mediaCont := payload.MessagePayload.MessageInfo[0].GetMediaContent()
-	if mediaCont == nil {
-		t.Fatal("MediaContent must be set")
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("cached media: got status %d, want 200", resp.StatusCode)
 	}
-	if mediaCont.MediaID != "media-abc-123" {
-		t.Errorf("MediaID = %q, want media-abc-123", mediaCont.MediaID)
+	if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+		t.Errorf("content type: got %q", ct)
 	}
-	if mediaCont.MimeType != "image/jpeg" {
-		t.Errorf("MimeType = %q, want image/jpeg", mediaCont.MimeType)
+	if body, _ := io.ReadAll(resp.Body); string(body) != "png bytes" {
+		t.Errorf("body: got %q", body)
 	}
 
-	// TmpID format: tmp_ followed by 12 digits
-	if !strings.HasPrefix(payload.TmpID, "tmp_") || len(payload.TmpID) != 16 {
-		t.Errorf("TmpID format wrong: %q (want tmp_ + 12 digits)", payload.TmpID)
+	// Not cached and no client: nothing we can do.
+	resp2, err := http.Get(srv.URL + "/api/media/m2")
+	if err != nil {
+		t.Fatal(err)
 	}
-	// TmpID must be in all 3 places
-	if payload.MessagePayload.TmpID != payload.TmpID {
-		t.Error("MessagePayload.TmpID must match root TmpID")
+	defer resp2.Body.Close()
+	if resp2.StatusCode != 503 {
+		t.Fatalf("uncached media: got status %d, want 503", resp2.StatusCode)
 	}
-	if payload.MessagePayload.TmpID2 != payload.TmpID {
-		t.Error("MessagePayload.TmpID2 must match root TmpID")
+}
+
+func TestBackfillEndpoints(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer store.Close()
 
-	// SIM payload must be set
-	if payload.SIMPayload == nil || payload.SIMPayload.SIMNumber != 1 {
-		t.Error("SIMPayload not set correctly")
+	a := &app.App{Store: store, Clients: client.NewProvider(nil), Logger: zerolog.Nop()}
+	srv := httptest.NewServer(APIHandlerFull(Config{
+		Store:     store,
+		Logger:    zerolog.Nop(),
+		Backfills: app.NewBackfillJobs(a),
+	}))
+	defer srv.Close()
+
+	do := func(method, body string) (int, map[string]any) {
+		req, _ := http.NewRequest(method, srv.URL+"/api/backfill", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
 	}
 
-	// ParticipantID and ConversationID
-	if payload.MessagePayload.ParticipantID != "+15551234567" {
-		t.Errorf("ParticipantID = %q, want +15551234567", payload.MessagePayload.ParticipantID)
+	if code, out := do("GET", ""); code != 200 || out["state"] != "idle" {
+		t.Errorf("GET: %d %v", code, out)
+	}
+	if code, _ := do("POST", ``); code != 503 {
+		t.Errorf("POST while disconnected: got %d, want 503", code)
 	}
-	if payload.ConversationID != "conv-1" {
-		t.Errorf("root ConversationID = %q", payload.ConversationID)
+	if code, _ := do("POST", `{"conversation_id":"c1","until":"last spring"}`); code != 400 {
+		t.Errorf("bad date: got %d, want 400", code)
 	}
-	if payload.MessagePayload.ConversationID != "conv-1" {
-		t.Errorf("payload ConversationID = %q", payload.MessagePayload.ConversationID)
+	if code, _ := do("POST", `{"until":"2025-06-01"}`); code != 400 {
+		t.Errorf("until without conversation: got %d, want 400", code)
+	}
+	if code, _ := do("DELETE", ""); code != 409 {
+		t.Errorf("DELETE with nothing running: got %d, want 409", code)
 	}
 }
 
-func TestSendMediaEndpointNoClient(t *testing.T) {
+func TestStaticFileServing(t *testing.T) {
 	ts := newTestServer(t)
 
-	// Multipart form with image data
-	body := strings.NewReader("")
-	resp, err := http.Post(ts.server.URL+"/api/send-media", "application/json", body)
+	resp, err := http.Get(ts.server.URL + "/")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer resp.Body.Close()
 
-	// Should return 405 for GET or 400/503 for POST without proper body
-	if resp.StatusCode != 400 && resp.StatusCode != 503 {
-		t.Fatalf("got status %d, want 400 or 503", resp.StatusCode)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 for index", resp.StatusCode)
+	}
+	ct := resp.Header.Get("Content-Type")
+	if !strings.Contains(ct, "text/html") {
+		t.Fatalf("got content-type %q, want text/html", ct)
 	}
 }
 
-func TestMediaEndpointWithMimeTypeButNoMediaID(t *testing.T) {
+func TestSetAIAccess(t *testing.T) {
 	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Mom"})
 
-	// Message has MimeType (from backfill) but no MediaID (expired)
-	// Historical media references are ephemeral and can't be re-fetched
-	ts.store.UpsertMessage(&db.Message{
-		MessageID:      "m-media-no-id",
-		ConversationID: "c1",
-		MimeType:       "image/png",
-		MediaID:        "", // empty — media reference expired
-		TimestampMS:    1000,
-	})
+	do := func(method, body string, headers map[string]string) (int, map[string]string) {
+		req, _ := http.NewRequest(method, ts.server.URL+"/api/conversations/c1/ai-access", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var got map[string]string
+		json.NewDecoder(resp.Body).Decode(&got)
+		return resp.StatusCode, got
+	}
 
-	resp, err := http.Get(ts.server.URL + "/api/media/m-media-no-id")
+	if code, got := do("GET", "", nil); code != 200 || got["ai_access"] != db.AIReadWrite {
+		t.Errorf("default: %d %v", code, got)
+	}
+	if code, got := do("PUT", `{"ai_access": "hidden"}`, nil); code != 200 || got["ai_access"] != db.AIHidden {
+		t.Errorf("hide: %d %v", code, got)
+	}
+	if access, _ := ts.store.AIAccess("c1"); access != db.AIHidden {
+		t.Errorf("stored: %q", access)
+	}
+	if code, _ := do("PUT", `{"ai_access": "sometimes"}`, nil); code != 400 {
+		t.Errorf("bad value: got %d, want 400", code)
+	}
+	if code, _ := do("PUT", `{"ai_access": "read-write"}`, map[string]string{"Authorization": "Bearer om_x"}); code != 403 {
+		t.Errorf("with a token: got %d, want 403", code)
+	}
+
+	// The web UI still lists it, with its setting.
+	resp, err := http.Get(ts.server.URL + "/api/conversations")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer resp.Body.Close()
+	var convs []db.Conversation
+	json.NewDecoder(resp.Body).Decode(&convs)
+	if len(convs) != 1 || convs[0].AIAccess != db.AIHidden {
+		t.Errorf("conversations: %+v", convs)
+	}
+
+	// An API token, like an MCP client, doesn't see it at all.
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "secret", TimestampMS: 1000})
+	for _, path := range []string{"/api/conversations", "/api/conversations/c1/messages", "/api/search?q=secret", "/api/messages/m1/status"} {
+		req, _ := http.NewRequest("GET", ts.server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer om_x")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if strings.Contains(string(body), "Mom") || strings.Contains(string(body), "secret") {
+			t.Errorf("%s with a token: %d %s", path, resp.StatusCode, body)
+		}
+	}
+	if code, _ := do("GET", "", map[string]string{"Authorization": "Bearer om_x"}); code != 404 {
+		t.Errorf("get hidden access with a token: got %d, want 404", code)
+	}
+	sreq, _ := http.NewRequest("POST", ts.server.URL+"/api/send", strings.NewReader(`{"conversation_id": "c1", "message": "hi"}`))
+	sreq.Header.Set("Authorization", "Bearer om_x")
+	sresp, err := http.DefaultClient.Do(sreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sresp.Body.Close()
+	if sresp.StatusCode != 404 {
+		t.Errorf("send to hidden with a token: got %d, want 404", sresp.StatusCode)
+	}
 
-	// No MediaID means we can't download — return 404
+	req, _ := http.NewRequest("PUT", ts.server.URL+"/api/conversations/nope/ai-access", strings.NewReader(`{"ai_access": "hidden"}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
 	if resp.StatusCode != 404 {
-		t.Fatalf("got status %d, want 404 (no media ID available)", resp.StatusCode)
+		t.Errorf("missing conversation: got %d, want 404", resp.StatusCode)
 	}
 }
 
-func TestStaticFileServing(t *testing.T) {
-	ts := newTestServer(t)
-
-	resp, err := http.Get(ts.server.URL + "/")
+func TestAgentWritesRespectAIAccess(t *testing.T) {
+	store, err := db.New(":memory:")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		t.Fatalf("got status %d, want 200 for index", resp.StatusCode)
-	}
-	ct := resp.Header.Get("Content-Type")
-	if !strings.Contains(ct, "text/html") {
-		t.Fatalf("got content-type %q, want text/html", ct)
+	defer store.Close()
+	a := &app.App{Store: store, Clients: client.NewProvider(nil), Logger: zerolog.Nop()}
+	a.Outbox = app.NewOutbox(a)
+	a.Scheduler = app.NewScheduler(a)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Clients: a.Clients, Outbox: a.Outbox, Scheduler: a.Scheduler}))
+	defer srv.Close()
+
+	store.UpsertConversation(&db.Conversation{ConversationID: "hidden"})
+	store.UpsertConversation(&db.Conversation{ConversationID: "readonly"})
+	store.UpsertConversation(&db.Conversation{ConversationID: "open"})
+	store.SetAIAccess("hidden", db.AIHidden)
+	store.SetAIAccess("readonly", db.AIReadOnly)
+	store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "hidden", TimestampMS: 1000})
+	store.UpsertDraft(&db.Draft{DraftID: "d1", ConversationID: "hidden", Body: "not yet"})
+	store.AddOutboxItem(&db.OutboxItem{ConversationID: "readonly", Body: "queued", State: db.OutboxQueued})
+	store.AddScheduledMessage(&db.ScheduledMessage{ConversationID: "hidden", Body: "later", SendAt: time.Now().Add(time.Hour).UnixMilli(), State: db.ScheduledPending})
+
+	do := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer om_x")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"mark hidden read", "POST", "/api/mark-read", `{"conversation_id": "hidden"}`, 404},
+		{"mark read-only read", "POST", "/api/mark-read", `{"conversation_id": "readonly"}`, 403},
+		// The message's own conversation counts, not the one named.
+		{"react in hidden", "POST", "/api/react", `{"conversation_id": "open", "message_id": "m1", "emoji": "👍"}`, 404},
+		{"delete hidden draft", "DELETE", "/api/drafts/d1", "", 404},
+		{"retry read-only outbox item", "POST", "/api/outbox/1/retry", "", 403},
+		{"cancel read-only outbox item", "DELETE", "/api/outbox/1", "", 403},
+		{"cancel hidden scheduled message", "DELETE", "/api/scheduled/1", "", 404},
+	}
+	for _, tt := range tests {
+		if code := do(tt.method, tt.path, tt.body); code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, code, tt.want)
+		}
+	}
+
+	if d, _ := store.GetDraft("d1"); d == nil {
+		t.Error("hidden draft was deleted")
+	}
+	if it, _ := store.GetOutboxItem(1); it == nil || it.State != db.OutboxQueued {
+		t.Errorf("read-only outbox item was changed: %+v", it)
+	}
+	if m, _ := store.GetScheduledMessage(1); m == nil || m.State != db.ScheduledPending {
+		t.Errorf("hidden scheduled message was cancelled: %+v", m)
+	}
+	if code := do("POST", "/api/mark-read", `{"conversation_id": "open"}`); code != 200 {
+		t.Errorf("mark read-write read: got %d, want 200", code)
 	}
 }
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// registerApprovalRoutes adds the API for messages agents asked to send
+// that the send policy held for approval:
+//
+//	GET  /api/approvals?state=pending  list, newest first
+//	POST /api/approvals/{id}/approve   send a pending one
+//	POST /api/approvals/{id}/reject    drop a pending one
+//
+// Approving and rejecting need a browser session: an agent holding an API
+// token mustn't be able to approve its own messages. Without approvals
+// every route answers 501.
+func registerApprovalRoutes(mux *http.ServeMux, store *db.Store, approvals *app.Approvals) {
+	mux.HandleFunc("/api/approvals", func(w http.ResponseWriter, r *http.Request) {
+		if approvals == nil {
+			httpError(w, "approvals not available", 501)
+			return
+		}
+		if r.Method != http.MethodGet {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		state := r.URL.Query().Get("state")
+		switch state {
+		case "", db.ApprovalPending, db.ApprovalApproved, db.ApprovalRejected, db.ApprovalFailed:
+		default:
+			httpError(w, "unknown state: "+state, 400)
+			return
+		}
+		list, err := storeFor(r, store).ListApprovals(state, queryInt(r, "limit", 100))
+		if err != nil {
+			httpError(w, "list approvals: "+err.Error(), 500)
+			return
+		}
+		if list == nil {
+			list = []*db.Approval{}
+		}
+		writeJSON(w, list)
+	})
+
+	mux.HandleFunc("/api/approvals/", func(w http.ResponseWriter, r *http.Request) {
+		if approvals == nil {
+			httpError(w, "approvals not available", 501)
+			return
+		}
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		idStr, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/approvals/"), "/")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			httpError(w, "invalid approval id", 400)
+			return
+		}
+		decide := approvals.Approve
+		switch action {
+		case "approve":
+		case "reject":
+			decide = approvals.Reject
+		default:
+			httpError(w, "not found", 404)
+			return
+		}
+		if fromToken(r) {
+			httpError(w, "approvals are decided in the web UI, not with an API token", 403)
+			return
+		}
+		a, err := decide(id)
+		switch {
+		case errors.Is(err, app.ErrApprovalDecided):
+			httpError(w, err.Error(), 409)
+		case err != nil:
+			httpError(w, err.Error(), 500)
+		case a == nil:
+			httpError(w, "approval not found", 404)
+		default:
+			writeJSON(w, a)
+		}
+	})
+}
+
+// fromToken reports whether r carries an API token rather than a browser
+// session. API tokens are what agents hold, so requests made with one get
+// what MCP clients get.
+func fromToken(r *http.Request) bool {
+	return r.Header.Get("Authorization") != ""
+}
+
+// holdAgentSend applies the send policy to a message sent with an API
+// token, as the MCP tools do. It reports whether it answered the request:
+// with the refusal, or with 202 and the approval the message now waits
+// for. Messages sent from a browser session, or without approvals, are
+// left to the caller.
+func holdAgentSend(w http.ResponseWriter, r *http.Request, approvals *app.Approvals, req app.AgentSend) bool {
+	if approvals == nil || !fromToken(r) {
+		return false
+	}
+	pending, err := approvals.Check(req)
+	switch {
+	case errors.Is(err, app.ErrNotAllowlisted):
+		httpError(w, err.Error(), 403)
+	case errors.Is(err, app.ErrRateLimited):
+		httpError(w, err.Error(), 429)
+	case err != nil:
+		httpError(w, err.Error(), 500)
+	case pending != nil:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(202)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":      "pending_approval",
+			"approval_id": pending.ID,
+		})
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestApprovalRoutes(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &app.App{Store: store, Logger: zerolog.Nop()}
+	a.Outbox = app.NewOutbox(a)
+	a.Approvals = app.NewApprovals(a, app.DefaultPolicy)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Outbox: a.Outbox, Approvals: a.Approvals}))
+	defer srv.Close()
+
+	do := func(method, path string, headers map[string]string) (int, []byte) {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var raw json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&raw)
+		return resp.StatusCode, raw
+	}
+
+	first, _ := a.Approvals.Check(app.AgentSend{PhoneNumber: "+15551234567", Body: "hello"})
+	second, _ := a.Approvals.Check(app.AgentSend{PhoneNumber: "+15551234567", Body: "again"})
+
+	code, body := do("GET", "/api/approvals?state=pending", nil)
+	var list []db.Approval
+	json.Unmarshal(body, &list)
+	if code != 200 || len(list) != 2 || list[0].ID != second.ID {
+		t.Fatalf("list: %d %s", code, body)
+	}
+	if code, _ := do("GET", "/api/approvals?state=maybe", nil); code != 400 {
+		t.Errorf("unknown state: got %d, want 400", code)
+	}
+
+	// An agent's API token can't approve its own messages.
+	if code, _ := do("POST", "/api/approvals/1/approve", map[string]string{"Authorization": "Bearer om_x"}); code != 403 {
+		t.Errorf("approve with a token: got %d, want 403", code)
+	}
+
+	code, body = do("POST", "/api/approvals/1/approve", nil)
+	var got db.Approval
+	json.Unmarshal(body, &got)
+	if code != 200 || got.ID != first.ID || got.State != db.ApprovalApproved || got.OutboxID == 0 {
+		t.Errorf("approve: %d %s", code, body)
+	}
+	if code, body := do("POST", "/api/approvals/2/reject", nil); code != 200 {
+		t.Errorf("reject: %d %s", code, body)
+	}
+	if code, _ := do("POST", "/api/approvals/2/approve", nil); code != 409 {
+		t.Errorf("approve a rejected one: got %d, want 409", code)
+	}
+	if code, _ := do("POST", "/api/approvals/99/reject", nil); code != 404 {
+		t.Errorf("missing: got %d, want 404", code)
+	}
+	if code, _ := do("POST", "/api/approvals/1/send", nil); code != 404 {
+		t.Errorf("unknown action: got %d, want 404", code)
+	}
+	if items, _ := store.ListOutbox("", 10); len(items) != 1 || items[0].Body != "hello" {
+		t.Errorf("outbox: %+v", items)
+	}
+
+	bare := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop()}))
+	defer bare.Close()
+	resp, err := http.Get(bare.URL + "/api/approvals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 501 {
+		t.Errorf("without approvals: got %d, want 501", resp.StatusCode)
+	}
+}
+
+func TestTokenSendsFollowPolicy(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &app.App{Store: store, Logger: zerolog.Nop()}
+	a.Sender = app.NewSender(a)
+	a.Outbox = app.NewOutbox(a)
+	a.Scheduler = app.NewScheduler(a)
+	a.Approvals = app.NewApprovals(a, app.DefaultPolicy)
+	srv := httptest.NewServer(APIHandlerFull(Config{
+		Store: store, Logger: zerolog.Nop(), Outbox: a.Outbox, Scheduler: a.Scheduler, Approvals: a.Approvals,
+	}))
+	defer srv.Close()
+	store.UpsertDraft(&db.Draft{DraftID: "d1", ConversationID: "c1", Body: "draft"})
+
+	post := func(path, body string, token bool) (int, map[string]any) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token {
+			req.Header.Set("Authorization", "Bearer om_x")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var got map[string]any
+		json.NewDecoder(resp.Body).Decode(&got)
+		return resp.StatusCode, got
+	}
+
+	sendAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	for path, body := range map[string]string{
+		"/api/send":        `{"conversation_id": "c1", "message": "hi"}`,
+		"/api/drafts/send": `{"draft_id": "d1", "body": "edited"}`,
+		"/api/scheduled":   `{"conversation_id": "c1", "message": "later", "send_at": "` + sendAt + `"}`,
+	} {
+		if code, got := post(path, body, true); code != 202 || got["status"] != "pending_approval" {
+			t.Errorf("%s with a token: %d %v", path, code, got)
+		}
+	}
+	if list, _ := store.ListApprovals(db.ApprovalPending, 10); len(list) != 3 {
+		t.Errorf("got %d pending approvals, want 3", len(list))
+	}
+	if items, _ := store.ListOutbox("", 10); len(items) != 0 {
+		t.Errorf("token sends went to the outbox: %+v", items)
+	}
+	if msgs, _ := store.ListScheduledMessages("", "", 10); len(msgs) != 0 {
+		t.Errorf("token schedule wasn't held: %+v", msgs)
+	}
+	if d, _ := store.GetDraft("d1"); d == nil {
+		t.Error("draft deleted while its send waits for approval")
+	}
+	if code, _ := post("/api/send-media", "", true); code != 403 {
+		t.Errorf("send-media with a token: got %d, want 403", code)
+	}
+
+	// The web UI isn't subject to the policy.
+	if code, got := post("/api/send", `{"conversation_id": "c1", "message": "hi"}`, false); code != 202 || got["queued"] != true {
+		t.Errorf("send from the web UI: %d %v", code, got)
+	}
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/maxghenis/openmessage/internal/auth"
+)
+
+// registerAuthRoutes adds the browser login flow:
+//
+//	GET  /login        the login page
+//	POST /api/login    {"token": "…"} starts a session and sets its cookie
+//	POST /api/logout   ends it
+//	GET  /api/session  the session's scope and CSRF token
+func registerAuthRoutes(mux *http.ServeMux, a *auth.Auth, static fs.FS) {
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, static, "login.html")
+	})
+
+	mux.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		cookie, ok := a.Login(strings.TrimSpace(req.Token), r.TLS != nil)
+		if !ok {
+			httpError(w, "invalid token", 401)
+			return
+		}
+		http.SetCookie(w, cookie)
+		scope, _ := a.Lookup(strings.TrimSpace(req.Token))
+		writeJSON(w, map[string]string{"scope": scope.Scope})
+	})
+
+	mux.HandleFunc("/api/logout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		http.SetCookie(w, a.Logout(r))
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("/api/session", func(w http.ResponseWriter, r *http.Request) {
+		scope, _ := auth.ScopeFrom(r.Context())
+		writeJSON(w, map[string]string{"scope": scope, "csrf_token": a.CSRFToken(r)})
+	})
+}
+
+// publicPaths are served without logging in.
+var publicPaths = map[string]bool{
+	"/login":       true,
+	"/api/login":   true,
+	"/api/logout":  true,
+	"/favicon.svg": true,
+}
+
+// requireAuth lets through requests with a valid bearer token or session
+// cookie whose scope allows them: reads need ScopeRead and anything else
+// ScopeSend. MCP requests need only ScopeRead here, since tool calls are
+// posted whatever they do; the tools that send check the scope themselves.
+//
+// Unauthenticated API and MCP requests get a 401 and page loads are sent
+// to the login page.
+func requireAuth(a *auth.Auth, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scope, ok := a.Authenticate(r)
+		if !ok {
+			if strings.HasPrefix(r.URL.Path, "/api/") || isMCP(r.URL.Path) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="openmessage"`)
+				httpError(w, "authentication required", 401)
+				return
+			}
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		need := auth.ScopeSend
+		switch {
+		case isMCP(r.URL.Path),
+			r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+			need = auth.ScopeRead
+		}
+		if !auth.Allows(scope, need) {
+			httpError(w, "this token is read-only", 403)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithScope(r.Context(), scope)))
+	})
+}
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/auth"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestAuthRequired(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := auth.New([]auth.Token{
+		{Name: "send", Token: "send-token-0123456789", Scope: auth.ScopeSend},
+		{Name: "read", Token: "read-token-0123456789", Scope: auth.ScopeRead},
+	})
+	mcp := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(202) })
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), MCPHandler: mcp, Auth: a}))
+	defer srv.Close()
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	do := func(method, path, token string, cookies ...*http.Cookie) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(`{}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := noRedirect.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, c := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/api/conversations", "", 401},
+		{"GET", "/api/conversations", "wrong", 401},
+		{"POST", "/api/unpair", "", 401},
+		{"POST", "/mcp/message", "", 401},
+		{"GET", "/api/conversations", "read-token-0123456789", 200},
+		{"GET", "/api/conversations", "send-token-0123456789", 200},
+		// Read-only tokens can't change anything, but can use MCP; the
+		// tools that send check for themselves.
+		{"POST", "/api/send", "read-token-0123456789", 403},
+		{"POST", "/api/unpair", "read-token-0123456789", 403},
+		{"POST", "/mcp/message", "read-token-0123456789", 202},
+		{"POST", "/mcp", "", 401},
+		{"POST", "/mcp", "read-token-0123456789", 202},
+		{"POST", "/api/unpair", "send-token-0123456789", 200},
+		// Pages send the browser to log in; the login page needs nothing.
+		{"GET", "/", "", 302},
+		{"GET", "/login", "", 200},
+		{"GET", "/favicon.svg", "", 200},
+	} {
+		if resp := do(c.method, c.path, c.token); resp.StatusCode != c.want {
+			t.Errorf("%s %s with %q: got %d, want %d", c.method, c.path, c.token, resp.StatusCode, c.want)
+		}
+	}
+
+	// Logging in from the browser.
+	login := func(token string) *http.Response {
+		resp, err := http.Post(srv.URL+"/api/login", "application/json", strings.NewReader(`{"token": "`+token+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := login("wrong"); resp.StatusCode != 401 || len(resp.Cookies()) != 0 {
+		t.Errorf("bad login: %d, %v", resp.StatusCode, resp.Cookies())
+	}
+	resp := login("read-token-0123456789")
+	if resp.StatusCode != 200 || len(resp.Cookies()) != 1 {
+		t.Fatalf("login: %d, %v", resp.StatusCode, resp.Cookies())
+	}
+	session := resp.Cookies()[0]
+	if resp := do("GET", "/", "", session); resp.StatusCode != 200 {
+		t.Errorf("page with session: got %d", resp.StatusCode)
+	}
+	if resp := do("GET", "/api/session", "", session); resp.StatusCode != 200 {
+		t.Errorf("session: got %d", resp.StatusCode)
+	}
+	if resp := do("POST", "/api/send", "", session); resp.StatusCode != 403 {
+		t.Errorf("send with a read-only session: got %d, want 403", resp.StatusCode)
+	}
+	if resp := do("POST", "/api/logout", "", session); resp.StatusCode != 200 {
+		t.Errorf("logout: got %d", resp.StatusCode)
+	}
+	if resp := do("GET", "/api/conversations", "", session); resp.StatusCode != 401 {
+		t.Errorf("after logout: got %d, want 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"crypto/subtle"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/maxghenis/openmessage/internal/auth"
+)
+
+// CSRFHeader carries a browser session's CSRF token, from /api/session,
+// on requests that change something.
+const CSRFHeader = "X-CSRF-Token"
+
+// loopbackHosts are always accepted in the Host header.
+var loopbackHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// safeMethod reports whether requests with method m only read.
+func safeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions
+}
+
+// checkOrigin refuses requests a web page on another site could have
+// made on the user's behalf:
+//
+//   - every request must name one of hosts, or a loopback address, in its
+//     Host header, so a page can't reach the server through a DNS name
+//     that it rebinds to 127.0.0.1;
+//   - requests that change something must come from this server's own
+//     origin when the browser says where they come from, in Origin or
+//     Sec-Fetch-Site;
+//   - when it doesn't, they mustn't be simple form posts, which a page
+//     on any site can make, unless they carry an API token or a CSRF
+//     token.
+//
+// A host of "*" accepts any Host header.
+func checkOrigin(hosts []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, h := range append(hosts, loopbackHosts...) {
+		allowed[strings.ToLower(strings.Trim(h, "[]"))] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed["*"] && !allowed[hostname(r.Host)] {
+			httpError(w, "unknown host: "+r.Host, 403)
+			return
+		}
+		if safeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host == "" || !strings.EqualFold(u.Host, r.Host) {
+				httpError(w, "cross-origin request refused", 403)
+				return
+			}
+		} else if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+			if site != "same-origin" && site != "none" {
+				httpError(w, "cross-site request refused", 403)
+				return
+			}
+		} else if formPost(r) && r.Header.Get("Authorization") == "" && r.Header.Get(CSRFHeader) == "" {
+			httpError(w, "form posts need an API token or a CSRF token", 403)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostname returns the lowercased host in a Host header, without its port.
+func hostname(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// formPost reports whether r has a body an HTML form could have sent.
+// Browsers old enough to send neither Origin nor Sec-Fetch-Site can only
+// post across sites with a form, which always says what it's sending.
+func formPost(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+		return true
+	}
+	return false
+}
+
+// requireCSRF makes requests that change something through a logged-in
+// browser session send the session's CSRF token. Requests with an API
+// token don't need one: a page on another site can't set their header.
+func requireCSRF(a *auth.Auth, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if safeMethod(r.Method) || publicPaths[r.URL.Path] || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		want := a.CSRFToken(r)
+		got := r.Header.Get(CSRFHeader)
+		if want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			httpError(w, "missing or invalid CSRF token", 403)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// registerEventRoutes adds the live event stream:
+//
+//	GET /api/events  server-sent events: message, message_updated,
+//	                 conversation, draft, typing, connection and resync
+//
+// A client that reconnects with Last-Event-ID (or ?last_event_id=) first
+// gets the events it missed, or a resync event if they are no longer kept.
+// A client with an API token gets no events about conversations hidden
+// from AI. Without a bus it answers 501.
+func registerEventRoutes(mux *http.ServeMux, store *db.Store, events *bus.Bus) {
+	mux.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		if events == nil {
+			httpError(w, "events not available", 501)
+			return
+		}
+		if r.Method != http.MethodGet {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.URL.Query().Get("last_event_id")
+		}
+		var after int64
+		if lastID != "" {
+			var err error
+			if after, err = strconv.ParseInt(lastID, 10, 64); err != nil {
+				httpError(w, "invalid Last-Event-ID", 400)
+				return
+			}
+		}
+		var skip func(bus.Event) bool
+		if fromToken(r) {
+			skip = func(evt bus.Event) bool { return hiddenEvent(store, evt) }
+		}
+		streamEvents(w, r, events, after, skip)
+	})
+}
+
+// hiddenEvent reports whether evt is about a conversation hidden from AI.
+func hiddenEvent(store *db.Store, evt bus.Event) bool {
+	var convID string
+	switch p := evt.Data.(type) {
+	case bus.MessageAdded:
+		convID = p.ConversationID
+	case bus.MessageUpdated:
+		convID = p.ConversationID
+	case bus.ConversationUpdated:
+		convID = p.ConversationID
+	case bus.DraftUpdated:
+		convID = p.ConversationID
+	case bus.Typing:
+		convID = p.ConversationID
+	case bus.ApprovalUpdated:
+		convID = p.ConversationID
+	default:
+		return false
+	}
+	access, err := store.AIAccess(convID)
+	return err != nil || access == db.AIHidden
+}
+
+// streamEvents sends the events after the given ID, then every new one,
+// until the client goes away or falls too far behind. Events skip reports
+// true for aren't sent; skip may be nil.
+func streamEvents(w http.ResponseWriter, r *http.Request, events *bus.Bus, after int64, skip func(bus.Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, "streaming not supported", 500)
+		return
+	}
+	sub, missed, complete := events.Subscribe(after)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprint(w, "retry: 2000\n\n")
+	if !complete {
+		// Carry the current ID so the next reconnect resumes from here.
+		writeEvent(w, bus.Event{ID: events.LastID(), Type: bus.Resync{}.EventType(), Time: time.Now(), Data: bus.Resync{}})
+	}
+	for _, evt := range missed {
+		if skip == nil || !skip(evt) {
+			writeEvent(w, evt)
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case evt, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the browser reconnects with
+				// its last ID and catches up.
+				return
+			}
+			if skip != nil && skip(evt) {
+				continue
+			}
+			writeEvent(w, evt)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, evt bus.Event) {
+	switch evt.Data.(type) {
+	case bus.MessageReceived, bus.ConversationReceived:
+		// The browser reads back from the store, so it's told once these
+		// are stored rather than when they arrive.
+		return
+	}
+	data, _ := json.Marshal(evt.Data)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, data)
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// sseEvent is one parsed server-sent event.
+type sseEvent struct {
+	id, typ, data string
+}
+
+func readEvents(t *testing.T, r *bufio.Reader, n int) []sseEvent {
+	t.Helper()
+	var evts []sseEvent
+	var cur sseEvent
+	for len(evts) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v (got %+v)", err, evts)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if cur.typ != "" {
+				evts = append(evts, cur)
+			}
+			cur = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			cur.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			cur.typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			cur.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return evts
+}
+
+func TestEventStreamResumes(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	events := bus.New(100)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Events: events}))
+	defer srv.Close()
+
+	events.Publish(bus.MessageAdded{Message: &db.Message{MessageID: "m1", ConversationID: "c1"}})
+	seen := events.LastID()
+	events.Publish(bus.MessageReceived{Message: &db.Message{MessageID: "m1", ConversationID: "c1", Status: "OUTGOING_DISPLAYED"}})
+	events.Publish(bus.MessageUpdated{Message: &db.Message{MessageID: "m1", ConversationID: "c1", DeliveryStatus: db.DeliveryRead}})
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/events", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(seen, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type: %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// First what was missed, less what the browser isn't told about, then
+	// what happens next.
+	got := readEvents(t, r, 1)
+	if got[0].typ != "message_updated" || !strings.Contains(got[0].data, `"DeliveryStatus":"read"`) {
+		t.Errorf("missed event: %+v", got[0])
+	}
+	events.Publish(bus.Typing{ConversationID: "c1", Typing: true})
+	got = readEvents(t, r, 1)
+	if got[0].typ != "typing" || got[0].id != strconv.FormatInt(events.LastID(), 10) {
+		t.Errorf("live event: %+v", got[0])
+	}
+}
+
+func TestEventStreamHidesConversationsFromTokens(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertConversation(&db.Conversation{ConversationID: "family"})
+	store.SetAIAccess("family", db.AIHidden)
+	events := bus.New(100)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Events: events}))
+	defer srv.Close()
+
+	events.Publish(bus.ConnectionChanged{Connected: true})
+	seen := events.LastID()
+	events.Publish(bus.MessageAdded{Message: &db.Message{MessageID: "m1", ConversationID: "family"}})
+	events.Publish(bus.MessageAdded{Message: &db.Message{MessageID: "m2", ConversationID: "work"}})
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/events?last_event_id="+strconv.FormatInt(seen, 10), nil)
+	req.Header.Set("Authorization", "Bearer om_x")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+
+	if got := readEvents(t, r, 1); !strings.Contains(got[0].data, `"m2"`) {
+		t.Errorf("missed event: %+v", got[0])
+	}
+	events.Publish(bus.Typing{ConversationID: "family", Typing: true})
+	events.Publish(bus.Typing{ConversationID: "work", Typing: true})
+	if got := readEvents(t, r, 1); !strings.Contains(got[0].data, `"work"`) {
+		t.Errorf("live event: %+v", got[0])
+	}
+}
+
+func TestEventStreamResyncsWhenTooFarBehind(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	events := bus.New(1)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Events: events}))
+	defer srv.Close()
+
+	events.Publish(bus.Resync{})
+	stale := events.LastID()
+	events.Publish(bus.Resync{})
+	events.Publish(bus.Resync{})
+
+	resp, err := http.Get(srv.URL + "/api/events?last_event_id=" + strconv.FormatInt(stale, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got := readEvents(t, bufio.NewReader(resp.Body), 1)
+	if got[0].typ != "resync" || got[0].id != strconv.FormatInt(events.LastID(), 10) {
+		t.Errorf("got %+v, want resync at the latest ID", got[0])
+	}
+}
+
+func TestEventStreamUnavailableWithoutBus(t *testing.T) {
+	ts := newTestServer(t)
+	resp, err := http.Get(ts.server.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 501 {
+		t.Errorf("got %d, want 501", resp.StatusCode)
+	}
+}
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// registerOutboxRoutes adds the outbox API:
+//
+//	GET    /api/outbox?state=queued  list items, oldest first
+//	POST   /api/outbox/{id}/retry    queue a failed item again
+//	DELETE /api/outbox/{id}          drop a queued or failed item
+//
+// Without an outbox every route answers 501.
+func registerOutboxRoutes(mux *http.ServeMux, store *db.Store, outbox *app.Outbox) {
+	mux.HandleFunc("/api/outbox", func(w http.ResponseWriter, r *http.Request) {
+		if outbox == nil {
+			httpError(w, "outbox not available", 501)
+			return
+		}
+		if r.Method != http.MethodGet {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		state := r.URL.Query().Get("state")
+		switch state {
+		case "", db.OutboxQueued, db.OutboxSending, db.OutboxSent, db.OutboxFailed:
+		default:
+			httpError(w, "unknown state: "+state, 400)
+			return
+		}
+		items, err := storeFor(r, store).ListOutbox(state, queryInt(r, "limit", 100))
+		if err != nil {
+			httpError(w, "list outbox: "+err.Error(), 500)
+			return
+		}
+		if items == nil {
+			items = []*db.OutboxItem{}
+		}
+		writeJSON(w, items)
+	})
+
+	mux.HandleFunc("/api/outbox/", func(w http.ResponseWriter, r *http.Request) {
+		if outbox == nil {
+			httpError(w, "outbox not available", 501)
+			return
+		}
+		rest := strings.TrimPrefix(r.URL.Path, "/api/outbox/")
+		idStr, action, _ := strings.Cut(rest, "/")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			httpError(w, "invalid outbox id", 400)
+			return
+		}
+		if fromToken(r) {
+			it, err := store.GetOutboxItem(id)
+			if err != nil {
+				httpError(w, "get outbox item: "+err.Error(), 500)
+				return
+			}
+			if it != nil && refuseAgentWrite(w, r, store, it.ConversationID) {
+				return
+			}
+		}
+
+		var it *db.OutboxItem
+		switch {
+		case action == "" && r.Method == http.MethodDelete:
+			it, err = outbox.Cancel(id)
+		case action == "retry" && r.Method == http.MethodPost:
+			it, err = outbox.Retry(id)
+		case action == "" || action == "retry":
+			httpError(w, "method not allowed", 405)
+			return
+		default:
+			httpError(w, "not found", 404)
+			return
+		}
+		switch {
+		case errors.Is(err, app.ErrOutboxItemBusy):
+			httpError(w, err.Error(), 409)
+		case err != nil:
+			httpError(w, err.Error(), 500)
+		case it == nil:
+			httpError(w, "outbox item not found", 404)
+		default:
+			writeJSON(w, it)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestOutboxRoutesUnavailableWithoutOutbox(t *testing.T) {
+	ts := newTestServer(t)
+	resp, err := http.Get(ts.server.URL + "/api/outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 501 {
+		t.Errorf("got %d, want 501", resp.StatusCode)
+	}
+}
+
+func TestSendWhileDisconnectedQueues(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &app.App{Store: store, Clients: client.NewProvider(nil), Logger: zerolog.Nop()}
+	a.Sender = app.NewSender(a)
+	a.Outbox = app.NewOutbox(a)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Sender: a.Sender, Outbox: a.Outbox}))
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, []byte) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var raw json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&raw)
+		return resp.StatusCode, raw
+	}
+
+	code, body := do("POST", "/api/send", `{"conversation_id":"c1","message":"hello"}`)
+	if code != 202 {
+		t.Fatalf("send while disconnected: got %d %s, want 202", code, body)
+	}
+	var queued struct {
+		Queued   bool  `json:"queued"`
+		OutboxID int64 `json:"outbox_id"`
+	}
+	json.Unmarshal(body, &queued)
+	if !queued.Queued || queued.OutboxID == 0 {
+		t.Fatalf("queued response: %s", body)
+	}
+
+	code, body = do("GET", "/api/outbox?state=queued", "")
+	var items []db.OutboxItem
+	json.Unmarshal(body, &items)
+	if code != 200 || len(items) != 1 || items[0].Body != "hello" || items[0].State != db.OutboxQueued {
+		t.Fatalf("list: %d %s", code, body)
+	}
+	if code, _ := do("GET", "/api/outbox?state=lost", ""); code != 400 {
+		t.Errorf("bad state: got %d, want 400", code)
+	}
+
+	if code, _ := do("POST", "/api/outbox/1/retry", ""); code != 409 {
+		t.Errorf("retry of a queued item: got %d, want 409", code)
+	}
+	if code, _ := do("DELETE", "/api/outbox/1", ""); code != 200 {
+		t.Errorf("cancel: got %d, want 200", code)
+	}
+	if code, _ := do("DELETE", "/api/outbox/1", ""); code != 404 {
+		t.Errorf("cancel again: got %d, want 404", code)
+	}
+	if code, _ := do("DELETE", "/api/outbox/abc", ""); code != 400 {
+		t.Errorf("bad id: got %d, want 400", code)
+	}
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+
+	"github.com/maxghenis/openmessage/internal/app"
+)
+
+// qrQuietZone is the blank border, in modules, that scanners need around a
+// QR code.
+const qrQuietZone = 4
+
+// registerPairRoutes adds the pairing API. Without a pairer every route
+// answers 501.
+func registerPairRoutes(mux *http.ServeMux, pairer *app.Pairer) {
+	available := func(w http.ResponseWriter) bool {
+		if pairer == nil {
+			httpError(w, "pairing not available", 501)
+			return false
+		}
+		return true
+	}
+
+	mux.HandleFunc("/api/pair/start", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if !available(w) {
+			return
+		}
+		st, err := pairer.Start()
+		if errors.Is(err, app.ErrAlreadyPaired) {
+			httpError(w, err.Error(), 409)
+			return
+		}
+		if err != nil {
+			httpError(w, "start pairing: "+err.Error(), 502)
+			return
+		}
+		writeJSON(w, st)
+	})
+
+	mux.HandleFunc("/api/pair/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if !available(w) {
+			return
+		}
+		pairer.Cancel()
+		writeJSON(w, pairer.Status())
+	})
+
+	qrHandler := func(render func(*qr.Code) (string, []byte)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !available(w) {
+				return
+			}
+			st := pairer.Status()
+			if st.QRURL == "" {
+				httpError(w, "no QR code to show; POST /api/pair/start first", 404)
+				return
+			}
+			code, err := qr.Encode(st.QRURL, qr.L)
+			if err != nil {
+				httpError(w, "encode QR code: "+err.Error(), 500)
+				return
+			}
+			contentType, body := render(code)
+			w.Header().Set("Content-Type", contentType)
+			// The code changes every refresh.
+			w.Header().Set("Cache-Control", "no-store")
+			w.Write(body)
+		}
+	}
+	mux.HandleFunc("/api/pair/qr.svg", qrHandler(func(code *qr.Code) (string, []byte) {
+		return "image/svg+xml", []byte(qrSVG(code))
+	}))
+	mux.HandleFunc("/api/pair/qr.png", qrHandler(func(code *qr.Code) (string, []byte) {
+		return "image/png", code.PNG()
+	}))
+
+	mux.HandleFunc("/api/pair/status", func(w http.ResponseWriter, r *http.Request) {
+		if !available(w) {
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			writeJSON(w, pairer.Status())
+			return
+		}
+		streamPairStatus(w, r, pairer)
+	})
+}
+
+// streamPairStatus sends the current pairing status and then every change
+// as server-sent events until the client goes away.
+func streamPairStatus(w http.ResponseWriter, r *http.Request, pairer *app.Pairer) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, "streaming not supported", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	updates, cancel := pairer.Subscribe()
+	defer cancel()
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case st := <-updates:
+			data, _ := json.Marshal(st)
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
+
+// qrSVG renders a QR code as an SVG with one unit per module, drawing each
+// horizontal run of dark modules as a single rectangle.
+func qrSVG(code *qr.Code) string {
+	size := code.Size + 2*qrQuietZone
+	var path strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; {
+			if !code.Black(x, y) {
+				x++
+				continue
+			}
+			run := 1
+			for code.Black(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", x+qrQuietZone, y+qrQuietZone, run, run)
+			x += run
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		size, size, path.String())
+}
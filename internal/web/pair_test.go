@@ -0,0 +1,137 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"rsc.io/qr"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func newPairTestServer(t *testing.T, cli *client.Client) *httptest.Server {
+	t.Helper()
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := client.NewProvider(cli)
+	pairer := app.NewPairer(&app.App{Clients: clients, Logger: zerolog.Nop()})
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Clients: clients, Logger: zerolog.Nop(), Pairer: pairer}))
+	t.Cleanup(func() {
+		srv.Close()
+		store.Close()
+	})
+	return srv
+}
+
+func TestPairRoutesUnavailableWithoutPairer(t *testing.T) {
+	ts := newTestServer(t)
+	for _, path := range []string{"/api/pair/status", "/api/pair/qr.svg", "/api/pair/qr.png"} {
+		resp, err := http.Get(ts.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 501 {
+			t.Errorf("%s: got %d, want 501", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestPairStatusSnapshotAndStream(t *testing.T) {
+	srv := newPairTestServer(t, nil)
+
+	resp, err := http.Get(srv.URL + "/api/pair/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st app.PairStatus
+	json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if st.State != app.PairIdle {
+		t.Errorf("snapshot state: got %q, want idle", st.State)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/pair/status", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content-type: got %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	event, _ := r.ReadString('\n')
+	data, _ := r.ReadString('\n')
+	if event != "event: status\n" || !strings.HasPrefix(data, "data: ") || !strings.Contains(data, `"state":"idle"`) {
+		t.Errorf("first event: %q %q", event, data)
+	}
+}
+
+func TestPairQRRequiresAttempt(t *testing.T) {
+	srv := newPairTestServer(t, nil)
+	resp, err := http.Get(srv.URL + "/api/pair/qr.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("got %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestPairStartRefusedWhenPaired(t *testing.T) {
+	srv := newPairTestServer(t, client.NewForPairing(zerolog.Nop()))
+	resp, err := http.Post(srv.URL+"/api/pair/start", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 409 {
+		t.Errorf("got %d, want 409", resp.StatusCode)
+	}
+}
+
+func TestQRSVGMatchesCode(t *testing.T) {
+	code, err := qr.Encode("https://support.google.com/messages/?p=web_computer#?c=abc", qr.L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := qrSVG(code)
+	size := code.Size + 2*qrQuietZone
+	if !strings.Contains(svg, fmt.Sprintf(`viewBox="0 0 %d %d"`, size, size)) {
+		t.Fatalf("unexpected viewBox in %.80s", svg)
+	}
+
+	// Paint the path's runs back onto a grid and compare with the code.
+	d := svg[strings.Index(svg, ` d="`)+4:]
+	d = d[:strings.Index(d, `"`)]
+	painted := make(map[[2]int]bool)
+	for _, cmd := range strings.Split(strings.TrimSuffix(d, "z"), "z") {
+		var x, y, run, back int
+		if _, err := fmt.Sscanf(cmd, "M%d %dh%dv1h-%d", &x, &y, &run, &back); err != nil {
+			t.Fatalf("bad path command %q: %v", cmd, err)
+		}
+		for i := 0; i < run; i++ {
+			painted[[2]int{x + i - qrQuietZone, y - qrQuietZone}] = true
+		}
+	}
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if painted[[2]int{x, y}] != code.Black(x, y) {
+				t.Fatalf("module (%d,%d): painted %v, code %v", x, y, painted[[2]int{x, y}], code.Black(x, y))
+			}
+		}
+	}
+}
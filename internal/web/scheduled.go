@@ -0,0 +1,150 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// registerScheduledRoutes adds the scheduled message API:
+//
+//	GET    /api/scheduled?conversation_id=…&state=scheduled  list, soonest first
+//	POST   /api/scheduled                                     schedule a message or draft
+//	DELETE /api/scheduled/{id}                                cancel a pending one
+//
+// A message scheduled with an API token is subject to the send policy in
+// approvals. Without a scheduler every route answers 501.
+func registerScheduledRoutes(mux *http.ServeMux, store *db.Store, scheduler *app.Scheduler, approvals *app.Approvals) {
+	mux.HandleFunc("/api/scheduled", func(w http.ResponseWriter, r *http.Request) {
+		if scheduler == nil {
+			httpError(w, "scheduling not available", 501)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			q := r.URL.Query()
+			state := q.Get("state")
+			switch state {
+			case "", db.ScheduledPending, db.ScheduledDispatched, db.ScheduledCancelled, db.ScheduledFailed:
+			default:
+				httpError(w, "unknown state: "+state, 400)
+				return
+			}
+			msgs, err := storeFor(r, store).ListScheduledMessages(q.Get("conversation_id"), state, queryInt(r, "limit", 100))
+			if err != nil {
+				httpError(w, "list scheduled: "+err.Error(), 500)
+				return
+			}
+			if msgs == nil {
+				msgs = []*db.ScheduledMessage{}
+			}
+			writeJSON(w, msgs)
+
+		case http.MethodPost:
+			var req struct {
+				ConversationID string `json:"conversation_id"`
+				Message        string `json:"message"`
+				DraftID        string `json:"draft_id"`
+				ReplyToID      string `json:"reply_to_id"`
+				SendAt         string `json:"send_at"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, "invalid JSON: "+err.Error(), 400)
+				return
+			}
+			sendAt, err := time.Parse(time.RFC3339, req.SendAt)
+			if err != nil {
+				httpError(w, "send_at must be an RFC 3339 time", 400)
+				return
+			}
+			// A draft in a conversation hidden from AI is missing, as far
+			// as an API token goes.
+			if req.DraftID != "" {
+				if d, err := storeFor(r, store).GetDraft(req.DraftID); err == nil && d == nil {
+					httpError(w, app.ErrDraftNotFound.Error(), 404)
+					return
+				}
+			}
+			// Checked before the send policy, so that a message that can't
+			// be scheduled isn't held for approval.
+			var m *db.ScheduledMessage
+			schedReq, err := scheduler.Check(app.ScheduleRequest{
+				ConversationID: req.ConversationID,
+				Body:           req.Message,
+				DraftID:        req.DraftID,
+				ReplyToID:      req.ReplyToID,
+				SendAt:         sendAt,
+			})
+			if err == nil {
+				if refuseAgentWrite(w, r, store, schedReq.ConversationID) || holdAgentSend(w, r, approvals, app.AgentSend{
+					ConversationID: schedReq.ConversationID,
+					Body:           schedReq.Body,
+					DraftID:        schedReq.DraftID,
+					SendAt:         schedReq.SendAt,
+				}) {
+					return
+				}
+				m, err = scheduler.Schedule(schedReq)
+			}
+			switch {
+			case errors.Is(err, app.ErrDraftNotFound):
+				httpError(w, err.Error(), 404)
+			case errors.Is(err, app.ErrNoConversation), errors.Is(err, app.ErrEmptyMessage),
+				errors.Is(err, app.ErrSendTimeInPast), errors.Is(err, app.ErrDraftConversation):
+				httpError(w, err.Error(), 400)
+			case err != nil:
+				httpError(w, err.Error(), 500)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(201)
+				json.NewEncoder(w).Encode(m)
+			}
+
+		default:
+			httpError(w, "method not allowed", 405)
+		}
+	})
+
+	mux.HandleFunc("/api/scheduled/", func(w http.ResponseWriter, r *http.Request) {
+		if scheduler == nil {
+			httpError(w, "scheduling not available", 501)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/scheduled/"), 10, 64)
+		if err != nil {
+			httpError(w, "invalid scheduled message id", 400)
+			return
+		}
+		if fromToken(r) {
+			m, err := store.GetScheduledMessage(id)
+			if err != nil {
+				httpError(w, "get scheduled message: "+err.Error(), 500)
+				return
+			}
+			if m != nil && refuseAgentWrite(w, r, store, m.ConversationID) {
+				return
+			}
+		}
+		m, err := scheduler.Cancel(id)
+		switch {
+		case errors.Is(err, app.ErrScheduledNotPending):
+			httpError(w, err.Error(), 409)
+		case err != nil:
+			httpError(w, err.Error(), 500)
+		case m == nil:
+			httpError(w, "scheduled message not found", 404)
+		default:
+			writeJSON(w, m)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestScheduledRoutes(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &app.App{Store: store, Logger: zerolog.Nop()}
+	a.Scheduler = app.NewScheduler(a)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Scheduler: a.Scheduler}))
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, []byte) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var raw json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&raw)
+		return resp.StatusCode, raw
+	}
+
+	sendAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	code, body := do("POST", "/api/scheduled", `{"conversation_id":"c1","message":"standup in 5","send_at":"`+sendAt+`"}`)
+	if code != 201 {
+		t.Fatalf("schedule: got %d %s, want 201", code, body)
+	}
+	var m db.ScheduledMessage
+	json.Unmarshal(body, &m)
+	if m.ID == 0 || m.State != db.ScheduledPending {
+		t.Fatalf("scheduled: %s", body)
+	}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	for name, req := range map[string]string{
+		"past":         `{"conversation_id":"c1","message":"hi","send_at":"` + past + `"}`,
+		"no time":      `{"conversation_id":"c1","message":"hi"}`,
+		"no message":   `{"conversation_id":"c1","send_at":"` + sendAt + `"}`,
+		"no recipient": `{"message":"hi","send_at":"` + sendAt + `"}`,
+	} {
+		if code, body := do("POST", "/api/scheduled", req); code != 400 {
+			t.Errorf("%s: got %d %s, want 400", name, code, body)
+		}
+	}
+	if code, _ := do("POST", "/api/scheduled", `{"draft_id":"nope","send_at":"`+sendAt+`"}`); code != 404 {
+		t.Errorf("missing draft: got %d, want 404", code)
+	}
+
+	code, body = do("GET", "/api/scheduled?conversation_id=c1", "")
+	var list []db.ScheduledMessage
+	json.Unmarshal(body, &list)
+	if code != 200 || len(list) != 1 || list[0].Body != "standup in 5" {
+		t.Fatalf("list: %d %s", code, body)
+	}
+
+	if code, _ := do("DELETE", "/api/scheduled/1", ""); code != 200 {
+		t.Errorf("cancel: got %d, want 200", code)
+	}
+	if code, _ := do("DELETE", "/api/scheduled/1", ""); code != 409 {
+		t.Errorf("cancel again: got %d, want 409", code)
+	}
+	if code, _ := do("DELETE", "/api/scheduled/2", ""); code != 404 {
+		t.Errorf("cancel missing: got %d, want 404", code)
+	}
+	if code, _ := do("GET", "/api/scheduled?state=scheduled", ""); code != 200 {
+		t.Errorf("list pending: got %d", code)
+	}
+}
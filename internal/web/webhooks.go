@@ -0,0 +1,216 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// registerWebhookRoutes adds the webhook API:
+//
+//	GET    /api/webhooks                                     list
+//	POST   /api/webhooks                                     create; the only response with its secret
+//	GET    /api/webhooks/{id}                                one webhook
+//	PUT    /api/webhooks/{id}                                replace its URL, filters and enabled flag
+//	DELETE /api/webhooks/{id}                                remove it and its delivery log
+//	GET    /api/webhooks/{id}/deliveries?state=failed        delivery log, newest first
+//	POST   /api/webhooks/{id}/deliveries/{delivery_id}/retry  redeliver a failed one
+//
+// Without webhooks every route answers 501. Webhooks post every matching
+// message, hidden conversations included, so like deciding approvals they
+// need a web UI session: requests with an API token are refused.
+func registerWebhookRoutes(mux *http.ServeMux, store *db.Store, webhooks *app.Webhooks) {
+	mux.HandleFunc("/api/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		if webhooks == nil {
+			httpError(w, "webhooks not available", 501)
+			return
+		}
+		if fromToken(r) {
+			httpError(w, "webhooks are managed in the web UI, not with an API token", 403)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			hooks, err := store.ListWebhooks()
+			if err != nil {
+				httpError(w, "list webhooks: "+err.Error(), 500)
+				return
+			}
+			if hooks == nil {
+				hooks = []*db.Webhook{}
+			}
+			writeJSON(w, hooks)
+
+		case http.MethodPost:
+			req, ok := decodeWebhookRequest(w, r)
+			if !ok {
+				return
+			}
+			hook, err := webhooks.Create(req)
+			switch {
+			case errors.Is(err, app.ErrWebhookURL):
+				httpError(w, err.Error(), 400)
+			case err != nil:
+				httpError(w, err.Error(), 500)
+			default:
+				// The secret is only ever shown here, when it's created.
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(201)
+				json.NewEncoder(w).Encode(struct {
+					*db.Webhook
+					Secret string
+				}{hook, hook.Secret})
+			}
+
+		default:
+			httpError(w, "method not allowed", 405)
+		}
+	})
+
+	mux.HandleFunc("/api/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+		if webhooks == nil {
+			httpError(w, "webhooks not available", 501)
+			return
+		}
+		if fromToken(r) {
+			httpError(w, "webhooks are managed in the web UI, not with an API token", 403)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/webhooks/"), "/")
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			httpError(w, "invalid webhook id", 400)
+			return
+		}
+
+		switch {
+		case len(parts) == 1:
+			webhook(w, r, store, webhooks, id)
+		case len(parts) == 2 && parts[1] == "deliveries":
+			if r.Method != http.MethodGet {
+				httpError(w, "method not allowed", 405)
+				return
+			}
+			webhookDeliveries(w, r, store, id)
+		case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "retry":
+			if r.Method != http.MethodPost {
+				httpError(w, "method not allowed", 405)
+				return
+			}
+			deliveryID, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				httpError(w, "invalid delivery id", 400)
+				return
+			}
+			redeliverWebhook(w, store, webhooks, id, deliveryID)
+		default:
+			httpError(w, "not found", 404)
+		}
+	})
+}
+
+func webhook(w http.ResponseWriter, r *http.Request, store *db.Store, webhooks *app.Webhooks, id int64) {
+	var hook *db.Webhook
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		hook, err = store.GetWebhook(id)
+	case http.MethodPut:
+		req, ok := decodeWebhookRequest(w, r)
+		if !ok {
+			return
+		}
+		hook, err = webhooks.Update(id, req)
+	case http.MethodDelete:
+		var deleted bool
+		if deleted, err = store.DeleteWebhook(id); err == nil && deleted {
+			w.WriteHeader(204)
+			return
+		}
+	default:
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	switch {
+	case errors.Is(err, app.ErrWebhookURL):
+		httpError(w, err.Error(), 400)
+	case err != nil:
+		httpError(w, err.Error(), 500)
+	case hook == nil:
+		httpError(w, "webhook not found", 404)
+	default:
+		writeJSON(w, hook)
+	}
+}
+
+func webhookDeliveries(w http.ResponseWriter, r *http.Request, store *db.Store, id int64) {
+	hook, err := store.GetWebhook(id)
+	if err != nil {
+		httpError(w, err.Error(), 500)
+		return
+	}
+	if hook == nil {
+		httpError(w, "webhook not found", 404)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", db.WebhookQueued, db.WebhookDelivering, db.WebhookDelivered, db.WebhookFailed:
+	default:
+		httpError(w, "unknown state: "+state, 400)
+		return
+	}
+	deliveries, err := store.ListWebhookDeliveries(id, state, queryInt(r, "limit", 100))
+	if err != nil {
+		httpError(w, "list deliveries: "+err.Error(), 500)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*db.WebhookDelivery{}
+	}
+	writeJSON(w, deliveries)
+}
+
+func redeliverWebhook(w http.ResponseWriter, store *db.Store, webhooks *app.Webhooks, id, deliveryID int64) {
+	d, err := store.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		httpError(w, err.Error(), 500)
+		return
+	}
+	if d == nil || d.WebhookID != id {
+		httpError(w, "delivery not found", 404)
+		return
+	}
+	d, err = webhooks.Redeliver(deliveryID)
+	switch {
+	case errors.Is(err, app.ErrWebhookDeliveryBusy):
+		httpError(w, err.Error(), 409)
+	case err != nil:
+		httpError(w, err.Error(), 500)
+	case d == nil:
+		httpError(w, "delivery not found", 404)
+	default:
+		writeJSON(w, d)
+	}
+}
+
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (app.WebhookRequest, bool) {
+	var req struct {
+		URL            string `json:"url"`
+		Secret         string `json:"secret"`
+		ConversationID string `json:"conversation_id"`
+		Sender         string `json:"sender"`
+		Keyword        string `json:"keyword"`
+		Enabled        *bool  `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid JSON: "+err.Error(), 400)
+		return app.WebhookRequest{}, false
+	}
+	return app.WebhookRequest(req), true
+}
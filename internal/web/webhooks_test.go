@@ -0,0 +1,151 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/bus"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestWebhookRoutes(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &app.App{Store: store, Logger: zerolog.Nop()}
+	a.Webhooks = app.NewWebhooks(a)
+	srv := httptest.NewServer(APIHandlerFull(Config{Store: store, Logger: zerolog.Nop(), Webhooks: a.Webhooks}))
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, []byte) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var raw json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&raw)
+		return resp.StatusCode, raw
+	}
+
+	// A receiver that always fails, so there's something to redeliver.
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+	}))
+	defer receiver.Close()
+
+	code, body := do("POST", "/api/webhooks", `{"url": "`+receiver.URL+`", "keyword": "code"}`)
+	var hook db.Webhook
+	json.Unmarshal(body, &hook)
+	var created struct{ Secret string }
+	json.Unmarshal(body, &created)
+	if code != 201 || hook.ID == 0 || created.Secret == "" || hook.Keyword != "code" || !hook.Enabled {
+		t.Fatalf("create: %d %s", code, body)
+	}
+	if code, _ := do("POST", "/api/webhooks", `{"url": "mailto:me@example.com"}`); code != 400 {
+		t.Errorf("bad url: got %d, want 400", code)
+	}
+
+	// Webhooks see hidden conversations, so API tokens can't touch them.
+	for _, path := range []string{"/api/webhooks", "/api/webhooks/" + strconv.FormatInt(hook.ID, 10) + "/deliveries"} {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer om_x")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 403 {
+			t.Errorf("%s with a token: got %d, want 403", path, resp.StatusCode)
+		}
+	}
+
+	code, body = do("GET", "/api/webhooks", "")
+	var hooks []db.Webhook
+	json.Unmarshal(body, &hooks)
+	if code != 200 || len(hooks) != 1 || strings.Contains(string(body), created.Secret) {
+		t.Errorf("list: %d %s", code, body)
+	}
+
+	id := "/api/webhooks/" + strconv.FormatInt(hook.ID, 10)
+	code, body = do("PUT", id, `{"url": "`+receiver.URL+`", "sender": "Alice"}`)
+	var updated db.Webhook
+	json.Unmarshal(body, &updated)
+	if code != 200 || updated.Sender != "Alice" || updated.Keyword != "" || strings.Contains(string(body), created.Secret) {
+		t.Errorf("update: %d %s", code, body)
+	}
+	if stored, _ := store.GetWebhook(hook.ID); stored == nil || stored.Secret != created.Secret {
+		t.Errorf("update changed the secret: %+v", stored)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		a.Webhooks.Run(ctx)
+		close(stopped)
+	}()
+	a.Webhooks.Handle(bus.Event{Data: bus.MessageAdded{Message: &db.Message{MessageID: "m1", ConversationID: "c1", SenderName: "Alice"}}})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if failed, _ := store.ListWebhookDeliveries(hook.ID, db.WebhookFailed, 1); len(failed) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("delivery wasn't attempted")
+		}
+	}
+	cancel()
+	<-stopped
+
+	code, body = do("GET", id+"/deliveries?state=failed", "")
+	var log []db.WebhookDelivery
+	json.Unmarshal(body, &log)
+	if code != 200 || len(log) != 1 || log[0].StatusCode != 400 || log[0].MessageID != "m1" {
+		t.Fatalf("deliveries: %d %s", code, body)
+	}
+	if code, body := do("POST", id+"/deliveries/"+strconv.FormatInt(log[0].ID, 10)+"/retry", ""); code != 200 || !strings.Contains(string(body), `"State":"queued"`) {
+		t.Errorf("retry: %d %s", code, body)
+	}
+	if code, _ := do("POST", id+"/deliveries/"+strconv.FormatInt(log[0].ID, 10)+"/retry", ""); code != 409 {
+		t.Errorf("retry of a queued delivery: got %d, want 409", code)
+	}
+	if code, _ := do("POST", "/api/webhooks/999/deliveries/"+strconv.FormatInt(log[0].ID, 10)+"/retry", ""); code != 404 {
+		t.Errorf("retry under another webhook: got %d, want 404", code)
+	}
+
+	if code, _ := do("DELETE", id, ""); code != 204 {
+		t.Errorf("delete: got %d, want 204", code)
+	}
+	for _, c := range []struct{ method, path string }{{"GET", id}, {"DELETE", id}, {"GET", id + "/deliveries"}} {
+		if code, _ := do(c.method, c.path, ""); code != 404 {
+			t.Errorf("%s %s after delete: got %d, want 404", c.method, c.path, code)
+		}
+	}
+	if code, _ := do("GET", "/api/webhooks/abc", ""); code != 400 {
+		t.Errorf("bad id: got %d, want 400", code)
+	}
+}
+
+func TestWebhookRoutesUnavailable(t *testing.T) {
+	ts := newTestServer(t)
+	resp, err := http.Get(ts.server.URL + "/api/webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 501 {
+		t.Errorf("got %d, want 501", resp.StatusCode)
+	}
+}
@@ -17,7 +17,7 @@ func main() {
 	if len(os.Args) < 2 {
 		fmt.Fprintln(os.Stderr, "Usage: openmessage <pair|serve|send>")
 		fmt.Fprintln(os.Stderr, "  pair                          - Pair with your phone via QR code")
-		fmt.Fprintln(os.Stderr, "  serve                         - Start MCP server (stdio)")
+		fmt.Fprintln(os.Stderr, "  serve [-mcp sse|http|stdio]   - Start the web UI and MCP server")
 		fmt.Fprintln(os.Stderr, "  send <conversation_id> <msg>  - Send message to a conversation")
 		os.Exit(1)
 	}
@@ -27,7 +27,7 @@ func main() {
 	case "pair":
 		err = cmd.RunPair(logger)
 	case "serve":
-		err = cmd.RunServe(logger)
+		err = cmd.RunServe(logger, os.Args[2:])
 	case "send":
 		if len(os.Args) < 4 {
 			fmt.Fprintln(os.Stderr, "Usage: openmessage send <conversation_id> <message>")